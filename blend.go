@@ -0,0 +1,230 @@
+package canvas
+
+import (
+	"image/color"
+	"math"
+)
+
+// BlendMode specifies how the colors of a group are composited with the backdrop, see https://www.w3.org/TR/compositing-1/#blending.
+type BlendMode int
+
+// see BlendMode
+const (
+	NormalBlend BlendMode = iota
+	MultiplyBlend
+	ScreenBlend
+	OverlayBlend
+	DarkenBlend
+	LightenBlend
+	ColorDodgeBlend
+	ColorBurnBlend
+	HardLightBlend
+	SoftLightBlend
+	DifferenceBlend
+	ExclusionBlend
+	HueBlend
+	SaturationBlend
+	ColorBlend
+	LuminosityBlend
+)
+
+var blendModeNames = []string{"Normal", "Multiply", "Screen", "Overlay", "Darken", "Lighten", "ColorDodge", "ColorBurn", "HardLight", "SoftLight", "Difference", "Exclusion", "Hue", "Saturation", "Color", "Luminosity"}
+
+// String returns the name of the blend mode as used by PDF, such as "ColorDodge".
+func (mode BlendMode) String() string {
+	if mode < 0 || int(mode) >= len(blendModeNames) {
+		return "Unknown"
+	}
+	return blendModeNames[mode]
+}
+
+// CSS returns the name of the blend mode as used by CSS and SVG, such as "color-dodge".
+func (mode BlendMode) CSS() string {
+	s := mode.String()
+	css := make([]byte, 0, len(s)+1)
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			if i != 0 {
+				css = append(css, '-')
+			}
+			css = append(css, s[i]-'A'+'a')
+		} else {
+			css = append(css, s[i])
+		}
+	}
+	return string(css)
+}
+
+// Composite returns the result of compositing the source color over the backdrop color using the blend mode. Both colors are alpha-premultiplied.
+func (mode BlendMode) Composite(backdrop, source color.RGBA) color.RGBA {
+	if source.A == 0 {
+		return backdrop
+	} else if mode == NormalBlend || backdrop.A == 0 {
+		a := 1.0 - float64(source.A)/255.0
+		return color.RGBA{
+			source.R + uint8(float64(backdrop.R)*a+0.5),
+			source.G + uint8(float64(backdrop.G)*a+0.5),
+			source.B + uint8(float64(backdrop.B)*a+0.5),
+			source.A + uint8(float64(backdrop.A)*a+0.5),
+		}
+	}
+
+	// see https://www.w3.org/TR/compositing-1/#generalformula
+	ab, as := float64(backdrop.A)/255.0, float64(source.A)/255.0
+	cb := [3]float64{float64(backdrop.R) / 255.0, float64(backdrop.G) / 255.0, float64(backdrop.B) / 255.0}
+	cs := [3]float64{float64(source.R) / 255.0, float64(source.G) / 255.0, float64(source.B) / 255.0}
+	var ub, us [3]float64 // un-premultiplied
+	for i := range cb {
+		ub[i], us[i] = cb[i]/ab, cs[i]/as
+	}
+	b := mode.blend(ub, us)
+
+	var c [3]uint8
+	for i := range c {
+		co := cs[i]*(1.0-ab) + cb[i]*(1.0-as) + as*ab*b[i]
+		c[i] = uint8(math.Max(0.0, math.Min(1.0, co))*255.0 + 0.5)
+	}
+	ao := as + ab*(1.0-as)
+	return color.RGBA{c[0], c[1], c[2], uint8(ao*255.0 + 0.5)}
+}
+
+// blend returns the blended color of the un-premultiplied backdrop and source colors.
+func (mode BlendMode) blend(cb, cs [3]float64) [3]float64 {
+	switch mode {
+	case HueBlend:
+		return blendSetLum(blendSetSat(cs, blendSat(cb)), blendLum(cb))
+	case SaturationBlend:
+		return blendSetLum(blendSetSat(cb, blendSat(cs)), blendLum(cb))
+	case ColorBlend:
+		return blendSetLum(cs, blendLum(cb))
+	case LuminosityBlend:
+		return blendSetLum(cb, blendLum(cs))
+	}
+
+	var c [3]float64
+	for i := range c {
+		c[i] = mode.blendSeparable(cb[i], cs[i])
+	}
+	return c
+}
+
+func (mode BlendMode) blendSeparable(cb, cs float64) float64 {
+	switch mode {
+	case MultiplyBlend:
+		return cb * cs
+	case ScreenBlend:
+		return cb + cs - cb*cs
+	case OverlayBlend:
+		return HardLightBlend.blendSeparable(cs, cb)
+	case DarkenBlend:
+		return math.Min(cb, cs)
+	case LightenBlend:
+		return math.Max(cb, cs)
+	case ColorDodgeBlend:
+		if cb == 0.0 {
+			return 0.0
+		} else if cs == 1.0 {
+			return 1.0
+		}
+		return math.Min(1.0, cb/(1.0-cs))
+	case ColorBurnBlend:
+		if cb == 1.0 {
+			return 1.0
+		} else if cs == 0.0 {
+			return 0.0
+		}
+		return 1.0 - math.Min(1.0, (1.0-cb)/cs)
+	case HardLightBlend:
+		if cs <= 0.5 {
+			return MultiplyBlend.blendSeparable(cb, 2.0*cs)
+		}
+		return ScreenBlend.blendSeparable(cb, 2.0*cs-1.0)
+	case SoftLightBlend:
+		if cs <= 0.5 {
+			return cb - (1.0-2.0*cs)*cb*(1.0-cb)
+		}
+		d := math.Sqrt(cb)
+		if cb <= 0.25 {
+			d = ((16.0*cb-12.0)*cb + 4.0) * cb
+		}
+		return cb + (2.0*cs-1.0)*(d-cb)
+	case DifferenceBlend:
+		return math.Abs(cb - cs)
+	case ExclusionBlend:
+		return cb + cs - 2.0*cb*cs
+	}
+	return cs
+}
+
+func blendLum(c [3]float64) float64 {
+	return 0.3*c[0] + 0.59*c[1] + 0.11*c[2]
+}
+
+func blendSetLum(c [3]float64, l float64) [3]float64 {
+	d := l - blendLum(c)
+	c = [3]float64{c[0] + d, c[1] + d, c[2] + d}
+
+	// clip color
+	l = blendLum(c)
+	n := math.Min(c[0], math.Min(c[1], c[2]))
+	x := math.Max(c[0], math.Max(c[1], c[2]))
+	for i := range c {
+		if n < 0.0 {
+			c[i] = l + (c[i]-l)*l/(l-n)
+		}
+		if 1.0 < x {
+			c[i] = l + (c[i]-l)*(1.0-l)/(x-l)
+		}
+	}
+	return c
+}
+
+func blendSat(c [3]float64) float64 {
+	return math.Max(c[0], math.Max(c[1], c[2])) - math.Min(c[0], math.Min(c[1], c[2]))
+}
+
+func blendSetSat(c [3]float64, s float64) [3]float64 {
+	// find indices of the minimum, middle and maximum components
+	imin, imid, imax := 0, 1, 2
+	if c[imin] > c[imid] {
+		imin, imid = imid, imin
+	}
+	if c[imid] > c[imax] {
+		imid, imax = imax, imid
+	}
+	if c[imin] > c[imid] {
+		imin, imid = imid, imin
+	}
+
+	var r [3]float64
+	if c[imin] < c[imax] {
+		r[imid] = (c[imid] - c[imin]) * s / (c[imax] - c[imin])
+		r[imax] = s
+	}
+	return r
+}
+
+// MaskType specifies which channel of a mask's drawing determines the opacity of the masked drawing.
+type MaskType int
+
+// see MaskType
+const (
+	LuminanceMask MaskType = iota
+	AlphaMask
+)
+
+func (maskType MaskType) String() string {
+	if maskType == AlphaMask {
+		return "Alpha"
+	}
+	return "Luminosity"
+}
+
+// Mask returns the mask value of an alpha-premultiplied color, where areas outside the drawing are transparent and thus have a mask value of zero.
+func (maskType MaskType) Mask(col color.RGBA) uint8 {
+	if maskType == AlphaMask {
+		return col.A
+	}
+	// premultiplied colors are composited over a black backdrop
+	return uint8(0.3*float64(col.R) + 0.59*float64(col.G) + 0.11*float64(col.B) + 0.5)
+}
@@ -0,0 +1,54 @@
+package canvas
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestBlendModeNames(t *testing.T) {
+	test.String(t, NormalBlend.String(), "Normal")
+	test.String(t, ColorDodgeBlend.String(), "ColorDodge")
+	test.String(t, BlendMode(-1).String(), "Unknown")
+	test.String(t, NormalBlend.CSS(), "normal")
+	test.String(t, ColorDodgeBlend.CSS(), "color-dodge")
+	test.String(t, SoftLightBlend.CSS(), "soft-light")
+}
+
+func TestBlendModeComposite(t *testing.T) {
+	var tts = []struct {
+		mode     BlendMode
+		backdrop color.RGBA
+		source   color.RGBA
+		expected color.RGBA
+	}{
+		{NormalBlend, Red, Transparent, Red},
+		{NormalBlend, Red, color.RGBA{0, 0, 128, 128}, color.RGBA{127, 0, 128, 255}},
+		{MultiplyBlend, White, Red, Red},
+		{MultiplyBlend, Red, Blue, Black},
+		{MultiplyBlend, Transparent, Blue, Blue},
+		{ScreenBlend, Red, Blue, color.RGBA{255, 0, 255, 255}},
+		{DarkenBlend, Gray, White, Gray},
+		{LightenBlend, Gray, White, White},
+		{DifferenceBlend, White, Red, color.RGBA{0, 255, 255, 255}},
+		{ExclusionBlend, Black, Red, Red},
+		{ColorDodgeBlend, Black, White, Black},
+		{ColorBurnBlend, White, Black, White},
+		{LuminosityBlend, Red, White, White},
+		{ColorBlend, Black, Red, Black},
+	}
+	for _, tt := range tts {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			test.T(t, tt.mode.Composite(tt.backdrop, tt.source), tt.expected)
+		})
+	}
+}
+
+func TestMaskType(t *testing.T) {
+	test.T(t, LuminanceMask.Mask(White), uint8(255))
+	test.T(t, LuminanceMask.Mask(Red), uint8(77))
+	test.T(t, LuminanceMask.Mask(Transparent), uint8(0))
+	test.T(t, AlphaMask.Mask(Red), uint8(255))
+	test.T(t, AlphaMask.Mask(color.RGBA{0, 0, 0, 128}), uint8(128))
+}
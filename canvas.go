@@ -1,10 +1,15 @@
 package canvas
 
 import (
+	"bytes"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"sort"
 )
 
 const mmPerPt = 25.4 / 72
@@ -21,6 +26,26 @@ const (
 	Lossy
 )
 
+// EncodedImage is an image that keeps the encoded data it was decoded from, such as a JPEG file. Renderers that support the encoding embed the data as is, which prevents the generation loss of decoding and encoding the image again, while other renderers use the decoded image. The PDF renderer embeds JPEG (image/jpeg) and JPEG 2000 (image/jp2) data.
+type EncodedImage struct {
+	image.Image
+	Mimetype string
+	Data     []byte
+}
+
+// NewJPEGImage reads a JPEG image and returns it decoded while keeping its encoded data.
+func NewJPEGImage(r io.Reader) (*EncodedImage, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return &EncodedImage{img, "image/jpeg", b}, nil
+}
+
 // DPMM (Dots-per-Millimetter) for the resolution of raster images. Higher DPMM will result in bigger images.
 type DPMM float64
 
@@ -29,10 +54,12 @@ const DPI = DPMM(1 / 25.4)
 
 ////////////////////////////////////////////////////////////////
 
-// Style is the path style that defines how to draw the path. When FillColor is transparent it will not fill the path. If StrokeColor is transparent or StrokeWidth is zero, it will not stroke the path. If Dashes is an empty array, it will not draw dashes but instead a solid stroke line. FillRule determines how to fill the path when paths overlap and have certain directions (clockwise, counter clockwise).
+// Style is the path style that defines how to draw the path. When FillColor is transparent it will not fill the path. If StrokeColor is transparent or StrokeWidth is zero, it will not stroke the path. When FillPaint or StrokePaint is set, such as a gradient, it is used instead of FillColor or StrokeColor respectively. If Dashes is an empty array, it will not draw dashes but instead a solid stroke line. FillRule determines how to fill the path when paths overlap and have certain directions (clockwise, counter clockwise).
 type Style struct {
 	FillColor    color.RGBA
+	FillPaint    Paint
 	StrokeColor  color.RGBA
+	StrokePaint  Paint
 	StrokeWidth  float64
 	StrokeCapper Capper
 	StrokeJoiner Joiner
@@ -53,12 +80,28 @@ var DefaultStyle = Style{
 	FillRule:     NonZero,
 }
 
-// Renderer is an interface that renderers implement. It defines the size of the target (in mm) and functions to render paths, text objects and raster images.
+// HasFill returns true if the style fills the path.
+func (style Style) HasFill() bool {
+	return style.FillPaint != nil || style.FillColor.A != 0
+}
+
+// HasStroke returns true if the style strokes the path.
+func (style Style) HasStroke() bool {
+	return (style.StrokePaint != nil || style.StrokeColor.A != 0) && 0.0 < style.StrokeWidth
+}
+
+// Renderer is an interface that renderers implement. It defines the size of the target (in mm) and functions to render paths, text objects and raster images. PushClip intersects the current clipping region with a path that is filled using the fill rule, until it is removed by PopClip. PushGroup starts a group that is composited as a single layer with the given opacity and blend mode when it is ended by PopGroup. PushMask masks the following drawings by the luminance or alpha of a canvas transformed by m, until it is removed by PopMask. Calls to PushClip/PopClip, PushGroup/PopGroup and PushMask/PopMask are properly nested.
 type Renderer interface {
 	Size() (float64, float64)
 	RenderPath(path *Path, style Style, m Matrix)
 	RenderText(text *Text, m Matrix)
 	RenderImage(img image.Image, m Matrix)
+	PushClip(path *Path, fillRule FillRule, m Matrix)
+	PopClip()
+	PushGroup(opacity float64, blendMode BlendMode)
+	PopGroup()
+	PushMask(mask *Canvas, maskType MaskType, m Matrix)
+	PopMask()
 }
 
 ////////////////////////////////////////////////////////////////
@@ -72,7 +115,7 @@ const (
 	CartesianIV
 )
 
-// Context maintains the state for the current path, path style, and view transformation matrix.
+// Context maintains the state for the current path, path style, view transformation matrix, clipping paths, groups, and masks.
 type Context struct {
 	Renderer
 
@@ -83,11 +126,13 @@ type Context struct {
 	viewStack      []Matrix
 	coordView      Matrix
 	coordViewStack []Matrix
+	scopes         []scopeKind
+	scopesStack    []int
 }
 
-// NewContext returns a new Context which is a wrapper around a Renderer. Context maintains state for the current path, path style, and view transformation matrix.
+// NewContext returns a new Context which is a wrapper around a Renderer. Context maintains state for the current path, path style, view transformation matrix, clipping paths, groups, and masks.
 func NewContext(r Renderer) *Context {
-	return &Context{r, &Path{}, DefaultStyle, nil, Identity, nil, Identity, nil}
+	return &Context{r, &Path{}, DefaultStyle, nil, Identity, nil, Identity, nil, nil, nil}
 }
 
 // Width returns the width of the canvas.
@@ -107,9 +152,10 @@ func (c *Context) Push() {
 	c.styleStack = append(c.styleStack, c.Style)
 	c.viewStack = append(c.viewStack, c.view)
 	c.coordViewStack = append(c.coordViewStack, c.coordView)
+	c.scopesStack = append(c.scopesStack, len(c.scopes))
 }
 
-// Pop restores the last pushed draw state and uses that as the current draw state. If there are no states on the stack, this will do nothing.
+// Pop restores the last pushed draw state and uses that as the current draw state, removing the clipping paths, groups, and masks added since. If there are no states on the stack, this will do nothing.
 func (c *Context) Pop() {
 	if len(c.styleStack) == 0 {
 		return
@@ -120,6 +166,39 @@ func (c *Context) Pop() {
 	c.viewStack = c.viewStack[:len(c.viewStack)-1]
 	c.coordView = c.coordViewStack[len(c.coordViewStack)-1]
 	c.coordViewStack = c.coordViewStack[:len(c.coordViewStack)-1]
+	for c.scopesStack[len(c.scopesStack)-1] < len(c.scopes) {
+		c.popScope()
+	}
+	c.scopesStack = c.scopesStack[:len(c.scopesStack)-1]
+}
+
+// popScope removes the last clipping path, group, or mask.
+func (c *Context) popScope() {
+	switch c.scopes[len(c.scopes)-1] {
+	case groupScope:
+		c.Renderer.PopGroup()
+	case maskScope:
+		c.Renderer.PopMask()
+	default:
+		c.Renderer.PopClip()
+	}
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// popScopeOf removes the last scope of the given kind and the scopes added after it, but only those added after the last pushed draw state.
+func (c *Context) popScopeOf(kind scopeKind) {
+	start := 0
+	if 0 < len(c.scopesStack) {
+		start = c.scopesStack[len(c.scopesStack)-1]
+	}
+	for i := len(c.scopes) - 1; start <= i; i-- {
+		if c.scopes[i] == kind {
+			for i < len(c.scopes) {
+				c.popScope()
+			}
+			return
+		}
+	}
 }
 
 // SetCoordView sets the current affine transformation matrix through which all operation coordinates will be transformed.
@@ -217,16 +296,28 @@ func (c *Context) ShearAbout(sx, sy, x, y float64) {
 	c.view = c.view.Mul(Identity.ShearAbout(sx, sy, x, y))
 }
 
-// SetFillColor sets the color to be used for filling operations.
+// SetFillColor sets the color to be used for filling operations. It removes any fill paint.
 func (c *Context) SetFillColor(col color.Color) {
 	r, g, b, a := col.RGBA()
 	c.Style.FillColor = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	c.Style.FillPaint = nil
 }
 
-// SetStrokeColor sets the color to be used for stroking operations.
+// SetFillPaint sets the paint, such as a gradient, to be used for filling operations. Setting it to nil will use the fill color instead.
+func (c *Context) SetFillPaint(paint Paint) {
+	c.Style.FillPaint = paint
+}
+
+// SetStrokeColor sets the color to be used for stroking operations. It removes any stroke paint.
 func (c *Context) SetStrokeColor(col color.Color) {
 	r, g, b, a := col.RGBA()
 	c.Style.StrokeColor = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	c.Style.StrokePaint = nil
+}
+
+// SetStrokePaint sets the paint, such as a gradient, to be used for stroking operations. Setting it to nil will use the stroke color instead.
+func (c *Context) SetStrokePaint(paint Paint) {
+	c.Style.StrokePaint = paint
 }
 
 // SetStrokeWidth sets the width in mm for stroking operations.
@@ -312,6 +403,7 @@ func (c *Context) Close() {
 func (c *Context) Fill() {
 	style := c.Style
 	style.StrokeColor = Transparent
+	style.StrokePaint = nil
 	c.RenderPath(c.path, style, c.view)
 	c.path = &Path{}
 }
@@ -320,6 +412,7 @@ func (c *Context) Fill() {
 func (c *Context) Stroke() {
 	style := c.Style
 	style.FillColor = Transparent
+	style.FillPaint = nil
 	c.RenderPath(c.path, style, c.view)
 	c.path = &Path{}
 }
@@ -332,7 +425,7 @@ func (c *Context) FillStroke() {
 
 // DrawPath draws a path at position (x,y) using the current draw state.
 func (c *Context) DrawPath(x, y float64, paths ...*Path) {
-	if c.Style.FillColor.A == 0 && (c.Style.StrokeColor.A == 0 || c.Style.StrokeWidth == 0.0) {
+	if !c.Style.HasFill() && !c.Style.HasStroke() {
 		return
 	}
 
@@ -350,6 +443,38 @@ func (c *Context) DrawPath(x, y float64, paths ...*Path) {
 	}
 }
 
+// Clip intersects the current clipping region with the path, using the current fill rule and view. Subsequent drawing operations are only visible within the clipping region until the draw state is popped.
+func (c *Context) Clip(path *Path) {
+	coord := c.coordView.Dot(Point{0.0, 0.0})
+	m := c.view.Translate(coord.X, coord.Y)
+	c.Renderer.PushClip(path, c.Style.FillRule, m)
+	c.scopes = append(c.scopes, clipScope)
+}
+
+// PushGroup starts a group of drawing operations that is composited as a single layer with the given opacity and blend mode, which avoids overlapping semi-transparent drawings from adding up.
+func (c *Context) PushGroup(opacity float64, blendMode BlendMode) {
+	c.Renderer.PushGroup(opacity, blendMode)
+	c.scopes = append(c.scopes, groupScope)
+}
+
+// PopGroup ends the last group, removing the clipping paths and masks added since. If there is no group, or it was started before the last pushed draw state, this will do nothing.
+func (c *Context) PopGroup() {
+	c.popScopeOf(groupScope)
+}
+
+// PushMask masks the following drawing operations by the luminance or alpha of the mask canvas, which is placed at the origin of the current coordinate system using the current view.
+func (c *Context) PushMask(mask *Canvas, maskType MaskType) {
+	coord := c.coordView.Dot(Point{0.0, 0.0})
+	m := c.view.Translate(coord.X, coord.Y)
+	c.Renderer.PushMask(mask, maskType, m)
+	c.scopes = append(c.scopes, maskScope)
+}
+
+// PopMask removes the last mask, removing the clipping paths and groups added since. If there is no mask, or it was pushed before the last pushed draw state, this will do nothing.
+func (c *Context) PopMask() {
+	c.popScopeOf(maskScope)
+}
+
 // DrawText draws text at position (x,y) using the current draw state. In particular, it only uses the current affine transformation matrix.
 func (c *Context) DrawText(x, y float64, texts ...*Text) {
 	coord := c.coordView.Dot(Point{x, y})
@@ -388,6 +513,30 @@ type ZIndexer interface {
 	SetZIndex(z int)
 }
 
+// Linker is implemented by renderers that support hyperlinks, such as PDF and SVG. RenderLink makes the rectangle transformed by m a link to the URI, where a URI of the form "#name" links to the named destination within the document. RenderDestination adds a named destination at the point transformed by m.
+type Linker interface {
+	RenderLink(uri string, rect Rect, m Matrix)
+	RenderDestination(name string, pos Point, m Matrix)
+}
+
+// AddLink makes the rectangle at position (x,y) with width w and height h a link to the URI, using the current view. A URI of the form "#name" links to the named destination added by AddDestination. It is ignored if the renderer does not support links.
+func (c *Context) AddLink(x, y, w, h float64, uri string) {
+	if linker, ok := c.Renderer.(Linker); ok {
+		coord := c.coordView.Dot(Point{x, y})
+		m := c.view.Translate(coord.X, coord.Y)
+		linker.RenderLink(uri, Rect{0.0, 0.0, w, h}, m)
+	}
+}
+
+// AddDestination adds a named destination at position (x,y) using the current view, which can be the target of links. It is ignored if the renderer does not support links.
+func (c *Context) AddDestination(x, y float64, name string) {
+	if linker, ok := c.Renderer.(Linker); ok {
+		coord := c.coordView.Dot(Point{x, y})
+		m := c.view.Translate(coord.X, coord.Y)
+		linker.RenderDestination(name, Point{}, m)
+	}
+}
+
 // Set the z-index of the render if it supports it
 // or ignore if it does not
 func (c *Context) SetZIndex(z int) {
@@ -401,15 +550,115 @@ func (c *Context) SetZIndex(z int) {
 ////////////////////////////////////////////////////////////////
 
 type layer struct {
-	// path, text OR img is set
+	// path, text, img, link OR dest is set
 	path *Path
 	text *Text
 	img  image.Image
+	link string // URI of the link over rect
+	rect Rect
+	dest string // name of the destination at the origin
 
 	m      Matrix
 	zIndex int
+	scopes []*scope // clipping paths, groups, and masks in the order they were pushed
+
+	style   Style // only for path
+	bounds  Rect  // bounds in canvas coordinates
+	clipped bool  // entirely outside its clipping paths
+}
+
+// strokeReach returns how far the stroke outline may reach from the path, in units of half the stroke width. It returns false if the reach is not bounded, such as for miter joins without a limit, or is unknown, such as for arcs joins or custom cappers and joiners.
+func strokeReach(capper Capper, joiner Joiner) (float64, bool) {
+	reach := 1.0
+	switch capper.(type) {
+	case ButtCapper, RoundCapper:
+	case SquareCapper:
+		reach = math.Sqrt2 // corners of the cap
+	default:
+		return 0.0, false
+	}
+	for {
+		switch j := joiner.(type) {
+		case BevelJoiner, RoundJoiner:
+			return reach, true
+		case MiterJoiner:
+			if math.IsNaN(j.Limit) || math.IsInf(j.Limit, 1) {
+				return 0.0, false
+			}
+			reach = math.Max(reach, j.Limit)
+			joiner = j.GapJoiner // used when the miter exceeds the limit
+		default:
+			return 0.0, false
+		}
+	}
+}
+
+// computeBounds returns the bounds of the layer in canvas coordinates, including the reach of the stroke for paths and limited by the clipping paths. The bounds are conservative and may be larger than what is drawn. It returns false if the layer is entirely outside its clipping paths.
+func (l layer) computeBounds() (Rect, bool) {
+	bounds := Rect{}
+	if l.link != "" {
+		bounds = l.rect
+	} else if l.path != nil {
+		bounds = l.path.Bounds()
+	} else if l.text != nil {
+		bounds = l.text.Bounds()
+	} else if l.img != nil {
+		size := l.img.Bounds().Size()
+		bounds = Rect{0.0, 0.0, float64(size.X), float64(size.Y)}
+	}
+	bounds = bounds.Transform(l.m)
+	if l.link == "" && l.path != nil && l.style.HasStroke() {
+		// renderers stroke the transformed path, dashes are ignored since they only shrink the stroke
+		if reach, ok := strokeReach(l.style.StrokeCapper, l.style.StrokeJoiner); ok {
+			d := reach * l.style.StrokeWidth / 2.0
+			bounds = Rect{bounds.X - d, bounds.Y - d, bounds.W + 2.0*d, bounds.H + 2.0*d}
+		} else {
+			stroke := l.path.Transform(l.m).Stroke(l.style.StrokeWidth, l.style.StrokeCapper, l.style.StrokeJoiner)
+			bounds = bounds.Add(stroke.Bounds())
+		}
+	}
+	for _, s := range l.scopes {
+		if s.kind == clipScope {
+			clip := s.path.Bounds().Transform(s.m)
+			if !bounds.Overlaps(clip) {
+				return Rect{}, false
+			}
 
-	style Style // only for path
+			// intersection that keeps rects of zero size
+			x0 := math.Max(bounds.X, clip.X)
+			y0 := math.Max(bounds.Y, clip.Y)
+			x1 := math.Min(bounds.X+bounds.W, clip.X+clip.W)
+			y1 := math.Min(bounds.Y+bounds.H, clip.Y+clip.H)
+			bounds = Rect{x0, y0, x1 - x0, y1 - y0}
+		}
+	}
+	return bounds, true
+}
+
+type scopeKind int
+
+const (
+	clipScope scopeKind = iota
+	groupScope
+	maskScope
+)
+
+// scope is a clipping path, group, or mask that applies to consecutive layers.
+type scope struct {
+	kind scopeKind
+	m    Matrix // for clipping paths and masks
+
+	// clipping path
+	path     *Path
+	fillRule FillRule
+
+	// group
+	opacity   float64
+	blendMode BlendMode
+
+	// mask
+	mask     *Canvas
+	maskType MaskType
 }
 
 // Canvas stores all drawing operations as layers that can be re-rendered to other renderers.
@@ -418,6 +667,8 @@ type Canvas struct {
 	layers       []layer
 	W, H         float64
 	zIndex, zPos int
+	scopes       []*scope
+	index        *rtreeNode // spatial index of the layer bounds, built when needed
 }
 
 // New returns a new Canvas that records all drawing operations into layers. The canvas can then be rendered to any other renderer.
@@ -468,8 +719,12 @@ func (c *Canvas) SetZIndex(z int) {
 
 // insert a new layer at the current zPos
 func (c *Canvas) insert(newL layer) {
-	// set the z-index of the new layer
+	// set the z-index, clipping paths, groups, and masks of the new layer
 	newL.zIndex = c.zIndex
+	newL.scopes = c.scopes
+	bounds, visible := newL.computeBounds()
+	newL.bounds, newL.clipped = bounds, !visible
+	c.index = nil
 	// insert the new layer
 	if c.zPos == len(c.layers) {
 		c.layers = append(c.layers, newL)
@@ -497,6 +752,57 @@ func (c *Canvas) RenderImage(img image.Image, m Matrix) {
 	c.insert(layer{img: img, m: m})
 }
 
+// RenderLink adds a link to the canvas over a rectangle transformed by a transformation matrix.
+func (c *Canvas) RenderLink(uri string, rect Rect, m Matrix) {
+	c.insert(layer{link: uri, rect: rect, m: m})
+}
+
+// RenderDestination adds a named destination to the canvas at a point transformed by a transformation matrix.
+func (c *Canvas) RenderDestination(name string, pos Point, m Matrix) {
+	c.insert(layer{dest: name, m: m.Translate(pos.X, pos.Y)})
+}
+
+func (c *Canvas) pushScope(s *scope) {
+	n := len(c.scopes)
+	c.scopes = append(c.scopes[:n:n], s) // always reallocate since layers share the stack
+}
+
+func (c *Canvas) popScope() {
+	if 0 < len(c.scopes) {
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	}
+}
+
+// PushClip intersects the clipping region of the following layers with a path.
+func (c *Canvas) PushClip(path *Path, fillRule FillRule, m Matrix) {
+	c.pushScope(&scope{kind: clipScope, path: path.Copy(), fillRule: fillRule, m: m})
+}
+
+// PopClip removes the last pushed clipping path.
+func (c *Canvas) PopClip() {
+	c.popScope()
+}
+
+// PushGroup starts a group for the following layers that is composited with the given opacity and blend mode.
+func (c *Canvas) PushGroup(opacity float64, blendMode BlendMode) {
+	c.pushScope(&scope{kind: groupScope, opacity: opacity, blendMode: blendMode})
+}
+
+// PopGroup ends the last started group.
+func (c *Canvas) PopGroup() {
+	c.popScope()
+}
+
+// PushMask masks the following layers by the luminance or alpha of a canvas.
+func (c *Canvas) PushMask(mask *Canvas, maskType MaskType, m Matrix) {
+	c.pushScope(&scope{kind: maskScope, m: m, mask: mask, maskType: maskType})
+}
+
+// PopMask removes the last pushed mask.
+func (c *Canvas) PopMask() {
+	c.popScope()
+}
+
 // Empty return true if the canvas is empty.
 func (c *Canvas) Empty() bool {
 	return len(c.layers) == 0
@@ -506,6 +812,8 @@ func (c *Canvas) Empty() bool {
 func (c *Canvas) Reset() {
 	c.layers = c.layers[:0]
 	c.zIndex, c.zPos = 0, 0
+	c.scopes = c.scopes[:0]
+	c.index = nil
 }
 
 // Fit shrinks the canvas size so all elements fit. The elements are translated towards the origin when any left/bottom margins exist and the canvas size is decreased if any margins exist. It will maintain a given margin.
@@ -517,35 +825,108 @@ func (c *Canvas) Fit(margin float64) {
 	}
 
 	rect := Rect{}
-	// TODO: slow when we have many paths (see Graph example)
-	for i, l := range c.layers {
-		bounds := Rect{}
-		if l.path != nil {
-			bounds = l.path.Bounds()
-			if l.style.StrokeColor.A != 0 && 0.0 < l.style.StrokeWidth {
-				bounds.X -= l.style.StrokeWidth / 2.0
-				bounds.Y -= l.style.StrokeWidth / 2.0
-				bounds.W += l.style.StrokeWidth
-				bounds.H += l.style.StrokeWidth
-			}
-		} else if l.text != nil {
-			bounds = l.text.Bounds()
-		} else if l.img != nil {
-			size := l.img.Bounds().Size()
-			bounds = Rect{0.0, 0.0, float64(size.X), float64(size.Y)}
-		}
-		bounds = bounds.Transform(l.m)
-		if i == 0 {
-			rect = bounds
+	empty := true
+	for _, l := range c.layers {
+		if l.link != "" || l.dest != "" || l.clipped {
+			continue // links, destinations, and clipped layers are not visible
+		} else if empty {
+			rect = l.bounds
+			empty = false
 		} else {
-			rect = rect.Add(bounds)
+			rect = rect.Add(l.bounds)
 		}
 	}
+	translate := Identity.Translate(-rect.X+margin, -rect.Y+margin)
+	scopes := map[*scope]bool{}
 	for i := range c.layers {
-		c.layers[i].m = Identity.Translate(-rect.X+margin, -rect.Y+margin).Mul(c.layers[i].m)
+		c.layers[i].m = translate.Mul(c.layers[i].m)
+		c.layers[i].bounds = c.layers[i].bounds.Move(Point{-rect.X + margin, -rect.Y + margin})
+		for _, s := range c.layers[i].scopes {
+			if !scopes[s] {
+				s.m = translate.Mul(s.m)
+				scopes[s] = true
+			}
+		}
+	}
+	for _, s := range c.scopes {
+		if !scopes[s] {
+			s.m = translate.Mul(s.m)
+		}
 	}
 	c.W = rect.W + 2*margin
 	c.H = rect.H + 2*margin
+	c.index = nil
+}
+
+// Hit is a layer of the canvas that overlaps with the rectangle passed to Query. Only one of Path, Text, Image, Link, or Destination is set.
+type Hit struct {
+	Index       int   // position of the layer in drawing order
+	Bounds      Rect  // bounds of the layer in canvas coordinates, which may be larger than what is drawn
+	Path        *Path // path in its own coordinates, see Matrix
+	Style       Style // style of the path
+	Text        *Text // text in its own coordinates, see Matrix
+	Image       image.Image
+	Link        string // URI of the link over Bounds
+	Destination string // name of the destination at the origin of Matrix
+	Matrix      Matrix // transformation to canvas coordinates
+}
+
+// Query returns the layers whose bounds overlap with rect in canvas coordinates, in drawing order. The bounds of paths include half the stroke width and are limited by clipping paths. Use this for hit-testing, such as finding the elements under the mouse cursor, and test the returned paths for an exact result.
+func (c *Canvas) Query(rect Rect) []Hit {
+	hits := []Hit{}
+	for _, i := range c.search(rect) {
+		l := c.layers[i]
+		hits = append(hits, Hit{
+			Index:       i,
+			Bounds:      l.bounds,
+			Path:        l.path,
+			Style:       l.style,
+			Text:        l.text,
+			Image:       l.img,
+			Link:        l.link,
+			Destination: l.dest,
+			Matrix:      l.m,
+		})
+	}
+	return hits
+}
+
+// search returns the indices of the layers that overlap with rect in increasing order, it builds the spatial index if needed.
+func (c *Canvas) search(rect Rect) []int {
+	if c.index == nil && 0 < len(c.layers) {
+		bounds := make([]Rect, 0, len(c.layers))
+		indices := make([]int, 0, len(c.layers))
+		for i, l := range c.layers {
+			if !l.clipped {
+				bounds = append(bounds, l.bounds)
+				indices = append(indices, i)
+			}
+		}
+		c.index = newRTree(bounds, indices)
+	}
+
+	indices := []int{}
+	c.index.search(rect, func(i int) {
+		indices = append(indices, i)
+	})
+	sort.Ints(indices)
+	return indices
+}
+
+// RenderRect renders only the layers of the canvas that overlap with the viewport, which is in canvas coordinates. The drawing operations are translated so that the bottom-left of the viewport is at the origin of the renderer. This is much faster than Render for small viewports of large canvases, such as when panning and zooming maps or rendering tiles.
+func (c *Canvas) RenderRect(r Renderer, viewport Rect) {
+	view := Identity
+	if viewer, ok := r.(interface{ View() Matrix }); ok {
+		view = viewer.View()
+	}
+	view = view.Translate(-viewport.X, -viewport.Y)
+
+	indices := c.search(viewport)
+	layers := make([]layer, len(indices))
+	for j, i := range indices {
+		layers[j] = c.layers[i]
+	}
+	renderLayers(r, view, layers)
 }
 
 // Render renders the accumulated canvas drawing operations to another renderer.
@@ -554,20 +935,74 @@ func (c *Canvas) Render(r Renderer) {
 	if viewer, ok := r.(interface{ View() Matrix }); ok {
 		view = viewer.View()
 	}
+	c.RenderView(r, view)
+}
+
+// RenderView renders the accumulated canvas drawing operations to another renderer, where all drawing operations are transformed by the view matrix.
+func (c *Canvas) RenderView(r Renderer, view Matrix) {
+	renderLayers(r, view, c.layers)
+}
+
+func renderLayers(r Renderer, view Matrix, layers []layer) {
 	zindexer, isZIndexer := r.(ZIndexer)
-	for _, l := range c.layers {
+	linker, isLinker := r.(Linker)
+	scopes := []*scope{}
+	for _, l := range layers {
 		m := view.Mul(l.m)
 		if isZIndexer {
 			zindexer.SetZIndex(l.zIndex)
 		}
+
+		// end the clipping paths, groups, and masks not shared with the previous layer and start the new ones
+		n := 0
+		for n < len(scopes) && n < len(l.scopes) && scopes[n] == l.scopes[n] {
+			n++
+		}
+		for i := len(scopes) - 1; n <= i; i-- {
+			scopes[i].pop(r)
+		}
+		for _, s := range l.scopes[n:] {
+			s.push(r, view)
+		}
+		scopes = l.scopes
+
 		if l.path != nil {
 			r.RenderPath(l.path, l.style, m)
 		} else if l.text != nil {
 			r.RenderText(l.text, m)
 		} else if l.img != nil {
 			r.RenderImage(l.img, m)
+		} else if l.link != "" && isLinker {
+			linker.RenderLink(l.link, l.rect, m)
+		} else if l.dest != "" && isLinker {
+			linker.RenderDestination(l.dest, Point{}, m)
 		}
 	}
+	for i := len(scopes) - 1; 0 <= i; i-- {
+		scopes[i].pop(r)
+	}
+}
+
+func (s *scope) push(r Renderer, view Matrix) {
+	switch s.kind {
+	case groupScope:
+		r.PushGroup(s.opacity, s.blendMode)
+	case maskScope:
+		r.PushMask(s.mask, s.maskType, view.Mul(s.m))
+	default:
+		r.PushClip(s.path, s.fillRule, view.Mul(s.m))
+	}
+}
+
+func (s *scope) pop(r Renderer) {
+	switch s.kind {
+	case groupScope:
+		r.PopGroup()
+	case maskScope:
+		r.PopMask()
+	default:
+		r.PopClip()
+	}
 }
 
 // Writer can write a canvas to a writer
@@ -0,0 +1,981 @@
+package canvas
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+)
+
+// encodingVersion is the version of the native canvas encoding. It is incremented whenever the encoding changes in a way that older versions cannot decode.
+const encodingVersion = 1
+
+// binaryMagic starts the binary encoding of a canvas.
+var binaryMagic = []byte("CANVAS\x00")
+
+// MarshalJSON encodes the canvas to its native JSON encoding, which holds all layers and can be decoded by UnmarshalJSON without loss. Fonts are embedded and images are embedded as PNG. It returns an error for paints, cappers, joiners, and font decorators that are not defined by this package.
+func (c *Canvas) MarshalJSON() ([]byte, error) {
+	file, err := encodeCanvasFile(c)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(file)
+}
+
+// UnmarshalJSON decodes a canvas from its native JSON encoding, see MarshalJSON.
+func (c *Canvas) UnmarshalJSON(b []byte) error {
+	file := encodedFile{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return err
+	}
+	return decodeCanvasFile(c, &file)
+}
+
+// MarshalBinary encodes the canvas to its native binary encoding, which is more compact and faster than the JSON encoding but otherwise equivalent, see MarshalJSON.
+func (c *Canvas) MarshalBinary() ([]byte, error) {
+	file, err := encodeCanvasFile(c)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write(binaryMagic)
+	if err := gob.NewEncoder(buf).Encode(file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a canvas from its native binary encoding, see MarshalBinary.
+func (c *Canvas) UnmarshalBinary(b []byte) error {
+	if !bytes.HasPrefix(b, binaryMagic) {
+		return fmt.Errorf("invalid canvas encoding")
+	}
+
+	file := encodedFile{}
+	if err := gob.NewDecoder(bytes.NewReader(b[len(binaryMagic):])).Decode(&file); err != nil {
+		return err
+	}
+	return decodeCanvasFile(c, &file)
+}
+
+// JSONWriter writes the canvas in its native JSON encoding, see MarshalJSON.
+func JSONWriter(w io.Writer, c *Canvas) error {
+	b, err := c.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// BinaryWriter writes the canvas in its native binary encoding, see MarshalBinary.
+func BinaryWriter(w io.Writer, c *Canvas) error {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read reads a canvas in its native JSON or binary encoding as written by JSONWriter or BinaryWriter. The canvas can be drawn on further or be rendered to any renderer using Render.
+func Read(r io.Reader) (*Canvas, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(binaryMagic)); err == nil && bytes.Equal(magic, binaryMagic) {
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		c := &Canvas{}
+		if err := c.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	file := encodedFile{}
+	if err := json.NewDecoder(br).Decode(&file); err != nil {
+		return nil, err
+	}
+	c := &Canvas{}
+	if err := decodeCanvasFile(c, &file); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadFile reads a canvas from a file in its native JSON or binary encoding, see Read.
+func ReadFile(filename string) (*Canvas, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+////////////////////////////////////////////////////////////////
+
+// encodedFile is the root of the native encoding, fonts and images are stored once and are referred to by their index.
+type encodedFile struct {
+	Version  int
+	Fonts    []encodedFont
+	Families []encodedFamily
+	Images   [][]byte // PNG
+	Canvas   encodedCanvas
+}
+
+type encodedFont struct {
+	Name      string
+	Data      []byte
+	Ligatures bool
+}
+
+type encodedFamily struct {
+	Name    string
+	Options TypographicOptions
+	Fonts   []encodedFamilyFont // only the fonts that are used
+}
+
+type encodedFamilyFont struct {
+	Style FontStyle
+	Font  int
+}
+
+type encodedCanvas struct {
+	W, H   float64
+	Layers []encodedLayer
+	Scopes []encodedScope // all clipping paths, groups, and masks of the layers
+	Open   []int          // clipping paths, groups, and masks that have not been popped
+	ZIndex int
+	ZPos   int
+}
+
+type encodedLayer struct {
+	Kind        string        // path, text, image, link, or destination
+	Path        []float64     `json:",omitempty"`
+	Style       *encodedStyle `json:",omitempty"`
+	Text        *encodedText  `json:",omitempty"`
+	Image       int           `json:",omitempty"`
+	Link        string        `json:",omitempty"`
+	Rect        Rect
+	Destination string `json:",omitempty"`
+	Matrix      Matrix
+	ZIndex      int
+	Scopes      []int `json:",omitempty"`
+}
+
+type encodedScope struct {
+	Kind      scopeKind
+	Matrix    Matrix
+	Path      []float64 `json:",omitempty"`
+	FillRule  FillRule
+	Opacity   float64
+	BlendMode BlendMode
+	Mask      *encodedCanvas `json:",omitempty"`
+	MaskType  MaskType
+}
+
+type encodedStyle struct {
+	FillColor    color.RGBA
+	FillPaint    *encodedPaint `json:",omitempty"`
+	StrokeColor  color.RGBA
+	StrokePaint  *encodedPaint `json:",omitempty"`
+	StrokeWidth  float64
+	StrokeCapper string
+	StrokeJoiner *encodedJoiner `json:",omitempty"`
+	DashOffset   float64
+	Dashes       []float64
+	FillRule     FillRule
+}
+
+type encodedJoiner struct {
+	Kind  string
+	Gap   *encodedJoiner `json:",omitempty"`
+	Limit *float64       `json:",omitempty"` // nil for NaN
+}
+
+type encodedPaint struct {
+	Kind   string         // linear, radial, or pattern
+	Stops  []GradientStop `json:",omitempty"`
+	Spread GradientSpread
+	P0, P1 Point
+	R0, R1 float64
+	Cell   *encodedCanvas `json:",omitempty"`
+	W, H   float64
+	View   Matrix
+}
+
+type encodedText struct {
+	Lines []encodedLine
+}
+
+type encodedLine struct {
+	Spans []encodedSpan
+	Decos []encodedDeco
+	Y     float64
+}
+
+type encodedSpan struct {
+	Face            encodedFace
+	Text            string
+	Width           float64
+	Boundaries      [][3]int // kind, position, and size
+	Dx              float64
+	SentenceSpacing float64
+	WordSpacing     float64
+	GlyphSpacing    float64
+}
+
+type encodedDeco struct {
+	Face   encodedFace
+	X0, X1 float64
+}
+
+type encodedFace struct {
+	Family      int // -1 if not set
+	Font        int // -1 if not set
+	Size        float64
+	Style       FontStyle
+	Variant     FontVariant
+	Color       color.RGBA
+	Decorations []string `json:",omitempty"`
+	Scale       float64
+	Voffset     float64
+	FauxBold    float64
+	FauxItalic  float64
+}
+
+var cappers = map[string]Capper{
+	"Butt":   ButtCap,
+	"Round":  RoundCap,
+	"Square": SquareCap,
+}
+
+var fontDecorators = map[string]FontDecorator{
+	"Underline":         FontUnderline,
+	"Overline":          FontOverline,
+	"Strikethrough":     FontStrikethrough,
+	"DoubleUnderline":   FontDoubleUnderline,
+	"DottedUnderline":   FontDottedUnderline,
+	"DashedUnderline":   FontDashedUnderline,
+	"SineUnderline":     FontSineUnderline,
+	"SawtoothUnderline": FontSawtoothUnderline,
+}
+
+////////////////////////////////////////////////////////////////
+
+type canvasEncoder struct {
+	file     *encodedFile
+	fonts    map[*Font]int
+	families map[*FontFamily]int
+	images   map[image.Image]int
+}
+
+func encodeCanvasFile(c *Canvas) (*encodedFile, error) {
+	e := &canvasEncoder{
+		file:     &encodedFile{Version: encodingVersion},
+		fonts:    map[*Font]int{},
+		families: map[*FontFamily]int{},
+		images:   map[image.Image]int{},
+	}
+	canvas, err := e.canvas(c)
+	if err != nil {
+		return nil, err
+	}
+	e.file.Canvas = *canvas
+	return e.file, nil
+}
+
+func (e *canvasEncoder) canvas(c *Canvas) (*encodedCanvas, error) {
+	ec := &encodedCanvas{
+		W:      c.W,
+		H:      c.H,
+		ZIndex: c.zIndex,
+		ZPos:   c.zPos,
+	}
+
+	scopes := map[*scope]int{}
+	scopeIndices := func(ss []*scope) ([]int, error) {
+		indices := make([]int, len(ss))
+		for i, s := range ss {
+			index, ok := scopes[s]
+			if !ok {
+				es, err := e.scope(s)
+				if err != nil {
+					return nil, err
+				}
+				index = len(ec.Scopes)
+				ec.Scopes = append(ec.Scopes, es)
+				scopes[s] = index
+			}
+			indices[i] = index
+		}
+		return indices, nil
+	}
+
+	var err error
+	for _, l := range c.layers {
+		el := encodedLayer{
+			Matrix: l.m,
+			ZIndex: l.zIndex,
+		}
+		if el.Scopes, err = scopeIndices(l.scopes); err != nil {
+			return nil, err
+		}
+
+		if l.path != nil {
+			el.Kind = "path"
+			el.Path = l.path.d
+			if el.Style, err = e.style(l.style); err != nil {
+				return nil, err
+			}
+		} else if l.text != nil {
+			el.Kind = "text"
+			if el.Text, err = e.text(l.text); err != nil {
+				return nil, err
+			}
+		} else if l.img != nil {
+			el.Kind = "image"
+			if el.Image, err = e.image(l.img); err != nil {
+				return nil, err
+			}
+		} else if l.link != "" {
+			el.Kind = "link"
+			el.Link = l.link
+			el.Rect = l.rect
+		} else if l.dest != "" {
+			el.Kind = "destination"
+			el.Destination = l.dest
+		}
+		ec.Layers = append(ec.Layers, el)
+	}
+	if ec.Open, err = scopeIndices(c.scopes); err != nil {
+		return nil, err
+	}
+	return ec, nil
+}
+
+func (e *canvasEncoder) scope(s *scope) (encodedScope, error) {
+	es := encodedScope{
+		Kind:      s.kind,
+		Matrix:    s.m,
+		FillRule:  s.fillRule,
+		Opacity:   s.opacity,
+		BlendMode: s.blendMode,
+		MaskType:  s.maskType,
+	}
+	if s.path != nil {
+		es.Path = s.path.d
+	}
+	if s.mask != nil {
+		var err error
+		if es.Mask, err = e.canvas(s.mask); err != nil {
+			return encodedScope{}, err
+		}
+	}
+	return es, nil
+}
+
+func (e *canvasEncoder) style(style Style) (*encodedStyle, error) {
+	es := &encodedStyle{
+		FillColor:   style.FillColor,
+		StrokeColor: style.StrokeColor,
+		StrokeWidth: style.StrokeWidth,
+		DashOffset:  style.DashOffset,
+		Dashes:      style.Dashes,
+		FillRule:    style.FillRule,
+	}
+
+	var err error
+	if es.FillPaint, err = e.paint(style.FillPaint); err != nil {
+		return nil, err
+	} else if es.StrokePaint, err = e.paint(style.StrokePaint); err != nil {
+		return nil, err
+	}
+
+	if style.StrokeCapper != nil {
+		for name, capper := range cappers {
+			if style.StrokeCapper == capper {
+				es.StrokeCapper = name
+			}
+		}
+		if es.StrokeCapper == "" {
+			return nil, fmt.Errorf("unsupported capper %T", style.StrokeCapper)
+		}
+	}
+	if es.StrokeJoiner, err = e.joiner(style.StrokeJoiner); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+func (e *canvasEncoder) joiner(joiner Joiner) (*encodedJoiner, error) {
+	var gap Joiner
+	var limit float64
+	ej := &encodedJoiner{}
+	switch j := joiner.(type) {
+	case nil:
+		return nil, nil
+	case BevelJoiner:
+		ej.Kind = "Bevel"
+	case RoundJoiner:
+		ej.Kind = "Round"
+	case MiterJoiner:
+		ej.Kind = "Miter"
+		gap, limit = j.GapJoiner, j.Limit
+	case ArcsJoiner:
+		ej.Kind = "Arcs"
+		gap, limit = j.GapJoiner, j.Limit
+	default:
+		return nil, fmt.Errorf("unsupported joiner %T", joiner)
+	}
+
+	if ej.Kind == "Miter" || ej.Kind == "Arcs" {
+		var err error
+		if ej.Gap, err = e.joiner(gap); err != nil {
+			return nil, err
+		}
+		if !math.IsNaN(limit) {
+			ej.Limit = &limit
+		}
+	}
+	return ej, nil
+}
+
+func (e *canvasEncoder) paint(paint Paint) (*encodedPaint, error) {
+	switch p := paint.(type) {
+	case nil:
+		return nil, nil
+	case *LinearGradient:
+		return &encodedPaint{
+			Kind:   "linear",
+			Stops:  p.Stops,
+			Spread: p.Spread,
+			P0:     p.Start,
+			P1:     p.End,
+		}, nil
+	case *RadialGradient:
+		return &encodedPaint{
+			Kind:   "radial",
+			Stops:  p.Stops,
+			Spread: p.Spread,
+			P0:     p.C0,
+			P1:     p.C1,
+			R0:     p.R0,
+			R1:     p.R1,
+		}, nil
+	case *Pattern:
+		ep := &encodedPaint{
+			Kind: "pattern",
+			W:    p.W,
+			H:    p.H,
+			View: p.View,
+		}
+		if p.Cell != nil {
+			var err error
+			if ep.Cell, err = e.canvas(p.Cell); err != nil {
+				return nil, err
+			}
+		}
+		return ep, nil
+	}
+	return nil, fmt.Errorf("unsupported paint %T", paint)
+}
+
+func (e *canvasEncoder) text(text *Text) (*encodedText, error) {
+	et := &encodedText{}
+	for _, line := range text.lines {
+		el := encodedLine{Y: line.y}
+		for _, span := range line.spans {
+			face, err := e.face(span.Face)
+			if err != nil {
+				return nil, err
+			}
+			boundaries := make([][3]int, len(span.boundaries))
+			for i, boundary := range span.boundaries {
+				boundaries[i] = [3]int{int(boundary.kind), boundary.pos, boundary.size}
+			}
+			el.Spans = append(el.Spans, encodedSpan{
+				Face:            face,
+				Text:            span.Text,
+				Width:           span.width,
+				Boundaries:      boundaries,
+				Dx:              span.dx,
+				SentenceSpacing: span.SentenceSpacing,
+				WordSpacing:     span.WordSpacing,
+				GlyphSpacing:    span.GlyphSpacing,
+			})
+		}
+		for _, deco := range line.decos {
+			face, err := e.face(deco.face)
+			if err != nil {
+				return nil, err
+			}
+			el.Decos = append(el.Decos, encodedDeco{
+				Face: face,
+				X0:   deco.x0,
+				X1:   deco.x1,
+			})
+		}
+		et.Lines = append(et.Lines, el)
+	}
+	return et, nil
+}
+
+func (e *canvasEncoder) face(ff FontFace) (encodedFace, error) {
+	ef := encodedFace{
+		Family:     -1,
+		Font:       -1,
+		Size:       ff.Size,
+		Style:      ff.Style,
+		Variant:    ff.Variant,
+		Color:      ff.Color,
+		Scale:      ff.Scale,
+		Voffset:    ff.Voffset,
+		FauxBold:   ff.FauxBold,
+		FauxItalic: ff.FauxItalic,
+	}
+	if ff.Font != nil {
+		ef.Font = e.font(ff.Font)
+	}
+	if ff.family != nil {
+		ef.Family = e.family(ff.family, ff.Font)
+	}
+	for _, deco := range ff.deco {
+		name := ""
+		for decoName, decorator := range fontDecorators {
+			if deco == decorator {
+				name = decoName
+			}
+		}
+		if name == "" {
+			return encodedFace{}, fmt.Errorf("unsupported font decorator %T", deco)
+		}
+		ef.Decorations = append(ef.Decorations, name)
+	}
+	return ef, nil
+}
+
+func (e *canvasEncoder) font(font *Font) int {
+	index, ok := e.fonts[font]
+	if !ok {
+		index = len(e.file.Fonts)
+		e.file.Fonts = append(e.file.Fonts, encodedFont{
+			Name:      font.name,
+			Data:      font.raw,
+			Ligatures: 0 < len(font.ligatures),
+		})
+		e.fonts[font] = index
+	}
+	return index
+}
+
+// family adds the family and the font when it belongs to the family.
+func (e *canvasEncoder) family(family *FontFamily, font *Font) int {
+	index, ok := e.families[family]
+	if !ok {
+		index = len(e.file.Families)
+		e.file.Families = append(e.file.Families, encodedFamily{
+			Name:    family.name,
+			Options: family.options,
+		})
+		e.families[family] = index
+	}
+
+	for style, familyFont := range family.fonts {
+		if familyFont == font {
+			ef := encodedFamilyFont{style, e.font(font)}
+			for _, existing := range e.file.Families[index].Fonts {
+				if existing == ef {
+					return index
+				}
+			}
+			e.file.Families[index].Fonts = append(e.file.Families[index].Fonts, ef)
+		}
+	}
+	return index
+}
+
+func (e *canvasEncoder) image(img image.Image) (int, error) {
+	comparable := reflect.TypeOf(img).Comparable()
+	if comparable {
+		if index, ok := e.images[img]; ok {
+			return index, nil
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return 0, err
+	}
+	index := len(e.file.Images)
+	e.file.Images = append(e.file.Images, buf.Bytes())
+	if comparable {
+		e.images[img] = index
+	}
+	return index, nil
+}
+
+////////////////////////////////////////////////////////////////
+
+type canvasDecoder struct {
+	file     *encodedFile
+	fonts    []*Font
+	families []*FontFamily
+	images   []image.Image
+}
+
+func decodeCanvasFile(c *Canvas, file *encodedFile) error {
+	if file.Version < 1 || encodingVersion < file.Version {
+		return fmt.Errorf("unsupported canvas encoding version %d", file.Version)
+	}
+
+	d := &canvasDecoder{file: file}
+	for _, ef := range file.Fonts {
+		font, err := parseFont(ef.Name, ef.Data)
+		if err != nil {
+			return err
+		}
+		if ef.Ligatures {
+			font.Use(CommonLigatures)
+		}
+		d.fonts = append(d.fonts, font)
+	}
+	for _, ef := range file.Families {
+		family := NewFontFamily(ef.Name)
+		family.options = ef.Options
+		for _, familyFont := range ef.Fonts {
+			font, err := d.font(familyFont.Font)
+			if err != nil {
+				return err
+			}
+			family.fonts[familyFont.Style] = font
+		}
+		d.families = append(d.families, family)
+	}
+	for _, b := range file.Images {
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		d.images = append(d.images, img)
+	}
+
+	decoded, err := d.canvas(&file.Canvas)
+	if err != nil {
+		return err
+	}
+	*c = *decoded
+	return nil
+}
+
+func (d *canvasDecoder) canvas(ec *encodedCanvas) (*Canvas, error) {
+	c := New(ec.W, ec.H)
+	scopes := make([]*scope, len(ec.Scopes))
+	for i, es := range ec.Scopes {
+		s, err := d.scope(es)
+		if err != nil {
+			return nil, err
+		}
+		scopes[i] = s
+	}
+	scopeList := func(indices []int) ([]*scope, error) {
+		ss := make([]*scope, len(indices))
+		for i, index := range indices {
+			if index < 0 || len(scopes) <= index {
+				return nil, fmt.Errorf("invalid scope index %d", index)
+			}
+			ss[i] = scopes[index]
+		}
+		return ss, nil
+	}
+
+	var err error
+	for _, el := range ec.Layers {
+		l := layer{
+			m:      el.Matrix,
+			zIndex: el.ZIndex,
+		}
+		if l.scopes, err = scopeList(el.Scopes); err != nil {
+			return nil, err
+		}
+
+		switch el.Kind {
+		case "path":
+			if l.path, err = decodePath(el.Path); err != nil {
+				return nil, err
+			} else if el.Style == nil {
+				return nil, fmt.Errorf("path layer without style")
+			} else if l.style, err = d.style(el.Style); err != nil {
+				return nil, err
+			}
+		case "text":
+			if el.Text == nil {
+				return nil, fmt.Errorf("text layer without text")
+			} else if l.text, err = d.text(el.Text); err != nil {
+				return nil, err
+			}
+		case "image":
+			if el.Image < 0 || len(d.images) <= el.Image {
+				return nil, fmt.Errorf("invalid image index %d", el.Image)
+			}
+			l.img = d.images[el.Image]
+		case "link":
+			l.link = el.Link
+			l.rect = el.Rect
+		case "destination":
+			l.dest = el.Destination
+		default:
+			return nil, fmt.Errorf("unknown layer kind '%s'", el.Kind)
+		}
+		bounds, visible := l.computeBounds()
+		l.bounds, l.clipped = bounds, !visible
+		c.layers = append(c.layers, l)
+	}
+	if c.scopes, err = scopeList(ec.Open); err != nil {
+		return nil, err
+	}
+	if ec.ZPos < 0 || len(c.layers) < ec.ZPos {
+		return nil, fmt.Errorf("invalid z-index position %d", ec.ZPos)
+	}
+	c.zIndex, c.zPos = ec.ZIndex, ec.ZPos
+	return c, nil
+}
+
+func (d *canvasDecoder) scope(es encodedScope) (*scope, error) {
+	s := &scope{
+		kind:      es.Kind,
+		m:         es.Matrix,
+		fillRule:  es.FillRule,
+		opacity:   es.Opacity,
+		blendMode: es.BlendMode,
+		maskType:  es.MaskType,
+	}
+
+	var err error
+	switch es.Kind {
+	case clipScope:
+		s.path, err = decodePath(es.Path)
+	case groupScope:
+	case maskScope:
+		if es.Mask == nil {
+			return nil, fmt.Errorf("mask without canvas")
+		}
+		s.mask, err = d.canvas(es.Mask)
+	default:
+		return nil, fmt.Errorf("unknown scope kind %d", es.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// decodePath returns the path of the path data and verifies that it is well-formed.
+func decodePath(d []float64) (*Path, error) {
+	for i := 0; i < len(d); {
+		cmd := d[i]
+		if cmd != moveToCmd && cmd != lineToCmd && cmd != quadToCmd && cmd != cubeToCmd && cmd != arcToCmd && cmd != closeCmd {
+			return nil, fmt.Errorf("invalid path data")
+		}
+		i += cmdLen(cmd)
+		if len(d) < i || d[i-1] != cmd {
+			return nil, fmt.Errorf("invalid path data")
+		}
+	}
+	return &Path{d: d}, nil
+}
+
+func (d *canvasDecoder) style(es *encodedStyle) (Style, error) {
+	style := Style{
+		FillColor:   es.FillColor,
+		StrokeColor: es.StrokeColor,
+		StrokeWidth: es.StrokeWidth,
+		DashOffset:  es.DashOffset,
+		Dashes:      es.Dashes,
+		FillRule:    es.FillRule,
+	}
+	if style.Dashes == nil {
+		style.Dashes = []float64{}
+	}
+
+	var err error
+	if style.FillPaint, err = d.paint(es.FillPaint); err != nil {
+		return Style{}, err
+	} else if style.StrokePaint, err = d.paint(es.StrokePaint); err != nil {
+		return Style{}, err
+	}
+
+	if es.StrokeCapper != "" {
+		var ok bool
+		if style.StrokeCapper, ok = cappers[es.StrokeCapper]; !ok {
+			return Style{}, fmt.Errorf("unknown capper '%s'", es.StrokeCapper)
+		}
+	}
+	if style.StrokeJoiner, err = d.joiner(es.StrokeJoiner); err != nil {
+		return Style{}, err
+	}
+	return style, nil
+}
+
+func (d *canvasDecoder) joiner(ej *encodedJoiner) (Joiner, error) {
+	if ej == nil {
+		return nil, nil
+	}
+
+	limit := math.NaN()
+	if ej.Limit != nil {
+		limit = *ej.Limit
+	}
+	switch ej.Kind {
+	case "Bevel":
+		return BevelJoin, nil
+	case "Round":
+		return RoundJoin, nil
+	case "Miter", "Arcs":
+		gap, err := d.joiner(ej.Gap)
+		if err != nil {
+			return nil, err
+		} else if ej.Kind == "Miter" {
+			return MiterJoiner{gap, limit}, nil
+		}
+		return ArcsJoiner{gap, limit}, nil
+	}
+	return nil, fmt.Errorf("unknown joiner '%s'", ej.Kind)
+}
+
+func (d *canvasDecoder) paint(ep *encodedPaint) (Paint, error) {
+	if ep == nil {
+		return nil, nil
+	}
+
+	switch ep.Kind {
+	case "linear":
+		return &LinearGradient{
+			Start:    ep.P0,
+			End:      ep.P1,
+			Gradient: Gradient{ep.Stops, ep.Spread},
+		}, nil
+	case "radial":
+		return &RadialGradient{
+			C0:       ep.P0,
+			C1:       ep.P1,
+			R0:       ep.R0,
+			R1:       ep.R1,
+			Gradient: Gradient{ep.Stops, ep.Spread},
+		}, nil
+	case "pattern":
+		p := &Pattern{
+			W:    ep.W,
+			H:    ep.H,
+			View: ep.View,
+		}
+		if ep.Cell != nil {
+			var err error
+			if p.Cell, err = d.canvas(ep.Cell); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown paint '%s'", ep.Kind)
+}
+
+func (d *canvasDecoder) text(et *encodedText) (*Text, error) {
+	text := &Text{
+		lines: []line{},
+		fonts: map[*Font]bool{},
+	}
+	for _, el := range et.Lines {
+		l := line{
+			spans: []TextSpan{},
+			decos: []decoSpan{},
+			y:     el.Y,
+		}
+		for _, es := range el.Spans {
+			face, err := d.face(es.Face)
+			if err != nil {
+				return nil, err
+			} else if face.Font == nil {
+				return nil, fmt.Errorf("text span without font")
+			}
+			text.fonts[face.Font] = true
+
+			boundaries := make([]textBoundary, len(es.Boundaries))
+			for i, boundary := range es.Boundaries {
+				boundaries[i] = textBoundary{textBoundaryKind(boundary[0]), boundary[1], boundary[2]}
+			}
+			l.spans = append(l.spans, TextSpan{
+				Face:            face,
+				Text:            es.Text,
+				width:           es.Width,
+				boundaries:      boundaries,
+				dx:              es.Dx,
+				SentenceSpacing: es.SentenceSpacing,
+				WordSpacing:     es.WordSpacing,
+				GlyphSpacing:    es.GlyphSpacing,
+			})
+		}
+		for _, ed := range el.Decos {
+			face, err := d.face(ed.Face)
+			if err != nil {
+				return nil, err
+			}
+			l.decos = append(l.decos, decoSpan{face, ed.X0, ed.X1})
+		}
+		text.lines = append(text.lines, l)
+	}
+	return text, nil
+}
+
+func (d *canvasDecoder) face(ef encodedFace) (FontFace, error) {
+	ff := FontFace{
+		Size:       ef.Size,
+		Style:      ef.Style,
+		Variant:    ef.Variant,
+		Color:      ef.Color,
+		Scale:      ef.Scale,
+		Voffset:    ef.Voffset,
+		FauxBold:   ef.FauxBold,
+		FauxItalic: ef.FauxItalic,
+	}
+
+	var err error
+	if ef.Font != -1 {
+		if ff.Font, err = d.font(ef.Font); err != nil {
+			return FontFace{}, err
+		}
+	}
+	if ef.Family != -1 {
+		if ef.Family < 0 || len(d.families) <= ef.Family {
+			return FontFace{}, fmt.Errorf("invalid font family index %d", ef.Family)
+		}
+		ff.family = d.families[ef.Family]
+	}
+	for _, name := range ef.Decorations {
+		deco, ok := fontDecorators[name]
+		if !ok {
+			return FontFace{}, fmt.Errorf("unknown font decorator '%s'", name)
+		}
+		ff.deco = append(ff.deco, deco)
+	}
+	return ff, nil
+}
+
+func (d *canvasDecoder) font(index int) (*Font, error) {
+	if index < 0 || len(d.fonts) <= index {
+		return nil, fmt.Errorf("invalid font index %d", index)
+	}
+	return d.fonts[index], nil
+}
@@ -0,0 +1,146 @@
+package canvas
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func testEncodingCanvas() *Canvas {
+	dejaVuSerif := NewFontFamily("dejavu-serif")
+	dejaVuSerif.LoadFontFile("font/DejaVuSerif.ttf", FontRegular)
+	face := dejaVuSerif.Face(10.0, Green, FontItalic, FontNormal, FontUnderline)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, Black)
+	img.Set(1, 0, Red)
+	img.Set(0, 1, Green)
+	img.Set(1, 1, Blue)
+
+	gradient := NewLinearGradient(0.0, 0.0, 10.0, 0.0)
+	gradient.Add(0.0, Red)
+	gradient.Add(1.0, Blue)
+
+	mask := New(20.0, 20.0)
+	mask.RenderPath(Circle(5.0), DefaultStyle, Identity.Translate(10.0, 10.0))
+
+	c := New(100.0, 100.0)
+	ctx := NewContext(c)
+	ctx.SetFillPaint(gradient)
+	ctx.SetStrokeColor(Gray)
+	ctx.SetStrokeCapper(RoundCap)
+	ctx.SetStrokeJoiner(MiterClipJoin(ArcsJoin, math.NaN()))
+	ctx.SetDashes(1.0, 2.0, 3.0)
+	ctx.Clip(Rectangle(80.0, 80.0))
+	ctx.DrawPath(10.0, 10.0, MustParseSVG("M0 0L20 0Q25 10 30 0C30 10 40 10 40 0A5 5 0 0 0 50 0z"))
+	ctx.PushGroup(0.5, MultiplyBlend)
+	ctx.DrawText(30.0, 30.0, NewTextLine(face, "Text", Left))
+	ctx.DrawImage(50.0, 50.0, img, 1.0)
+	ctx.DrawImage(60.0, 60.0, img, 1.0)
+	ctx.PopGroup()
+	ctx.PushMask(mask, LuminanceMask)
+	ctx.ResetStyle()
+	ctx.SetFillPaint(NewDotPattern(Red, 2.0, 0.5))
+	ctx.DrawPath(0.0, 0.0, Rectangle(20.0, 20.0))
+	ctx.AddLink(0.0, 0.0, 10.0, 10.0, "https://example.com")
+	ctx.AddDestination(5.0, 5.0, "dest")
+	ctx.SetZIndex(-1)
+	ctx.DrawPath(0.0, 0.0, Rectangle(100.0, 100.0))
+	return c
+}
+
+func TestCanvasEncoding(t *testing.T) {
+	c := testEncodingCanvas()
+	b, err := c.MarshalJSON()
+	test.Error(t, err)
+
+	c2, err := Read(bytes.NewReader(b))
+	test.Error(t, err)
+	b2, err := c2.MarshalJSON()
+	test.Error(t, err)
+	test.String(t, string(b2), string(b))
+
+	test.T(t, c2.W, c.W)
+	test.T(t, c2.H, c.H)
+	test.T(t, len(c2.layers), len(c.layers))
+	test.T(t, c2.zIndex, -1)
+	test.T(t, c2.zPos, 1)
+	test.T(t, len(c2.scopes), 2) // clip and mask
+	for i, l := range c2.layers {
+		test.T(t, l.bounds, c.layers[i].bounds)
+		test.T(t, l.zIndex, c.layers[i].zIndex)
+		test.T(t, l.m, c.layers[i].m)
+	}
+
+	// path
+	l := c2.layers[1]
+	test.T(t, l.path.String(), c.layers[1].path.String())
+	test.T(t, l.style.FillPaint, c.layers[1].style.FillPaint)
+	test.T(t, l.style.StrokeCapper, RoundCap)
+	test.T(t, l.style.StrokeJoiner.(MiterJoiner).GapJoiner, ArcsJoin)
+	test.That(t, math.IsNaN(l.style.StrokeJoiner.(MiterJoiner).Limit))
+	test.T(t, l.style.Dashes, []float64{2.0, 3.0})
+	test.T(t, l.scopes[0], c2.scopes[0]) // shares the clipping path
+
+	// text
+	text, text2 := c.layers[2].text, c2.layers[2].text
+	test.T(t, text2.Bounds(), text.Bounds())
+	paths, colors := text.ToPaths()
+	paths2, colors2 := text2.ToPaths()
+	test.T(t, colors2, colors)
+	for i := range paths {
+		test.T(t, paths2[i].String(), paths[i].String())
+	}
+	test.T(t, text2.lines[0].decos[0].face.deco, []FontDecorator{FontUnderline})
+	test.T(t, text2.MostCommonFontFace().family.Name(), "dejavu-serif")
+
+	// images are stored once
+	test.T(t, c2.layers[3].img, c2.layers[4].img)
+	test.T(t, rgbaColor(c2.layers[3].img.At(1, 1)), Blue)
+	test.T(t, c2.layers[3].scopes[1].opacity, 0.5)
+
+	// pattern and mask
+	test.T(t, c2.layers[5].style.FillPaint.(*Pattern).Cell.layers[0].path.String(), c.layers[5].style.FillPaint.(*Pattern).Cell.layers[0].path.String())
+	test.T(t, len(c2.layers[5].scopes[1].mask.layers), 1)
+	test.T(t, c2.layers[6].link, "https://example.com")
+	test.T(t, c2.layers[7].dest, "dest")
+}
+
+func TestCanvasEncodingBinary(t *testing.T) {
+	c := testEncodingCanvas()
+	json, err := c.MarshalJSON()
+	test.Error(t, err)
+
+	buf := &bytes.Buffer{}
+	test.Error(t, BinaryWriter(buf, c))
+	test.That(t, buf.Len() < len(json), "binary encoding is smaller")
+
+	c2, err := Read(buf)
+	test.Error(t, err)
+	json2, err := c2.MarshalJSON()
+	test.Error(t, err)
+	test.String(t, string(json2), string(json))
+}
+
+func TestCanvasEncodingErrors(t *testing.T) {
+	c := New(10.0, 10.0)
+	style := DefaultStyle
+	style.StrokeJoiner = nil // unset cappers and joiners are allowed
+	c.RenderPath(Rectangle(5.0, 5.0), style, Identity)
+	_, err := c.MarshalJSON()
+	test.Error(t, err)
+
+	type customCapper struct{ ButtCapper }
+	style.StrokeCapper = customCapper{}
+	c.RenderPath(Rectangle(5.0, 5.0), style, Identity)
+	_, err = c.MarshalJSON()
+	test.T(t, err.Error(), "unsupported capper canvas.customCapper")
+
+	var c2 Canvas
+	test.T(t, c2.UnmarshalJSON([]byte(`{"Version":2}`)).Error(), "unsupported canvas encoding version 2")
+	test.T(t, c2.UnmarshalJSON([]byte(`{"Version":1,"Canvas":{"Layers":[{"Kind":"path","Path":[2,1,1],"Style":{}}]}}`)).Error(), "invalid path data")
+	test.T(t, c2.UnmarshalBinary([]byte("CANVAS")).Error(), "invalid canvas encoding")
+}
@@ -1,7 +1,9 @@
 package canvas
 
 import (
+	"fmt"
 	"image"
+	"math"
 	"testing"
 
 	"github.com/tdewolff/test"
@@ -36,8 +38,8 @@ func TestCanvas(t *testing.T) {
 	ctx.DrawImage(50.0, 50.0, img, 0.1) // 20x20 => -20x40
 
 	c.Fit(6.0)
-	test.Float(t, c.W, 72.5) // img upper bound - (path lower bound - path half stroke width) + margin
-	test.Float(t, c.H, 113)  // path bounds + path stroke width + margin
+	test.Float(t, c.W, 72.5)  // img upper bound - (path lower bound - path half stroke width) + margin
+	test.Float(t, c.H, 112.5) // bounds + half the path stroke width + margin
 
 	// draw a yellow background
 	ctx.SetZIndex(-100)
@@ -75,3 +77,252 @@ func TestCanvasFit(t *testing.T) {
 	test.Float(t, c.W, 20)
 	test.Float(t, c.H, 20)
 }
+
+type recorder struct {
+	ops []string
+}
+
+func (r *recorder) Size() (float64, float64) {
+	return 100.0, 100.0
+}
+
+func (r *recorder) RenderPath(path *Path, style Style, m Matrix) {
+	r.ops = append(r.ops, "path")
+}
+
+func (r *recorder) RenderText(text *Text, m Matrix) {
+	r.ops = append(r.ops, "text")
+}
+
+func (r *recorder) RenderImage(img image.Image, m Matrix) {
+	r.ops = append(r.ops, "image")
+}
+
+func (r *recorder) PushClip(path *Path, fillRule FillRule, m Matrix) {
+	r.ops = append(r.ops, "clip "+path.Transform(m).String())
+}
+
+func (r *recorder) PopClip() {
+	r.ops = append(r.ops, "pop")
+}
+
+func (r *recorder) PushGroup(opacity float64, blendMode BlendMode) {
+	r.ops = append(r.ops, fmt.Sprintf("group %v %v", opacity, blendMode))
+}
+
+func (r *recorder) PopGroup() {
+	r.ops = append(r.ops, "end")
+}
+
+func (r *recorder) PushMask(mask *Canvas, maskType MaskType, m Matrix) {
+	r.ops = append(r.ops, fmt.Sprintf("mask %v %v", maskType, m.Dot(Point{0.0, 0.0})))
+}
+
+func (r *recorder) PopMask() {
+	r.ops = append(r.ops, "unmask")
+}
+
+type linkRecorder struct {
+	recorder
+}
+
+func (r *linkRecorder) RenderLink(uri string, rect Rect, m Matrix) {
+	r.ops = append(r.ops, fmt.Sprintf("link %v %v", uri, rect.Transform(m)))
+}
+
+func (r *linkRecorder) RenderDestination(name string, pos Point, m Matrix) {
+	r.ops = append(r.ops, fmt.Sprintf("dest %v %v", name, m.Dot(pos)))
+}
+
+func TestCanvasLink(t *testing.T) {
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.DrawPath(20, 20, Rectangle(10, 10))
+	ctx.AddLink(0, 0, 10, 5, "https://example.com/")
+	ctx.AddDestination(50, 50, "end")
+	ctx.AddLink(20, 20, 10, 10, "#end")
+
+	// links and destinations are not visible
+	c.Fit(0.0)
+	test.Float(t, c.W, 10.0)
+	test.Float(t, c.H, 10.0)
+
+	r := &linkRecorder{}
+	c.Render(r)
+	test.T(t, r.ops, []string{"path", "link https://example.com/ (-20,-20)-(-10,-15)", "dest end (30,30)", "link #end (0,0)-(10,10)"})
+
+	// ignored by renderers that do not support links
+	r2 := &recorder{}
+	c.Render(r2)
+	test.T(t, r2.ops, []string{"path"})
+}
+
+func TestCanvasClip(t *testing.T) {
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.Push()
+	ctx.Clip(Rectangle(10, 10))
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.Push()
+	ctx.Clip(Rectangle(5, 20))
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.Pop()
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.Pop()
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.SetZIndex(-1)
+	ctx.Clip(Rectangle(1, 1))
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+
+	r := &recorder{}
+	c.Render(r)
+	test.T(t, r.ops, []string{
+		"clip M0 0L1 0L1 1L0 1z", "path", "pop",
+		"clip M0 0L10 0L10 10L0 10z", "path",
+		"clip M0 0L5 0L5 20L0 20z", "path", "pop",
+		"path", "pop",
+		"path",
+	})
+
+	// clipped bounds
+	c.Fit(0.0)
+	test.Float(t, c.W, 20.0)
+	test.Float(t, c.H, 20.0)
+
+	c.Reset()
+	ctx.Clip(Rectangle(10, 10).Translate(5, 5))
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	c.Fit(1.0)
+	test.Float(t, c.W, 12.0)
+	test.Float(t, c.H, 12.0)
+
+	r = &recorder{}
+	c.Render(r)
+	test.T(t, r.ops, []string{"clip M1 1L11 1L11 11L1 11z", "path", "pop"})
+}
+
+func TestCanvasGroup(t *testing.T) {
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.PushGroup(0.5, MultiplyBlend)
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.Clip(Rectangle(10, 10))
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.PopGroup() // removes clip as well
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.Push()
+	ctx.PushGroup(1.0, NormalBlend)
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.Pop()      // ends group
+	ctx.PopGroup() // does nothing
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+
+	r := &recorder{}
+	c.Render(r)
+	test.T(t, r.ops, []string{
+		"group 0.5 Multiply", "path",
+		"clip M0 0L10 0L10 10L0 10z", "path", "pop",
+		"end", "path",
+		"group 1 Normal", "path", "end",
+		"path",
+	})
+}
+
+func TestCanvasMask(t *testing.T) {
+	mask := New(10, 10)
+	mask.RenderPath(Rectangle(10, 10), DefaultStyle, Identity)
+
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.SetView(Identity.Translate(5, 5))
+	ctx.PushMask(mask, AlphaMask)
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.PushGroup(0.5, NormalBlend)
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+	ctx.PopMask() // ends group as well
+	ctx.DrawPath(0, 0, Rectangle(20, 20))
+
+	r := &recorder{}
+	c.Render(r)
+	test.T(t, r.ops, []string{
+		"mask Alpha (5,5)", "path",
+		"group 0.5 Normal", "path", "end",
+		"unmask", "path",
+	})
+}
+
+func TestCanvasQuery(t *testing.T) {
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.DrawPath(0, 0, Rectangle(10, 10))
+	ctx.DrawPath(50, 50, Rectangle(10, 10))
+	ctx.SetStrokeColor(Black)
+	ctx.SetStrokeWidth(2.0)
+	ctx.DrawPath(20, 0, MustParseSVG("V10")) // zero width
+	ctx.AddDestination(80, 80, "end")
+	ctx.SetZIndex(-1)
+	ctx.DrawPath(5, 5, Rectangle(10, 10))
+	ctx.SetZIndex(0)
+	ctx.Clip(Rectangle(5, 5))
+	ctx.DrawPath(60, 60, Rectangle(10, 10)) // clipped entirely
+
+	indices := func(hits []Hit) []int {
+		is := []int{}
+		for _, hit := range hits {
+			is = append(is, hit.Index)
+		}
+		return is
+	}
+	test.T(t, indices(c.Query(Rect{X: 8.0, Y: 8.0, W: 1.0, H: 1.0})), []int{0, 1})
+	test.T(t, indices(c.Query(Rect{X: 20.5, Y: 5.0, W: 0.0, H: 0.0})), []int{3})
+	test.T(t, indices(c.Query(Rect{X: 55.0, Y: 55.0, W: 20.0, H: 20.0})), []int{2})
+	test.T(t, indices(c.Query(Rect{X: 0.0, Y: 0.0, W: 100.0, H: 100.0})), []int{0, 1, 2, 3, 4})
+
+	hits := c.Query(Rect{X: 80.0, Y: 80.0})
+	test.T(t, len(hits), 1)
+	test.String(t, hits[0].Destination, "end")
+	test.T(t, hits[0].Bounds, Rect{X: 80.0, Y: 80.0})
+
+	hits = c.Query(Rect{X: 19.0, Y: -1.0, W: 2.0, H: 12.0})
+	test.T(t, len(hits), 1)
+	test.T(t, hits[0].Bounds, Rect{X: 18.0, Y: -2.0, W: 4.0, H: 14.0}) // reach of the miter limit
+	test.T(t, hits[0].Style.StrokeWidth, 2.0)
+
+	// the index is rebuilt after changes
+	c.Fit(1.0)
+	test.T(t, indices(c.Query(Rect{X: 1.5, Y: 10.5, W: 0.5, H: 0.5})), []int{1}) // moved by the margin
+}
+
+func TestCanvasQueryStroke(t *testing.T) {
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.SetFillColor(Transparent)
+	ctx.SetStrokeColor(Black)
+	ctx.SetStrokeWidth(4.0)
+	ctx.SetStrokeJoiner(MiterJoin)
+	ctx.DrawPath(0, 0, MustParseSVG("M10 10L50 50L10 90")) // miter reaches x=50+2*sqrt(2)
+
+	test.T(t, len(c.Query(Rect{X: 52.3, Y: 49.9, W: 0.2, H: 0.2})), 1)
+	test.T(t, len(c.Query(Rect{X: 54.1, Y: 49.9, W: 0.2, H: 0.2})), 0) // beyond the miter limit
+
+	ctx.SetStrokeCapper(SquareCap)
+	ctx.SetStrokeJoiner(BevelJoin)
+	ctx.DrawPath(0, 0, MustParseSVG("M70 10L90 30")) // corner of the cap reaches y=10-sqrt(2)
+	test.T(t, len(c.Query(Rect{X: 70.0, Y: 8.7, W: 0.2, H: 0.2})), 1)
+
+	ctx.SetStrokeJoiner(MiterClipJoin(BevelJoin, math.NaN()))
+	ctx.DrawPath(0, 0, MustParseSVG("M10 10L90 12L10 14")) // unlimited miter reaches far beyond x=90
+	test.T(t, len(c.Query(Rect{X: 150.0, Y: 11.9, W: 0.2, H: 0.2})), 1)
+}
+
+func TestCanvasRenderRect(t *testing.T) {
+	c := New(100, 100)
+	ctx := NewContext(c)
+	ctx.DrawPath(0, 0, Rectangle(10, 10))
+	ctx.AddDestination(50, 50, "center")
+	ctx.DrawPath(90, 90, Rectangle(10, 10))
+
+	r := &linkRecorder{}
+	c.RenderRect(r, Rect{X: 40.0, Y: 40.0, W: 60.0, H: 60.0})
+	test.T(t, r.ops, []string{"dest center (10,10)", "path"})
+}
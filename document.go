@@ -0,0 +1,92 @@
+package canvas
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNoPages is returned when writing a document without pages.
+var ErrNoPages = errors.New("document has no pages")
+
+// Page is a page of a document with its own size and metadata.
+type Page struct {
+	*Canvas
+	Title string // used for the document outline if not empty
+}
+
+// Document is an ordered list of pages that can be written to multi-page formats such as PDF and PostScript, or to a series of files for single-page formats.
+type Document struct {
+	Pages    []*Page
+	Title    string
+	Subject  string
+	Keywords string
+	Author   string
+}
+
+// NewDocument returns a new document without pages.
+func NewDocument() *Document {
+	return &Document{}
+}
+
+// NewPage appends a new page of the given size in mm and returns its canvas for drawing.
+func (d *Document) NewPage(width, height float64) *Canvas {
+	c := New(width, height)
+	d.AddPage(c, "")
+	return c
+}
+
+// AddPage appends a canvas as a page with the given title, which may be empty.
+func (d *Document) AddPage(c *Canvas, title string) *Page {
+	page := &Page{
+		Canvas: c,
+		Title:  title,
+	}
+	d.Pages = append(d.Pages, page)
+	return page
+}
+
+// Size returns the largest width and height in mm over all pages.
+func (d *Document) Size() (float64, float64) {
+	width, height := 0.0, 0.0
+	for _, page := range d.Pages {
+		if width < page.W {
+			width = page.W
+		}
+		if height < page.H {
+			height = page.H
+		}
+	}
+	return width, height
+}
+
+// DocumentWriter can write a document to a writer
+type DocumentWriter func(w io.Writer, d *Document) error
+
+// WriteFile writes the document to a file named by filename using the given DocumentWriter (for the encoding).
+func (d *Document) WriteFile(filename string, w DocumentWriter) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err = w(f, d); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteFiles writes each page to its own file using the given Writer (for the encoding), where the filenames are formatted from pattern with the page number starting at one, such as "page-%d.png".
+func (d *Document) WriteFiles(pattern string, w Writer) error {
+	if len(d.Pages) == 0 {
+		return ErrNoPages
+	}
+	for i, page := range d.Pages {
+		if err := page.WriteFile(fmt.Sprintf(pattern, i+1), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
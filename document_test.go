@@ -0,0 +1,43 @@
+package canvas
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestDocument(t *testing.T) {
+	d := NewDocument()
+	d.NewPage(210.0, 297.0)
+	d.AddPage(New(297.0, 100.0), "Landscape")
+	test.T(t, len(d.Pages), 2)
+	test.String(t, d.Pages[1].Title, "Landscape")
+
+	w, h := d.Size()
+	test.Float(t, w, 297.0)
+	test.Float(t, h, 297.0)
+
+	dir, err := ioutil.TempDir("", "canvas")
+	test.Error(t, err)
+	defer os.RemoveAll(dir)
+
+	sizes := []float64{}
+	err = d.WriteFiles(filepath.Join(dir, "page-%d.txt"), func(w io.Writer, c *Canvas) error {
+		sizes = append(sizes, c.W)
+		_, err := w.Write([]byte("page"))
+		return err
+	})
+	test.Error(t, err)
+	test.T(t, sizes, []float64{210.0, 297.0})
+	for _, name := range []string{"page-1.txt", "page-2.txt"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		test.Error(t, err)
+		test.String(t, string(b), "page")
+	}
+
+	test.T(t, NewDocument().WriteFiles(filepath.Join(dir, "page-%d.txt"), nil), ErrNoPages)
+}
@@ -0,0 +1,61 @@
+package eps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/tiff"
+)
+
+// writeEPSIPreview writes the image as an 8-bit grayscale bitmap in the comments, where zero is white.
+func writeEPSIPreview(w io.Writer, img image.Image) {
+	size := img.Bounds().Size()
+	sp := img.Bounds().Min // starting point
+	bytesPerLine := 32
+	linesPerRow := (size.X + bytesPerLine - 1) / bytesPerLine
+
+	fmt.Fprintf(w, "%%%%BeginPreview: %d %d 8 %d\n", size.X, size.Y, size.Y*linesPerRow)
+	row := make([]byte, size.X)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			// luminance composited on white
+			R, G, B, A := img.At(sp.X+x, sp.Y+y).RGBA()
+			lum := (19595*(R+0xFFFF-A) + 38470*(G+0xFFFF-A) + 7471*(B+0xFFFF-A) + 1<<15) >> 24
+			row[x] = 255 - byte(lum)
+		}
+		for i := 0; i < len(row); i += bytesPerLine {
+			j := i + bytesPerLine
+			if len(row) < j {
+				j = len(row)
+			}
+			fmt.Fprintf(w, "%% %X\n", row[i:j])
+		}
+	}
+	fmt.Fprintf(w, "%%%%EndPreview\n")
+}
+
+// writeTIFFPreview writes a DOS EPS binary file with the PostScript program followed by the image in the TIFF format.
+func writeTIFFPreview(w io.Writer, ps []byte, img image.Image) error {
+	preview := &bytes.Buffer{}
+	if err := tiff.Encode(preview, img, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		return err
+	}
+
+	header := make([]byte, 30)
+	binary.LittleEndian.PutUint32(header[0:], 0xC6D3D0C5)
+	binary.LittleEndian.PutUint32(header[4:], 30)                     // PostScript offset
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(ps)))        // PostScript length
+	binary.LittleEndian.PutUint32(header[20:], uint32(30+len(ps)))    // TIFF offset
+	binary.LittleEndian.PutUint32(header[24:], uint32(preview.Len())) // TIFF length
+	binary.LittleEndian.PutUint16(header[28:], 0xFFFF)                // no checksum
+	if _, err := w.Write(header); err != nil {
+		return err
+	} else if _, err := w.Write(ps); err != nil {
+		return err
+	}
+	_, err := w.Write(preview.Bytes())
+	return err
+}
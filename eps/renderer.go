@@ -1,106 +1,130 @@
 package eps
 
 import (
-	"fmt"
+	"bytes"
 	"image"
-	"image/color"
 	"io"
-	"math"
-	"strings"
 
 	"github.com/tdewolff/canvas"
-	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/canvas/ps"
 )
 
-var psEllipseDef = `/ellipse {
-/rot exch def
-/endangle exch def
-/startangle exch def
-/yrad exch def
-/xrad exch def
-/y exch def
-/x exch def
-/savematrix matrix currentmatrix def
-x y translate
-rot rotate
-xrad yrad scale
-0 0 1 startangle endangle arc
-savematrix setmatrix
-} def /ellipsen {
-/rot exch def
-/endangle exch def
-/startangle exch def
-/yrad exch def
-/xrad exch def
-/y exch def
-/x exch def
-/savematrix matrix currentmatrix def
-x y translate
-rot rotate
-xrad yrad scale
-0 0 1 startangle endangle arcn
-savematrix setmatrix
-} def`
+// Preview is the format of the preview image of an EPS file, which is shown by applications that cannot interpret PostScript.
+type Preview int
 
+// see Preview
+const (
+	NoPreview   Preview = iota
+	EPSIPreview         // grayscale bitmap in the comments of the file (Encapsulated PostScript Interchange)
+	TIFFPreview         // TIFF image after the PostScript in a binary DOS EPS file
+)
+
+// Options are the options for the EPS renderer.
+type Options struct {
+	Preview           Preview
+	PreviewResolution canvas.DPMM // defaults to 72 DPI
+}
+
+// DefaultOptions are the default options for the EPS renderer.
+var DefaultOptions = Options{}
+
+// Renderer is an encapsulated PostScript renderer, which writes a single page using the PostScript renderer of the ps package.
 type Renderer struct {
-	w             io.Writer
-	width, height float64
-	color         color.RGBA
+	w       io.Writer
+	buf     *bytes.Buffer // buffers the file when it has a preview
+	ps      *ps.PS
+	opts    Options
+	preview image.Image
 }
 
-// New creates an encapsulated PostScript renderer.
+// New creates an encapsulated PostScript renderer, which writes to w as it renders. Call Close to write the end of the file.
 func New(w io.Writer, width, height float64) *Renderer {
-	fmt.Fprintf(w, "%%!PS-Adobe-3.0 EPSF-3.0\n%%%%BoundingBox: 0 0 %v %v\n", dec(width), dec(height))
-	fmt.Fprintf(w, psEllipseDef)
-	// TODO: (EPS) generate and add preview
-
-	return &Renderer{
-		w:      w,
-		width:  width,
-		height: height,
-		color:  canvas.Black,
+	return NewWithOptions(w, width, height, nil)
+}
+
+// NewWithOptions creates an encapsulated PostScript renderer with options. If opts is nil, DefaultOptions is used. The file is written as it renders, unless a preview is requested, in which case the file is buffered and written by Close.
+func NewWithOptions(w io.Writer, width, height float64, opts *Options) *Renderer {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+
+	r := &Renderer{
+		w:    w,
+		opts: *opts,
 	}
+	if opts.Preview == NoPreview {
+		r.ps = ps.New(w, width, height, &ps.Options{Encapsulated: true})
+	} else {
+		r.buf = &bytes.Buffer{}
+		r.ps = ps.New(r.buf, width, height, &ps.Options{Encapsulated: true})
+	}
+	return r
+}
+
+// SetPreview sets the image that is written as a preview of the file according to the Preview option, see also WriterWithOptions.
+func (r *Renderer) SetPreview(img image.Image) {
+	r.preview = img
 }
 
-func (r *Renderer) setColor(color color.RGBA) {
-	if color != r.color {
-		fmt.Fprintf(r.w, " %v %v %v setrgbcolor", dec(float64(color.R)/255.0), dec(float64(color.G)/255.0), dec(float64(color.B)/255.0))
-		r.color = color
+// Close writes the end of the file, or the buffered file with its preview.
+func (r *Renderer) Close() error {
+	if err := r.ps.Close(); err != nil {
+		return err
+	} else if r.buf == nil {
+		return nil
 	}
+
+	b := r.buf.Bytes()
+	if r.preview != nil && r.opts.Preview == EPSIPreview {
+		// the preview follows the header comments
+		end := []byte("%%EndComments\n")
+		i := bytes.Index(b, end) + len(end)
+		preview := &bytes.Buffer{}
+		writeEPSIPreview(preview, r.preview)
+		b = append(b[:i:i], append(preview.Bytes(), b[i:]...)...)
+	} else if r.preview != nil && r.opts.Preview == TIFFPreview {
+		return writeTIFFPreview(r.w, b, r.preview)
+	}
+	_, err := r.w.Write(b)
+	return err
 }
 
 func (r *Renderer) Size() (float64, float64) {
-	return r.width, r.height
+	return r.ps.Size()
 }
 
 func (r *Renderer) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
-	// TODO: (EPS) test ellipse, rotations etc
-	// TODO: (EPS) add drawState support
-	// TODO: (EPS) use dither to fake transparency
-	r.setColor(style.FillColor)
-	r.w.Write([]byte(" "))
-	r.w.Write([]byte(path.Transform(m).ToPS()))
-	r.w.Write([]byte(" fill"))
+	r.ps.RenderPath(path, style, m)
 }
 
 func (r *Renderer) RenderText(text *canvas.Text, m canvas.Matrix) {
-	// TODO: (EPS) write text natively
-	canvas.RenderTextAsPath(r, text, m)
+	r.ps.RenderText(text, m)
 }
 
 func (r *Renderer) RenderImage(img image.Image, m canvas.Matrix) {
-	// TODO: (EPS) write image
+	r.ps.RenderImage(img, m)
+}
+
+func (r *Renderer) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	r.ps.PushClip(path, fillRule, m)
 }
 
-type dec float64
+func (r *Renderer) PopClip() {
+	r.ps.PopClip()
+}
 
-func (f dec) String() string {
-	s := fmt.Sprintf("%.*f", canvas.Precision, f)
-	s = string(minify.Decimal([]byte(s), canvas.Precision))
-	if dec(math.MaxInt32) < f || f < dec(math.MinInt32) {
-		if i := strings.IndexByte(s, '.'); i == -1 {
-			s += ".0"
-		}
-	}
-	return s
+func (r *Renderer) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	r.ps.PushGroup(opacity, blendMode)
+}
+
+func (r *Renderer) PopGroup() {
+	r.ps.PopGroup()
+}
+
+func (r *Renderer) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	r.ps.PushMask(mask, maskType, m)
+}
+
+func (r *Renderer) PopMask() {
+	r.ps.PopMask()
 }
@@ -2,14 +2,68 @@ package eps
 
 import (
 	"bytes"
+	"encoding/binary"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
 )
 
 func TestEPS(t *testing.T) {
 	w := &bytes.Buffer{}
 	eps := New(w, 100, 80)
-	eps.setColor(canvas.Red)
-	//test.String(t, string(w.Bytes()), "")
+	eps.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+
+	// written as it is rendered
+	s := w.String()
+	test.That(t, strings.HasPrefix(s, "%!PS-Adobe-3.0 EPSF-3.0\n"), "must have header")
+	test.That(t, strings.Contains(s, "%%BoundingBox: 0 0 284 227\n"), "bounding box must be in points")
+	test.That(t, strings.HasSuffix(s, "2.8346457 2.8346457 scale 0 0 moveto 10 0 lineto 10 10 lineto 0 10 lineto closepath fill"))
+
+	test.Error(t, eps.Close())
+	s = w.String()
+	test.That(t, !strings.Contains(s, "showpage"), "must not have page setup")
+	test.That(t, strings.HasSuffix(s, "closepath fill\n%%EOF\n"))
+}
+
+func TestEPSText(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	face := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+
+	w := &bytes.Buffer{}
+	eps := New(w, 100, 80)
+	eps.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	eps.RenderText(canvas.NewTextLine(face, "a", canvas.Left), canvas.Identity)
+	eps.RenderText(canvas.NewTextLine(face, "b", canvas.Left), canvas.Identity)
+	test.Error(t, eps.Close())
+
+	// the complete font is embedded before its first use
+	s := w.String()
+	test.T(t, strings.Count(s, "%%BeginResource: font dejavu-serif\n"), 1)
+	test.That(t, strings.Contains(s, "closepath fill\n%%BeginResource: font dejavu-serif\n"), "font must start on a new line")
+	test.That(t, strings.Index(s, "%%EndResource") < strings.Index(s, "selectfont"), "must embed font before its use")
+	test.That(t, strings.Contains(s, "/F0.0 4.2333333 selectfont 0 0 moveto <44> "), "must show original glyph IDs")
+}
+
+func TestEPSPreview(t *testing.T) {
+	c := canvas.New(10.0, 5.0)
+	ctx := canvas.NewContext(c)
+	ctx.DrawPath(0.0, 0.0, canvas.Rectangle(5.0, 5.0))
+
+	w := &bytes.Buffer{}
+	test.Error(t, WriterWithOptions(&Options{Preview: EPSIPreview, PreviewResolution: 1.0})(w, c))
+	s := w.String()
+	test.That(t, strings.Contains(s, "%%EndComments\n%%BeginPreview: 10 5 8 5\n% FFFFFFFFFF0000000000\n"), "must have EPSI preview")
+
+	w.Reset()
+	test.Error(t, WriterWithOptions(&Options{Preview: TIFFPreview, PreviewResolution: 1.0})(w, c))
+	b := w.Bytes()
+	test.T(t, binary.LittleEndian.Uint32(b), uint32(0xC6D3D0C5))
+	psLength := binary.LittleEndian.Uint32(b[8:])
+	test.That(t, strings.HasPrefix(string(b[30:]), "%!PS-Adobe-3.0 EPSF-3.0\n"), "must have PostScript program")
+	test.That(t, strings.HasSuffix(string(b[30:30+psLength]), "%%EOF\n"), "must have PostScript program")
+	test.T(t, binary.LittleEndian.Uint32(b[20:]), 30+psLength)
+	test.T(t, string(b[30+psLength:30+psLength+4]), "II*\x00", "must have TIFF preview")
 }
@@ -1,9 +1,12 @@
 package eps
 
 import (
+	"image"
+	"image/draw"
 	"io"
 
 	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/rasterizer"
 )
 
 // Writer writes the canvas as an EPS file.
@@ -11,5 +14,24 @@ import (
 func Writer(w io.Writer, c *canvas.Canvas) error {
 	eps := New(w, c.W, c.H)
 	c.Render(eps)
-	return nil
+	return eps.Close()
+}
+
+// WriterWithOptions returns a writer that writes the canvas as an EPS file with the given options, which may include a rasterized preview of the canvas.
+func WriterWithOptions(opts *Options) canvas.Writer {
+	return func(w io.Writer, c *canvas.Canvas) error {
+		eps := NewWithOptions(w, c.W, c.H, opts)
+		if opts != nil && opts.Preview != NoPreview {
+			resolution := opts.PreviewResolution
+			if resolution == 0.0 {
+				resolution = 72.0 * canvas.DPI
+			}
+			img := image.NewRGBA(image.Rect(0, 0, int(c.W*float64(resolution)+0.5), int(c.H*float64(resolution)+0.5)))
+			draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+			c.Render(rasterizer.New(img, resolution))
+			eps.SetPreview(img)
+		}
+		c.Render(eps)
+		return eps.Close()
+	}
 }
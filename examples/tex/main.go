@@ -25,7 +25,7 @@ func main() {
 	}
 	defer f.Close()
 
-	c := tex.New(f, 20, 10)
+	c := tex.NewWithOptions(f, 20, 10, &tex.Options{NativeText: true, ImagePrefix: "out"})
 	defer c.Close()
 
 	ctx := canvas.NewContext(c)
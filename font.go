@@ -24,7 +24,7 @@ const (
 
 // Font defines a font of type TTF or OTF which which a FontFace can be generated for use in text drawing operations.
 type Font struct {
-	// TODO: extend to fully read in sfnt data and read liga tables, generate Raw font data (base on used glyphs), etc
+	// TODO: extend to fully read in sfnt data and read liga tables, etc
 	name      string
 	mediatype string
 	raw       []byte
@@ -70,6 +70,25 @@ func (f *Font) Raw() (string, []byte) {
 	return f.mediatype, f.raw
 }
 
+// Subset returns the mimetype and binary data of the font in the TTF or OTF format containing only the given glyphs, see font.Subset. The glyph glyphIDs[i] becomes glyph i in the subset, where glyphIDs[0] must be zero.
+func (f *Font) Subset(glyphIDs []uint16) (string, []byte, error) {
+	mediatype, b := f.mediatype, f.raw
+	if mediatype != "font/truetype" && mediatype != "font/opentype" {
+		var err error
+		if b, err = canvasFont.ToSFNT(b); err != nil {
+			return "", nil, err
+		} else if mediatype, err = canvasFont.MediaType(b); err != nil {
+			return "", nil, err
+		}
+	}
+
+	b, err := canvasFont.Subset(b, glyphIDs)
+	if err != nil {
+		return "", nil, err
+	}
+	return mediatype, b, nil
+}
+
 // UnitsPerEm returns the number of units per em for f.
 func (f *Font) UnitsPerEm() float64 {
 	return float64(f.sfnt.UnitsPerEm())
@@ -144,14 +163,15 @@ func (f *Font) Metrics(ppem float64) FontMetrics {
 	}
 }
 
+// Widths returns the advance widths of all glyphs, indexed by glyph ID. Glyphs of which the advance width cannot be read have zero width.
 func (f *Font) Widths(ppem float64) []float64 {
 	buffer := &sfnt.Buffer{}
-	widths := []float64{}
-	for i := 0; i < f.sfnt.NumGlyphs(); i++ {
+	widths := make([]float64, f.sfnt.NumGlyphs())
+	for i := range widths {
 		index := sfnt.GlyphIndex(i)
 		advance, err := f.sfnt.GlyphAdvance(buffer, index, toI26_6(ppem), font.HintingNone)
 		if err == nil {
-			widths = append(widths, fromI26_6(advance))
+			widths[i] = fromI26_6(advance)
 		}
 	}
 	return widths
@@ -300,6 +320,16 @@ func (f *Font) Use(options TypographicOptions) {
 	}
 }
 
+// LigatureText returns the text that was substituted by the ligature character when enabled by Use, or the character itself otherwise.
+func (f *Font) LigatureText(r rune) string {
+	for _, stn := range f.ligatures {
+		if stn.dst == r {
+			return stn.src
+		}
+	}
+	return string(r)
+}
+
 func (f *Font) substituteLigatures(s string) string {
 	for _, stn := range f.ligatures {
 		s = strings.ReplaceAll(s, stn.src, string(stn.dst))
@@ -0,0 +1,1123 @@
+package font
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Subset returns a subset of an SFNT font (TTF or OTF) that contains only the given glyphs and the glyphs they depend on, such as the components of composite glyphs. The glyph glyphIDs[i] becomes glyph i of the subset, where glyphIDs[0] must be the .notdef glyph (zero), and dependencies are appended after the given glyphs. The glyf, loca, hmtx, cmap, kern and CFF tables are rewritten accordingly, where the cmap table only maps the characters of the retained glyphs. Tables that reference glyphs but are not subsetted, such as GSUB and GPOS, are removed.
+func Subset(b []byte, glyphIDs []uint16) ([]byte, error) {
+	sfntVersion, tables, err := parseSFNTTables(b)
+	if err != nil {
+		return nil, err
+	} else if len(glyphIDs) == 0 || glyphIDs[0] != 0 {
+		return nil, fmt.Errorf("first glyph must be .notdef")
+	}
+
+	maxp, head, hhea, hmtx := tables["maxp"], tables["head"], tables["hhea"], tables["hmtx"]
+	if len(maxp) < 6 || len(head) < 54 || len(hhea) < 36 || hmtx == nil {
+		return nil, ErrInvalidFontData
+	}
+	numGlyphs := binary.BigEndian.Uint16(maxp[4:])
+
+	glyphs := []uint16{}
+	index := map[uint16]uint16{}
+	add := func(glyphID uint16) {
+		if _, ok := index[glyphID]; !ok && glyphID < numGlyphs {
+			index[glyphID] = uint16(len(glyphs))
+			glyphs = append(glyphs, glyphID)
+		}
+	}
+	for _, glyphID := range glyphIDs {
+		if numGlyphs <= glyphID {
+			return nil, fmt.Errorf("glyph %d does not exist", glyphID)
+		}
+		add(glyphID)
+	}
+
+	subset := map[string][]byte{}
+	if _, ok := tables["CFF2"]; ok {
+		return nil, fmt.Errorf("CFF2: unsupported")
+	} else if cff, ok := tables["CFF "]; ok {
+		if subset["CFF "], err = subsetCFF(cff, glyphs); err != nil {
+			return nil, fmt.Errorf("CFF: %w", err)
+		}
+	} else {
+		glyf, loca := tables["glyf"], tables["loca"]
+		if glyf == nil || loca == nil {
+			return nil, fmt.Errorf("glyf or CFF table missing")
+		}
+		offsets, err := parseLoca(loca, numGlyphs, int16(binary.BigEndian.Uint16(head[50:])) == 1, uint32(len(glyf)))
+		if err != nil {
+			return nil, fmt.Errorf("loca: %w", err)
+		}
+
+		// add components of composite glyphs, which may add composite glyphs themselves
+		for i := 0; i < len(glyphs); i++ {
+			data := glyf[offsets[glyphs[i]]:offsets[glyphs[i]+1]]
+			for _, pos := range glyfComponents(data) {
+				add(binary.BigEndian.Uint16(data[pos:]))
+			}
+		}
+
+		w := newBinaryWriter([]byte{})
+		newOffsets := make([]uint32, 0, len(glyphs)+1)
+		for _, glyphID := range glyphs {
+			newOffsets = append(newOffsets, w.Len())
+			data := append([]byte{}, glyf[offsets[glyphID]:offsets[glyphID+1]]...)
+			for _, pos := range glyfComponents(data) {
+				binary.BigEndian.PutUint16(data[pos:], index[binary.BigEndian.Uint16(data[pos:])])
+			}
+			w.WriteBytes(data)
+			for w.Len()%4 != 0 {
+				w.WriteByte(0)
+			}
+		}
+		newOffsets = append(newOffsets, w.Len())
+		subset["glyf"] = w.Bytes()
+
+		w = newBinaryWriter([]byte{})
+		longLoca := 0x1FFFF < newOffsets[len(newOffsets)-1]
+		for _, offset := range newOffsets {
+			if longLoca {
+				w.WriteUint32(offset)
+			} else {
+				w.WriteUint16(uint16(offset / 2))
+			}
+		}
+		subset["loca"] = w.Bytes()
+
+		head = append([]byte{}, head...)
+		if longLoca {
+			binary.BigEndian.PutUint16(head[50:], 1)
+		} else {
+			binary.BigEndian.PutUint16(head[50:], 0)
+		}
+	}
+	if math.MaxUint16 < len(glyphs) {
+		return nil, ErrInvalidFontData
+	}
+
+	// metrics
+	numberOfHMetrics := binary.BigEndian.Uint16(hhea[34:])
+	if numberOfHMetrics == 0 || uint32(len(hmtx)) < 4*uint32(numberOfHMetrics)+2*uint32(numGlyphs-numberOfHMetrics) {
+		return nil, fmt.Errorf("hmtx: %w", ErrInvalidFontData)
+	}
+	advances := make([]uint16, len(glyphs))
+	lsbs := make([]uint16, len(glyphs))
+	for i, glyphID := range glyphs {
+		if glyphID < numberOfHMetrics {
+			advances[i] = binary.BigEndian.Uint16(hmtx[4*uint32(glyphID):])
+			lsbs[i] = binary.BigEndian.Uint16(hmtx[4*uint32(glyphID)+2:])
+		} else {
+			advances[i] = binary.BigEndian.Uint16(hmtx[4*uint32(numberOfHMetrics-1):])
+			lsbs[i] = binary.BigEndian.Uint16(hmtx[4*uint32(numberOfHMetrics)+2*uint32(glyphID-numberOfHMetrics):])
+		}
+	}
+	numberOfHMetrics = uint16(len(glyphs))
+	for 1 < numberOfHMetrics && advances[numberOfHMetrics-1] == advances[numberOfHMetrics-2] {
+		numberOfHMetrics--
+	}
+	w := newBinaryWriter([]byte{})
+	for i := range glyphs {
+		if i < int(numberOfHMetrics) {
+			w.WriteUint16(advances[i])
+		}
+		w.WriteUint16(lsbs[i])
+	}
+	subset["hmtx"] = w.Bytes()
+
+	hhea = append([]byte{}, hhea...)
+	binary.BigEndian.PutUint16(hhea[34:], numberOfHMetrics)
+	maxp = append([]byte{}, maxp...)
+	binary.BigEndian.PutUint16(maxp[4:], uint16(len(glyphs)))
+	head = append([]byte{}, head...)
+	binary.BigEndian.PutUint32(head[8:], 0) // checkSumAdjustment
+	subset["head"], subset["hhea"], subset["maxp"] = head, hhea, maxp
+
+	// character to glyph mapping
+	cmap, ok := tables["cmap"]
+	if !ok {
+		return nil, fmt.Errorf("cmap table missing")
+	}
+	if subset["cmap"], err = subsetCmap(cmap, index); err != nil {
+		return nil, fmt.Errorf("cmap: %w", err)
+	}
+
+	// remove glyph names
+	if post, ok := tables["post"]; ok && 32 <= len(post) {
+		post = append([]byte{}, post[:32]...)
+		binary.BigEndian.PutUint32(post, 0x00030000)
+		subset["post"] = post
+	}
+
+	if kern, ok := tables["kern"]; ok {
+		if kern = subsetKern(kern, index); kern != nil {
+			subset["kern"] = kern
+		}
+	}
+
+	// tables that do not reference glyphs
+	for _, tag := range []string{"OS/2", "name", "cvt ", "fpgm", "prep", "gasp"} {
+		if table, ok := tables[tag]; ok {
+			subset[tag] = table
+		}
+	}
+	return writeSFNT(sfntVersion, subset), nil
+}
+
+// parseSFNTTables parses the table directory of an SFNT font and returns its version and tables.
+func parseSFNTTables(b []byte) (uint32, map[string][]byte, error) {
+	if len(b) < 12 {
+		return 0, nil, ErrInvalidFontData
+	}
+
+	r := newBinaryReader(b)
+	sfntVersion := r.ReadUint32()
+	if uint32ToString(sfntVersion) == "ttcf" {
+		return 0, nil, fmt.Errorf("collections are unsupported")
+	}
+	numTables := r.ReadUint16()
+	_ = r.ReadBytes(6) // searchRange, entrySelector, rangeShift
+
+	tables := map[string][]byte{}
+	for i := 0; i < int(numTables); i++ {
+		tag := r.ReadString(4)
+		_ = r.ReadUint32() // checksum
+		offset := r.ReadUint32()
+		length := r.ReadUint32()
+		if r.EOF() || uint32(len(b)) < offset || uint32(len(b))-offset < length {
+			return 0, nil, ErrInvalidFontData
+		}
+		tables[tag] = b[offset : offset+length : offset+length]
+	}
+	return sfntVersion, tables, nil
+}
+
+// writeSFNT writes an SFNT font with the given tables, sorted by tag and padded to four bytes. It sets the checksums and the checkSumAdjustment of the head table.
+func writeSFNT(sfntVersion uint32, tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	numTables := uint16(len(tags))
+	var searchRange uint16 = 1
+	var entrySelector uint16
+	for searchRange*2 <= numTables {
+		searchRange *= 2
+		entrySelector++
+	}
+	searchRange *= 16
+	rangeShift := numTables*16 - searchRange
+
+	w := newBinaryWriter([]byte{})
+	w.WriteUint32(sfntVersion)
+	w.WriteUint16(numTables)
+	w.WriteUint16(searchRange)
+	w.WriteUint16(entrySelector)
+	w.WriteUint16(rangeShift)
+
+	offset := 12 + 16*uint32(numTables)
+	var headOffset uint32
+	for _, tag := range tags {
+		table := tables[tag]
+		padded := make([]byte, (len(table)+3)&^3)
+		copy(padded, table)
+		if tag == "head" {
+			headOffset = offset
+		}
+
+		w.WriteString(tag)
+		w.WriteUint32(calcChecksum(padded))
+		w.WriteUint32(offset)
+		w.WriteUint32(uint32(len(table)))
+		offset += uint32(len(padded))
+	}
+	for _, tag := range tags {
+		table := tables[tag]
+		w.WriteBytes(table)
+		for i := len(table); i%4 != 0; i++ {
+			w.WriteByte(0)
+		}
+	}
+
+	b := w.Bytes()
+	if _, ok := tables["head"]; ok {
+		binary.BigEndian.PutUint32(b[headOffset+8:], 0xB1B0AFBA-calcChecksum(b))
+	}
+	return b
+}
+
+func parseLoca(loca []byte, numGlyphs uint16, long bool, glyfLength uint32) ([]uint32, error) {
+	offsets := make([]uint32, int(numGlyphs)+1)
+	if long && len(loca) < 4*len(offsets) || !long && len(loca) < 2*len(offsets) {
+		return nil, ErrInvalidFontData
+	}
+	for i := range offsets {
+		if long {
+			offsets[i] = binary.BigEndian.Uint32(loca[4*i:])
+		} else {
+			offsets[i] = 2 * uint32(binary.BigEndian.Uint16(loca[2*i:]))
+		}
+		if glyfLength < offsets[i] || 0 < i && offsets[i] < offsets[i-1] {
+			return nil, ErrInvalidFontData
+		}
+	}
+	return offsets, nil
+}
+
+// glyfComponents returns the positions of the glyph indices of the components in the data of a composite glyph, or nil for a simple glyph.
+func glyfComponents(data []byte) []uint32 {
+	if len(data) < 10 || 0 <= int16(binary.BigEndian.Uint16(data)) {
+		return nil
+	}
+
+	positions := []uint32{}
+	pos := uint32(10)
+	for pos+4 <= uint32(len(data)) {
+		flags := binary.BigEndian.Uint16(data[pos:])
+		positions = append(positions, pos+2)
+		pos += 4
+		if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+			pos += 4
+		} else {
+			pos += 2
+		}
+		if flags&0x0008 != 0 { // WE_HAVE_A_SCALE
+			pos += 2
+		} else if flags&0x0040 != 0 { // WE_HAVE_AN_X_AND_Y_SCALE
+			pos += 4
+		} else if flags&0x0080 != 0 { // WE_HAVE_A_TWO_BY_TWO
+			pos += 8
+		}
+		if flags&0x0020 == 0 { // MORE_COMPONENTS
+			break
+		}
+	}
+	return positions
+}
+
+////////////////////////////////////////////////////////////////
+
+// parseCmap returns the character to glyph mapping of the preferred Unicode subtable of the cmap table, and whether it is a symbol font.
+func parseCmap(b []byte) (map[rune]uint16, bool, error) {
+	r := newBinaryReader(b)
+	_ = r.ReadUint16() // version
+	numTables := r.ReadUint16()
+	offsets := map[uint32]uint32{}
+	for i := 0; i < int(numTables); i++ {
+		platformID := r.ReadUint16()
+		encodingID := r.ReadUint16()
+		offset := r.ReadUint32()
+		if r.EOF() || uint32(len(b)) <= offset {
+			return nil, false, ErrInvalidFontData
+		}
+		offsets[uint32(platformID)<<16|uint32(encodingID)] = offset
+	}
+
+	// in order of preference
+	for _, id := range []uint32{3<<16 | 10, 0<<16 | 6, 0<<16 | 4, 3<<16 | 1, 0<<16 | 3, 0<<16 | 2, 0<<16 | 1, 0<<16 | 0, 3<<16 | 0} {
+		offset, ok := offsets[id]
+		if !ok {
+			continue
+		}
+		if m, err := parseCmapSubtable(b[offset:]); err == nil {
+			return m, id == 3<<16|0, nil
+		}
+	}
+	return nil, false, fmt.Errorf("no supported Unicode subtable")
+}
+
+func parseCmapSubtable(b []byte) (map[rune]uint16, error) {
+	m := map[rune]uint16{}
+	r := newBinaryReader(b)
+	format := r.ReadUint16()
+	switch format {
+	case 0:
+		_ = r.ReadUint16() // length
+		_ = r.ReadUint16() // language
+		glyphIDs := r.ReadBytes(256)
+		for c, glyphID := range glyphIDs {
+			if glyphID != 0 {
+				m[rune(c)] = uint16(glyphID)
+			}
+		}
+	case 4:
+		_ = r.ReadUint16() // length
+		_ = r.ReadUint16() // language
+		segCount := uint32(r.ReadUint16() / 2)
+		_ = r.ReadBytes(6) // searchRange, entrySelector, rangeShift
+		if r.EOF() || uint32(len(b)) < 16+8*segCount {
+			return nil, ErrInvalidFontData
+		}
+		for i := uint32(0); i < segCount; i++ {
+			endCode := binary.BigEndian.Uint16(b[14+2*i:])
+			startCode := binary.BigEndian.Uint16(b[16+2*segCount+2*i:])
+			idDelta := binary.BigEndian.Uint16(b[16+4*segCount+2*i:])
+			idRangeOffsetPos := 16 + 6*segCount + 2*i
+			idRangeOffset := uint32(binary.BigEndian.Uint16(b[idRangeOffsetPos:]))
+			for c := uint32(startCode); c <= uint32(endCode) && c != 0xFFFF; c++ {
+				glyphID := uint16(c) + idDelta
+				if idRangeOffset != 0 {
+					pos := idRangeOffsetPos + idRangeOffset + 2*(c-uint32(startCode))
+					if uint32(len(b)) < pos+2 {
+						return nil, ErrInvalidFontData
+					}
+					glyphID = binary.BigEndian.Uint16(b[pos:])
+					if glyphID != 0 {
+						glyphID += idDelta
+					}
+				}
+				if glyphID != 0 {
+					m[rune(c)] = glyphID
+				}
+			}
+		}
+	case 6:
+		_ = r.ReadUint16() // length
+		_ = r.ReadUint16() // language
+		firstCode := r.ReadUint16()
+		entryCount := r.ReadUint16()
+		for i := 0; i < int(entryCount); i++ {
+			if glyphID := r.ReadUint16(); glyphID != 0 {
+				m[rune(firstCode)+rune(i)] = glyphID
+			}
+		}
+	case 12:
+		_ = r.ReadUint16() // reserved
+		_ = r.ReadUint32() // length
+		_ = r.ReadUint32() // language
+		numGroups := r.ReadUint32()
+		if r.EOF() || uint32(len(b)-16)/12 < numGroups {
+			return nil, ErrInvalidFontData
+		}
+		for i := 0; i < int(numGroups); i++ {
+			startCharCode := r.ReadUint32()
+			endCharCode := r.ReadUint32()
+			startGlyphID := r.ReadUint32()
+			if endCharCode < startCharCode || unicodeMax < endCharCode || math.MaxUint16 < startGlyphID+(endCharCode-startCharCode) {
+				return nil, ErrInvalidFontData
+			}
+			for c := startCharCode; c <= endCharCode; c++ {
+				m[rune(c)] = uint16(startGlyphID + c - startCharCode)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %d", format)
+	}
+	if r.EOF() {
+		return nil, ErrInvalidFontData
+	}
+	return m, nil
+}
+
+const unicodeMax = 0x10FFFF
+
+// subsetCmap returns a cmap table with format 4 and 12 subtables for the characters that map to the retained glyphs, where index maps the original to the new glyph IDs.
+func subsetCmap(b []byte, index map[uint16]uint16) ([]byte, error) {
+	m, symbol, err := parseCmap(b)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune{}
+	for r, glyphID := range m {
+		if _, ok := index[glyphID]; ok {
+			runes = append(runes, r)
+		}
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	// ranges of consecutive characters that map to consecutive glyphs
+	type cmapRange struct {
+		start, end rune
+		glyphID    uint16
+	}
+	ranges := []cmapRange{}
+	for _, r := range runes {
+		glyphID := index[m[r]]
+		if 0 < len(ranges) {
+			last := &ranges[len(ranges)-1]
+			if last.end+1 == r && rune(last.glyphID)+r-last.start == rune(glyphID) && (0xFFFF < last.end || r <= 0xFFFF) {
+				last.end = r
+				continue
+			}
+		}
+		ranges = append(ranges, cmapRange{r, r, glyphID})
+	}
+
+	// format 4 for the Basic Multilingual Plane
+	bmp := []cmapRange{}
+	for _, rng := range ranges {
+		if rng.start <= 0xFFFF {
+			bmp = append(bmp, rng)
+		}
+	}
+	bmp = append(bmp, cmapRange{0xFFFF, 0xFFFF, 0})
+	segCount := uint16(len(bmp))
+	var searchRange uint16 = 1
+	var entrySelector uint16
+	for searchRange*2 <= segCount {
+		searchRange *= 2
+		entrySelector++
+	}
+	searchRange *= 2
+
+	w4 := newBinaryWriter([]byte{})
+	w4.WriteUint16(4)
+	w4.WriteUint16(16 + 8*segCount) // length
+	w4.WriteUint16(0)               // language
+	w4.WriteUint16(2 * segCount)
+	w4.WriteUint16(searchRange)
+	w4.WriteUint16(entrySelector)
+	w4.WriteUint16(2*segCount - searchRange)
+	for _, rng := range bmp {
+		w4.WriteUint16(uint16(rng.end))
+	}
+	w4.WriteUint16(0) // reservedPad
+	for _, rng := range bmp {
+		w4.WriteUint16(uint16(rng.start))
+	}
+	for _, rng := range bmp {
+		if rng.start == 0xFFFF {
+			w4.WriteUint16(1)
+		} else {
+			w4.WriteUint16(rng.glyphID - uint16(rng.start))
+		}
+	}
+	for range bmp {
+		w4.WriteUint16(0) // idRangeOffset
+	}
+	if math.MaxUint16 < w4.Len() {
+		return nil, fmt.Errorf("too many segments")
+	}
+
+	subtables := [][]byte{w4.Bytes()}
+	ids := [][2]uint16{{3, 1}}
+	if symbol {
+		ids[0][1] = 0
+	} else if 0 < len(ranges) && 0xFFFF < ranges[len(ranges)-1].end {
+		w12 := newBinaryWriter([]byte{})
+		w12.WriteUint16(12)
+		w12.WriteUint16(0) // reserved
+		w12.WriteUint32(16 + 12*uint32(len(ranges)))
+		w12.WriteUint32(0) // language
+		w12.WriteUint32(uint32(len(ranges)))
+		for _, rng := range ranges {
+			w12.WriteUint32(uint32(rng.start))
+			w12.WriteUint32(uint32(rng.end))
+			w12.WriteUint32(uint32(rng.glyphID))
+		}
+		subtables = append(subtables, w12.Bytes())
+		ids = append(ids, [2]uint16{3, 10})
+	}
+
+	w := newBinaryWriter([]byte{})
+	w.WriteUint16(0) // version
+	w.WriteUint16(uint16(len(subtables)))
+	offset := 4 + 8*uint32(len(subtables))
+	for i, subtable := range subtables {
+		w.WriteUint16(ids[i][0])
+		w.WriteUint16(ids[i][1])
+		w.WriteUint32(offset)
+		offset += uint32(len(subtable))
+	}
+	for _, subtable := range subtables {
+		w.WriteBytes(subtable)
+	}
+	return w.Bytes(), nil
+}
+
+// subsetKern returns a kern table with the pairs of the format 0 subtables of which both glyphs are retained, or nil if there are none.
+func subsetKern(b []byte, index map[uint16]uint16) []byte {
+	r := newBinaryReader(b)
+	if version := r.ReadUint16(); version != 0 {
+		return nil
+	}
+	nTables := r.ReadUint16()
+
+	type kernPair struct {
+		left, right uint16
+		value       uint16
+	}
+	subtables := [][]byte{}
+	for i := 0; i < int(nTables) && !r.EOF(); i++ {
+		start := r.Pos()
+		_ = r.ReadUint16() // version
+		length := uint32(r.ReadUint16())
+		coverage := r.ReadUint16()
+		if coverage>>8 == 0 && 14 <= length {
+			nPairs := r.ReadUint16()
+			_ = r.ReadBytes(6) // searchRange, entrySelector, rangeShift
+
+			pairs := []kernPair{}
+			for j := 0; j < int(nPairs) && !r.EOF(); j++ {
+				left, okLeft := index[r.ReadUint16()]
+				right, okRight := index[r.ReadUint16()]
+				value := r.ReadUint16()
+				if okLeft && okRight {
+					pairs = append(pairs, kernPair{left, right, value})
+				}
+			}
+			if r.EOF() {
+				return nil
+			}
+			if 0 < len(pairs) && len(pairs) < (math.MaxUint16-14)/6 {
+				sort.Slice(pairs, func(i, j int) bool {
+					return pairs[i].left < pairs[j].left || pairs[i].left == pairs[j].left && pairs[i].right < pairs[j].right
+				})
+				var searchRange uint16 = 1
+				var entrySelector uint16
+				for searchRange*2 <= uint16(len(pairs)) {
+					searchRange *= 2
+					entrySelector++
+				}
+				searchRange *= 6
+
+				w := newBinaryWriter([]byte{})
+				w.WriteUint16(0) // version
+				w.WriteUint16(uint16(14 + 6*len(pairs)))
+				w.WriteUint16(coverage)
+				w.WriteUint16(uint16(len(pairs)))
+				w.WriteUint16(searchRange)
+				w.WriteUint16(entrySelector)
+				w.WriteUint16(uint16(6*len(pairs)) - searchRange)
+				for _, pair := range pairs {
+					w.WriteUint16(pair.left)
+					w.WriteUint16(pair.right)
+					w.WriteUint16(pair.value)
+				}
+				subtables = append(subtables, w.Bytes())
+			}
+		}
+		r.Seek(start + length)
+	}
+	if len(subtables) == 0 {
+		return nil
+	}
+
+	w := newBinaryWriter([]byte{})
+	w.WriteUint16(0) // version
+	w.WriteUint16(uint16(len(subtables)))
+	for _, subtable := range subtables {
+		w.WriteBytes(subtable)
+	}
+	return w.Bytes()
+}
+
+////////////////////////////////////////////////////////////////
+
+// CFF DICT operators
+const (
+	cffCharset        = 15
+	cffEncoding       = 16
+	cffCharStrings    = 17
+	cffPrivate        = 18
+	cffSubrs          = 19
+	cffCharstringType = 1206
+	cffROS            = 1230
+	cffCIDCount       = 1234
+	cffFDArray        = 1236
+	cffFDSelect       = 1237
+)
+
+type cffDictEntry struct {
+	op       int
+	operands [][]byte
+}
+
+type cffDict []cffDictEntry
+
+func (dict cffDict) get(op int) []int {
+	for _, entry := range dict {
+		if entry.op == op {
+			ints := make([]int, len(entry.operands))
+			for i, operand := range entry.operands {
+				ints[i] = cffDictInt(operand)
+			}
+			return ints
+		}
+	}
+	return nil
+}
+
+func (dict cffDict) set(op int, values ...int) cffDict {
+	operands := make([][]byte, len(values))
+	for i, v := range values {
+		operands[i] = []byte{29, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	for i, entry := range dict {
+		if entry.op == op {
+			dict[i].operands = operands
+			return dict
+		}
+	}
+	return append(dict, cffDictEntry{op, operands})
+}
+
+func (dict cffDict) remove(op int) cffDict {
+	for i, entry := range dict {
+		if entry.op == op {
+			return append(dict[:i:i], dict[i+1:]...)
+		}
+	}
+	return dict
+}
+
+func (dict cffDict) bytes() []byte {
+	w := newBinaryWriter([]byte{})
+	for _, entry := range dict {
+		for _, operand := range entry.operands {
+			w.WriteBytes(operand)
+		}
+		if 1200 <= entry.op {
+			w.WriteByte(12)
+			w.WriteByte(byte(entry.op - 1200))
+		} else {
+			w.WriteByte(byte(entry.op))
+		}
+	}
+	return w.Bytes()
+}
+
+func parseCFFDict(b []byte) (cffDict, error) {
+	dict := cffDict{}
+	operands := [][]byte{}
+	for i := 0; i < len(b); {
+		b0 := b[i]
+		n := 1
+		switch {
+		case b0 <= 21:
+			op := int(b0)
+			if b0 == 12 {
+				if len(b) <= i+1 {
+					return nil, ErrInvalidFontData
+				}
+				op = 1200 + int(b[i+1])
+				n = 2
+			}
+			dict = append(dict, cffDictEntry{op, operands})
+			operands = [][]byte{}
+			i += n
+			continue
+		case b0 == 28:
+			n = 3
+		case b0 == 29:
+			n = 5
+		case b0 == 30:
+			for n = 1; i+n < len(b); n++ {
+				if b[i+n]&0x0F == 0x0F || b[i+n]>>4 == 0x0F {
+					n++
+					break
+				}
+			}
+		case 32 <= b0 && b0 <= 246:
+			n = 1
+		case 247 <= b0 && b0 <= 254:
+			n = 2
+		default:
+			return nil, ErrInvalidFontData
+		}
+		if len(b) < i+n {
+			return nil, ErrInvalidFontData
+		}
+		operands = append(operands, b[i:i+n])
+		i += n
+	}
+	return dict, nil
+}
+
+// cffDictInt returns the value of an integer operand, real operands return zero.
+func cffDictInt(b []byte) int {
+	switch b0 := int(b[0]); {
+	case b0 == 28:
+		return int(int16(binary.BigEndian.Uint16(b[1:])))
+	case b0 == 29:
+		return int(int32(binary.BigEndian.Uint32(b[1:])))
+	case 32 <= b0 && b0 <= 246:
+		return b0 - 139
+	case 247 <= b0 && b0 <= 250:
+		return (b0-247)*256 + int(b[1]) + 108
+	case 251 <= b0 && b0 <= 254:
+		return -(b0-251)*256 - int(b[1]) - 108
+	}
+	return 0
+}
+
+// parseCFFIndex parses an INDEX at the position of the reader and returns its items.
+func parseCFFIndex(r *binaryReader) ([][]byte, error) {
+	count := uint32(r.ReadUint16())
+	if count == 0 {
+		return [][]byte{}, nil
+	}
+	offSize := uint32(r.ReadByte())
+	if offSize < 1 || 4 < offSize {
+		return nil, ErrInvalidFontData
+	}
+
+	offsets := make([]uint32, count+1)
+	for i := range offsets {
+		for _, b := range r.ReadBytes(offSize) {
+			offsets[i] = offsets[i]<<8 | uint32(b)
+		}
+		if r.EOF() || offsets[i] == 0 || 0 < i && offsets[i] < offsets[i-1] {
+			return nil, ErrInvalidFontData
+		}
+	}
+
+	data := r.ReadBytes(offsets[count] - 1)
+	if r.EOF() {
+		return nil, ErrInvalidFontData
+	}
+	items := make([][]byte, count)
+	for i := range items {
+		items[i] = data[offsets[i]-1 : offsets[i+1]-1]
+	}
+	return items, nil
+}
+
+func writeCFFIndex(w *binaryWriter, items [][]byte) {
+	w.WriteUint16(uint16(len(items)))
+	if len(items) == 0 {
+		return
+	}
+
+	size := uint32(1)
+	for _, item := range items {
+		size += uint32(len(item))
+	}
+	offSize := 1
+	for ; offSize < 4 && 1<<(8*uint(offSize)) <= size; offSize++ {
+	}
+	w.WriteByte(byte(offSize))
+
+	offset := uint32(1)
+	writeOffset := func() {
+		for i := offSize - 1; 0 <= i; i-- {
+			w.WriteByte(byte(offset >> (8 * uint(i))))
+		}
+	}
+	writeOffset()
+	for _, item := range items {
+		offset += uint32(len(item))
+		writeOffset()
+	}
+	for _, item := range items {
+		w.WriteBytes(item)
+	}
+}
+
+// subsetCFFPrivate returns the Private DICT and its local subroutines, where the Subrs offset is set to directly follow the DICT. It also returns the size of the DICT.
+func subsetCFFPrivate(b []byte, size, offset int) ([]byte, int, error) {
+	if size < 0 || offset < 0 || len(b) < offset+size {
+		return nil, 0, ErrInvalidFontData
+	}
+	dict, err := parseCFFDict(b[offset : offset+size])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var subrs []byte
+	if v := dict.get(cffSubrs); len(v) == 1 {
+		r := newBinaryReader(b)
+		r.Seek(uint32(offset + v[0]))
+		start := r.Pos()
+		if _, err := parseCFFIndex(r); err != nil {
+			return nil, 0, err
+		}
+		subrs = b[start:r.Pos()]
+		dict = dict.set(cffSubrs, 0)
+		dict = dict.set(cffSubrs, len(dict.bytes()))
+	}
+	private := dict.bytes()
+	return append(private, subrs...), len(private), nil
+}
+
+// subsetCFF returns a CFF table with the given glyphs, in which the CharStrings INDEX, charset and FDSelect are rewritten. Subroutines are kept as is. CID-keyed fonts are renumbered so that the CIDs equal the glyph IDs.
+func subsetCFF(b []byte, glyphs []uint16) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, ErrInvalidFontData
+	}
+	r := newBinaryReader(b)
+	r.Seek(uint32(b[2])) // hdrSize
+	nameStart := r.Pos()
+	if _, err := parseCFFIndex(r); err != nil {
+		return nil, err
+	}
+	nameIndex := b[nameStart:r.Pos()]
+	topDicts, err := parseCFFIndex(r)
+	if err != nil {
+		return nil, err
+	} else if len(topDicts) != 1 {
+		return nil, fmt.Errorf("must contain exactly one font")
+	}
+	stringsStart := r.Pos()
+	if _, err := parseCFFIndex(r); err != nil { // String INDEX
+		return nil, err
+	}
+	if _, err := parseCFFIndex(r); err != nil { // Global Subr INDEX
+		return nil, err
+	}
+	stringsAndSubrs := b[stringsStart:r.Pos()]
+
+	topDict, err := parseCFFDict(topDicts[0])
+	if err != nil {
+		return nil, err
+	}
+	if v := topDict.get(cffCharstringType); v != nil && (len(v) != 1 || v[0] != 2) {
+		return nil, fmt.Errorf("unsupported charstring type")
+	}
+
+	v := topDict.get(cffCharStrings)
+	if len(v) != 1 || v[0] < 0 || len(b) < v[0] {
+		return nil, ErrInvalidFontData
+	}
+	r.Seek(uint32(v[0]))
+	charStrings, err := parseCFFIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	numGlyphs := len(charStrings)
+	for _, glyphID := range glyphs {
+		if numGlyphs <= int(glyphID) {
+			return nil, ErrInvalidFontData
+		}
+	}
+	isCID := topDict.get(cffROS) != nil
+
+	// charset
+	charset := newBinaryWriter([]byte{})
+	if isCID {
+		// CIDs equal the glyph IDs
+		charset.WriteByte(2)
+		if 1 < len(glyphs) {
+			charset.WriteUint16(1)
+			charset.WriteUint16(uint16(len(glyphs) - 2))
+		}
+	} else {
+		sids := make([]uint16, numGlyphs)
+		for i := range sids {
+			sids[i] = uint16(i) // predefined charsets
+		}
+		if v := topDict.get(cffCharset); len(v) == 1 && 2 < v[0] {
+			if err := parseCFFCharset(b, v[0], sids); err != nil {
+				return nil, err
+			}
+		}
+		charset.WriteByte(0)
+		for _, glyphID := range glyphs[1:] {
+			charset.WriteUint16(sids[glyphID])
+		}
+	}
+
+	// FDSelect
+	var fdSelect *binaryWriter
+	if isCID {
+		v := topDict.get(cffFDSelect)
+		if len(v) != 1 || v[0] < 0 || len(b) <= v[0] {
+			return nil, ErrInvalidFontData
+		}
+		fds, err := parseCFFFDSelect(b, v[0], numGlyphs)
+		if err != nil {
+			return nil, err
+		}
+		fdSelect = newBinaryWriter([]byte{})
+		fdSelect.WriteByte(0)
+		for _, glyphID := range glyphs {
+			fdSelect.WriteByte(fds[glyphID])
+		}
+	}
+
+	// CharStrings
+	newCharStrings := make([][]byte, len(glyphs))
+	for i, glyphID := range glyphs {
+		newCharStrings[i] = charStrings[glyphID]
+	}
+	charStringsIndex := newBinaryWriter([]byte{})
+	writeCFFIndex(charStringsIndex, newCharStrings)
+
+	// Private DICTs, which are either in the Top DICT or in the Font DICTs of FDArray
+	privates := [][]byte{}
+	privateSizes := []int{}
+	fontDicts := []cffDict{}
+	if isCID {
+		v := topDict.get(cffFDArray)
+		if len(v) != 1 || v[0] < 0 || len(b) <= v[0] {
+			return nil, ErrInvalidFontData
+		}
+		r.Seek(uint32(v[0]))
+		fdArray, err := parseCFFIndex(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range fdArray {
+			fontDict, err := parseCFFDict(item)
+			if err != nil {
+				return nil, err
+			}
+			v := fontDict.get(cffPrivate)
+			if len(v) != 2 {
+				return nil, ErrInvalidFontData
+			}
+			private, size, err := subsetCFFPrivate(b, v[0], v[1])
+			if err != nil {
+				return nil, err
+			}
+			fontDicts = append(fontDicts, fontDict.set(cffPrivate, 0, 0))
+			privates = append(privates, private)
+			privateSizes = append(privateSizes, size)
+		}
+	} else {
+		v := topDict.get(cffPrivate)
+		if len(v) != 2 {
+			return nil, ErrInvalidFontData
+		}
+		private, size, err := subsetCFFPrivate(b, v[0], v[1])
+		if err != nil {
+			return nil, err
+		}
+		privates = append(privates, private)
+		privateSizes = append(privateSizes, size)
+	}
+
+	// all offsets are written as five byte integers, so that the DICT sizes do not depend on the offsets
+	topDict = topDict.remove(cffEncoding)
+	topDict = topDict.set(cffCharset, 0)
+	topDict = topDict.set(cffCharStrings, 0)
+	if isCID {
+		topDict = topDict.set(cffCIDCount, len(glyphs))
+		topDict = topDict.set(cffFDSelect, 0)
+		topDict = topDict.set(cffFDArray, 0)
+	} else {
+		topDict = topDict.set(cffPrivate, 0, 0)
+	}
+	topDictIndex := newBinaryWriter([]byte{})
+	writeCFFIndex(topDictIndex, [][]byte{topDict.bytes()})
+	fdArrayIndex := newBinaryWriter([]byte{})
+	if isCID {
+		items := [][]byte{}
+		for _, fontDict := range fontDicts {
+			items = append(items, fontDict.bytes())
+		}
+		writeCFFIndex(fdArrayIndex, items)
+	}
+
+	// layout
+	pos := int(b[2]) + len(nameIndex) + int(topDictIndex.Len()) + len(stringsAndSubrs)
+	charsetOffset := pos
+	pos += int(charset.Len())
+	fdSelectOffset := pos
+	if isCID {
+		pos += int(fdSelect.Len())
+	}
+	charStringsOffset := pos
+	pos += int(charStringsIndex.Len())
+	fdArrayOffset := pos
+	pos += int(fdArrayIndex.Len())
+	privateOffsets := []int{}
+	for _, private := range privates {
+		privateOffsets = append(privateOffsets, pos)
+		pos += len(private)
+	}
+
+	topDict = topDict.set(cffCharset, charsetOffset)
+	topDict = topDict.set(cffCharStrings, charStringsOffset)
+	if isCID {
+		topDict = topDict.set(cffFDSelect, fdSelectOffset)
+		topDict = topDict.set(cffFDArray, fdArrayOffset)
+		items := [][]byte{}
+		for i, fontDict := range fontDicts {
+			items = append(items, fontDict.set(cffPrivate, privateSizes[i], privateOffsets[i]).bytes())
+		}
+		fdArrayIndex = newBinaryWriter([]byte{})
+		writeCFFIndex(fdArrayIndex, items)
+	} else {
+		topDict = topDict.set(cffPrivate, privateSizes[0], privateOffsets[0])
+	}
+	topDictIndex = newBinaryWriter([]byte{})
+	writeCFFIndex(topDictIndex, [][]byte{topDict.bytes()})
+
+	w := newBinaryWriter([]byte{})
+	w.WriteBytes(b[:b[2]])
+	w.WriteBytes(nameIndex)
+	w.WriteBytes(topDictIndex.Bytes())
+	w.WriteBytes(stringsAndSubrs)
+	w.WriteBytes(charset.Bytes())
+	if isCID {
+		w.WriteBytes(fdSelect.Bytes())
+	}
+	w.WriteBytes(charStringsIndex.Bytes())
+	w.WriteBytes(fdArrayIndex.Bytes())
+	for _, private := range privates {
+		w.WriteBytes(private)
+	}
+	if int(w.Len()) != pos {
+		return nil, fmt.Errorf("bad layout")
+	}
+	return w.Bytes(), nil
+}
+
+// parseCFFCharset fills the SIDs (or CIDs) of the glyphs from the charset at the given offset.
+func parseCFFCharset(b []byte, offset int, sids []uint16) error {
+	r := newBinaryReader(b)
+	r.Seek(uint32(offset))
+	format := r.ReadByte()
+	for glyphID := 1; glyphID < len(sids); {
+		switch format {
+		case 0:
+			sids[glyphID] = r.ReadUint16()
+			glyphID++
+		case 1, 2:
+			first := r.ReadUint16()
+			nLeft := uint16(r.ReadByte())
+			if format == 2 {
+				nLeft = nLeft<<8 | uint16(r.ReadByte())
+			}
+			for i := uint16(0); i <= nLeft && glyphID < len(sids); i++ {
+				sids[glyphID] = first + i
+				glyphID++
+			}
+		default:
+			return fmt.Errorf("unsupported charset format %d", format)
+		}
+		if r.EOF() {
+			return ErrInvalidFontData
+		}
+	}
+	return nil
+}
+
+// parseCFFFDSelect returns the Font DICT index of the glyphs from the FDSelect at the given offset.
+func parseCFFFDSelect(b []byte, offset, numGlyphs int) ([]byte, error) {
+	fds := make([]byte, numGlyphs)
+	r := newBinaryReader(b)
+	r.Seek(uint32(offset))
+	switch format := r.ReadByte(); format {
+	case 0:
+		copy(fds, r.ReadBytes(uint32(numGlyphs)))
+	case 3:
+		nRanges := r.ReadUint16()
+		first := r.ReadUint16()
+		for i := 0; i < int(nRanges); i++ {
+			fd := r.ReadByte()
+			next := r.ReadUint16()
+			if next < first || numGlyphs < int(next) {
+				return nil, ErrInvalidFontData
+			}
+			for glyphID := first; glyphID < next; glyphID++ {
+				fds[glyphID] = fd
+			}
+			first = next
+		}
+	default:
+		return nil, fmt.Errorf("unsupported FDSelect format %d", format)
+	}
+	if r.EOF() {
+		return nil, ErrInvalidFontData
+	}
+	return fds, nil
+}
@@ -0,0 +1,88 @@
+package font
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/test"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestSubset(t *testing.T) {
+	var tts = []struct {
+		filename string
+		text     string
+	}{
+		{"DejaVuSerif.ttf", "Hello, world!"},
+		{"DejaVuSerif.ttf", "ÀÉîõü"}, // composite glyphs
+		{"EBGaramond12-Regular.otf", "Hello, world!"},
+	}
+	for _, tt := range tts {
+		t.Run(tt.filename, func(t *testing.T) {
+			b, err := ioutil.ReadFile(tt.filename)
+			test.Error(t, err)
+			font, err := sfnt.Parse(b)
+			test.Error(t, err)
+
+			var buf sfnt.Buffer
+			glyphIDs := []uint16{0}
+			runes := []rune{}
+			for _, r := range tt.text {
+				if strings.ContainsRune(string(runes), r) {
+					continue
+				}
+				glyphID, err := font.GlyphIndex(&buf, r)
+				test.Error(t, err)
+				glyphIDs = append(glyphIDs, uint16(glyphID))
+				runes = append(runes, r)
+			}
+
+			subset, err := Subset(b, glyphIDs)
+			test.Error(t, err)
+			test.That(t, len(subset) < len(b), "subset must be smaller")
+
+			subsetFont, err := sfnt.Parse(subset)
+			test.Error(t, err)
+			test.That(t, len(glyphIDs) <= subsetFont.NumGlyphs(), "subset must contain all glyphs")
+			test.That(t, subsetFont.NumGlyphs() < font.NumGlyphs(), "subset must contain fewer glyphs")
+
+			ppem := fixed.I(int(font.UnitsPerEm()))
+			for i, r := range runes {
+				glyphID, err := subsetFont.GlyphIndex(&buf, r)
+				test.Error(t, err)
+				test.T(t, glyphID, sfnt.GlyphIndex(i+1), string(r))
+
+				advance, err := font.GlyphAdvance(&buf, sfnt.GlyphIndex(glyphIDs[i+1]), ppem, 0)
+				test.Error(t, err)
+				subsetAdvance, err := subsetFont.GlyphAdvance(&buf, glyphID, ppem, 0)
+				test.Error(t, err)
+				test.T(t, subsetAdvance, advance, string(r))
+
+				segments, err := font.LoadGlyph(&buf, sfnt.GlyphIndex(glyphIDs[i+1]), ppem, nil)
+				test.Error(t, err)
+				n := len(segments)
+				subsetSegments, err := subsetFont.LoadGlyph(&buf, glyphID, ppem, nil)
+				test.Error(t, err)
+				test.T(t, len(subsetSegments), n, string(r))
+			}
+
+			glyphID, err := subsetFont.GlyphIndex(&buf, 'x')
+			test.Error(t, err)
+			test.T(t, glyphID, sfnt.GlyphIndex(0), "unused character must not be mapped")
+		})
+	}
+}
+
+func TestSubsetError(t *testing.T) {
+	b, err := ioutil.ReadFile("DejaVuSerif.ttf")
+	test.Error(t, err)
+
+	_, err = Subset(b, []uint16{})
+	test.T(t, err.Error(), "first glyph must be .notdef")
+	_, err = Subset(b, []uint16{0, 65535})
+	test.T(t, err.Error(), "glyph 65535 does not exist")
+	_, err = Subset(b[:10], []uint16{0})
+	test.T(t, err, ErrInvalidFontData)
+}
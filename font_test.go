@@ -59,6 +59,8 @@ func TestSubstitutes(t *testing.T) {
 	font.Use(CommonLigatures)
 
 	test.String(t, font.substituteLigatures("fi fl ffi ffl"), "ﬁ ﬂ ﬃ ﬄ")
+	test.String(t, font.LigatureText('ﬃ'), "ffi")
+	test.String(t, font.LigatureText('f'), "f")
 	s, inSingleQuote, inDoubleQuote := font.substituteTypography(`... . . . --- -- (c) (r) (tm) 1/2 1/4 3/4 +/- '' ""`, false, false)
 	test.String(t, s, "… … — – © ® ™ ½ ¼ ¾ ± ‘’ “”")
 	test.That(t, !inSingleQuote)
@@ -55,6 +55,11 @@ func NewFontFamily(name string) *FontFamily {
 	}
 }
 
+// Name returns the name of the font family.
+func (family *FontFamily) Name() string {
+	return family.name
+}
+
 // LoadLocalFont loads a font from the system fonts location.
 func (family *FontFamily) LoadLocalFont(name string, style FontStyle) error {
 	match := name
@@ -4,6 +4,7 @@ package htmlcanvas
 
 import (
 	"image"
+	"image/color"
 	"math"
 	"syscall/js"
 
@@ -15,6 +16,17 @@ type htmlCanvas struct {
 	width, height float64
 	dpm           float64
 	style         canvas.Style
+	styleStack    []canvas.Style
+	groups        []htmlGroup
+}
+
+// htmlGroup is a group that is drawn to an offscreen canvas, which is drawn onto the enclosing canvas when ended.
+type htmlGroup struct {
+	ctx       js.Value // enclosing context
+	style     canvas.Style
+	opacity   float64
+	blendMode canvas.BlendMode
+	mask      *js.Value // offscreen canvas of which the alpha masks the group, can be nil
 }
 
 func New(c js.Value, width, height, dpm float64) *htmlCanvas {
@@ -38,13 +50,7 @@ func (r *htmlCanvas) Size() (float64, float64) {
 	return r.width / r.dpm, r.height / r.dpm
 }
 
-func (r *htmlCanvas) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
-	if path.Empty() {
-		return
-	}
-	path = path.Transform(m)
-	path = path.ReplaceArcs()
-
+func (r *htmlCanvas) writePath(path *canvas.Path) {
 	r.ctx.Call("beginPath")
 	path.Iterate(func(start, end canvas.Point) {
 		r.ctx.Call("moveTo", end.X*r.dpm, r.height-end.Y*r.dpm)
@@ -59,14 +65,110 @@ func (r *htmlCanvas) RenderPath(path *canvas.Path, style canvas.Style, m canvas.
 	}, func(start, end canvas.Point) {
 		r.ctx.Call("closePath")
 	})
+}
+
+func (r *htmlCanvas) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	r.styleStack = append(r.styleStack, r.style)
+	r.ctx.Call("save")
+	r.writePath(path.Transform(m).ReplaceArcs())
+	if fillRule == canvas.EvenOdd {
+		r.ctx.Call("clip", "evenodd")
+	} else {
+		r.ctx.Call("clip", "nonzero")
+	}
+}
 
-	if style.FillColor.A != 0 {
-		if style.FillColor != r.style.FillColor {
+func (r *htmlCanvas) PopClip() {
+	if len(r.styleStack) == 0 {
+		return
+	}
+	r.ctx.Call("restore")
+	r.style = r.styleStack[len(r.styleStack)-1]
+	r.styleStack = r.styleStack[:len(r.styleStack)-1]
+}
+
+func (r *htmlCanvas) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	r.pushGroup(htmlGroup{opacity: opacity, blendMode: blendMode})
+}
+
+func (r *htmlCanvas) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	c := js.Global().Get("document").Call("createElement", "canvas")
+	mask.RenderView(New(c, r.width/r.dpm, r.height/r.dpm, r.dpm), m)
+	if maskType == canvas.LuminanceMask {
+		// move the luminance to the alpha channel
+		ctx := c.Call("getContext", "2d")
+		imgData := ctx.Call("getImageData", 0, 0, c.Get("width"), c.Get("height"))
+		data := js.Global().Get("Uint8Array").New(imgData.Get("data").Get("buffer")) // CopyBytesToGo requires an Uint8Array
+		pix := make([]byte, data.Get("length").Int())
+		js.CopyBytesToGo(pix, data)
+		for i := 0; i < len(pix); i += 4 {
+			col := color.RGBA{pix[i], pix[i+1], pix[i+2], pix[i+3]}
+			col.R = uint8((uint32(col.R)*uint32(col.A) + 127) / 255) // premultiply
+			col.G = uint8((uint32(col.G)*uint32(col.A) + 127) / 255)
+			col.B = uint8((uint32(col.B)*uint32(col.A) + 127) / 255)
+			pix[i+3] = maskType.Mask(col)
+		}
+		js.CopyBytesToJS(data, pix)
+		ctx.Call("putImageData", imgData, 0, 0)
+	}
+	r.pushGroup(htmlGroup{opacity: 1.0, blendMode: canvas.NormalBlend, mask: &c})
+}
+
+func (r *htmlCanvas) PopMask() {
+	r.PopGroup()
+}
+
+func (r *htmlCanvas) pushGroup(group htmlGroup) {
+	group.ctx, group.style = r.ctx, r.style
+	r.groups = append(r.groups, group)
+
+	c := js.Global().Get("document").Call("createElement", "canvas")
+	c.Set("width", r.width)
+	c.Set("height", r.height)
+	r.ctx = c.Call("getContext", "2d")
+	r.ctx.Set("imageSmoothingEnabled", true)
+	r.ctx.Set("imageSmoothingQuality", "high")
+	r.style = canvas.DefaultStyle
+}
+
+func (r *htmlCanvas) PopGroup() {
+	if len(r.groups) == 0 {
+		return
+	}
+	group := r.groups[len(r.groups)-1]
+	r.groups = r.groups[:len(r.groups)-1]
+
+	if group.mask != nil {
+		r.ctx.Set("globalCompositeOperation", "destination-in")
+		r.ctx.Call("drawImage", *group.mask, 0, 0)
+	}
+
+	c := r.ctx.Get("canvas")
+	r.ctx, r.style = group.ctx, group.style
+	r.ctx.Call("save")
+	r.ctx.Set("globalAlpha", group.opacity)
+	if group.blendMode != canvas.NormalBlend {
+		r.ctx.Set("globalCompositeOperation", group.blendMode.CSS())
+	}
+	r.ctx.Call("drawImage", c, 0, 0)
+	r.ctx.Call("restore")
+}
+
+func (r *htmlCanvas) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
+	if path.Empty() {
+		return
+	}
+	r.writePath(path.Transform(m).ReplaceArcs())
+
+	if style.HasFill() {
+		if style.FillPaint != nil {
+			r.ctx.Set("fillStyle", r.paint(style.FillColor, style.FillPaint, m))
+		} else if style.FillColor != r.style.FillColor || r.style.FillPaint != nil {
 			r.ctx.Set("fillStyle", canvas.CSSColor(style.FillColor).String())
 		}
 		r.ctx.Call("fill")
 	}
-	if style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth {
+	if style.HasStroke() {
 		if style.StrokeCapper != r.style.StrokeCapper {
 			if _, ok := style.StrokeCapper.(canvas.RoundCapper); ok {
 				r.ctx.Set("lineCap", "round")
@@ -118,7 +220,9 @@ func (r *htmlCanvas) RenderPath(path *canvas.Path, style canvas.Style, m canvas.
 		if style.StrokeWidth != r.style.StrokeWidth {
 			r.ctx.Set("lineWidth", style.StrokeWidth*r.dpm)
 		}
-		if style.StrokeColor != r.style.StrokeColor {
+		if style.StrokePaint != nil {
+			r.ctx.Set("strokeStyle", r.paint(style.StrokeColor, style.StrokePaint, m))
+		} else if style.StrokeColor != r.style.StrokeColor || r.style.StrokePaint != nil {
 			r.ctx.Set("strokeStyle", canvas.CSSColor(style.StrokeColor).String())
 		}
 		r.ctx.Call("stroke")
@@ -126,6 +230,30 @@ func (r *htmlCanvas) RenderPath(path *canvas.Path, style canvas.Style, m canvas.
 	r.style = style
 }
 
+// paint returns a canvas gradient for the paint transformed by m, or the CSS color if the paint is not supported. Gradients always use the pad spread method.
+func (r *htmlCanvas) paint(col color.RGBA, paint canvas.Paint, m canvas.Matrix) interface{} {
+	var gradient js.Value
+	var stops []canvas.GradientStop
+	switch p := paint.(type) {
+	case *canvas.LinearGradient:
+		start, end := m.Dot(p.Start), m.Dot(p.End)
+		gradient = r.ctx.Call("createLinearGradient", start.X*r.dpm, r.height-start.Y*r.dpm, end.X*r.dpm, r.height-end.Y*r.dpm)
+		stops = p.Stops
+	case *canvas.RadialGradient:
+		// radii are only exact for conformal transformations
+		scale := math.Sqrt(math.Abs(m.Det()))
+		c0, c1 := m.Dot(p.C0), m.Dot(p.C1)
+		gradient = r.ctx.Call("createRadialGradient", c0.X*r.dpm, r.height-c0.Y*r.dpm, p.R0*scale*r.dpm, c1.X*r.dpm, r.height-c1.Y*r.dpm, p.R1*scale*r.dpm)
+		stops = p.Stops
+	default:
+		return canvas.CSSColor(col).String()
+	}
+	for _, stop := range stops {
+		gradient.Call("addColorStop", stop.Offset, canvas.CSSColor(stop.Color).String())
+	}
+	return gradient
+}
+
 func (r *htmlCanvas) RenderText(text *canvas.Text, m canvas.Matrix) {
 	canvas.RenderTextAsPath(r, text, m)
 }
@@ -1,203 +1,232 @@
 package opengl
 
-//import (
-//	"fmt"
-//	"image/color"
-//	"strings"
-//
-//	"github.com/go-gl/gl/v4.6-core/gl"
-//)
-//
-//var vertexShaderSource = `
-//	#version 410
-//	in vec2 position;
-//	in vec4 vertTexcoord;
-//	in vec4 vertColor;
-//
-//	out vec4 fragTexcoord;
-//	out vec4 fragColor;
-//
-//	void main() {
-//		gl_Position = vec4(position, 0.0, 1.0);
-//		fragTexcoord = vertTexcoord;
-//		fragColor = vertColor;
-//	}
-//` + "\x00"
-//
-//var fragmentShaderSource = `
-//	#version 410
-//	in vec4 fragTexcoord;
-//	in vec4 fragColor;
-//
-//	out vec4 color;
-//
-//	void main() {
-//		float u = fragTexcoord.s;
-//		float v = fragTexcoord.t;
-//		float w1 = fragTexcoord.p;
-//		float w2 = fragTexcoord.q;
-//
-//		float denom = ((1-u)*(1-u)*(1-u) + w1*(1-u)*(1-u)*u + w2*(1-u)*u*u + u*u*u);
-//		float f = v - (w1*(1-u)*(1-u)*u + w2*(1-u)*u*u) / denom;
-//		float gx = dFdx(fragTexcoord.st)
-//		float gy = dFdy(fragTexcoord.st)
-//		float g =
-//		float e = 0.5 - f / sqrt(g.x*g.x+g.y*g.y);
-//
-//		vec2 p = fragTexcoord.st;
-//		vec2 px = dFdx(p);
-//		vec2 py = dFdy(p);
-//		float fx = (2*p.x)*px.x - px.y;
-//		float fy = (2*p.x)*py.x - py.y;
-//		float sd = (p.x*p.x - p.y)/sqrt(fx*fx + fy*fy);
-//
-//		float alpha = 0.5 - sd;
-//		if (e >= 1)
-//			color = fragColor;
-//		else if (e <= 0)
-//			discard;
-//		else
-//			color = vec4(fragColor.rgb, fragColor.a*e);
-//	}
-//` + "\x00"
-//
-//func compileShader(source string, shaderType uint32) (uint32, error) {
-//	shader := gl.CreateShader(shaderType)
-//
-//	csources, free := gl.Strs(source)
-//	gl.ShaderSource(shader, 1, csources, nil)
-//	free()
-//	gl.CompileShader(shader)
-//
-//	var status int32
-//	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
-//	if status == gl.FALSE {
-//		var logLength int32
-//		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
-//
-//		log := strings.Repeat("\x00", int(logLength+1))
-//		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
-//
-//		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
-//	}
-//
-//	return shader, nil
-//}
-//
-//type OpenGL struct {
-//	points       []float32
-//	program, vao uint32
-//	n            int32
-//}
-//
-//func newOpenGL() *OpenGL {
-//	return &OpenGL{}
-//}
-//
-//func (ogl *OpenGL) AddPath(p *Path, color color.RGBA) {
-//	a := float32(color.A) / 255.0
-//	r := float32(color.R) / 255.0 / a
-//	g := float32(color.G) / 255.0 / a
-//	b := float32(color.B) / 255.0 / a
-//
-//	triangles, beziers := p.Tessellate()
-//	for _, tr := range triangles {
-//		ogl.points = append(ogl.points, float32(tr[0].X), float32(tr[0].Y), 0.5, 0.0, 0.0, 0.0, r, g, b, a)
-//		ogl.points = append(ogl.points, float32(tr[1].X), float32(tr[1].Y), 0.5, 0.0, 0.0, 0.0, r, g, b, a)
-//		ogl.points = append(ogl.points, float32(tr[2].X), float32(tr[2].Y), 0.5, 0.0, 0.0, 0.0, r, g, b, a)
-//	}
-//	for _, bz := range beziers {
-//		w1 := float32(bz[4].X)
-//		w2 := float32(bz[4].Y)
-//		ogl.points = append(ogl.points, float32(bz[0].X), float32(bz[0].Y), 0.0, 0.0, w1, w2, r, g, b, a)
-//		ogl.points = append(ogl.points, float32(bz[2].X), float32(bz[2].Y), 1.0, 1.0, w1, w2, r, g, b, a)
-//		ogl.points = append(ogl.points, float32(bz[1].X), float32(bz[1].Y), 0.0, 1.0, w1, w2, r, g, b, a)
-//
-//		ogl.points = append(ogl.points, float32(bz[3].X), float32(bz[3].Y), 1.0, 0.0, w1, w2, r, g, b, a)
-//		ogl.points = append(ogl.points, float32(bz[2].X), float32(bz[2].Y), 1.0, 1.0, w1, w2, r, g, b, a)
-//		ogl.points = append(ogl.points, float32(bz[0].X), float32(bz[0].Y), 0.0, 0.0, w1, w2, r, g, b, a)
-//	}
-//}
-//
-//func (ogl *OpenGL) Compile() {
-//	const N = 10
-//
-//	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
-//	if err != nil {
-//		panic(err)
-//	}
-//	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
-//	if err != nil {
-//		panic(err)
-//	}
-//
-//	prog := gl.CreateProgram()
-//	gl.AttachShader(prog, vertexShader)
-//	gl.AttachShader(prog, fragmentShader)
-//	gl.LinkProgram(prog)
-//
-//	var vbo uint32
-//	gl.GenBuffers(1, &vbo)
-//	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
-//	gl.BufferData(gl.ARRAY_BUFFER, 4*len(ogl.points), gl.Ptr(ogl.points), gl.STATIC_DRAW)
-//
-//	var vao uint32
-//	gl.GenVertexArrays(1, &vao)
-//	gl.BindVertexArray(vao)
-//
-//	vertexAttrib := uint32(gl.GetAttribLocation(prog, gl.Str("position\x00")))
-//	texcoordAttrib := uint32(gl.GetAttribLocation(prog, gl.Str("vertTexcoord\x00")))
-//	colorAttrib := uint32(gl.GetAttribLocation(prog, gl.Str("vertColor\x00")))
-//	gl.EnableVertexAttribArray(vertexAttrib)
-//	gl.EnableVertexAttribArray(texcoordAttrib)
-//	gl.EnableVertexAttribArray(colorAttrib)
-//	gl.VertexAttribPointer(vertexAttrib, 2, gl.FLOAT, false, N*4, gl.PtrOffset(0))
-//	gl.VertexAttribPointer(texcoordAttrib, 4, gl.FLOAT, false, N*4, gl.PtrOffset(2*4))
-//	gl.VertexAttribPointer(colorAttrib, 4, gl.FLOAT, false, N*4, gl.PtrOffset(6*4))
-//
-//	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-//	gl.BindVertexArray(0)
-//	gl.UseProgram(0)
-//
-//	ogl.program = prog
-//	ogl.vao = vao
-//	ogl.n = int32(len(ogl.points) / N)
-//}
-//
-//func (ogl *OpenGL) Draw() {
-//	gl.UseProgram(ogl.program)
-//	gl.BindVertexArray(ogl.vao)
-//
-//	gl.DrawArrays(gl.TRIANGLES, 0, ogl.n)
-//
-//	gl.BindVertexArray(0)
-//	gl.UseProgram(0)
-//}
-//
-//func (l pathLayer) ToOpenGL(ogl *OpenGL) {
-//	// TODO: use fill rule (EvenOdd) for OpenGL
-//	if l.fillColor.A != 0 {
-//		ogl.AddPath(l.path, l.fillColor)
-//	}
-//	if l.strokeColor.A != 0 && 0.0 < l.strokeWidth {
-//		strokePath := l.path
-//		if 0 < len(l.dashes) {
-//			strokePath = strokePath.Dash(l.dashOffset, l.dashes...)
-//		}
-//		strokePath = strokePath.Stroke(l.strokeWidth, l.strokeCapper, l.strokeJoiner)
-//		ogl.AddPath(strokePath, l.strokeColor)
-//	}
-//}
-//
-//func (l textLayer) ToOpenGL(ogl *OpenGL) {
-//	paths, colors := l.text.ToPaths()
-//	for i, path := range paths {
-//		state := defaultDrawState
-//		state.fillColor = colors[i]
-//		pathLayer{path.Transform(l.m), state, false}.ToOpenGL(ogl)
-//	}
-//}
-//
-//func (l imageLayer) ToOpenGL(ogl *OpenGL) {
-//	panic("images not supported in OpenGL")
-//}
+import (
+	"image"
+	"image/color"
+
+	"github.com/tdewolff/canvas"
+)
+
+// VertexShader is the GLSL vertex shader for the vertex data, the uniform size must be set to the canvas size in millimeters.
+const VertexShader = `
+#version 330 core
+uniform vec2 size;
+
+in vec2 position;
+in vec3 texcoord;
+in vec4 color;
+
+out vec3 fragTexcoord;
+out vec4 fragColor;
+
+void main() {
+	gl_Position = vec4(2.0*position/size - 1.0, 0.0, 1.0);
+	fragTexcoord = texcoord;
+	fragColor = color;
+}
+` + "\x00"
+
+// FragmentShader is the GLSL fragment shader for the vertex data, the uniform image must be bound to the texture of the batch's image. It outputs alpha-premultiplied colors, so blending should use GL_ONE and GL_ONE_MINUS_SRC_ALPHA.
+const FragmentShader = `
+#version 330 core
+uniform sampler2D image;
+
+in vec3 fragTexcoord;
+in vec4 fragColor;
+
+out vec4 color;
+
+void main() {
+	float kind = fragTexcoord.z;
+	if (abs(kind) < 0.5) {
+		color = fragColor;
+		return;
+	} else if (1.5 < kind) {
+		color = texture(image, fragTexcoord.xy) * fragColor;
+		return;
+	}
+
+	// Loop-Blinn, anti-aliased using the signed distance to the curve
+	vec2 p = fragTexcoord.xy;
+	vec2 px = dFdx(p);
+	vec2 py = dFdy(p);
+	float fx = 2.0*p.x*px.x - px.y;
+	float fy = 2.0*p.x*py.x - py.y;
+	float sd = kind * (p.x*p.x - p.y) / sqrt(fx*fx + fy*fy);
+	float alpha = clamp(0.5 - sd, 0.0, 1.0);
+	if (alpha <= 0.0) {
+		discard;
+	}
+	color = fragColor * alpha;
+}
+` + "\x00"
+
+// VertexSize is the number of float32 values per vertex. Each vertex consists of its position (x,y) in millimeters, texture coordinates (u,v,kind) and alpha-premultiplied color (r,g,b,a). The kind is 0 for triangles that are filled completely, 1 or -1 for Loop-Blinn curve triangles that are filled at the side where kind*(u^2-v) < 0, and 2 for image triangles that are textured at (u,v).
+const VertexSize = 9
+
+const (
+	fillKind    = 0.0
+	convexKind  = 1.0
+	concaveKind = -1.0
+	imageKind   = 2.0
+)
+
+// Batch is a range of indices that is drawn with the same texture.
+type Batch struct {
+	Image         image.Image // texture for the image triangles, or nil
+	Offset, Count int         // range in Indices
+}
+
+// OpenGL is a renderer that tessellates paths, text and images into triangles, which are stored in vertex and index buffers that can be drawn by OpenGL using VertexShader and FragmentShader. Paints are drawn using their average color, and clipping paths, groups and masks are ignored.
+type OpenGL struct {
+	width, height float64
+
+	Vertices []float32 // see VertexSize
+	Indices  []uint32  // triangles as indices into Vertices
+	Batches  []Batch
+}
+
+// Draw tessellates the canvas into the vertex and index buffers of a new OpenGL renderer.
+func Draw(c *canvas.Canvas) *OpenGL {
+	ogl := New(c.W, c.H)
+	c.Render(ogl)
+	return ogl
+}
+
+// New creates an OpenGL renderer.
+func New(width, height float64) *OpenGL {
+	return &OpenGL{
+		width:  width,
+		height: height,
+	}
+}
+
+// Size returns the width and height in millimeters.
+func (r *OpenGL) Size() (float64, float64) {
+	return r.width, r.height
+}
+
+// addVertex adds a vertex and returns its index.
+func (r *OpenGL) addVertex(p canvas.Point, u, v, kind float32, col color.RGBA) uint32 {
+	i := uint32(len(r.Vertices) / VertexSize)
+	r.Vertices = append(r.Vertices, float32(p.X), float32(p.Y), u, v, kind, float32(col.R)/255.0, float32(col.G)/255.0, float32(col.B)/255.0, float32(col.A)/255.0)
+	return i
+}
+
+// addIndices adds the indices of triangles to the last batch if it uses the same texture, or to a new batch otherwise.
+func (r *OpenGL) addIndices(img image.Image, indices ...uint32) {
+	if len(r.Batches) == 0 || r.Batches[len(r.Batches)-1].Image != img {
+		r.Batches = append(r.Batches, Batch{img, len(r.Indices), 0})
+	}
+	r.Indices = append(r.Indices, indices...)
+	r.Batches[len(r.Batches)-1].Count += len(indices)
+}
+
+func (r *OpenGL) addPath(path *canvas.Path, fillRule canvas.FillRule, col color.RGBA) {
+	mesh, curves := path.Tessellate(fillRule)
+
+	offset := uint32(len(r.Vertices) / VertexSize)
+	for _, p := range mesh.Vertices {
+		r.addVertex(p, 0.0, 0.0, fillKind, col)
+	}
+	for _, tr := range mesh.Triangles {
+		r.addIndices(nil, offset+uint32(tr[0]), offset+uint32(tr[1]), offset+uint32(tr[2]))
+	}
+
+	for _, curve := range curves {
+		kind := float32(concaveKind)
+		if curve.Convex {
+			kind = convexKind
+		}
+		i0 := r.addVertex(curve.Start, 0.0, 0.0, kind, col)
+		i1 := r.addVertex(curve.Control, 0.5, 0.0, kind, col)
+		i2 := r.addVertex(curve.End, 1.0, 1.0, kind, col)
+		r.addIndices(nil, i0, i1, i2)
+	}
+}
+
+// paintColor returns the average color of the paint over the bounds in the coordinate system of the paint.
+func paintColor(paint canvas.Paint, bounds canvas.Rect) color.RGBA {
+	const n = 8 // samples per dimension
+	var sum [4]float64
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			x := bounds.X + (float64(i)+0.5)*bounds.W/n
+			y := bounds.Y + (float64(j)+0.5)*bounds.H/n
+			col := paint.At(x, y)
+			sum[0] += float64(col.R)
+			sum[1] += float64(col.G)
+			sum[2] += float64(col.B)
+			sum[3] += float64(col.A)
+		}
+	}
+	return color.RGBA{
+		R: uint8(sum[0]/(n*n) + 0.5),
+		G: uint8(sum[1]/(n*n) + 0.5),
+		B: uint8(sum[2]/(n*n) + 0.5),
+		A: uint8(sum[3]/(n*n) + 0.5),
+	}
+}
+
+// RenderPath tessellates the filled path and its stroke. Paints are not supported and are drawn using their average color over the bounds of the path.
+func (r *OpenGL) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
+	// TODO: (OpenGL) support paints
+	fillColor, strokeColor := style.FillColor, style.StrokeColor
+	if style.FillPaint != nil {
+		fillColor = paintColor(style.FillPaint, path.Bounds())
+	}
+	if style.StrokePaint != nil {
+		strokeColor = paintColor(style.StrokePaint, path.Bounds())
+	}
+
+	path = path.Transform(m)
+	if style.HasFill() && fillColor.A != 0 {
+		r.addPath(path, style.FillRule, fillColor)
+	}
+	if style.HasStroke() && strokeColor.A != 0 {
+		if 0 < len(style.Dashes) {
+			path = path.Dash(style.DashOffset, style.Dashes...)
+		}
+		path = path.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
+		r.addPath(path, canvas.NonZero, strokeColor)
+	}
+}
+
+func (r *OpenGL) RenderText(text *canvas.Text, m canvas.Matrix) {
+	canvas.RenderTextAsPath(r, text, m)
+}
+
+func (r *OpenGL) RenderImage(img image.Image, m canvas.Matrix) {
+	// the image spans one unit per pixel, where the first row of the texture is the top of the image
+	size := img.Bounds().Size()
+	w, h := float64(size.X), float64(size.Y)
+	i0 := r.addVertex(m.Dot(canvas.Point{X: 0.0, Y: 0.0}), 0.0, 1.0, imageKind, canvas.White)
+	i1 := r.addVertex(m.Dot(canvas.Point{X: w, Y: 0.0}), 1.0, 1.0, imageKind, canvas.White)
+	i2 := r.addVertex(m.Dot(canvas.Point{X: w, Y: h}), 1.0, 0.0, imageKind, canvas.White)
+	i3 := r.addVertex(m.Dot(canvas.Point{X: 0.0, Y: h}), 0.0, 0.0, imageKind, canvas.White)
+	r.addIndices(img, i0, i1, i2, i0, i2, i3)
+}
+
+// PushClip is a no-op for the OpenGL renderer. Clipping paths are not supported and are ignored, so that the following drawings are not clipped.
+func (r *OpenGL) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	// TODO: (OpenGL) support clipping paths
+}
+
+func (r *OpenGL) PopClip() {
+}
+
+// PushGroup is a no-op for the OpenGL renderer. Groups are not supported and are ignored, so that the following drawings are drawn directly without the opacity and blend mode of the group.
+func (r *OpenGL) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	// TODO: (OpenGL) support groups
+}
+
+func (r *OpenGL) PopGroup() {
+}
+
+// PushMask is a no-op for the OpenGL renderer. Masks are not supported and are ignored, so that the following drawings are not masked.
+func (r *OpenGL) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	// TODO: (OpenGL) support masks
+}
+
+func (r *OpenGL) PopMask() {
+}
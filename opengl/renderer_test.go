@@ -0,0 +1,63 @@
+package opengl
+
+import (
+	"image"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+)
+
+func TestOpenGLPath(t *testing.T) {
+	ogl := New(10.0, 10.0)
+	ogl.RenderPath(canvas.Rectangle(5.0, 5.0), canvas.DefaultStyle, canvas.Identity.Translate(1.0, 0.0))
+	test.T(t, len(ogl.Vertices), 4*VertexSize)
+	test.T(t, len(ogl.Indices), 6)
+	test.T(t, ogl.Batches, []Batch{{nil, 0, 6}})
+	for i := 0; i < len(ogl.Vertices); i += VertexSize {
+		test.That(t, ogl.Vertices[i] == 1.0 || ogl.Vertices[i] == 6.0)
+		test.T(t, ogl.Vertices[i+4], float32(fillKind))
+		test.T(t, ogl.Vertices[i+5:i+9], []float32{0.0, 0.0, 0.0, 1.0})
+	}
+
+	style := canvas.DefaultStyle
+	style.FillColor = canvas.Transparent
+	style.StrokeColor = canvas.Red
+	style.StrokeWidth = 1.0
+	ogl = New(10.0, 10.0)
+	ogl.RenderPath(canvas.Circle(2.0), style, canvas.Identity)
+	test.That(t, 0 < len(ogl.Indices))
+	kinds := map[float32]bool{}
+	for i := 0; i < len(ogl.Vertices); i += VertexSize {
+		kinds[ogl.Vertices[i+4]] = true
+	}
+	test.T(t, kinds, map[float32]bool{fillKind: true, convexKind: true, concaveKind: true})
+}
+
+func TestOpenGLPaint(t *testing.T) {
+	gradient := canvas.NewLinearGradient(0.0, 0.0, 10.0, 0.0)
+	gradient.Add(0.0, canvas.Black)
+	gradient.Add(1.0, canvas.White)
+
+	style := canvas.DefaultStyle
+	style.FillPaint = gradient
+	style.StrokePaint = gradient
+	style.StrokeWidth = 1.0
+	ogl := New(10.0, 10.0)
+	ogl.RenderPath(canvas.Rectangle(10.0, 5.0), style, canvas.Identity)
+
+	// the fill and stroke use the average color of the gradient
+	gray := []float32{128.0 / 255.0, 128.0 / 255.0, 128.0 / 255.0, 1.0}
+	test.T(t, ogl.Vertices[5:9], gray)
+	test.T(t, ogl.Vertices[len(ogl.Vertices)-4:], gray)
+}
+
+func TestOpenGLImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 4))
+	ogl := New(10.0, 10.0)
+	ogl.RenderPath(canvas.Rectangle(5.0, 5.0), canvas.DefaultStyle, canvas.Identity)
+	ogl.RenderImage(img, canvas.Identity.Translate(1.0, 1.0))
+	test.T(t, ogl.Batches, []Batch{{nil, 0, 6}, {img, 6, 6}})
+	test.T(t, ogl.Vertices[4*VertexSize:5*VertexSize], []float32{1.0, 1.0, 0.0, 1.0, imageKind, 1.0, 1.0, 1.0, 1.0})
+	test.T(t, ogl.Vertices[6*VertexSize:6*VertexSize+5], []float32{3.0, 5.0, 1.0, 0.0, imageKind})
+}
@@ -0,0 +1,372 @@
+package canvas
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Paint is a fill or stroke paint that is used instead of a solid color, such as LinearGradient and RadialGradient. The paint is defined in the coordinate system of the path it is painting, so that it transforms along with the path.
+type Paint interface {
+	// At returns the color of the paint at the given coordinate.
+	At(x, y float64) color.RGBA
+}
+
+// GradientSpread specifies how a gradient paints the area outside of its range, ie. when the gradient parameter is outside of [0,1].
+type GradientSpread int
+
+// see GradientSpread
+const (
+	PadSpread     GradientSpread = iota // continue with the color of the first or last stop
+	ReflectSpread                       // repeat the gradient in alternating directions
+	RepeatSpread                        // repeat the gradient
+)
+
+func (spread GradientSpread) String() string {
+	switch spread {
+	case PadSpread:
+		return "Pad"
+	case ReflectSpread:
+		return "Reflect"
+	case RepeatSpread:
+		return "Repeat"
+	}
+	return "Unknown"
+}
+
+// GradientStop is a color stop of a gradient at offset within [0,1].
+type GradientStop struct {
+	Offset float64
+	Color  color.RGBA
+}
+
+// Gradient holds the color stops and spread method of LinearGradient and RadialGradient. The gradient parameter t is zero at the start and one at the end of the gradient.
+type Gradient struct {
+	Stops  []GradientStop
+	Spread GradientSpread
+}
+
+// Add adds a color stop at the given offset within [0,1]. Adding multiple stops at the same offset results in an abrupt color change.
+func (g *Gradient) Add(offset float64, col color.Color) {
+	stop := GradientStop{
+		Offset: math.Max(0.0, math.Min(1.0, offset)),
+		Color:  rgbaColor(col),
+	}
+	i := sort.Search(len(g.Stops), func(i int) bool { return stop.Offset < g.Stops[i].Offset })
+	g.Stops = append(g.Stops, GradientStop{})
+	copy(g.Stops[i+1:], g.Stops[i:])
+	g.Stops[i] = stop
+}
+
+// spread maps the gradient parameter t to [0,1] using the spread method.
+func (g *Gradient) spread(t float64) float64 {
+	switch g.Spread {
+	case ReflectSpread:
+		t = math.Mod(math.Abs(t), 2.0)
+		if 1.0 < t {
+			t = 2.0 - t
+		}
+		return t
+	case RepeatSpread:
+		return t - math.Floor(t)
+	}
+	return math.Max(0.0, math.Min(1.0, t))
+}
+
+// ColorAt returns the color of the gradient at parameter t, taking into account the spread method.
+func (g *Gradient) ColorAt(t float64) color.RGBA {
+	if len(g.Stops) == 0 {
+		return Transparent
+	}
+	t = g.spread(t)
+	if t <= g.Stops[0].Offset {
+		return g.Stops[0].Color
+	}
+	for i, stop := range g.Stops[1:] {
+		if t < stop.Offset {
+			prev := g.Stops[i]
+			return interpolateColor(prev.Color, stop.Color, (t-prev.Offset)/(stop.Offset-prev.Offset))
+		}
+	}
+	return g.Stops[len(g.Stops)-1].Color
+}
+
+// StopsIn returns the color stops of the gradient between the parameters t0 and t1 (t0 < t1), taking into account the spread method. The offsets of the returned stops are gradient parameters within [t0,t1], and the first and last stop are at t0 and t1 respectively. This is useful for output formats that support only a single gradient range without repetition.
+func (g *Gradient) StopsIn(t0, t1 float64) []GradientStop {
+	if len(g.Stops) == 0 {
+		return []GradientStop{}
+	}
+
+	// stops of a single period covering [0,1]
+	period := g.Stops
+	if 0.0 < period[0].Offset {
+		period = append([]GradientStop{{0.0, period[0].Color}}, period...)
+	}
+	if period[len(period)-1].Offset < 1.0 {
+		period = append(period, GradientStop{1.0, period[len(period)-1].Color})
+	}
+
+	stops := []GradientStop{{t0, g.ColorAt(t0)}}
+	if g.Spread == PadSpread {
+		for _, stop := range period {
+			if t0 < stop.Offset && stop.Offset <= t1 {
+				stops = append(stops, stop)
+			}
+		}
+	} else {
+		kmin, kmax := math.Floor(t0), math.Ceil(t1)
+		if 1000.0 < kmax-kmin {
+			kmax = kmin + 1000.0 // limit the number of repetitions
+		}
+		for k := kmin; k < kmax; k++ {
+			reverse := g.Spread == ReflectSpread && math.Mod(math.Abs(k), 2.0) == 1.0
+			for i := range period {
+				stop := period[i]
+				if reverse {
+					stop = period[len(period)-1-i]
+					stop.Offset = 1.0 - stop.Offset
+				}
+				stop.Offset += k
+				if stop == stops[len(stops)-1] {
+					continue // coinciding stops at the border of reflected periods
+				} else if t0 < stop.Offset && stop.Offset <= t1 {
+					stops = append(stops, stop)
+				}
+			}
+		}
+	}
+	if stops[len(stops)-1].Offset < t1 {
+		stops = append(stops, GradientStop{t1, g.ColorAt(t1)})
+	}
+	return stops
+}
+
+func rgbaColor(col color.Color) color.RGBA {
+	r, g, b, a := col.RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+}
+
+func interpolateColor(a, b color.RGBA, t float64) color.RGBA {
+	return color.RGBA{
+		uint8(float64(a.R) + t*(float64(b.R)-float64(a.R)) + 0.5),
+		uint8(float64(a.G) + t*(float64(b.G)-float64(a.G)) + 0.5),
+		uint8(float64(a.B) + t*(float64(b.B)-float64(a.B)) + 0.5),
+		uint8(float64(a.A) + t*(float64(b.A)-float64(a.A)) + 0.5),
+	}
+}
+
+////////////////////////////////////////////////////////////////
+
+// LinearGradient is a gradient paint along the line from Start to End, where the color is constant perpendicular to that line.
+type LinearGradient struct {
+	Start, End Point
+	Gradient
+}
+
+// NewLinearGradient returns a new linear gradient from (x0,y0) to (x1,y1). Add color stops using Add.
+func NewLinearGradient(x0, y0, x1, y1 float64) *LinearGradient {
+	return &LinearGradient{
+		Start: Point{x0, y0},
+		End:   Point{x1, y1},
+	}
+}
+
+// T returns the gradient parameter at the given coordinate, which is zero at Start and one at End.
+func (g *LinearGradient) T(x, y float64) float64 {
+	d := g.End.Sub(g.Start)
+	dd := d.Dot(d)
+	if dd == 0.0 {
+		return 0.0
+	}
+	return Point{x, y}.Sub(g.Start).Dot(d) / dd
+}
+
+// At returns the color of the gradient at the given coordinate.
+func (g *LinearGradient) At(x, y float64) color.RGBA {
+	return g.ColorAt(g.T(x, y))
+}
+
+// Range returns the range of the gradient parameter over the rectangle.
+func (g *LinearGradient) Range(rect Rect) (float64, float64) {
+	return gradientRange(g.T, rect)
+}
+
+////////////////////////////////////////////////////////////////
+
+// RadialGradient is a gradient paint between two circles, the start circle with center C0 and radius R0 and the end circle with center C1 and radius R1. The color is constant over each circle interpolated between both circles. The most common case is a start circle with zero radius within the end circle, which sets the focal point of the gradient.
+type RadialGradient struct {
+	C0, C1 Point
+	R0, R1 float64
+	Gradient
+}
+
+// NewRadialGradient returns a new radial gradient between the start circle at (x0,y0) with radius r0 and the end circle at (x1,y1) with radius r1. Add color stops using Add.
+func NewRadialGradient(x0, y0, r0, x1, y1, r1 float64) *RadialGradient {
+	return &RadialGradient{
+		C0: Point{x0, y0},
+		R0: r0,
+		C1: Point{x1, y1},
+		R1: r1,
+	}
+}
+
+// T returns the gradient parameter at the given coordinate, which is zero at the start circle and one at the end circle. It returns NaN if the coordinate is not covered by the gradient.
+func (g *RadialGradient) T(x, y float64) float64 {
+	// find the largest t for which the point lies on the circle with center C0+t*(C1-C0) and radius R0+t*(R1-R0) >= 0
+	cd := g.C1.Sub(g.C0)
+	pd := Point{x, y}.Sub(g.C0)
+	dr := g.R1 - g.R0
+	a := cd.Dot(cd) - dr*dr
+	b := pd.Dot(cd) + g.R0*dr
+	c := pd.Dot(pd) - g.R0*g.R0
+
+	if math.Abs(a) < Epsilon {
+		if b == 0.0 {
+			return math.NaN()
+		}
+		if t := c / (2.0 * b); 0.0 <= g.R0+t*dr {
+			return t
+		}
+		return math.NaN()
+	}
+
+	disc := b*b - a*c
+	if disc < 0.0 {
+		return math.NaN()
+	}
+	t1 := (b + math.Sqrt(disc)) / a
+	t2 := (b - math.Sqrt(disc)) / a
+	if t1 < t2 {
+		t1, t2 = t2, t1
+	}
+	if 0.0 <= g.R0+t1*dr {
+		return t1
+	} else if 0.0 <= g.R0+t2*dr {
+		return t2
+	}
+	return math.NaN()
+}
+
+// At returns the color of the gradient at the given coordinate.
+func (g *RadialGradient) At(x, y float64) color.RGBA {
+	t := g.T(x, y)
+	if math.IsNaN(t) {
+		return Transparent
+	}
+	return g.ColorAt(t)
+}
+
+// Range returns the range of the gradient parameter over the rectangle, limited to where the circles have a non-negative radius.
+func (g *RadialGradient) Range(rect Rect) (float64, float64) {
+	t0, t1 := gradientRange(g.T, rect)
+	t0, t1 = math.Min(t0, 0.0), math.Max(t1, 1.0)
+	if dr := g.R1 - g.R0; 0.0 < dr {
+		t0 = math.Max(t0, -g.R0/dr)
+	} else if dr < 0.0 {
+		t1 = math.Min(t1, -g.R0/dr)
+	}
+	return t0, t1
+}
+
+// gradientRange returns the minimum and maximum of the gradient parameter at the corners of the rectangle.
+func gradientRange(T func(float64, float64) float64, rect Rect) (float64, float64) {
+	t0, t1 := math.Inf(1), math.Inf(-1)
+	for _, p := range []Point{{rect.X, rect.Y}, {rect.X + rect.W, rect.Y}, {rect.X, rect.Y + rect.H}, {rect.X + rect.W, rect.Y + rect.H}} {
+		if t := T(p.X, p.Y); !math.IsNaN(t) {
+			t0 = math.Min(t0, t)
+			t1 = math.Max(t1, t)
+		}
+	}
+	if math.IsInf(t0, 1) {
+		return 0.0, 1.0
+	}
+	return t0, t1
+}
+
+////////////////////////////////////////////////////////////////
+
+// Pattern is a tiling paint that repeats the cell of size W×H at the origin of the Cell canvas, where the tiles are transformed by View. Drawing outside of the cell is clipped.
+type Pattern struct {
+	Cell *Canvas
+	W, H float64
+	View Matrix
+}
+
+// NewPattern returns a new pattern that tiles the cell canvas with a cell size of w×h, transformed by view.
+func NewPattern(cell *Canvas, w, h float64, view Matrix) *Pattern {
+	return &Pattern{
+		Cell: cell,
+		W:    w,
+		H:    h,
+		View: view,
+	}
+}
+
+// NewPathPattern returns a new pattern that tiles a path drawn with the given style, with a cell size of w×h and transformed by view.
+func NewPathPattern(path *Path, style Style, w, h float64, view Matrix) *Pattern {
+	cell := New(w, h)
+	cell.RenderPath(path, style, Identity)
+	return NewPattern(cell, w, h, view)
+}
+
+// NewHatchPattern returns a pattern of parallel lines of the given color and thickness, the given distance apart, and rotated by angle in degrees counter clockwise from the horizontal.
+func NewHatchPattern(col color.Color, angle, distance, thickness float64) *Pattern {
+	style := DefaultStyle
+	style.FillColor = rgbaColor(col)
+	line := Rectangle(distance, thickness).Translate(0.0, (distance-thickness)/2.0)
+	return NewPathPattern(line, style, distance, distance, Identity.Rotate(angle))
+}
+
+// NewCrossHatchPattern returns a pattern of perpendicular lines of the given color and thickness, the given distance apart, and rotated by angle in degrees counter clockwise from the horizontal.
+func NewCrossHatchPattern(col color.Color, angle, distance, thickness float64) *Pattern {
+	style := DefaultStyle
+	style.FillColor = rgbaColor(col)
+	horizontal := Rectangle(distance, thickness).Translate(0.0, (distance-thickness)/2.0)
+	vertical := Rectangle(thickness, distance).Translate((distance-thickness)/2.0, 0.0)
+	return NewPathPattern(horizontal.Append(vertical), style, distance, distance, Identity.Rotate(angle))
+}
+
+// NewDotPattern returns a pattern of dots of the given color and radius on a square grid with the given distance between the dots.
+func NewDotPattern(col color.Color, distance, radius float64) *Pattern {
+	style := DefaultStyle
+	style.FillColor = rgbaColor(col)
+	dot := Circle(radius).Translate(distance/2.0, distance/2.0)
+	return NewPathPattern(dot, style, distance, distance, Identity)
+}
+
+// At returns the color of the pattern at the given coordinate, which is the color of the topmost path in the cell that covers the coordinate. Text and images are ignored, and there is no anti-aliasing or blending of the colors.
+func (p *Pattern) At(x, y float64) color.RGBA {
+	if p.Cell == nil || p.W <= 0.0 || p.H <= 0.0 {
+		return Transparent
+	}
+	pos := p.View.Inv().Dot(Point{x, y})
+	pos.X -= math.Floor(pos.X/p.W) * p.W
+	pos.Y -= math.Floor(pos.Y/p.H) * p.H
+	for i := len(p.Cell.layers) - 1; 0 <= i; i-- {
+		l := p.Cell.layers[i]
+		if l.path == nil {
+			continue
+		}
+		q := l.m.Inv().Dot(pos)
+		if l.style.HasStroke() {
+			stroke := l.path
+			if 0 < len(l.style.Dashes) {
+				stroke = stroke.Dash(l.style.DashOffset, l.style.Dashes...)
+			}
+			stroke = stroke.Stroke(l.style.StrokeWidth, l.style.StrokeCapper, l.style.StrokeJoiner)
+			if stroke.Interior(q.X, q.Y, NonZero) {
+				if l.style.StrokePaint != nil {
+					return l.style.StrokePaint.At(q.X, q.Y)
+				}
+				return l.style.StrokeColor
+			}
+		}
+		if l.style.HasFill() && l.path.Interior(q.X, q.Y, l.style.FillRule) {
+			if l.style.FillPaint != nil {
+				return l.style.FillPaint.At(q.X, q.Y)
+			}
+			return l.style.FillColor
+		}
+	}
+	return Transparent
+}
@@ -0,0 +1,158 @@
+package canvas
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestGradientColorAt(t *testing.T) {
+	g := Gradient{}
+	test.T(t, g.ColorAt(0.5), Transparent)
+
+	g.Add(1.0, color.RGBA{0, 0, 255, 255})
+	g.Add(0.0, color.RGBA{255, 0, 0, 255})
+	test.T(t, len(g.Stops), 2)
+	test.T(t, g.Stops[0].Offset, 0.0)
+
+	var tts = []struct {
+		spread GradientSpread
+		t      float64
+		col    color.RGBA
+	}{
+		{PadSpread, 0.0, color.RGBA{255, 0, 0, 255}},
+		{PadSpread, 0.5, color.RGBA{128, 0, 128, 255}},
+		{PadSpread, 1.0, color.RGBA{0, 0, 255, 255}},
+		{PadSpread, -1.0, color.RGBA{255, 0, 0, 255}},
+		{PadSpread, 2.0, color.RGBA{0, 0, 255, 255}},
+		{ReflectSpread, 1.25, color.RGBA{64, 0, 191, 255}},
+		{ReflectSpread, -0.25, color.RGBA{191, 0, 64, 255}},
+		{RepeatSpread, 1.25, color.RGBA{191, 0, 64, 255}},
+		{RepeatSpread, -0.25, color.RGBA{64, 0, 191, 255}},
+	}
+	for _, tt := range tts {
+		t.Run(fmt.Sprintf("%v %v", tt.spread, tt.t), func(t *testing.T) {
+			g.Spread = tt.spread
+			test.T(t, g.ColorAt(tt.t), tt.col)
+		})
+	}
+}
+
+func TestGradientStopsIn(t *testing.T) {
+	g := Gradient{}
+	g.Add(0.0, Red)
+	g.Add(1.0, Blue)
+
+	stops := g.StopsIn(-1.0, 2.0)
+	test.T(t, len(stops), 4)
+	test.T(t, stops[0], GradientStop{-1.0, Red})
+	test.T(t, stops[1], GradientStop{0.0, Red})
+	test.T(t, stops[2], GradientStop{1.0, Blue})
+	test.T(t, stops[3], GradientStop{2.0, Blue})
+
+	g.Spread = ReflectSpread
+	stops = g.StopsIn(0.5, 2.5)
+	test.T(t, len(stops), 4)
+	test.Float(t, stops[0].Offset, 0.5)
+	test.T(t, stops[1], GradientStop{1.0, Blue})
+	test.T(t, stops[2], GradientStop{2.0, Red})
+	test.Float(t, stops[3].Offset, 2.5)
+
+	g.Spread = RepeatSpread
+	stops = g.StopsIn(0.0, 2.0)
+	test.T(t, len(stops), 4)
+	test.T(t, stops[1], GradientStop{1.0, Blue})
+	test.T(t, stops[2], GradientStop{1.0, Red})
+	test.T(t, stops[3], GradientStop{2.0, Blue})
+}
+
+func TestLinearGradient(t *testing.T) {
+	g := NewLinearGradient(0.0, 0.0, 10.0, 0.0)
+	g.Add(0.0, Red)
+	g.Add(1.0, Blue)
+	test.Float(t, g.T(5.0, 3.0), 0.5)
+	test.Float(t, g.T(-10.0, 3.0), -1.0)
+	test.T(t, g.At(10.0, 5.0), Blue)
+
+	t0, t1 := g.Range(Rect{X: -5.0, Y: 0.0, W: 20.0, H: 10.0})
+	test.Float(t, t0, -0.5)
+	test.Float(t, t1, 1.5)
+}
+
+func TestRadialGradient(t *testing.T) {
+	g := NewRadialGradient(0.0, 0.0, 0.0, 0.0, 0.0, 10.0)
+	g.Add(0.0, Red)
+	g.Add(1.0, Blue)
+	test.Float(t, g.T(0.0, 0.0), 0.0)
+	test.Float(t, g.T(3.0, 4.0), 0.5)
+	test.Float(t, g.T(0.0, -20.0), 2.0)
+	test.T(t, g.At(0.0, 0.0), Red)
+
+	t0, t1 := g.Range(Rect{X: 0.0, Y: 0.0, W: 30.0, H: 40.0})
+	test.Float(t, t0, 0.0)
+	test.Float(t, t1, 5.0)
+
+	// focal point
+	g = NewRadialGradient(5.0, 0.0, 0.0, 0.0, 0.0, 10.0)
+	test.Float(t, g.T(5.0, 0.0), 0.0)
+	test.Float(t, g.T(10.0, 0.0), 1.0)
+	test.Float(t, g.T(-10.0, 0.0), 1.0)
+
+	// cone not covering the plane
+	g = NewRadialGradient(0.0, 0.0, 1.0, 10.0, 0.0, 2.0)
+	test.That(t, math.IsNaN(g.T(-20.0, 0.0)))
+}
+
+func TestStylePaint(t *testing.T) {
+	g := NewLinearGradient(0.0, 0.0, 1.0, 0.0)
+
+	ctx := NewContext(New(10, 10))
+	ctx.SetFillColor(Transparent)
+	test.That(t, !ctx.Style.HasFill())
+	ctx.SetFillPaint(g)
+	test.That(t, ctx.Style.HasFill())
+	ctx.SetFillColor(Red)
+	test.T(t, ctx.Style.FillPaint, nil)
+
+	ctx.SetStrokeColor(Transparent)
+	ctx.SetStrokeWidth(1.0)
+	test.That(t, !ctx.Style.HasStroke())
+	ctx.SetStrokePaint(g)
+	test.That(t, ctx.Style.HasStroke())
+	ctx.SetStrokeWidth(0.0)
+	test.That(t, !ctx.Style.HasStroke())
+}
+
+func TestPattern(t *testing.T) {
+	hatch := NewHatchPattern(Red, 0.0, 4.0, 1.0)
+	test.T(t, hatch.At(1.0, 2.0), Red)
+	test.T(t, hatch.At(1.0, 0.5), Transparent)
+	test.T(t, hatch.At(-3.0, 6.0), Red)
+
+	hatch = NewHatchPattern(Red, 90.0, 4.0, 1.0)
+	test.T(t, hatch.At(-2.0, 1.0), Red)
+	test.T(t, hatch.At(1.0, 1.0), Transparent)
+
+	cross := NewCrossHatchPattern(Red, 0.0, 4.0, 1.0)
+	test.T(t, cross.At(2.0, 0.5), Red)
+	test.T(t, cross.At(0.5, 2.0), Red)
+	test.T(t, cross.At(0.5, 0.5), Transparent)
+
+	dots := NewDotPattern(Blue, 4.0, 1.0)
+	test.T(t, dots.At(2.0, 2.0), Blue)
+	test.T(t, dots.At(10.0, 6.0), Blue)
+	test.T(t, dots.At(0.0, 0.0), Transparent)
+
+	style := DefaultStyle
+	style.FillColor = Transparent
+	style.StrokeColor = Green
+	style.StrokeWidth = 0.5
+	ring := NewPathPattern(Circle(1.0), style, 4.0, 4.0, Identity)
+	test.T(t, ring.At(0.0, 1.1), Green)
+	test.T(t, ring.At(0.1, 0.2), Transparent)
+
+	test.T(t, (&Pattern{}).At(0.0, 0.0), Transparent)
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/tdewolff/parse/v2/strconv"
 	"golang.org/x/image/vector"
@@ -66,9 +67,11 @@ func fromArcFlags(large, sweep bool) float64 {
 // Path defines a vector path in 2D using a series of connected commands (MoveTo, LineTo, QuadTo, CubeTo, ArcTo and Close).
 // Each command consists of a number of float64 values (depending on the command) that fully define the action. The first value is the command itself (as a float64). The last two values are the end point position of the pen after the action (x,y). QuadTo defined one control point (x,y) in between, CubeTo defines two control points, and ArcTo defines (rx,ry,phi,large+sweep) i.e. the radius in x and y, its rotation (in radians) and the large and sweep booleans in one float64.
 // Only valid commands are appended, so that LineTo has a non-zero length, QuadTo's and CubeTo's control point(s) don't (both) overlap with the start and end point, and ArcTo has non-zero radii and has non-zero length. For ArcTo we also make sure the angle is is in the range [0, 2*PI) and we scale the radii up if they appear too small to fit the arc.
+// Derived data such as the bounds, length, and flattened path is cached until the path is modified. Methods that do not modify the path are safe for concurrent use.
 type Path struct {
-	d []float64
-	// TODO: optimization: cache bounds and path len until changes (clearCache()), set bounds directly for predefined shapes
+	d     []float64
+	mu    sync.Mutex // guards cache
+	cache *pathCache // derived data, cleared when the path is modified
 }
 
 // Empty returns true if p is an empty path or consists of only MoveTos and Closes.
@@ -96,7 +99,9 @@ func (p *Path) Closed() bool {
 
 // Copy returns a copy of p.
 func (p *Path) Copy() *Path {
-	q := &Path{}
+	p.mu.Lock()
+	q := &Path{cache: p.cache.copy()}
+	p.mu.Unlock()
 	q.d = append(q.d, p.d...)
 	return q
 }
@@ -108,7 +113,7 @@ func (p *Path) Append(q *Path) *Path {
 	} else if p.Empty() {
 		return q
 	}
-	return &Path{append(p.d, q.d...)}
+	return &Path{d: append(p.d, q.d...)}
 }
 
 // Join joins path q to p and returns a new path if succesful (otherwise either p or q are returned). Its like executing the commands in q to p in sequence, where if the first MoveTo of q doesn't coincide with p it will fallback to appending the paths.
@@ -124,6 +129,7 @@ func (p *Path) Join(q *Path) *Path {
 	}
 
 	q.d = q.d[cmdLen(moveToCmd):]
+	q.clearCache()
 
 	// add the first command through the command functions to use the optimization features
 	// q is not empty, so starts with a MoveTo followed by other commands
@@ -144,7 +150,7 @@ func (p *Path) Join(q *Path) *Path {
 
 	i := len(p.d)
 	end := p.StartPos()
-	p = &Path{append(p.d, q.d[cmdLen(cmd):]...)}
+	p = &Path{d: append(p.d, q.d[cmdLen(cmd):]...)}
 
 	// repair close commands
 	for i < len(p.d) {
@@ -200,6 +206,7 @@ func (p *Path) Coords() []Point {
 // useful when negating parts of a previous path by overlapping it with a path in the opposite direction. The behaviour for
 // overlapping paths depend on the FillRule.
 func (p *Path) MoveTo(x, y float64) *Path {
+	p.clearCache()
 	if 0 < len(p.d) && p.d[len(p.d)-1] == moveToCmd {
 		p.d[len(p.d)-3] = x
 		p.d[len(p.d)-2] = y
@@ -211,6 +218,7 @@ func (p *Path) MoveTo(x, y float64) *Path {
 
 // LineTo adds a linear path to x,y.
 func (p *Path) LineTo(x, y float64) *Path {
+	p.clearCache()
 	start := p.Pos()
 	end := Point{x, y}
 	if start.Equals(end) {
@@ -238,6 +246,7 @@ func (p *Path) LineTo(x, y float64) *Path {
 
 // QuadTo adds a quadratic Bézier path with control point cpx,cpy and end point x,y.
 func (p *Path) QuadTo(cpx, cpy, x, y float64) *Path {
+	p.clearCache()
 	start := p.Pos()
 	cp := Point{cpx, cpy}
 	end := Point{x, y}
@@ -258,6 +267,7 @@ func (p *Path) QuadTo(cpx, cpy, x, y float64) *Path {
 
 // CubeTo adds a cubic Bézier path with control points cpx1,cpy1 and cpx2,cpy2 and end point x,y.
 func (p *Path) CubeTo(cpx1, cpy1, cpx2, cpy2, x, y float64) *Path {
+	p.clearCache()
 	start := p.Pos()
 	cp1 := Point{cpx1, cpy1}
 	cp2 := Point{cpx2, cpy2}
@@ -282,6 +292,7 @@ func (p *Path) CubeTo(cpx1, cpy1, cpx2, cpy2, x, y float64) *Path {
 // and x,y the end position of the pen. The start position of the pen was given by a previous command end point.
 // When sweep is true it means following the arc in a CCW direction in the Cartesian coordinate system, ie. that is CW in the upper-left coordinate system as is the case in SVGs.
 func (p *Path) ArcTo(rx, ry, rot float64, large, sweep bool, x, y float64) *Path {
+	p.clearCache()
 	start := p.Pos()
 	end := Point{x, y}
 	if start.Equals(end) {
@@ -351,6 +362,7 @@ func (p *Path) Arc(rx, ry, rot, theta0, theta1 float64) *Path {
 // Close closes a (sub)path with a LineTo to the start of the path (the most recent MoveTo command).
 // It also signals the path closes as opposed to being just a LineTo command, which can be significant for stroking purposes for example.
 func (p *Path) Close() *Path {
+	p.clearCache()
 	end := p.StartPos()
 	if len(p.d) == 0 || p.d[len(p.d)-1] == closeCmd {
 		return p
@@ -490,6 +502,12 @@ func (p *Path) Bounds() Rect {
 		return Rect{}
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cache != nil && p.cache.hasBounds {
+		return p.cache.bounds
+	}
+
 	xmin, xmax := math.Inf(1), math.Inf(-1)
 	ymin, ymax := math.Inf(1), math.Inf(-1)
 
@@ -612,46 +630,32 @@ func (p *Path) Bounds() Rect {
 		i += cmdLen(cmd)
 		start = end
 	}
-	return Rect{xmin, ymin, xmax - xmin, ymax - ymin}
+
+	cache := p.getCache()
+	cache.bounds = Rect{xmin, ymin, xmax - xmin, ymax - ymin}
+	cache.hasBounds = true
+	return cache.bounds
 }
 
 // Length returns the length of the path in millimeters. The length is approximated for cubic Béziers.
 func (p *Path) Length() float64 {
-	d := 0.0
-	var start, end Point
-	for i := 0; i < len(p.d); {
-		cmd := p.d[i]
-		switch cmd {
-		case moveToCmd:
-			end = Point{p.d[i+1], p.d[i+2]}
-		case lineToCmd, closeCmd:
-			end = Point{p.d[i+1], p.d[i+2]}
-			d += end.Sub(start).Length()
-		case quadToCmd:
-			cp := Point{p.d[i+1], p.d[i+2]}
-			end = Point{p.d[i+3], p.d[i+4]}
-			d += quadraticBezierLength(start, cp, end)
-		case cubeToCmd:
-			cp1 := Point{p.d[i+1], p.d[i+2]}
-			cp2 := Point{p.d[i+3], p.d[i+4]}
-			end = Point{p.d[i+5], p.d[i+6]}
-			d += cubicBezierLength(start, cp1, cp2, end)
-		case arcToCmd:
-			rx, ry, phi := p.d[i+1], p.d[i+2], p.d[i+3]
-			large, sweep := toArcFlags(p.d[i+4])
-			end = Point{p.d[i+5], p.d[i+6]}
-			_, _, theta1, theta2 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
-			d += ellipseLength(rx, ry, theta1, theta2)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cache := p.getCache()
+	if !cache.hasLength {
+		cache.length = 0.0
+		for _, length := range cache.segmentLengths(p.d) {
+			cache.length += length
 		}
-		i += cmdLen(cmd)
-		start = end
+		cache.hasLength = true
 	}
-	return d
+	return cache.length
 }
 
 // Transform transform the path by the given transformation matrix and returns a new path.
 func (p *Path) Transform(m Matrix) *Path {
 	p = p.Copy()
+	p.cache = p.cache.transform(m)
 	_, _, _, xscale, yscale, _ := m.Decompose()
 	for i := 0; i < len(p.d); {
 		cmd := p.d[i]
@@ -730,7 +734,22 @@ func (p *Path) Translate(x, y float64) *Path {
 
 // Flatten flattens all Bézier and arc curves into linear segments and returns a new path. It uses Tolerance as the maximum deviation.
 func (p *Path) Flatten() *Path {
-	return p.replace(nil, flattenQuadraticBezier, flattenCubicBezier, flattenEllipticArc)
+	p.mu.Lock()
+	flat, tolerance := (*Path)(nil), Tolerance
+	if p.cache != nil && p.cache.flatTolerance == tolerance {
+		flat = p.cache.flat
+	}
+	p.mu.Unlock()
+
+	if flat == nil {
+		// flatten without holding the lock since replace copies the path
+		flat = p.replace(nil, flattenQuadraticBezier, flattenCubicBezier, flattenEllipticArc)
+		p.mu.Lock()
+		cache := p.getCache()
+		cache.flat, cache.flatTolerance = flat, tolerance
+		p.mu.Unlock()
+	}
+	return flat.Copy()
 }
 
 // ReplaceArcs replaces ArcTo commands by CubeTo commands.
@@ -748,6 +767,7 @@ func (p *Path) replace(
 	arc func(Point, float64, float64, float64, bool, bool, Point) *Path,
 ) *Path {
 	p = p.Copy()
+	p.clearCache()
 
 	var start, end Point
 	for i := 0; i < len(p.d); {
@@ -785,7 +805,7 @@ func (p *Path) replace(
 		}
 
 		if q != nil {
-			r := &Path{append([]float64{moveToCmd, end.X, end.Y, moveToCmd}, p.d[i+cmdLen(cmd):]...)}
+			r := &Path{d: append([]float64{moveToCmd, end.X, end.Y, moveToCmd}, p.d[i+cmdLen(cmd):]...)}
 
 			p.d = p.d[: i : i+cmdLen(cmd)] // make sure not to overwrite the rest of the path
 			p = p.Join(q)
@@ -828,17 +848,17 @@ func (p *Path) Markers(first, mid, last *Path, align bool) []*Path {
 				case quadToCmd, cubeToCmd:
 					var cp1, cp2 Point
 					if cmd == quadToCmd {
-						cp := Point{p.d[i-5], p.d[i-4]}
+						cp := Point{ps.d[i-5], ps.d[i-4]}
 						cp1, cp2 = quadraticToCubicBezier(start, cp, end)
 					} else {
-						cp1 = Point{p.d[i-7], p.d[i-6]}
-						cp2 = Point{p.d[i-5], p.d[i-4]}
+						cp1 = Point{ps.d[i-7], ps.d[i-6]}
+						cp2 = Point{ps.d[i-5], ps.d[i-4]}
 					}
 					n0 = cubicBezierNormal(start, cp1, cp2, end, 0.0, 1.0)
 					n1 = cubicBezierNormal(start, cp1, cp2, end, 1.0, 1.0)
 				case arcToCmd:
-					rx, ry, phi := p.d[i-7], p.d[i-6], p.d[i-5]
-					large, sweep := toArcFlags(p.d[i-4])
+					rx, ry, phi := ps.d[i-7], ps.d[i-6], ps.d[i-5]
+					large, sweep := toArcFlags(ps.d[i-4])
 					_, _, theta0, theta1 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
 					n0 = ellipseNormal(rx, ry, phi, sweep, theta0, 1.0)
 					n1 = ellipseNormal(rx, ry, phi, sweep, theta1, 1.0)
@@ -889,20 +909,39 @@ func (p *Path) Split() []*Path {
 	ps := []*Path{}
 
 	var i, j int
+	var k0, k int // command indices
 	for j < len(p.d) {
 		cmd := p.d[j]
 		if i < j && cmd == moveToCmd {
-			ps = append(ps, &Path{p.d[i:j:j]})
-			i = j
+			ps = append(ps, p.subpath(i, j, k0, k))
+			i, k0 = j, k
 		}
 		j += cmdLen(cmd)
+		k++
 	}
 	if i+cmdLen(moveToCmd) < j {
-		ps = append(ps, &Path{p.d[i:j:j]})
+		ps = append(ps, p.subpath(i, j, k0, k))
 	}
 	return ps
 }
 
+// subpath returns the subpath between positions i and j, with command indices k0 and k. It shares the cached per-segment data with p if available.
+func (p *Path) subpath(i, j, k0, k int) *Path {
+	q := &Path{d: p.d[i:j:j]}
+	p.mu.Lock()
+	if p.cache != nil {
+		q.cache = &pathCache{}
+		if p.cache.lengths != nil {
+			q.cache.lengths = p.cache.lengths[k0:k:k]
+		}
+		arcLengths := p.cache.getArcLengths(p.numCommands())
+		q.cache.arcLengths = arcLengths[k0:k:k]
+		q.cache.arcMu = p.cache.arcMu
+	}
+	p.mu.Unlock()
+	return q
+}
+
 // SplitAt splits the path into separate paths at the specified intervals (given in millimeters) along the path.
 func (p *Path) SplitAt(ts ...float64) []*Path {
 	if len(ts) == 0 {
@@ -927,15 +966,19 @@ func (p *Path) SplitAt(ts ...float64) []*Path {
 	if 0 < len(p.d) && p.d[0] == moveToCmd {
 		q.MoveTo(p.d[1], p.d[2])
 	}
+	p.mu.Lock()
+	p.getCache() // share the arc length parametrizations with the subpaths
+	p.mu.Unlock()
 	for _, ps := range p.Split() {
 		var start, end Point
-		for i := 0; i < len(ps.d); {
+		for i, k := 0, 0; i < len(ps.d); k++ {
 			cmd := ps.d[i]
 			switch cmd {
 			case moveToCmd:
-				end = Point{p.d[i+1], p.d[i+2]}
+				end = Point{ps.d[i+1], ps.d[i+2]}
+				q.MoveTo(end.X, end.Y)
 			case lineToCmd, closeCmd:
-				end = Point{p.d[i+1], p.d[i+2]}
+				end = Point{ps.d[i+1], ps.d[i+2]}
 
 				if j == len(ts) {
 					q.LineTo(end.X, end.Y)
@@ -958,16 +1001,14 @@ func (p *Path) SplitAt(ts ...float64) []*Path {
 					T += dT
 				}
 			case quadToCmd:
-				cp := Point{p.d[i+1], p.d[i+2]}
-				end = Point{p.d[i+3], p.d[i+4]}
+				cp := Point{ps.d[i+1], ps.d[i+2]}
+				end = Point{ps.d[i+3], ps.d[i+4]}
 
 				if j == len(ts) {
 					q.QuadTo(cp.X, cp.Y, end.X, end.Y)
 				} else {
-					speed := func(t float64) float64 {
-						return quadraticBezierDeriv(start, cp, end, t).Length()
-					}
-					invL, dT := invSpeedPolynomialChebyshevApprox(20, gaussLegendre7, speed, 0.0, 1.0)
+					table := ps.arcLength(k, i)
+					invL, dT := table.invL, table.length
 
 					t0 := 0.0
 					r0, r1, r2 := start, cp, end
@@ -990,19 +1031,15 @@ func (p *Path) SplitAt(ts ...float64) []*Path {
 					T += dT
 				}
 			case cubeToCmd:
-				cp1 := Point{p.d[i+1], p.d[i+2]}
-				cp2 := Point{p.d[i+3], p.d[i+4]}
-				end = Point{p.d[i+5], p.d[i+6]}
+				cp1 := Point{ps.d[i+1], ps.d[i+2]}
+				cp2 := Point{ps.d[i+3], ps.d[i+4]}
+				end = Point{ps.d[i+5], ps.d[i+6]}
 
 				if j == len(ts) {
 					q.CubeTo(cp1.X, cp1.Y, cp2.X, cp2.Y, end.X, end.Y)
 				} else {
-					speed := func(t float64) float64 {
-						// splitting on inflection points does not improve output
-						return cubicBezierDeriv(start, cp1, cp2, end, t).Length()
-					}
-					N := 20 + 20*cubicBezierNumInflections(start, cp1, cp2, end) // TODO: needs better N
-					invL, dT := invSpeedPolynomialChebyshevApprox(N, gaussLegendre7, speed, 0.0, 1.0)
+					table := ps.arcLength(k, i)
+					invL, dT := table.invL, table.length
 
 					t0 := 0.0
 					r0, r1, r2, r3 := start, cp1, cp2, end
@@ -1025,18 +1062,16 @@ func (p *Path) SplitAt(ts ...float64) []*Path {
 					T += dT
 				}
 			case arcToCmd:
-				rx, ry, phi := p.d[i+1], p.d[i+2], p.d[i+3]
-				large, sweep := toArcFlags(p.d[i+4])
-				end = Point{p.d[i+5], p.d[i+6]}
+				rx, ry, phi := ps.d[i+1], ps.d[i+2], ps.d[i+3]
+				large, sweep := toArcFlags(ps.d[i+4])
+				end = Point{ps.d[i+5], ps.d[i+6]}
 				cx, cy, theta1, theta2 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
 
 				if j == len(ts) {
 					q.ArcTo(rx, ry, phi*180.0/math.Pi, large, sweep, end.X, end.Y)
 				} else {
-					speed := func(theta float64) float64 {
-						return ellipseDeriv(rx, ry, 0.0, true, theta).Length()
-					}
-					invL, dT := invSpeedPolynomialChebyshevApprox(10, gaussLegendre7, speed, theta1, theta2)
+					table := ps.arcLength(k, i)
+					invL, dT := table.invL, table.length
 
 					startTheta := theta1
 					nextLarge := large
@@ -1217,6 +1252,7 @@ func (p *Path) Dash(offset float64, d ...float64) *Path {
 
 	i0, pos0 := dashStart(offset, d)
 
+	p.segmentLengths() // share the segment lengths with the subpaths
 	q := &Path{}
 	for _, ps := range p.Split() {
 		i := i0
@@ -1743,27 +1779,62 @@ func (p *Path) ToPDF() string {
 func (p *Path) ToRasterizer(ras *vector.Rasterizer, dpm float64) {
 	p = p.replace(nil, nil, nil, arcToCube)
 
+	// The rasterizer accumulates rounding errors for every row a line spans, including the rows above its bounds, which shifts long lines noticeably for small images and makes tiles of a larger image differ from the image. Lines are therefore split at the top edge and in pieces spanning a few rows, and segments above the top edge are replaced by lines since they do not add coverage.
+	const maxRows = 8.0
 	dy := float64(ras.Bounds().Size().Y)
+	var start, pen Point
+	lineTo := func(end Point) {
+		if (pen.Y < 0.0) != (end.Y < 0.0) && pen.Y != 0.0 && end.Y != 0.0 {
+			pen = pen.Interpolate(end, pen.Y/(pen.Y-end.Y))
+			ras.LineTo(float32(pen.X), 0.0)
+		}
+		if 0.0 <= end.Y && (pen.Y < dy || end.Y < dy) {
+			n := math.Ceil(math.Abs(end.Y-pen.Y) / maxRows)
+			for j := 1.0; j < n; j++ {
+				mid := pen.Interpolate(end, j/n)
+				ras.LineTo(float32(mid.X), float32(mid.Y))
+			}
+		}
+		ras.LineTo(float32(end.X), float32(end.Y))
+		pen = end
+	}
 	for i := 0; i < len(p.d); {
 		cmd := p.d[i]
 		switch cmd {
 		case moveToCmd:
-			ras.MoveTo(float32(p.d[i+1]*dpm), float32(dy-p.d[i+2]*dpm))
+			start = Point{p.d[i+1] * dpm, dy - p.d[i+2]*dpm}
+			pen = start
+			ras.MoveTo(float32(start.X), float32(start.Y))
 		case lineToCmd:
-			ras.LineTo(float32(p.d[i+1]*dpm), float32(dy-p.d[i+2]*dpm))
+			lineTo(Point{p.d[i+1] * dpm, dy - p.d[i+2]*dpm})
 		case quadToCmd:
-			ras.QuadTo(float32(p.d[i+1]*dpm), float32(dy-p.d[i+2]*dpm), float32(p.d[i+3]*dpm), float32(dy-p.d[i+4]*dpm))
+			cp := Point{p.d[i+1] * dpm, dy - p.d[i+2]*dpm}
+			end := Point{p.d[i+3] * dpm, dy - p.d[i+4]*dpm}
+			if pen.Y < 0.0 && cp.Y < 0.0 && end.Y < 0.0 {
+				lineTo(end)
+			} else {
+				ras.QuadTo(float32(cp.X), float32(cp.Y), float32(end.X), float32(end.Y))
+				pen = end
+			}
 		case cubeToCmd:
-			ras.CubeTo(float32(p.d[i+1]*dpm), float32(dy-p.d[i+2]*dpm), float32(p.d[i+3]*dpm), float32(dy-p.d[i+4]*dpm), float32(p.d[i+5]*dpm), float32(dy-p.d[i+6]*dpm))
+			cp1 := Point{p.d[i+1] * dpm, dy - p.d[i+2]*dpm}
+			cp2 := Point{p.d[i+3] * dpm, dy - p.d[i+4]*dpm}
+			end := Point{p.d[i+5] * dpm, dy - p.d[i+6]*dpm}
+			if pen.Y < 0.0 && cp1.Y < 0.0 && cp2.Y < 0.0 && end.Y < 0.0 {
+				lineTo(end)
+			} else {
+				ras.CubeTo(float32(cp1.X), float32(cp1.Y), float32(cp2.X), float32(cp2.Y), float32(end.X), float32(end.Y))
+				pen = end
+			}
 		case arcToCmd:
 			panic("arcs should have been replaced")
 		case closeCmd:
-			ras.ClosePath()
+			lineTo(start)
 		}
 		i += cmdLen(cmd)
 	}
 	if 0 < len(p.d) && p.d[len(p.d)-1] != closeCmd {
 		// implicitly close path
-		ras.ClosePath()
+		lineTo(start)
 	}
 }
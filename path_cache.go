@@ -0,0 +1,206 @@
+package canvas
+
+import (
+	"math"
+	"sync"
+)
+
+// pathCache holds data derived from a path that is expensive to compute, such as its bounds and length. It is filled when needed and cleared when the path is modified, and is guarded by the mutex of the path. Subpaths returned by Split share the per-segment data with their parent, so that the data is computed only once for both.
+type pathCache struct {
+	bounds    Rect
+	hasBounds bool
+	length    float64
+	hasLength bool
+
+	lengths    []float64         // length of each segment by command index, nil if not computed, not modified once computed
+	arcLengths []*arcLengthTable // arc length parametrization of each curved segment by command index, nil if not computed
+	arcMu      *sync.Mutex       // guards the elements of arcLengths, shared with the caches of subpaths
+
+	flat          *Path   // flattened path
+	flatTolerance float64 // Tolerance used for the flattened path
+}
+
+// arcLengthTable is the arc length parametrization of a curved segment, where invL maps the length along the segment to its parameter, which is t for Béziers and theta for elliptic arcs.
+type arcLengthTable struct {
+	invL   func(float64) float64
+	length float64
+}
+
+// getCache returns the cache of the path, which is created if needed. The mutex of the path must be held.
+func (p *Path) getCache() *pathCache {
+	if p.cache == nil {
+		p.cache = &pathCache{}
+	}
+	return p.cache
+}
+
+// clearCache clears the derived data of the path, it must be called whenever the path is modified.
+func (p *Path) clearCache() {
+	p.cache = nil
+}
+
+// numCommands returns the number of commands of the path.
+func (p *Path) numCommands() int {
+	n := 0
+	for i := 0; i < len(p.d); i += cmdLen(p.d[i]) {
+		n++
+	}
+	return n
+}
+
+// copy returns a copy of the cache for a copy of the path, which does not share the data that is filled later.
+func (c *pathCache) copy() *pathCache {
+	if c == nil {
+		return nil
+	}
+
+	q := &pathCache{
+		bounds:        c.bounds,
+		hasBounds:     c.hasBounds,
+		length:        c.length,
+		hasLength:     c.hasLength,
+		lengths:       c.lengths,
+		flat:          c.flat,
+		flatTolerance: c.flatTolerance,
+	}
+	if c.arcLengths != nil {
+		c.arcMu.Lock()
+		q.arcLengths = append([]*arcLengthTable{}, c.arcLengths...)
+		c.arcMu.Unlock()
+		q.arcMu = &sync.Mutex{}
+	}
+	return q
+}
+
+// segmentLengths returns the length of each segment of the path by command index, where MoveTo commands have zero length. The length is approximated for cubic Béziers.
+func (p *Path) segmentLengths() []float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getCache().segmentLengths(p.d)
+}
+
+// segmentLengths returns the length of each segment of path data d, which is computed if needed.
+func (c *pathCache) segmentLengths(d []float64) []float64 {
+	if c.lengths != nil {
+		return c.lengths
+	}
+
+	lengths := []float64{}
+	var start, end Point
+	for i := 0; i < len(d); {
+		length := 0.0
+		cmd := d[i]
+		switch cmd {
+		case moveToCmd:
+			end = Point{d[i+1], d[i+2]}
+		case lineToCmd, closeCmd:
+			end = Point{d[i+1], d[i+2]}
+			length = end.Sub(start).Length()
+		case quadToCmd:
+			cp := Point{d[i+1], d[i+2]}
+			end = Point{d[i+3], d[i+4]}
+			length = quadraticBezierLength(start, cp, end)
+		case cubeToCmd:
+			cp1 := Point{d[i+1], d[i+2]}
+			cp2 := Point{d[i+3], d[i+4]}
+			end = Point{d[i+5], d[i+6]}
+			length = cubicBezierLength(start, cp1, cp2, end)
+		case arcToCmd:
+			rx, ry, phi := d[i+1], d[i+2], d[i+3]
+			large, sweep := toArcFlags(d[i+4])
+			end = Point{d[i+5], d[i+6]}
+			_, _, theta1, theta2 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
+			length = ellipseLength(rx, ry, theta1, theta2)
+		}
+		lengths = append(lengths, length)
+		i += cmdLen(cmd)
+		start = end
+	}
+	c.lengths = lengths
+	return lengths
+}
+
+// getArcLengths returns the arc length parametrizations by command index for a path of n commands, which are created if needed.
+func (c *pathCache) getArcLengths(n int) []*arcLengthTable {
+	if c.arcLengths == nil {
+		c.arcLengths = make([]*arcLengthTable, n)
+		c.arcMu = &sync.Mutex{}
+	}
+	return c.arcLengths
+}
+
+// arcLength returns the arc length parametrization of the curved segment with command index k at position i in the path.
+func (p *Path) arcLength(k, i int) arcLengthTable {
+	p.mu.Lock()
+	cache := p.getCache()
+	arcLengths, arcMu := cache.getArcLengths(p.numCommands()), cache.arcMu
+	p.mu.Unlock()
+
+	arcMu.Lock()
+	defer arcMu.Unlock()
+	if table := arcLengths[k]; table != nil {
+		return *table
+	}
+
+	start := Point{}
+	if 0 < i {
+		start = Point{p.d[i-3], p.d[i-2]}
+	}
+
+	var table arcLengthTable
+	switch p.d[i] {
+	case quadToCmd:
+		cp := Point{p.d[i+1], p.d[i+2]}
+		end := Point{p.d[i+3], p.d[i+4]}
+		speed := func(t float64) float64 {
+			return quadraticBezierDeriv(start, cp, end, t).Length()
+		}
+		table.invL, table.length = invSpeedPolynomialChebyshevApprox(20, gaussLegendre7, speed, 0.0, 1.0)
+	case cubeToCmd:
+		cp1 := Point{p.d[i+1], p.d[i+2]}
+		cp2 := Point{p.d[i+3], p.d[i+4]}
+		end := Point{p.d[i+5], p.d[i+6]}
+		speed := func(t float64) float64 {
+			// splitting on inflection points does not improve output
+			return cubicBezierDeriv(start, cp1, cp2, end, t).Length()
+		}
+		N := 20 + 20*cubicBezierNumInflections(start, cp1, cp2, end) // TODO: needs better N
+		table.invL, table.length = invSpeedPolynomialChebyshevApprox(N, gaussLegendre7, speed, 0.0, 1.0)
+	case arcToCmd:
+		rx, ry, phi := p.d[i+1], p.d[i+2], p.d[i+3]
+		large, sweep := toArcFlags(p.d[i+4])
+		end := Point{p.d[i+5], p.d[i+6]}
+		_, _, theta1, theta2 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
+		speed := func(theta float64) float64 {
+			return ellipseDeriv(rx, ry, 0.0, true, theta).Length()
+		}
+		table.invL, table.length = invSpeedPolynomialChebyshevApprox(10, gaussLegendre7, speed, theta1, theta2)
+	default:
+		panic("arc length of non-curved segment")
+	}
+	arcLengths[k] = &table
+	return table
+}
+
+// transform returns the cache for the path transformed by m, keeping only the data that can be transformed exactly. Bounds are kept for translations and scaling along the axes, and lengths are kept for translations and uniform scaling. The arc length parametrizations are not kept since Transform recomputes the rotation of elliptic arcs.
+func (c *pathCache) transform(m Matrix) *pathCache {
+	if c == nil || m[0][1] != 0.0 || m[1][0] != 0.0 {
+		return nil
+	}
+
+	q := &pathCache{}
+	if c.hasBounds {
+		q.bounds = c.bounds.Transform(m)
+		q.hasBounds = true
+	}
+	if scale := math.Abs(m[0][0]); scale == math.Abs(m[1][1]) {
+		q.length, q.hasLength = scale*c.length, c.hasLength
+		if c.lengths != nil {
+			q.lengths = make([]float64, len(c.lengths))
+			for i, d := range c.lengths {
+				q.lengths[i] = scale * d
+			}
+		}
+	}
+	return q
+}
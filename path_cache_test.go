@@ -0,0 +1,125 @@
+package canvas
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestPathCache(t *testing.T) {
+	p := MustParseSVG("M0 0L10 0Q10 10 0 10z")
+	test.T(t, p.Bounds(), Rect{0.0, 0.0, 10.0, 10.0})
+	test.That(t, p.cache != nil && p.cache.hasBounds, "bounds are cached")
+
+	length := p.Length()
+	test.That(t, p.cache.hasLength, "length is cached")
+	test.Float(t, p.Length(), length)
+
+	// modifying the path clears the cache
+	p.MoveTo(20.0, 20.0).LineTo(30.0, 20.0)
+	test.T(t, p.cache, (*pathCache)(nil))
+	test.T(t, p.Bounds(), Rect{0.0, 0.0, 30.0, 20.0})
+	test.Float(t, p.Length(), length+10.0)
+
+	// copies have their own cache with the same data
+	q := p.Copy()
+	test.That(t, q.cache != p.cache && q.cache.hasBounds && q.cache.hasLength, "copy has its own cache")
+	q.LineTo(40.0, 20.0)
+	test.T(t, q.Bounds(), Rect{0.0, 0.0, 40.0, 20.0})
+	test.T(t, p.Bounds(), Rect{0.0, 0.0, 30.0, 20.0})
+}
+
+func TestPathCacheTransform(t *testing.T) {
+	p := MustParseSVG("M0 0L10 0L10 10")
+	p.Bounds()
+	p.Length()
+
+	q := p.Translate(5.0, 5.0)
+	test.That(t, q.cache.hasBounds && q.cache.hasLength, "translation keeps the cache")
+	test.T(t, q.Bounds(), Rect{5.0, 5.0, 10.0, 10.0})
+	test.Float(t, q.Length(), 20.0)
+
+	q = p.Transform(Identity.Scale(2.0, -2.0))
+	test.T(t, q.Bounds(), Rect{0.0, -20.0, 20.0, 20.0})
+	test.Float(t, q.Length(), 40.0)
+
+	q = p.Transform(Identity.Scale(2.0, 1.0))
+	test.That(t, q.cache.hasBounds && !q.cache.hasLength, "non-uniform scaling keeps only the bounds")
+	test.Float(t, q.Length(), 30.0)
+
+	q = p.Transform(Identity.Rotate(45.0))
+	test.T(t, q.cache, (*pathCache)(nil))
+}
+
+func TestPathCacheFlatten(t *testing.T) {
+	tolerance := Tolerance
+	defer func() { Tolerance = tolerance }()
+
+	p := MustParseSVG("M0 0A5 5 0 0 0 10 0")
+	flat := p.Flatten()
+	flat.LineTo(0.0, 0.0) // does not change the cached path
+	test.T(t, p.Flatten().Equals(flat), false)
+	test.T(t, len(p.Flatten().d), len(flat.d)-cmdLen(lineToCmd))
+
+	Tolerance /= 10.0
+	test.That(t, len(flat.d)-cmdLen(lineToCmd) < len(p.Flatten().d), "smaller tolerance gives more segments")
+}
+
+func TestPathCacheDash(t *testing.T) {
+	p := MustParseSVG("M0 0C0 10 10 10 10 0A5 5 0 0 0 20 0M30 0Q35 5 40 0")
+	expected := MustParseSVG(p.String()).Dash(1.0, 2.0, 3.0)
+	test.T(t, p.Dash(1.0, 2.0, 3.0), expected)
+	test.That(t, p.cache.arcLengths[1] != nil && p.cache.arcLengths[2] != nil && p.cache.arcLengths[4] != nil, "arc lengths of subpaths are shared")
+	test.T(t, p.cache.arcLengths[0], (*arcLengthTable)(nil))
+
+	// the cached arc lengths give the same result
+	test.That(t, p.cache.lengths != nil, "segment lengths are cached")
+	test.T(t, p.Dash(1.0, 2.0, 3.0), expected)
+	test.T(t, p.SplitAt(5.0, 25.0), MustParseSVG(p.String()).SplitAt(5.0, 25.0))
+}
+
+func TestPathCacheConcurrent(t *testing.T) {
+	// run with -race to detect data races between read-only methods, the results are checked afterwards since the testing functions synchronize
+	expected := MustParseSVG("M0 0C0 10 10 10 10 0A5 5 0 0 0 20 0M30 0Q35 5 40 0L40 10z")
+	expectedDash := expected.Dash(1.0, 2.0, 3.0)
+	expectedLength := expected.Length()
+
+	n := 4
+	for k := 0; k < 10; k++ {
+		p := MustParseSVG(expected.String())
+		q := p.Copy()
+		ps := p.Split()
+
+		dashes := make([]*Path, 2*n)
+		lengths := make([]float64, n)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				lengths[i] = p.Length()
+				p.Bounds()
+				p.Flatten()
+				dashes[2*i] = p.Dash(1.0, 2.0, 3.0)
+				dashes[2*i+1] = q.Dash(1.0, 2.0, 3.0)
+				for _, ps := range ps {
+					ps.SplitAt(5.0)
+				}
+				p.Copy().Length()
+				p.Translate(1.0, 0.0).Bounds()
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		for _, dash := range dashes {
+			test.T(t, dash, expectedDash)
+		}
+		for _, length := range lengths {
+			test.Float(t, length, expectedLength)
+		}
+	}
+}
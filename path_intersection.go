@@ -1,6 +1,9 @@
 package canvas
 
-import "math"
+import (
+	"math"
+	"sort"
+)
 
 // intersection between two line segments
 // see http://www.cs.swan.ac.uk/~cssimon/line_intersection.html
@@ -62,3 +65,830 @@ func intersectionCircleCircle(c0 Point, r0 float64, c1 Point, r1 float64) (Point
 	i2 := Point{c1.Y - c0.Y, c0.X - c1.X}.Mul(c)
 	return i0.Add(i1).Add(i2), i0.Add(i1).Sub(i2), true
 }
+
+////////////////////////////////////////////////////////////////
+
+// Intersection is an intersection point between two paths, see Path.Intersections.
+type Intersection struct {
+	Point              // coordinate of intersection
+	Seg     [2]int     // index of the segment in path p and q respectively, where each command (including MoveTo) counts as a segment
+	T       [2]float64 // parametric position along the segment in path p and q respectively, within [0,1]
+	Dir     [2]float64 // direction angle of path p and q respectively at the intersection in radians within [0,2*PI)
+	Tangent bool       // the paths touch instead of cross
+	Overlap bool       // the paths overlap, the intersection is at the start or end of the overlapping part
+}
+
+// intersectionTolerance is the maximum distance between both paths for an intersection, intersectionFlatness is the flatness at which segments are approximated by lines to find an initial intersection
+const (
+	intersectionTolerance = 1e-9
+	intersectionFlatness  = 1e-6
+	intersectionMaxDepth  = 50
+)
+
+// intersectionSegment is a segment of a path with the parametric functions needed for finding intersections.
+type intersectionSegment struct {
+	seg            int
+	cmd            float64
+	p0, p1, p2, p3 Point // control points, for lines and arcs only p0 and p3 are used
+
+	// arcs only
+	rx, ry, phi, cx, cy, theta0, theta1 float64
+}
+
+func intersectionSegments(p *Path) []intersectionSegment {
+	segs := []intersectionSegment{}
+	var start, end Point
+	for i, seg := 0, 0; i < len(p.d); seg++ {
+		cmd := p.d[i]
+		switch cmd {
+		case moveToCmd:
+			end = Point{p.d[i+1], p.d[i+2]}
+		case lineToCmd, closeCmd:
+			end = Point{p.d[i+1], p.d[i+2]}
+			if !start.Equals(end) {
+				segs = append(segs, intersectionSegment{seg: seg, cmd: lineToCmd, p0: start, p3: end})
+			}
+		case quadToCmd:
+			cp := Point{p.d[i+1], p.d[i+2]}
+			end = Point{p.d[i+3], p.d[i+4]}
+			segs = append(segs, intersectionSegment{seg: seg, cmd: cmd, p0: start, p1: cp, p2: end})
+		case cubeToCmd:
+			cp1 := Point{p.d[i+1], p.d[i+2]}
+			cp2 := Point{p.d[i+3], p.d[i+4]}
+			end = Point{p.d[i+5], p.d[i+6]}
+			segs = append(segs, intersectionSegment{seg: seg, cmd: cmd, p0: start, p1: cp1, p2: cp2, p3: end})
+		case arcToCmd:
+			rx, ry, phi := p.d[i+1], p.d[i+2], p.d[i+3]
+			large, sweep := toArcFlags(p.d[i+4])
+			end = Point{p.d[i+5], p.d[i+6]}
+			cx, cy, theta0, theta1 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
+			segs = append(segs, intersectionSegment{seg: seg, cmd: cmd, p0: start, p3: end, rx: rx, ry: ry, phi: phi, cx: cx, cy: cy, theta0: theta0, theta1: theta1})
+		}
+		i += cmdLen(cmd)
+		start = end
+	}
+	return segs
+}
+
+func (s intersectionSegment) pos(t float64) Point {
+	switch s.cmd {
+	case quadToCmd:
+		return quadraticBezierPos(s.p0, s.p1, s.p2, t)
+	case cubeToCmd:
+		return cubicBezierPos(s.p0, s.p1, s.p2, s.p3, t)
+	case arcToCmd:
+		if t == 0.0 {
+			return s.p0
+		} else if t == 1.0 {
+			return s.p3
+		}
+		return ellipsePos(s.rx, s.ry, s.phi, s.cx, s.cy, s.theta0+t*(s.theta1-s.theta0))
+	}
+	return s.p0.Interpolate(s.p3, t)
+}
+
+func (s intersectionSegment) deriv(t float64) Point {
+	switch s.cmd {
+	case quadToCmd:
+		return quadraticBezierDeriv(s.p0, s.p1, s.p2, t)
+	case cubeToCmd:
+		return cubicBezierDeriv(s.p0, s.p1, s.p2, s.p3, t)
+	case arcToCmd:
+		return ellipseDeriv(s.rx, s.ry, s.phi, true, s.theta0+t*(s.theta1-s.theta0)).Mul(s.theta1 - s.theta0)
+	}
+	return s.p3.Sub(s.p0)
+}
+
+// hull returns a bounding box of the segment between t0 and t1, and the maximum distance of the segment to the line between its end points.
+func (s intersectionSegment) hull(t0, t1 float64) (Rect, float64) {
+	var ps []Point
+	switch s.cmd {
+	case quadToCmd:
+		_, _, _, q0, q1, q2 := quadraticBezierSplit(s.p0, s.p1, s.p2, t0)
+		q0, q1, q2, _, _, _ = quadraticBezierSplit(q0, q1, q2, (t1-t0)/(1.0-t0))
+		ps = []Point{q0, q1, q2}
+	case cubeToCmd:
+		_, _, _, _, q0, q1, q2, q3 := cubicBezierSplit(s.p0, s.p1, s.p2, s.p3, t0)
+		q0, q1, q2, q3, _, _, _, _ = cubicBezierSplit(q0, q1, q2, q3, (t1-t0)/(1.0-t0))
+		ps = []Point{q0, q1, q2, q3}
+	case arcToCmd:
+		a, b := s.pos(t0), s.pos(t1)
+		flatness := math.Max(s.rx, s.ry) * (1.0 - math.Cos((t1-t0)*math.Abs(s.theta1-s.theta0)/2.0))
+		r := Rect{math.Min(a.X, b.X) - flatness, math.Min(a.Y, b.Y) - flatness, 0.0, 0.0}
+		r.W = math.Max(a.X, b.X) + flatness - r.X
+		r.H = math.Max(a.Y, b.Y) + flatness - r.Y
+		return r, flatness
+	default:
+		ps = []Point{s.pos(t0), s.pos(t1)}
+	}
+
+	xmin, xmax := ps[0].X, ps[0].X
+	ymin, ymax := ps[0].Y, ps[0].Y
+	for _, p := range ps[1:] {
+		xmin, xmax = math.Min(xmin, p.X), math.Max(xmax, p.X)
+		ymin, ymax = math.Min(ymin, p.Y), math.Max(ymax, p.Y)
+	}
+
+	flatness := 0.0
+	chord := ps[len(ps)-1].Sub(ps[0])
+	for _, p := range ps[1 : len(ps)-1] {
+		if chord.IsZero() {
+			flatness = math.Max(flatness, p.Sub(ps[0]).Length())
+		} else {
+			flatness = math.Max(flatness, math.Abs(chord.PerpDot(p.Sub(ps[0])))/chord.Length())
+		}
+	}
+	return Rect{xmin, ymin, xmax - xmin, ymax - ymin}, flatness
+}
+
+// intersectionOverlap is an overlapping range between two segments.
+type intersectionOverlap struct {
+	ta0, ta1, tb0, tb1 float64 // ta0 < ta1, tb0 corresponds to ta0
+}
+
+type intersectionFinder struct {
+	a, b     intersectionSegment
+	zs       []Intersection
+	overlaps []intersectionOverlap
+}
+
+func (f *intersectionFinder) find(ta0, ta1, tb0, tb1 float64, depth int) {
+	ra, flatA := f.a.hull(ta0, ta1)
+	rb, flatB := f.b.hull(tb0, tb1)
+	if ra.X+ra.W+intersectionFlatness < rb.X || rb.X+rb.W+intersectionFlatness < ra.X || ra.Y+ra.H+intersectionFlatness < rb.Y || rb.Y+rb.H+intersectionFlatness < ra.Y {
+		return
+	} else if intersectionMaxDepth <= depth || flatA <= intersectionFlatness && flatB <= intersectionFlatness {
+		f.leaf(ta0, ta1, tb0, tb1)
+		return
+	}
+
+	// subdivide the least flat segment, or both
+	tam, tbm := (ta0+ta1)/2.0, (tb0+tb1)/2.0
+	if flatB <= intersectionFlatness || flatA > 4.0*flatB {
+		f.find(ta0, tam, tb0, tb1, depth+1)
+		f.find(tam, ta1, tb0, tb1, depth+1)
+	} else if flatA <= intersectionFlatness || flatB > 4.0*flatA {
+		f.find(ta0, ta1, tb0, tbm, depth+1)
+		f.find(ta0, ta1, tbm, tb1, depth+1)
+	} else {
+		f.find(ta0, tam, tb0, tbm, depth+1)
+		f.find(ta0, tam, tbm, tb1, depth+1)
+		f.find(tam, ta1, tb0, tbm, depth+1)
+		f.find(tam, ta1, tbm, tb1, depth+1)
+	}
+}
+
+// leaf finds the intersection between two nearly flat parts of the segments.
+func (f *intersectionFinder) leaf(ta0, ta1, tb0, tb1 float64) {
+	a0, a1 := f.a.pos(ta0), f.a.pos(ta1)
+	b0, b1 := f.b.pos(tb0), f.b.pos(tb1)
+	da, db := a1.Sub(a0), b1.Sub(b0)
+	la, lb := da.Length(), db.Length()
+
+	div := da.PerpDot(db)
+	if 1e-9*la*lb < math.Abs(div) {
+		// crossing chords, refine using Newton's method on both segments
+		sa := db.PerpDot(a0.Sub(b0)) / div
+		sb := da.PerpDot(a0.Sub(b0)) / div
+		const margin = 0.1
+		if -margin <= sa && sa <= 1.0+margin && -margin <= sb && sb <= 1.0+margin {
+			ta := ta0 + math.Max(0.0, math.Min(1.0, sa))*(ta1-ta0)
+			tb := tb0 + math.Max(0.0, math.Min(1.0, sb))*(tb1-tb0)
+			if ta, tb, ok := f.newton(ta, tb); ok {
+				f.add(ta, tb)
+				return
+			}
+		}
+	} else if la != 0.0 && lb != 0.0 && math.Abs(da.PerpDot(b0.Sub(a0)))/la <= intersectionFlatness {
+		// collinear chords
+		sb0 := b0.Sub(a0).Dot(da) / la / la
+		sb1 := b1.Sub(a0).Dot(da) / la / la
+		if 0.0 < math.Max(sb0, sb1) && math.Min(sb0, sb1) < 1.0 {
+			// the range of a that overlaps with b
+			sa0 := math.Max(0.0, math.Min(sb0, sb1))
+			sa1 := math.Min(1.0, math.Max(sb0, sb1))
+			if intersectionTolerance < (sa1-sa0)*la {
+				// position along b for the start and end of the overlap
+				ua0 := a0.Interpolate(a1, sa0).Sub(b0).Dot(db) / lb / lb
+				ua1 := a0.Interpolate(a1, sa1).Sub(b0).Dot(db) / lb / lb
+				f.overlaps = append(f.overlaps, intersectionOverlap{
+					ta0 + sa0*(ta1-ta0),
+					ta0 + sa1*(ta1-ta0),
+					tb0 + math.Max(0.0, math.Min(1.0, ua0))*(tb1-tb0),
+					tb0 + math.Max(0.0, math.Min(1.0, ua1))*(tb1-tb0),
+				})
+				return
+			}
+		}
+	}
+
+	// no crossing, check whether the segments touch
+	ta, tb := (ta0+ta1)/2.0, (tb0+tb1)/2.0
+	for i := 0; i < 50; i++ {
+		ta = f.project(f.a, ta, f.b.pos(tb))
+		tb = f.project(f.b, tb, f.a.pos(ta))
+	}
+	if ta0 <= ta && ta <= ta1 && tb0 <= tb && tb <= tb1 && f.a.pos(ta).Sub(f.b.pos(tb)).Length() <= intersectionTolerance {
+		f.add(ta, tb)
+	}
+}
+
+// project returns the parametric position on s closest to p, starting from t.
+func (f *intersectionFinder) project(s intersectionSegment, t float64, p Point) float64 {
+	d := s.deriv(t)
+	if dd := d.Dot(d); dd != 0.0 {
+		t += p.Sub(s.pos(t)).Dot(d) / dd
+	}
+	return math.Max(0.0, math.Min(1.0, t))
+}
+
+// newton finds the crossing of both segments near ta and tb.
+func (f *intersectionFinder) newton(ta, tb float64) (float64, float64, bool) {
+	for i := 0; i < 20; i++ {
+		r := f.b.pos(tb).Sub(f.a.pos(ta))
+		if r.Length() <= intersectionTolerance/10.0 {
+			break
+		}
+		da, db := f.a.deriv(ta), f.b.deriv(tb).Neg()
+		div := da.PerpDot(db)
+		if div == 0.0 {
+			return ta, tb, false
+		}
+		ta = math.Max(0.0, math.Min(1.0, ta+r.PerpDot(db)/div))
+		tb = math.Max(0.0, math.Min(1.0, tb+da.PerpDot(r)/div))
+	}
+	return ta, tb, f.a.pos(ta).Sub(f.b.pos(tb)).Length() <= intersectionTolerance
+}
+
+func (f *intersectionFinder) add(ta, tb float64) {
+	da, db := f.a.deriv(ta), f.b.deriv(tb)
+	tangent := math.Abs(da.PerpDot(db)) <= 1e-6*da.Length()*db.Length()
+	f.zs = append(f.zs, Intersection{
+		Point:   f.a.pos(ta),
+		Seg:     [2]int{f.a.seg, f.b.seg},
+		T:       [2]float64{ta, tb},
+		Dir:     [2]float64{intersectionDir(da), intersectionDir(db)},
+		Tangent: tangent,
+	})
+}
+
+// intersectionDir returns the direction angle of the derivative within [0,2*PI).
+func intersectionDir(d Point) float64 {
+	if dir := angleNorm(d.Angle()); dir < 2.0*math.Pi {
+		return dir
+	}
+	return 0.0
+}
+
+// intersections returns the intersections between two segments.
+func (f *intersectionFinder) intersections() []Intersection {
+	f.find(0.0, 1.0, 0.0, 1.0, 0)
+
+	// merge adjacent overlapping ranges
+	sort.Slice(f.overlaps, func(i, j int) bool { return f.overlaps[i].ta0 < f.overlaps[j].ta0 })
+	overlaps := []intersectionOverlap{}
+	for _, o := range f.overlaps {
+		if 0 < len(overlaps) && o.ta0 <= overlaps[len(overlaps)-1].ta1+1e-9 {
+			last := &overlaps[len(overlaps)-1]
+			if last.ta1 < o.ta1 {
+				last.ta1 = o.ta1
+				last.tb1 = o.tb1
+			}
+		} else {
+			overlaps = append(overlaps, o)
+		}
+	}
+
+	// remove intersections within overlapping ranges
+	zs := f.zs[:0]
+	for _, z := range f.zs {
+		inside := false
+		for _, o := range overlaps {
+			if o.ta0-1e-9 <= z.T[0] && z.T[0] <= o.ta1+1e-9 {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			zs = append(zs, z)
+		}
+	}
+
+	for _, o := range overlaps {
+		n := len(zs)
+		f.add(o.ta0, o.tb0)
+		f.add(o.ta1, o.tb1)
+		zs = append(zs, f.zs[len(f.zs)-2:]...)
+		zs[n].Overlap, zs[n].Tangent = true, false
+		zs[n+1].Overlap, zs[n+1].Tangent = true, false
+	}
+	return zs
+}
+
+// Intersections returns all intersections between the paths p and q, sorted by their position along p. Intersections where the paths cross, touch (Tangent) or where the paths start or stop overlapping (Overlap) are returned. Intersections at a point between two connected segments are returned only once, at the start of the latter segment.
+func (p *Path) Intersections(q *Path) []Intersection {
+	segsP := intersectionSegments(p)
+	segsQ := intersectionSegments(q)
+	if len(segsP) == 0 || len(segsQ) == 0 {
+		return []Intersection{}
+	}
+
+	// normalize returns the segment and position at the start of the following segment when at the end of a segment
+	normalize := func(segs []intersectionSegment, seg int, t, dir float64) (int, float64, float64) {
+		if 1.0-1e-9 < t {
+			for k, s := range segs {
+				if s.seg == seg && k+1 < len(segs) && segs[k+1].seg == seg+1 && segs[k+1].p0.Equals(s.pos(1.0)) {
+					return seg + 1, 0.0, intersectionDir(segs[k+1].deriv(0.0))
+				}
+			}
+		} else if t < 1e-9 {
+			t = 0.0
+		}
+		return seg, t, dir
+	}
+
+	zs := []Intersection{}
+	boundsQ := q.Bounds()
+	for _, a := range segsP {
+		ra, _ := a.hull(0.0, 1.0)
+		if ra.X+ra.W+intersectionFlatness < boundsQ.X || boundsQ.X+boundsQ.W+intersectionFlatness < ra.X || ra.Y+ra.H+intersectionFlatness < boundsQ.Y || boundsQ.Y+boundsQ.H+intersectionFlatness < ra.Y {
+			continue
+		}
+		for _, b := range segsQ {
+			f := &intersectionFinder{a: a, b: b}
+			for _, z := range f.intersections() {
+				z.Seg[0], z.T[0], z.Dir[0] = normalize(segsP, z.Seg[0], z.T[0], z.Dir[0])
+				z.Seg[1], z.T[1], z.Dir[1] = normalize(segsQ, z.Seg[1], z.T[1], z.Dir[1])
+				duplicate := false
+				for i, z2 := range zs {
+					if z.Seg == z2.Seg && math.Abs(z.T[0]-z2.T[0]) < 1e-7 && math.Abs(z.T[1]-z2.T[1]) < 1e-7 {
+						zs[i].Overlap = z2.Overlap || z.Overlap
+						zs[i].Tangent = z2.Tangent && z.Tangent
+						duplicate = true
+						break
+					}
+				}
+				if !duplicate {
+					zs = append(zs, z)
+				}
+			}
+		}
+	}
+	sort.SliceStable(zs, func(i, j int) bool {
+		if zs[i].Seg[0] == zs[j].Seg[0] {
+			return zs[i].T[0] < zs[j].T[0]
+		}
+		return zs[i].Seg[0] < zs[j].Seg[0]
+	})
+	return zs
+}
+
+// LengthAt returns the length along the path up to the parametric position t within segment seg, where each command (including MoveTo) counts as a segment. This can be used to split a path at an intersection using SplitAt.
+func (p *Path) LengthAt(seg int, t float64) float64 {
+	d := 0.0
+	var start, end Point
+	for i, j := 0, 0; i < len(p.d) && j <= seg; j++ {
+		cmd := p.d[i]
+		tmax := 1.0
+		if j == seg {
+			tmax = t
+		}
+		switch cmd {
+		case moveToCmd:
+			end = Point{p.d[i+1], p.d[i+2]}
+		case lineToCmd, closeCmd:
+			end = Point{p.d[i+1], p.d[i+2]}
+			d += tmax * end.Sub(start).Length()
+		case quadToCmd:
+			cp := Point{p.d[i+1], p.d[i+2]}
+			end = Point{p.d[i+3], p.d[i+4]}
+			speed := func(t float64) float64 {
+				return quadraticBezierDeriv(start, cp, end, t).Length()
+			}
+			d += gaussLegendre7(speed, 0.0, tmax)
+		case cubeToCmd:
+			cp1 := Point{p.d[i+1], p.d[i+2]}
+			cp2 := Point{p.d[i+3], p.d[i+4]}
+			end = Point{p.d[i+5], p.d[i+6]}
+			speed := func(t float64) float64 {
+				return cubicBezierDeriv(start, cp1, cp2, end, t).Length()
+			}
+			d += gaussLegendre7(speed, 0.0, tmax)
+		case arcToCmd:
+			rx, ry, phi := p.d[i+1], p.d[i+2], p.d[i+3]
+			large, sweep := toArcFlags(p.d[i+4])
+			end = Point{p.d[i+5], p.d[i+6]}
+			_, _, theta1, theta2 := ellipseToCenter(start.X, start.Y, rx, ry, phi, large, sweep, end.X, end.Y)
+			speed := func(theta float64) float64 {
+				return ellipseDeriv(rx, ry, 0.0, true, theta).Length()
+			}
+			d += math.Abs(gaussLegendre7(speed, theta1, theta1+tmax*(theta2-theta1)))
+		}
+		i += cmdLen(cmd)
+		start = end
+	}
+	return d
+}
+
+////////////////////////////////////////////////////////////////
+
+// BooleanOp is a boolean operation that combines two paths, see Path.Boolean.
+type BooleanOp int
+
+// see BooleanOp
+const (
+	BooleanAnd BooleanOp = iota // intersection
+	BooleanOr                   // union
+	BooleanNot                  // difference
+	BooleanXor                  // exclusive or
+)
+
+// And returns the intersection of p and q, ie. the area that is filled by both paths. Both paths are filled using the NonZero fill rule.
+func (p *Path) And(q *Path) *Path {
+	return p.Boolean(BooleanAnd, q, NonZero)
+}
+
+// Or returns the union of p and q, ie. the area that is filled by either path. Both paths are filled using the NonZero fill rule.
+func (p *Path) Or(q *Path) *Path {
+	return p.Boolean(BooleanOr, q, NonZero)
+}
+
+// Not returns the difference of p and q, ie. the area that is filled by p but not by q. Both paths are filled using the NonZero fill rule.
+func (p *Path) Not(q *Path) *Path {
+	return p.Boolean(BooleanNot, q, NonZero)
+}
+
+// Xor returns the exclusive or of p and q, ie. the area that is filled by either p or q but not both. Both paths are filled using the NonZero fill rule.
+func (p *Path) Xor(q *Path) *Path {
+	return p.Boolean(BooleanXor, q, NonZero)
+}
+
+// Settle returns the outline of the area that is filled by p given the fill rule. The result consists of closed subpaths that do not intersect each other or themselves, where filled areas are counter clockwise and holes are clockwise. Filling the result with either fill rule gives the same area as filling p with the given fill rule.
+func (p *Path) Settle(fillRule FillRule) *Path {
+	return boolean(p, nil, func(inP, _ bool) bool { return inP }, fillRule)
+}
+
+// Boolean applies the boolean operation op to the areas filled by p and q, where fillRule determines which areas of p and q are filled. Curves are flattened within Tolerance, and the result consists of closed subpaths that do not intersect each other or themselves, where filled areas are counter clockwise and holes are clockwise.
+func (p *Path) Boolean(op BooleanOp, q *Path, fillRule FillRule) *Path {
+	var f func(bool, bool) bool
+	switch op {
+	case BooleanAnd:
+		f = func(inP, inQ bool) bool { return inP && inQ }
+	case BooleanOr:
+		f = func(inP, inQ bool) bool { return inP || inQ }
+	case BooleanNot:
+		f = func(inP, inQ bool) bool { return inP && !inQ }
+	case BooleanXor:
+		f = func(inP, inQ bool) bool { return inP != inQ }
+	default:
+		panic("unknown boolean operation")
+	}
+	return boolean(p, q, f, fillRule)
+}
+
+// boolEdge is a linear edge between two vertices of a boolGraph, src is 0 for edges of the first and 1 for edges of the second path.
+type boolEdge struct {
+	u, v int
+	src  int
+}
+
+// boolGraph is a planar graph of the flattened edges of both paths, with vertices merged within a small distance.
+type boolGraph struct {
+	vertices  []Point
+	grid      map[[2]int64][]int
+	edges     []boolEdge
+	mergeDist float64 // vertices closer than this are merged
+}
+
+// boolMergeDist is the initial distance to merge vertices, which is increased up to boolMaxMergeDist when near-coincident vertices result in an inconsistent graph.
+const boolMergeDist = 1e-9
+const boolMaxMergeDist = 1e-5
+
+func (g *boolGraph) vertex(p Point) int {
+	kx, ky := int64(math.Floor(p.X/g.mergeDist)), int64(math.Floor(p.Y/g.mergeDist))
+	for dx := int64(-1); dx <= 1; dx++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for _, i := range g.grid[[2]int64{kx + dx, ky + dy}] {
+				if math.Abs(g.vertices[i].X-p.X) <= g.mergeDist && math.Abs(g.vertices[i].Y-p.Y) <= g.mergeDist {
+					return i
+				}
+			}
+		}
+	}
+	g.vertices = append(g.vertices, p)
+	g.grid[[2]int64{kx, ky}] = append(g.grid[[2]int64{kx, ky}], len(g.vertices)-1)
+	return len(g.vertices) - 1
+}
+
+func (g *boolGraph) addPath(p *Path, src int) {
+	if p == nil {
+		return
+	}
+	for _, ps := range p.Flatten().Split() {
+		coords := ps.Coords()
+		if 1 < len(coords) && coords[0].Equals(coords[len(coords)-1]) {
+			coords = coords[:len(coords)-1]
+		}
+		if len(coords) < 3 {
+			continue // no area
+		}
+		first := g.vertex(coords[0])
+		prev := first
+		for _, coord := range coords[1:] {
+			cur := g.vertex(coord)
+			if cur != prev {
+				g.edges = append(g.edges, boolEdge{prev, cur, src})
+			}
+			prev = cur
+		}
+		if prev != first {
+			g.edges = append(g.edges, boolEdge{prev, first, src})
+		}
+	}
+}
+
+type boolSplit struct {
+	t float64
+	v int
+}
+
+// split splits all edges where they intersect or touch other edges, so that edges only meet at their end points.
+func (g *boolGraph) split() {
+	type span struct {
+		i          int
+		xmin, xmax float64
+	}
+	spans := make([]span, len(g.edges))
+	for i, e := range g.edges {
+		a, b := g.vertices[e.u], g.vertices[e.v]
+		spans[i] = span{i, math.Min(a.X, b.X), math.Max(a.X, b.X)}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].xmin < spans[j].xmin })
+
+	splits := map[int][]boolSplit{}
+	addSplit := func(i int, t float64, v int) {
+		e := g.edges[i]
+		if v != e.u && v != e.v {
+			splits[i] = append(splits[i], boolSplit{t, v})
+		}
+	}
+	// project returns the parametric position of c on the edge a-b
+	project := func(a, b, c Point) float64 {
+		d := b.Sub(a)
+		return c.Sub(a).Dot(d) / d.Dot(d)
+	}
+
+	for k, sa := range spans {
+		ea := g.edges[sa.i]
+		a0, a1 := g.vertices[ea.u], g.vertices[ea.v]
+		for _, sb := range spans[k+1:] {
+			if sa.xmax+g.mergeDist < sb.xmin {
+				break
+			}
+			eb := g.edges[sb.i]
+			b0, b1 := g.vertices[eb.u], g.vertices[eb.v]
+			if math.Max(a0.Y, a1.Y)+g.mergeDist < math.Min(b0.Y, b1.Y) || math.Max(b0.Y, b1.Y)+g.mergeDist < math.Min(a0.Y, a1.Y) {
+				continue
+			}
+
+			da, db := a1.Sub(a0), b1.Sub(b0)
+			div := da.PerpDot(db)
+			if math.Abs(div) <= 1e-12*da.Length()*db.Length() {
+				// parallel, split at each other's end points if collinear
+				if g.mergeDist < math.Abs(da.PerpDot(b0.Sub(a0)))/da.Length() {
+					continue
+				}
+				if t := project(a0, a1, b0); 0.0 < t && t < 1.0 {
+					addSplit(sa.i, t, eb.u)
+				}
+				if t := project(a0, a1, b1); 0.0 < t && t < 1.0 {
+					addSplit(sa.i, t, eb.v)
+				}
+				if t := project(b0, b1, a0); 0.0 < t && t < 1.0 {
+					addSplit(sb.i, t, ea.u)
+				}
+				if t := project(b0, b1, a1); 0.0 < t && t < 1.0 {
+					addSplit(sb.i, t, ea.v)
+				}
+				continue
+			}
+
+			ta := db.PerpDot(a0.Sub(b0)) / div
+			tb := da.PerpDot(a0.Sub(b0)) / div
+			epsA := g.mergeDist / da.Length()
+			epsB := g.mergeDist / db.Length()
+			if ta < -epsA || 1.0+epsA < ta || tb < -epsB || 1.0+epsB < tb {
+				continue
+			}
+
+			var v int
+			if ta <= epsA {
+				v = ea.u
+			} else if 1.0-epsA <= ta {
+				v = ea.v
+			} else if tb <= epsB {
+				v = eb.u
+			} else if 1.0-epsB <= tb {
+				v = eb.v
+			} else {
+				v = g.vertex(a0.Interpolate(a1, ta))
+			}
+			addSplit(sa.i, ta, v)
+			addSplit(sb.i, tb, v)
+		}
+	}
+
+	n := len(g.edges)
+	for i := 0; i < n; i++ {
+		ss, ok := splits[i]
+		if !ok {
+			continue
+		}
+		sort.Slice(ss, func(i, j int) bool { return ss[i].t < ss[j].t })
+		e := g.edges[i]
+		prev := e.u
+		first := true
+		for _, s := range ss {
+			if s.v == prev {
+				continue
+			}
+			if first {
+				g.edges[i].v = s.v
+				first = false
+			} else {
+				g.edges = append(g.edges, boolEdge{prev, s.v, e.src})
+			}
+			prev = s.v
+		}
+		if prev != e.v {
+			g.edges = append(g.edges, boolEdge{prev, e.v, e.src})
+		}
+	}
+}
+
+// boolWinding calculates winding numbers of the original paths using horizontal bands to speed up the ray casting.
+type boolWinding struct {
+	g          *boolGraph
+	ymin, dy   float64
+	bands      [][]int
+	numSources int
+}
+
+func newBoolWinding(g *boolGraph) *boolWinding {
+	ymin, ymax := math.Inf(1), math.Inf(-1)
+	for _, v := range g.vertices {
+		ymin = math.Min(ymin, v.Y)
+		ymax = math.Max(ymax, v.Y)
+	}
+	n := int(math.Sqrt(float64(len(g.edges)))) + 1
+	w := &boolWinding{g: g, ymin: ymin, dy: (ymax - ymin) / float64(n), bands: make([][]int, n)}
+	for i, e := range g.edges {
+		y0, y1 := g.vertices[e.u].Y, g.vertices[e.v].Y
+		if y1 < y0 {
+			y0, y1 = y1, y0
+		}
+		j0, j1 := w.band(y0), w.band(y1)
+		for j := j0; j <= j1; j++ {
+			w.bands[j] = append(w.bands[j], i)
+		}
+	}
+	return w
+}
+
+func (w *boolWinding) band(y float64) int {
+	if w.dy == 0.0 {
+		return 0
+	}
+	j := int((y - w.ymin) / w.dy)
+	if j < 0 {
+		return 0
+	} else if len(w.bands) <= j {
+		return len(w.bands) - 1
+	}
+	return j
+}
+
+// at returns the winding numbers of the first and second path at the given point, counter clockwise windings are counted positively.
+func (w *boolWinding) at(p Point) (int, int) {
+	var n [2]int
+	for _, i := range w.bands[w.band(p.Y)] {
+		e := w.g.edges[i]
+		a, b := w.g.vertices[e.u], w.g.vertices[e.v]
+		if (a.Y <= p.Y) != (b.Y <= p.Y) {
+			x := a.X + (p.Y-a.Y)*(b.X-a.X)/(b.Y-a.Y)
+			if p.X < x {
+				if a.Y < b.Y {
+					n[e.src]++
+				} else {
+					n[e.src]--
+				}
+			}
+		}
+	}
+	return n[0], n[1]
+}
+
+func boolFilled(n int, fillRule FillRule) bool {
+	if fillRule == NonZero {
+		return n != 0
+	}
+	return n%2 != 0
+}
+
+func boolean(p, q *Path, op func(bool, bool) bool, fillRule FillRule) *Path {
+	for mergeDist := boolMergeDist; ; mergeDist *= 100.0 {
+		if r, ok := booleanMerge(p, q, op, fillRule, mergeDist); ok || boolMaxMergeDist <= mergeDist {
+			return r
+		}
+	}
+}
+
+// booleanMerge returns the result of the boolean operation where vertices within mergeDist are merged, and false if edges could not be chained into closed subpaths, in which case the incomplete subpaths are dropped.
+func booleanMerge(p, q *Path, op func(bool, bool) bool, fillRule FillRule, mergeDist float64) (*Path, bool) {
+	g := &boolGraph{grid: map[[2]int64][]int{}, mergeDist: mergeDist}
+	g.addPath(p, 0)
+	g.addPath(q, 1)
+	if len(g.edges) == 0 {
+		return &Path{}, true
+	}
+	g.split()
+	winding := newBoolWinding(g)
+
+	// keep the edges that have the result's interior on one side only, and orient them to have the interior on their left
+	kept := map[[2]int]bool{}
+	out := map[int][]int{}
+	for _, e := range g.edges {
+		a, b := g.vertices[e.u], g.vertices[e.v]
+		d := b.Sub(a)
+		offset := math.Min(1e-3*d.Length(), 1e-6)
+		mid := a.Interpolate(b, 0.5)
+		n := d.Rot90CCW().Norm(offset)
+
+		nlp, nlq := winding.at(mid.Add(n))
+		nrp, nrq := winding.at(mid.Sub(n))
+		left := op(boolFilled(nlp, fillRule), boolFilled(nlq, fillRule))
+		right := op(boolFilled(nrp, fillRule), boolFilled(nrq, fillRule))
+		if left == right {
+			continue
+		}
+
+		u, v := e.u, e.v
+		if right {
+			u, v = v, u
+		}
+		if !kept[[2]int{u, v}] {
+			kept[[2]int{u, v}] = true
+			out[u] = append(out[u], v)
+		}
+	}
+
+	// chain the edges into closed subpaths, taking the left-most turn at each vertex
+	r := &Path{}
+	complete := true
+	starts := make([]int, 0, len(out))
+	for u := range out {
+		starts = append(starts, u)
+	}
+	sort.Ints(starts)
+	for _, start := range starts {
+		for 0 < len(out[start]) {
+			ring := []int{start}
+			prev, cur := start, out[start][0]
+			out[start] = out[start][1:]
+			closed := true
+			for cur != start {
+				ring = append(ring, cur)
+				next := out[cur]
+				if len(next) == 0 {
+					// dead end, which happens only when numerical errors classify edges inconsistently
+					closed = false
+					complete = false
+					break
+				}
+				k := 0
+				if 1 < len(next) {
+					dIn := g.vertices[cur].Sub(g.vertices[prev])
+					best := math.Inf(-1)
+					for j, v := range next {
+						dOut := g.vertices[v].Sub(g.vertices[cur])
+						if angle := math.Atan2(dIn.PerpDot(dOut), dIn.Dot(dOut)); best < angle {
+							best = angle
+							k = j
+						}
+					}
+				}
+				prev, cur = cur, next[k]
+				out[prev] = append(next[:k:k], next[k+1:]...)
+			}
+			if !closed || len(ring) < 3 {
+				continue // drop incomplete rings rather than closing them into a bogus polygon
+			}
+			r.MoveTo(g.vertices[ring[0]].X, g.vertices[ring[0]].Y)
+			for _, v := range ring[1:] {
+				r.LineTo(g.vertices[v].X, g.vertices[v].Y)
+			}
+			r.Close()
+		}
+	}
+	return r, complete
+}
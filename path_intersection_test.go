@@ -2,6 +2,7 @@ package canvas
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/tdewolff/test"
@@ -69,3 +70,206 @@ func TestIntersectionCircleCircle(t *testing.T) {
 		})
 	}
 }
+
+func polygonArea(p *Path) float64 {
+	area := 0.0
+	for _, ps := range p.Split() {
+		coords := ps.Coords()
+		for i := range coords {
+			a, b := coords[i], coords[(i+1)%len(coords)]
+			area += a.PerpDot(b) / 2.0
+		}
+	}
+	return area
+}
+
+func TestPathBoolean(t *testing.T) {
+	var tts = []struct {
+		p, q   string
+		op     BooleanOp
+		area   float64
+		bounds Rect
+	}{
+		// overlapping squares
+		{"M0 0L2 0L2 2L0 2z", "M1 1L3 1L3 3L1 3z", BooleanAnd, 1.0, Rect{1.0, 1.0, 1.0, 1.0}},
+		{"M0 0L2 0L2 2L0 2z", "M1 1L3 1L3 3L1 3z", BooleanOr, 7.0, Rect{0.0, 0.0, 3.0, 3.0}},
+		{"M0 0L2 0L2 2L0 2z", "M1 1L3 1L3 3L1 3z", BooleanNot, 3.0, Rect{0.0, 0.0, 2.0, 2.0}},
+		{"M0 0L2 0L2 2L0 2z", "M1 1L3 1L3 3L1 3z", BooleanXor, 6.0, Rect{0.0, 0.0, 3.0, 3.0}},
+
+		// disjoint squares
+		{"M0 0L1 0L1 1L0 1z", "M2 0L3 0L3 1L2 1z", BooleanAnd, 0.0, Rect{}},
+		{"M0 0L1 0L1 1L0 1z", "M2 0L3 0L3 1L2 1z", BooleanOr, 2.0, Rect{0.0, 0.0, 3.0, 1.0}},
+		{"M0 0L1 0L1 1L0 1z", "M2 0L3 0L3 1L2 1z", BooleanNot, 1.0, Rect{0.0, 0.0, 1.0, 1.0}},
+
+		// contained square, clockwise
+		{"M0 0L4 0L4 4L0 4z", "M1 1L1 3L3 3L3 1z", BooleanAnd, 4.0, Rect{1.0, 1.0, 2.0, 2.0}},
+		{"M0 0L4 0L4 4L0 4z", "M1 1L1 3L3 3L3 1z", BooleanOr, 16.0, Rect{0.0, 0.0, 4.0, 4.0}},
+		{"M0 0L4 0L4 4L0 4z", "M1 1L1 3L3 3L3 1z", BooleanNot, 12.0, Rect{0.0, 0.0, 4.0, 4.0}},
+
+		// squares sharing an edge
+		{"M0 0L1 0L1 1L0 1z", "M1 0L2 0L2 1L1 1z", BooleanOr, 2.0, Rect{0.0, 0.0, 2.0, 1.0}},
+		{"M0 0L1 0L1 1L0 1z", "M1 0L2 0L2 1L1 1z", BooleanAnd, 0.0, Rect{}},
+		{"M0 0L2 0L2 1L0 1z", "M1 0L3 0L3 1L1 1z", BooleanAnd, 1.0, Rect{1.0, 0.0, 1.0, 1.0}},
+
+		// equal squares
+		{"M0 0L1 0L1 1L0 1z", "M0 0L1 0L1 1L0 1z", BooleanAnd, 1.0, Rect{0.0, 0.0, 1.0, 1.0}},
+		{"M0 0L1 0L1 1L0 1z", "M0 0L1 0L1 1L0 1z", BooleanXor, 0.0, Rect{}},
+	}
+	for i, tt := range tts {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			r := MustParseSVG(tt.p).Boolean(tt.op, MustParseSVG(tt.q), NonZero)
+			test.Float(t, polygonArea(r), tt.area)
+			test.T(t, r.Bounds(), tt.bounds)
+		})
+	}
+}
+
+func TestPathBooleanCircles(t *testing.T) {
+	Tolerance = 0.01
+	p := Circle(1.0)
+	q := Circle(1.0).Translate(1.0, 0.0)
+
+	// area of the lens of two unit circles at a distance of one, curves are flattened so allow for some error
+	lens := 2.0*math.Pi/3.0 - math.Sqrt(3.0)/2.0
+	and := polygonArea(p.And(q))
+	test.That(t, math.Abs(and-lens) < 0.05, "And area", and)
+
+	// the operations must be consistent with each other
+	circle := polygonArea(p.Flatten())
+	test.Float(t, polygonArea(p.Or(q)), 2.0*circle-and)
+	test.Float(t, polygonArea(p.Not(q)), circle-and)
+	test.Float(t, polygonArea(p.Xor(q)), 2.0*circle-2.0*and)
+}
+
+func TestPathBooleanDegenerate(t *testing.T) {
+	// near-coincident vertices classify edges inconsistently so that chaining them runs into a dead end, the result must equal that of the rounded input
+	p := MustParseSVG("M3 0.9999995980308748L0 0L1.0000000064970638 3.279280961505589e-10L3 2.8075408455849262e-09L3.000000000214696 4.738818740706729e-09L3.000000044519476 2.9999999811885543z")
+	q := MustParseSVG("M1.7182910623463955e-09 1L1 1L-2.636725256956395e-09 2.275772560415229e-09L1 4.777634773577185e-07L-2.521554768130047e-10 1.0000000003351037z")
+	p0 := MustParseSVG("M3 1L0 0L1 0L3 0L3 0L3 3z")
+	q0 := MustParseSVG("M0 1L1 1L0 0L1 0L0 1z")
+	for _, op := range []BooleanOp{BooleanAnd, BooleanOr, BooleanNot, BooleanXor} {
+		r := p.Boolean(op, q, NonZero)
+		area, area0 := polygonArea(r), polygonArea(p0.Boolean(op, q0, NonZero))
+		test.That(t, math.Abs(area-area0) < 1e-5, "operation", op, "area", area, "!=", area0)
+		for _, ps := range r.Split() {
+			test.That(t, ps.Closed(), "subpaths must be closed")
+		}
+	}
+
+	// incomplete subpaths are dropped
+	r, ok := booleanMerge(p, q, func(inP, inQ bool) bool { return inP && !inQ }, NonZero, boolMergeDist)
+	test.That(t, !ok, "must run into a dead end")
+	for _, ps := range r.Split() {
+		test.That(t, ps.Closed(), "subpaths must be closed")
+	}
+}
+
+func TestPathSettle(t *testing.T) {
+	// self-intersecting bow tie
+	p := MustParseSVG("M0 0L2 2L2 0L0 2z")
+	r := p.Settle(NonZero)
+	test.Float(t, polygonArea(r), 2.0)
+	test.T(t, len(r.Split()), 2)
+
+	// overlapping subpaths with the same orientation
+	p = MustParseSVG("M0 0L2 0L2 2L0 2zM1 1L3 1L3 3L1 3z")
+	test.Float(t, polygonArea(p.Settle(NonZero)), 7.0)
+	test.Float(t, polygonArea(p.Settle(EvenOdd)), 6.0)
+
+	// settled paths fill the same area with either fill rule
+	r = p.Settle(EvenOdd)
+	test.Float(t, polygonArea(r.Settle(NonZero)), polygonArea(r.Settle(EvenOdd)))
+}
+
+func TestPathIntersections(t *testing.T) {
+	var tts = []struct {
+		p, q string
+		zs   []Intersection
+	}{
+		// lines
+		{"M0 0L10 10", "M0 10L10 0", []Intersection{
+			{Point: Point{5.0, 5.0}, Seg: [2]int{1, 1}, T: [2]float64{0.5, 0.5}, Dir: [2]float64{0.25 * math.Pi, 1.75 * math.Pi}},
+		}},
+		{"M0 0L10 0", "M0 5L10 5", []Intersection{}},
+		{"M0 0L10 0", "M5 0L5 5", []Intersection{
+			{Point: Point{5.0, 0.0}, Seg: [2]int{1, 1}, T: [2]float64{0.5, 0.0}, Dir: [2]float64{0.0, 0.5 * math.Pi}},
+		}},
+		{"M0 0L10 0", "M5 0L15 0", []Intersection{
+			{Point: Point{5.0, 0.0}, Seg: [2]int{1, 1}, T: [2]float64{0.5, 0.0}, Overlap: true},
+			{Point: Point{10.0, 0.0}, Seg: [2]int{1, 1}, T: [2]float64{1.0, 0.5}, Overlap: true},
+		}},
+
+		// polylines, intersection at the joint is returned once
+		{"M0 0L5 5L10 0", "M0 5L10 5", []Intersection{
+			{Point: Point{5.0, 5.0}, Seg: [2]int{2, 1}, T: [2]float64{0.0, 0.5}, Dir: [2]float64{1.75 * math.Pi, 0.0}, Tangent: false},
+		}},
+
+		// curves
+		{"M0 0Q5 10 10 0", "M0 2.5L10 2.5", []Intersection{
+			{Point: Point{5.0 - 5.0/math.Sqrt2, 2.5}, Seg: [2]int{1, 1}, T: [2]float64{0.5 - 0.5/math.Sqrt2, 0.5 - 0.5/math.Sqrt2}},
+			{Point: Point{5.0 + 5.0/math.Sqrt2, 2.5}, Seg: [2]int{1, 1}, T: [2]float64{0.5 + 0.5/math.Sqrt2, 0.5 + 0.5/math.Sqrt2}},
+		}},
+		{"M0 0Q5 10 10 0", "M0 5L10 5", []Intersection{
+			{Point: Point{5.0, 5.0}, Seg: [2]int{1, 1}, T: [2]float64{0.5, 0.5}, Tangent: true},
+		}},
+		{"M0 0C0 10 10 10 10 0", "M0 7.5L10 7.5", []Intersection{
+			{Point: Point{5.0, 7.5}, Seg: [2]int{1, 1}, T: [2]float64{0.5, 0.5}, Tangent: true},
+		}},
+		{"M-1 0A1 1 0 0 0 1 0", "M0 -2L0 2", []Intersection{
+			{Point: Point{0.0, 1.0}, Seg: [2]int{1, 1}, T: [2]float64{0.5, 0.75}, Dir: [2]float64{0.0, 0.5 * math.Pi}},
+		}},
+		{"M0 0Q5 10 10 0", "M0 0Q5 10 10 0", []Intersection{
+			{Point: Point{0.0, 0.0}, Seg: [2]int{1, 1}, T: [2]float64{0.0, 0.0}, Overlap: true},
+			{Point: Point{10.0, 0.0}, Seg: [2]int{1, 1}, T: [2]float64{1.0, 1.0}, Overlap: true},
+		}},
+	}
+	for i, tt := range tts {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			zs := MustParseSVG(tt.p).Intersections(MustParseSVG(tt.q))
+			test.T(t, len(zs), len(tt.zs))
+			for i := range zs {
+				if i < len(tt.zs) {
+					test.T(t, zs[i].Point, tt.zs[i].Point)
+					test.T(t, zs[i].Seg, tt.zs[i].Seg)
+					test.Float(t, zs[i].T[0], tt.zs[i].T[0])
+					test.Float(t, zs[i].T[1], tt.zs[i].T[1])
+					test.T(t, zs[i].Tangent, tt.zs[i].Tangent, "tangent")
+					test.T(t, zs[i].Overlap, tt.zs[i].Overlap, "overlap")
+					if !tt.zs[i].Tangent && !tt.zs[i].Overlap && (tt.zs[i].Dir[0] != 0.0 || tt.zs[i].Dir[1] != 0.0) {
+						test.Float(t, zs[i].Dir[0], tt.zs[i].Dir[0])
+						test.Float(t, zs[i].Dir[1], tt.zs[i].Dir[1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPathIntersectionsCircles(t *testing.T) {
+	p := Circle(1.0)
+	q := Circle(1.0).Translate(1.0, 0.0)
+	zs := p.Intersections(q)
+	test.T(t, len(zs), 2)
+	for _, z := range zs {
+		test.Float(t, z.X, 0.5)
+		test.Float(t, math.Abs(z.Y), math.Sqrt(3.0)/2.0)
+		test.That(t, !z.Tangent && !z.Overlap)
+	}
+}
+
+func TestPathLengthAt(t *testing.T) {
+	Tolerance = 0.01
+	p := MustParseSVG("M0 0L10 0L10 10Q10 20 0 20")
+	test.Float(t, p.LengthAt(0, 0.0), 0.0)
+	test.Float(t, p.LengthAt(1, 0.5), 5.0)
+	test.Float(t, p.LengthAt(2, 1.0), 20.0)
+	test.That(t, math.Abs(p.LengthAt(3, 1.0)-p.Length()) < 1e-3, "length of whole path")
+
+	// split at an intersection
+	zs := p.Intersections(MustParseSVG("M5 -5L5 25"))
+	test.T(t, len(zs), 2)
+	ps := p.SplitAt(p.LengthAt(zs[0].Seg[0], zs[0].T[0]), p.LengthAt(zs[1].Seg[0], zs[1].T[0]))
+	test.T(t, len(ps), 3)
+	test.T(t, ps[0].Pos(), Point{5.0, 0.0})
+	test.That(t, ps[1].Pos().Sub(zs[1].Point).Length() < Tolerance, "split at intersection", ps[1].Pos(), zs[1].Point)
+}
@@ -477,6 +477,7 @@ func offsetSegment(p *Path, halfWidth float64, cr Capper, jr Joiner) (*Path, *Pa
 }
 
 func closeInnerBends(p *Path, indices []int, closed bool) {
+	p.clearCache()
 	// closed paths end with a LineTo to the original MoveTo but are not (yet) closed
 	di := 0
 	for _, i := range indices {
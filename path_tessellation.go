@@ -1,50 +1,533 @@
 package canvas
 
 import (
-	"github.com/ByteArena/poly2tri-go"
+	"math"
+	"sort"
 )
 
-// Tessellate tessellates the path and returns the triangles that fill the path. WIP
-func (p *Path) Tessellate() ([][3]Point, [][5]Point) {
-	p = p.replace(nil, nil, nil, arcToCube)
+// Mesh is an indexed triangle mesh, where each triangle consists of three indices into the vertices and is counter clockwise.
+type Mesh struct {
+	Vertices  []Point
+	Triangles [][3]int
+}
 
-	beziers := [][5]Point{}
-	contour := []*poly2tri.Point{}
+// Area returns the total area of the triangles.
+func (m *Mesh) Area() float64 {
+	area := 0.0
+	for _, tr := range m.Triangles {
+		p0, p1, p2 := m.Vertices[tr[0]], m.Vertices[tr[1]], m.Vertices[tr[2]]
+		area += p1.Sub(p0).PerpDot(p2.Sub(p0)) / 2.0
+	}
+	return area
+}
+
+// TessellatedCurve is a quadratic Bézier curve on the outline of a tessellated path. The triangle formed by its start, control and end points is to be filled using the Loop-Blinn method, where the texture coordinates (0,0), (0.5,0) and (1,1) are assigned to the start, control and end points respectively, so that the curve is given by u^2-v = 0. When Convex is true, the side of the curve towards its chord is filled (u^2-v < 0), otherwise the side of the curve towards its control point is filled (u^2-v > 0).
+type TessellatedCurve struct {
+	Start, Control, End Point
+	Convex              bool
+}
+
+// Triangulate returns a mesh of triangles that fill the path given the fill rule. Curves are flattened within Tolerance, and any number of subpaths, holes and self-intersections are supported.
+func (p *Path) Triangulate(fillRule FillRule) *Mesh {
+	return triangulate(p.Flatten().Settle(fillRule))
+}
+
+// Tessellate tessellates the path and returns the mesh of triangles that fill the interior of the path given the fill rule, and the quadratic Bézier curves along its outline. Cubic Béziers and arcs are approximated by quadratic Béziers within Tolerance. The interior triangles are bounded by the chords of convex curves and the control points of concave curves, so that the curve triangles add the remaining area without overlap. Any number of subpaths, holes and self-intersections are supported.
+func (p *Path) Tessellate(fillRule FillRule) (*Mesh, []TessellatedCurve) {
+	p = p.ReplaceArcs().replace(nil, nil, func(p0, p1, p2, p3 Point) *Path {
+		q := &Path{}
+		q.MoveTo(p0.X, p0.Y)
+		for _, quad := range cubicToQuadraticBeziers(p0, p1, p2, p3) {
+			q.QuadTo(quad[1].X, quad[1].Y, quad[2].X, quad[2].Y)
+		}
+		return q
+	}, nil)
+
+	// polylines to test which side of a curve is filled
+	polylines := []*Polyline{}
+	for _, ps := range p.Split() {
+		polylines = append(polylines, PolylineFromPath(ps.Close()))
+	}
+	interior := func(test Point) bool {
+		fillCount := 0
+		for _, polyline := range polylines {
+			fillCount += polyline.FillCount(test.X, test.Y)
+		}
+		if fillRule == NonZero {
+			return fillCount != 0
+		}
+		return fillCount%2 != 0
+	}
+
+	curves := []TessellatedCurve{}
+	chords := &Path{}
 	var start, end Point
 	for i := 0; i < len(p.d); {
 		cmd := p.d[i]
 		switch cmd {
-		case moveToCmd, lineToCmd:
+		case moveToCmd:
 			end = Point{p.d[i+1], p.d[i+2]}
-			contour = append(contour, poly2tri.NewPoint(end.X, end.Y))
+			chords.MoveTo(end.X, end.Y)
+		case lineToCmd:
+			end = Point{p.d[i+1], p.d[i+2]}
+			chords.LineTo(end.X, end.Y)
 		case quadToCmd:
 			cp := Point{p.d[i+1], p.d[i+2]}
 			end = Point{p.d[i+3], p.d[i+4]}
-			cp1, cp2 := quadraticToCubicBezier(start, cp, end)
-			contour = append(contour, poly2tri.NewPoint(end.X, end.Y))
-			beziers = append(beziers, [5]Point{start, cp1, cp2, end, Point{1.0, 1.0}})
-		case cubeToCmd:
-			cp1 := Point{p.d[i+1], p.d[i+2]}
-			cp2 := Point{p.d[i+3], p.d[i+4]}
-			end = Point{p.d[i+5], p.d[i+6]}
-			contour = append(contour, poly2tri.NewPoint(end.X, end.Y))
-			beziers = append(beziers, [5]Point{start, cp1, cp2, end, Point{1.0, 1.0}})
-		case arcToCmd:
-			panic("arcs should have been replaced")
+			if math.Abs(end.Sub(start).PerpDot(cp.Sub(start))) < Epsilon {
+				chords.LineTo(end.X, end.Y)
+				break
+			}
+
+			// test the point halfway between the curve and the middle of its chord
+			mid := quadraticBezierPos(start, cp, end, 0.5)
+			convex := interior(mid.Interpolate(start.Interpolate(end, 0.5), 0.5))
+			curves = append(curves, TessellatedCurve{start, cp, end, convex})
+			if !convex {
+				chords.LineTo(cp.X, cp.Y)
+			}
+			chords.LineTo(end.X, end.Y)
+		case closeCmd:
+			end = Point{p.d[i+1], p.d[i+2]}
+			chords.Close()
 		}
 		i += cmdLen(cmd)
 		start = end
 	}
+	return triangulate(chords.Settle(fillRule)), curves
+}
+
+// triangulate returns the triangles that fill a path consisting of linear subpaths that do not intersect, where filled areas are counter clockwise and holes are clockwise, such as returned by Settle.
+func triangulate(p *Path) *Mesh {
+	type polygon struct {
+		coords []Point
+		area   float64
+		holes  [][]Point
+	}
+
+	outers := []*polygon{}
+	holes := [][]Point{}
+	for _, ps := range p.Split() {
+		coords := ps.Coords()
+		if 1 < len(coords) && coords[0].Equals(coords[len(coords)-1]) {
+			coords = coords[:len(coords)-1]
+		}
+		if len(coords) < 3 {
+			continue
+		}
+
+		area := ringArea(coords)
+		if 0.0 < area {
+			outers = append(outers, &polygon{coords: coords, area: area})
+		} else if area < 0.0 {
+			holes = append(holes, coords)
+		}
+	}
+
+	// add each hole to the smallest polygon that encloses it, the filled area is at the left of the hole's edges
+	for _, hole := range holes {
+		d := hole[1].Sub(hole[0])
+		test := hole[0].Interpolate(hole[1], 0.5).Add(d.Rot90CCW().Norm(math.Min(1e-3*d.Length(), 1e-6)))
+
+		var outer *polygon
+		for _, polygon := range outers {
+			if outer != nil && outer.area <= polygon.area {
+				continue
+			}
+			n := len(polygon.coords)
+			polyline := &Polyline{append(polygon.coords[:n:n], polygon.coords[0])}
+			if polyline.Interior(test.X, test.Y, NonZero) {
+				outer = polygon
+			}
+		}
+		if outer != nil {
+			outer.holes = append(outer.holes, hole)
+		}
+	}
+
+	mesh := &Mesh{}
+	for _, polygon := range outers {
+		offset := len(mesh.Vertices)
+		mesh.Vertices = append(mesh.Vertices, polygon.coords...)
+		for _, hole := range polygon.holes {
+			mesh.Vertices = append(mesh.Vertices, hole...)
+		}
+		for _, tr := range earcut(mesh.Vertices[offset:], polygon.coords, polygon.holes) {
+			mesh.Triangles = append(mesh.Triangles, [3]int{offset + tr[0], offset + tr[1], offset + tr[2]})
+		}
+	}
+	return mesh
+}
+
+// ringArea returns the signed area of a closed ring of coordinates, which is positive for counter clockwise polygons.
+func ringArea(coords []Point) float64 {
+	// use the Shoelace formula
+	area := 0.0
+	for i := range coords {
+		j := (i + 1) % len(coords)
+		area += coords[i].PerpDot(coords[j]) / 2.0
+	}
+	return area
+}
+
+////////////////////////////////////////////////////////////////
+
+// earNode is a vertex in a circular doubly linked list of polygon vertices.
+type earNode struct {
+	i          int // index of the vertex
+	Point          // position of the vertex
+	prev, next *earNode
+	steiner    bool
+}
+
+// earcut triangulates a polygon with holes by ear clipping, where the vertices of the polygon are followed by those of the holes. It is robust against touching holes and degenerate polygons, see https://github.com/mapbox/earcut.
+func earcut(vertices []Point, outer []Point, holes [][]Point) [][3]int {
+	triangles := [][3]int{}
+	node := earLinkedList(vertices, 0, len(outer), true)
+	if node == nil || node.next == node.prev {
+		return triangles
+	}
+
+	// bridge the holes from left to right into the outer polygon
+	if 0 < len(holes) {
+		leftmosts := []*earNode{}
+		start := len(outer)
+		for _, hole := range holes {
+			list := earLinkedList(vertices, start, start+len(hole), false)
+			start += len(hole)
+			if list == nil {
+				continue
+			} else if list == list.next {
+				list.steiner = true
+			}
+			leftmosts = append(leftmosts, earLeftmost(list))
+		}
+		sort.SliceStable(leftmosts, func(i, j int) bool {
+			return leftmosts[i].X < leftmosts[j].X
+		})
+		for _, leftmost := range leftmosts {
+			if bridge := earHoleBridge(leftmost, node); bridge != nil {
+				bridgeReverse := earSplit(bridge, leftmost)
+				earFilter(bridgeReverse, bridgeReverse.next)
+				node = earFilter(bridge, bridge.next)
+			}
+		}
+	}
+
+	earcutLinked(node, &triangles, 0)
+	return triangles
+}
+
+// earcutLinked clips ears from the polygon. When no ears can be found, it removes degenerate vertices, then cures small self-intersections, and finally splits the polygon in two.
+func earcutLinked(ear *earNode, triangles *[][3]int, pass int) {
+	if ear == nil {
+		return
+	}
+
+	stop := ear
+	for ear.prev != ear.next {
+		prev, next := ear.prev, ear.next
+		if earIsEar(ear) {
+			*triangles = append(*triangles, [3]int{prev.i, ear.i, next.i})
+			earRemove(ear)
+			ear = next.next
+			stop = next.next
+			continue
+		}
+
+		ear = next
+		if ear == stop {
+			if pass == 0 {
+				earcutLinked(earFilter(ear, nil), triangles, 1)
+			} else if pass == 1 {
+				ear = earCureLocalIntersections(earFilter(ear, nil), triangles)
+				earcutLinked(ear, triangles, 2)
+			} else {
+				earSplitEarcut(ear, triangles)
+			}
+			break
+		}
+	}
+}
+
+// earLinkedList creates a circular doubly linked list from the vertices in the given range, which is counter clockwise if ccw is true and clockwise otherwise.
+func earLinkedList(vertices []Point, start, end int, ccw bool) *earNode {
+	var last *earNode
+	if ccw == (0.0 < ringArea(vertices[start:end])) {
+		for i := start; i < end; i++ {
+			last = earInsert(i, vertices[i], last)
+		}
+	} else {
+		for i := end - 1; start <= i; i-- {
+			last = earInsert(i, vertices[i], last)
+		}
+	}
+	if last != nil && last.Point == last.next.Point {
+		earRemove(last)
+		last = last.next
+	}
+	return last
+}
+
+// earFilter removes duplicate and collinear vertices.
+func earFilter(start, end *earNode) *earNode {
+	if start == nil {
+		return start
+	} else if end == nil {
+		end = start
+	}
 
-	swctx := poly2tri.NewSweepContext(contour, false)
-	swctx.Triangulate()
+	p := start
+	for {
+		again := false
+		if !p.steiner && (p.Point == p.next.Point || earArea(p.prev, p, p.next) == 0.0) {
+			earRemove(p)
+			p = p.prev
+			end = p
+			if p == p.next {
+				break
+			}
+			again = true
+		} else {
+			p = p.next
+		}
+		if !again && p == end {
+			break
+		}
+	}
+	return end
+}
+
+// earIsEar returns true if the triangle formed by the vertex and its neighbours is convex and contains no other vertices.
+func earIsEar(ear *earNode) bool {
+	a, b, c := ear.prev, ear, ear.next
+	if 0.0 <= earArea(a, b, c) {
+		return false // reflex
+	}
+
+	x0, x1 := math.Min(a.X, math.Min(b.X, c.X)), math.Max(a.X, math.Max(b.X, c.X))
+	y0, y1 := math.Min(a.Y, math.Min(b.Y, c.Y)), math.Max(a.Y, math.Max(b.Y, c.Y))
+	for p := c.next; p != a; p = p.next {
+		if x0 <= p.X && p.X <= x1 && y0 <= p.Y && p.Y <= y1 && earPointInTriangle(a.Point, b.Point, c.Point, p.Point) && 0.0 <= earArea(p.prev, p, p.next) {
+			return false
+		}
+	}
+	return true
+}
+
+// earCureLocalIntersections removes self-intersections of two consecutive edges.
+func earCureLocalIntersections(start *earNode, triangles *[][3]int) *earNode {
+	p := start
+	for {
+		a, b := p.prev, p.next.next
+		if a.Point != b.Point && earIntersects(a, p, p.next, b) && earLocallyInside(a, b) && earLocallyInside(b, a) {
+			*triangles = append(*triangles, [3]int{a.i, p.i, b.i})
+			earRemove(p)
+			earRemove(p.next)
+			p = b
+			start = b
+		}
+		p = p.next
+		if p == start {
+			break
+		}
+	}
+	return earFilter(p, nil)
+}
+
+// earSplitEarcut splits the polygon along a valid diagonal and triangulates both halves.
+func earSplitEarcut(start *earNode, triangles *[][3]int) {
+	a := start
+	for {
+		for b := a.next.next; b != a.prev; b = b.next {
+			if a.i != b.i && earIsValidDiagonal(a, b) {
+				c := earSplit(a, b)
+				a = earFilter(a, a.next)
+				c = earFilter(c, c.next)
+				earcutLinked(a, triangles, 0)
+				earcutLinked(c, triangles, 0)
+				return
+			}
+		}
+		a = a.next
+		if a == start {
+			break
+		}
+	}
+}
 
-	triangles := [][3]Point{}
-	for _, tr := range swctx.GetTriangles() {
-		p0 := Point{tr.Points[0].X, tr.Points[0].Y}
-		p1 := Point{tr.Points[1].X, tr.Points[1].Y}
-		p2 := Point{tr.Points[2].X, tr.Points[2].Y}
-		triangles = append(triangles, [3]Point{p0, p1, p2})
+// earHoleBridge finds a vertex of the outer polygon that is visible from the leftmost vertex of a hole.
+func earHoleBridge(hole, outer *earNode) *earNode {
+	// find a segment intersected by a ray from the hole's leftmost vertex to the left
+	var m *earNode
+	qx := math.Inf(-1)
+	for p := outer; ; {
+		if hole.Y <= p.Y && p.next.Y <= hole.Y && p.next.Y != p.Y {
+			x := p.X + (hole.Y-p.Y)*(p.next.X-p.X)/(p.next.Y-p.Y)
+			if x <= hole.X && qx < x {
+				qx = x
+				m = p.next
+				if p.X < p.next.X {
+					m = p
+				}
+				if x == hole.X {
+					return m // hole touches outer segment, pick leftmost endpoint
+				}
+			}
+		}
+		p = p.next
+		if p == outer {
+			break
+		}
+	}
+	if m == nil {
+		return nil
+	}
+
+	// look for vertices inside the triangle of the hole vertex, segment intersection and endpoint, and choose the one with the minimum angle with the ray
+	stop := m
+	mp := m.Point
+	tanMin := math.Inf(1)
+	for p := m; ; {
+		a, c := Point{qx, hole.Y}, Point{hole.X, hole.Y}
+		if hole.Y < mp.Y {
+			a, c = c, a
+		}
+		if p.X <= hole.X && mp.X <= p.X && hole.X != p.X && earPointInTriangle(a, mp, c, p.Point) {
+			tan := math.Abs(hole.Y-p.Y) / (hole.X - p.X)
+			if earLocallyInside(p, hole) && (tan < tanMin || tan == tanMin && (m.X < p.X || p.X == m.X && earSectorContainsSector(m, p))) {
+				m = p
+				tanMin = tan
+			}
+		}
+		p = p.next
+		if p == stop {
+			break
+		}
+	}
+	return m
+}
+
+// earSectorContainsSector returns true if the sector of vertex m contains the sector of vertex p.
+func earSectorContainsSector(m, p *earNode) bool {
+	return earArea(m.prev, m, p.prev) < 0.0 && earArea(p.next, m, m.next) < 0.0
+}
+
+func earLeftmost(start *earNode) *earNode {
+	leftmost := start
+	for p := start.next; p != start; p = p.next {
+		if p.X < leftmost.X || p.X == leftmost.X && p.Y < leftmost.Y {
+			leftmost = p
+		}
 	}
-	return triangles, beziers
+	return leftmost
+}
+
+// earIsValidDiagonal returns true if a diagonal between a and b lies inside the polygon without intersecting its edges.
+func earIsValidDiagonal(a, b *earNode) bool {
+	return a.next.i != b.i && a.prev.i != b.i && !earIntersectsPolygon(a, b) &&
+		(earLocallyInside(a, b) && earLocallyInside(b, a) && earMiddleInside(a, b) && (earArea(a.prev, a, b.prev) != 0.0 || earArea(a, b.prev, b) != 0.0) ||
+			a.Point == b.Point && 0.0 < earArea(a.prev, a, a.next) && 0.0 < earArea(b.prev, b, b.next))
+}
+
+// earArea returns twice the signed area of a triangle, which is negative for counter clockwise triangles.
+func earArea(p, q, r *earNode) float64 {
+	return (q.Y-p.Y)*(r.X-q.X) - (q.X-p.X)*(r.Y-q.Y)
+}
+
+func earPointInTriangle(a, b, c, p Point) bool {
+	return (a.X-p.X)*(c.Y-p.Y) <= (c.X-p.X)*(a.Y-p.Y) &&
+		(b.X-p.X)*(a.Y-p.Y) <= (a.X-p.X)*(b.Y-p.Y) &&
+		(c.X-p.X)*(b.Y-p.Y) <= (b.X-p.X)*(c.Y-p.Y)
+}
+
+// earIntersects returns true if the segments p1-q1 and p2-q2 intersect.
+func earIntersects(p1, q1, p2, q2 *earNode) bool {
+	sign := func(f float64) int {
+		if 0.0 < f {
+			return 1
+		} else if f < 0.0 {
+			return -1
+		}
+		return 0
+	}
+	onSegment := func(p, q, r *earNode) bool {
+		return q.X <= math.Max(p.X, r.X) && math.Min(p.X, r.X) <= q.X && q.Y <= math.Max(p.Y, r.Y) && math.Min(p.Y, r.Y) <= q.Y
+	}
+
+	o1 := sign(earArea(p1, q1, p2))
+	o2 := sign(earArea(p1, q1, q2))
+	o3 := sign(earArea(p2, q2, p1))
+	o4 := sign(earArea(p2, q2, q1))
+	return o1 != o2 && o3 != o4 ||
+		o1 == 0 && onSegment(p1, p2, q1) || o2 == 0 && onSegment(p1, q2, q1) ||
+		o3 == 0 && onSegment(p2, p1, q2) || o4 == 0 && onSegment(p2, q1, q2)
+}
+
+// earIntersectsPolygon returns true if the diagonal a-b intersects any edge of the polygon.
+func earIntersectsPolygon(a, b *earNode) bool {
+	for p := a; ; {
+		if p.i != a.i && p.next.i != a.i && p.i != b.i && p.next.i != b.i && earIntersects(p, p.next, a, b) {
+			return true
+		}
+		p = p.next
+		if p == a {
+			break
+		}
+	}
+	return false
+}
+
+// earLocallyInside returns true if the diagonal a-b is inside the polygon near vertex a.
+func earLocallyInside(a, b *earNode) bool {
+	if earArea(a.prev, a, a.next) < 0.0 {
+		return 0.0 <= earArea(a, b, a.next) && 0.0 <= earArea(a, a.prev, b)
+	}
+	return earArea(a, b, a.prev) < 0.0 || earArea(a, a.next, b) < 0.0
+}
+
+// earMiddleInside returns true if the middle of the diagonal a-b is inside the polygon.
+func earMiddleInside(a, b *earNode) bool {
+	inside := false
+	mid := a.Interpolate(b.Point, 0.5)
+	for p := a; ; {
+		if (mid.Y < p.Y) != (mid.Y < p.next.Y) && p.next.Y != p.Y && mid.X < (p.next.X-p.X)*(mid.Y-p.Y)/(p.next.Y-p.Y)+p.X {
+			inside = !inside
+		}
+		p = p.next
+		if p == a {
+			break
+		}
+	}
+	return inside
+}
+
+// earSplit splits the polygon into two by a diagonal between a and b, and returns the new vertex of b of the second polygon.
+func earSplit(a, b *earNode) *earNode {
+	a2 := &earNode{i: a.i, Point: a.Point}
+	b2 := &earNode{i: b.i, Point: b.Point}
+	an, bp := a.next, b.prev
+
+	a.next, b.prev = b, a
+	a2.next, an.prev = an, a2
+	b2.next, a2.prev = a2, b2
+	bp.next, b2.prev = b2, bp
+	return b2
+}
+
+func earInsert(i int, p Point, last *earNode) *earNode {
+	node := &earNode{i: i, Point: p}
+	if last == nil {
+		node.prev, node.next = node, node
+	} else {
+		node.next, node.prev = last.next, last
+		last.next.prev = node
+		last.next = node
+	}
+	return node
+}
+
+func earRemove(p *earNode) {
+	p.next.prev = p.prev
+	p.prev.next = p.next
 }
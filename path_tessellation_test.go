@@ -0,0 +1,82 @@
+package canvas
+
+import (
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestPathTessellate(t *testing.T) {
+	var tts = []struct {
+		p        string
+		fillRule FillRule
+		area     float64
+	}{
+		{"M0 0L10 0L10 10L0 10z", NonZero, 100.0},
+		{"M0 0L10 0L10 10L0 10", EvenOdd, 100.0},
+		{"M0 0L10 0L10 10L0 10zM2 2L8 2L8 8L2 8z", NonZero, 100.0},
+		{"M0 0L10 0L10 10L0 10zM2 2L8 2L8 8L2 8z", EvenOdd, 64.0},
+		{"M0 0L10 0L10 10L0 10zM2 2L2 8L8 8L8 2z", NonZero, 64.0},
+		{"M0 0L10 0L10 10L0 10zM5 0L15 0L15 10L5 10z", NonZero, 150.0},
+		{"M0 0L10 0L10 10L0 10zM5 0L15 0L15 10L5 10z", EvenOdd, 100.0},
+		{"M0 0L10 0L0 10L10 10z", NonZero, 50.0},
+		{"M0 0L5 0L10 0L10 10L0 10L0 5z", NonZero, 100.0},
+		{"M0 0L10 0L10 10L0 10zM0 0L5 2L2 5z", EvenOdd, 89.5},
+		{"M0 0L10 0L10 10L0 10zM5 0L7 5L3 5z", EvenOdd, 90.0},
+		{"M0 0L10 0L10 10L0 10zM1 1L1 5L5 5L5 1zM5 5L5 9L9 9L9 5z", EvenOdd, 68.0},
+		{"M0 0L10 0L10 10L0 10zM1 1L9 1L9 9L1 9zM2 2L8 2L8 8L2 8zM3 3L7 3L7 7L3 7z", EvenOdd, 56.0},
+	}
+	for _, tt := range tts {
+		t.Run(tt.p, func(t *testing.T) {
+			mesh, curves := MustParseSVG(tt.p).Tessellate(tt.fillRule)
+			test.T(t, len(curves), 0)
+			test.Float(t, mesh.Area(), tt.area)
+			for _, tr := range mesh.Triangles {
+				p0, p1, p2 := mesh.Vertices[tr[0]], mesh.Vertices[tr[1]], mesh.Vertices[tr[2]]
+				test.That(t, 0.0 < p1.Sub(p0).PerpDot(p2.Sub(p0)), "triangle must be counter clockwise")
+			}
+		})
+	}
+}
+
+func TestPathTriangulate(t *testing.T) {
+	Tolerance = 0.01
+	Epsilon = 1e-6
+
+	mesh := Rectangle(10.0, 10.0).Triangulate(NonZero)
+	test.T(t, len(mesh.Vertices), 4)
+	test.T(t, len(mesh.Triangles), 2)
+
+	mesh = Circle(5.0).Triangulate(NonZero)
+	test.Float(t, mesh.Area(), polygonArea(Circle(5.0).Flatten()))
+
+	dejaVuSerif := NewFontFamily("dejavu-serif")
+	dejaVuSerif.LoadFontFile("font/DejaVuSerif.ttf", FontRegular)
+	face := dejaVuSerif.Face(100.0, Black, FontRegular, FontNormal)
+	p, _ := face.ToPath("B8%&@gQ#")
+	for _, fillRule := range []FillRule{NonZero, EvenOdd} {
+		settled := p.Settle(fillRule)
+		test.Float(t, settled.Triangulate(fillRule).Area(), p.Triangulate(fillRule).Area())
+	}
+}
+
+func TestPathTessellateCurves(t *testing.T) {
+	Tolerance = 0.01
+
+	// convex curve
+	mesh, curves := MustParseSVG("M0 0L10 0Q10 10 0 10z").Tessellate(NonZero)
+	test.T(t, curves, []TessellatedCurve{{Point{10.0, 0.0}, Point{10.0, 10.0}, Point{0.0, 10.0}, true}})
+	test.Float(t, mesh.Area(), 50.0)
+
+	// concave curve
+	mesh, curves = MustParseSVG("M0 0L10 0L10 10L0 10Q5 5 0 0z").Tessellate(NonZero)
+	test.T(t, curves, []TessellatedCurve{{Point{0.0, 10.0}, Point{5.0, 5.0}, Point{0.0, 0.0}, false}})
+	test.Float(t, mesh.Area(), 75.0)
+
+	// curves of a hole are concave
+	_, curves = MustParseSVG("M-10 -10L10 -10L10 10L-10 10z").Append(Circle(5.0)).Tessellate(EvenOdd)
+	test.That(t, 0 < len(curves))
+	for _, curve := range curves {
+		test.That(t, !curve.Convex)
+	}
+}
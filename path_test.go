@@ -2,12 +2,14 @@ package canvas
 
 import (
 	"fmt"
+	"image"
 	"math"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/tdewolff/test"
+	"golang.org/x/image/vector"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
@@ -368,6 +370,7 @@ func TestPathMarkersAligned(t *testing.T) {
 		{"Q0 10 10 10Q20 10 20 0", []string{"L0 1L-1 0z", "M9 10A1 1 0 0 0 11 10z", "M20 0L20 1L21 0z"}},
 		{"C0 6.66667 3.33333 10 10 10C16.66667 10 20 6.66667 20 0", []string{"L0 1L-1 0z", "M9 10A1 1 0 0 0 11 10z", "M20 0L20 1L21 0z"}},
 		{"A10 10 0 0 0 10 10A10 10 0 0 0 20 0", []string{"L0 1L-1 0z", "M9 10A1 1 0 0 0 11 10z", "M20 0L20 1L21 0z"}},
+		{"M30 0L30 5M0 0Q0 10 10 10Q20 10 20 0", []string{"M30 0L30 1L29 0z", "M30 5L30 4L29 5z", "L0 1L-1 0z", "M9 10A1 1 0 0 0 11 10z", "M20 0L20 1L21 0z"}},
 	}
 	for _, tt := range tts {
 		t.Run(tt.orig, func(t *testing.T) {
@@ -416,7 +419,7 @@ func TestPathSplit(t *testing.T) {
 		})
 	}
 
-	ps := (&Path{[]float64{moveToCmd, 5.0, 5.0, moveToCmd, moveToCmd, 10.0, 10.0, moveToCmd, closeCmd, 10.0, 10.0, closeCmd}}).Split()
+	ps := (&Path{d: []float64{moveToCmd, 5.0, 5.0, moveToCmd, moveToCmd, 10.0, 10.0, moveToCmd, closeCmd, 10.0, 10.0, closeCmd}}).Split()
 	test.T(t, ps[0].String(), "M5 5")
 	test.T(t, ps[1].String(), "M10 10z")
 }
@@ -678,6 +681,71 @@ func TestPathToPDF(t *testing.T) {
 	}
 }
 
+func rasterizePath(p *Path, w, h int, direct bool) *image.Alpha {
+	ras := vector.NewRasterizer(w, h)
+	if direct {
+		// pass the line segments to the rasterizer as is
+		for i := 0; i < len(p.d); {
+			cmd := p.d[i]
+			if cmd == moveToCmd {
+				ras.MoveTo(float32(p.d[i+1]), float32(float64(h)-p.d[i+2]))
+			} else if cmd == lineToCmd {
+				ras.LineTo(float32(p.d[i+1]), float32(float64(h)-p.d[i+2]))
+			} else if cmd == closeCmd {
+				ras.ClosePath()
+			}
+			i += cmdLen(cmd)
+		}
+	} else {
+		p.ToRasterizer(ras, 1.0)
+	}
+	img := image.NewAlpha(image.Rect(0, 0, w, h))
+	ras.Draw(img, img.Bounds(), image.Opaque, image.Point{})
+	return img
+}
+
+func maxAlphaDiff(a, b *image.Alpha, w, h int) int {
+	diff := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := int(a.AlphaAt(x, y).A) - int(b.AlphaAt(x, y).A)
+			if d < 0 {
+				d = -d
+			}
+			if diff < d {
+				diff = d
+			}
+		}
+	}
+	return diff
+}
+
+func TestPathToRasterizer(t *testing.T) {
+	// rasters wider than 512 pixels use floating point math in the rasterizer and serve as reference, smaller rasters use fixed point math which drifts for every row a line spans
+	var tts = []struct {
+		p     string
+		drift int // difference when passing the lines directly to the rasterizer
+	}{
+		{"M10 4000L50 4L10 4z", 250}, // starts far above the raster, as for tiles
+		{"M10 60L50 4L10 4z", 20},
+		{"M5 60L60 2L20 10z", 15},
+		{"M2 62L62 1L3 2z", 18},
+	}
+	for _, tt := range tts {
+		t.Run(tt.p, func(t *testing.T) {
+			p := MustParseSVG(tt.p)
+			ref := rasterizePath(p, 600, 64, true)
+			test.T(t, maxAlphaDiff(rasterizePath(p, 64, 64, true), ref, 64, 64), tt.drift)
+			test.That(t, maxAlphaDiff(rasterizePath(p, 64, 64, false), ref, 64, 64) <= 3, "drift in small raster")
+			test.That(t, maxAlphaDiff(rasterizePath(p, 600, 64, false), ref, 600, 64) <= 1, "large raster changed")
+		})
+	}
+
+	// lines spanning a few rows are not split
+	p := MustParseSVG("M2 20L62 14L30 22z")
+	test.T(t, maxAlphaDiff(rasterizePath(p, 64, 64, false), rasterizePath(p, 64, 64, true), 64, 64), 0)
+}
+
 func plotPathLengthParametrization(filename string, N int, speed, length func(float64) float64, tmin, tmax float64) {
 	Tc, totalLength := invSpeedPolynomialChebyshevApprox(N, gaussLegendre7, speed, tmin, tmax)
 
@@ -0,0 +1,220 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Options are the options for the PDF renderer.
+type Options struct {
+	PDFA   bool   // write a PDF/A-2b conforming file, which implies Tagged
+	Tagged bool   // write a structure tree that marks text and images, for accessibility
+	Lang   string // natural language of the document, such as "en-US"
+}
+
+// DefaultOptions are the default options for the PDF renderer.
+var DefaultOptions = Options{}
+
+// pdfStructElem is a structure element of the structure tree that refers to marked content on a page.
+type pdfStructElem struct {
+	kind pdfName // P or Figure
+	mcid int
+}
+
+// pdfStructTree holds the references to the structure elements of the pages that have been written.
+type pdfStructTree struct {
+	document   pdfRef   // document element, which is the parent of all structure elements
+	kids       pdfArray // structure elements in order
+	parentTree pdfArray // page keys and the structure elements of each page by MCID
+}
+
+// addStructElems writes the structure elements of a page, which are children of the document element.
+func (w *pdfWriter) addStructElems(page pdfRef, structParents int, elems []pdfStructElem) {
+	if w.structTree.document == 0 {
+		w.structTree.document = w.reserveObject()
+	}
+
+	refs := pdfArray{}
+	for _, elem := range elems {
+		ref := w.writeObject(pdfDict{
+			"Type": pdfName("StructElem"),
+			"S":    elem.kind,
+			"P":    w.structTree.document,
+			"Pg":   page,
+			"K":    elem.mcid,
+		})
+		refs = append(refs, ref)
+	}
+	w.structTree.kids = append(w.structTree.kids, refs...)
+	w.structTree.parentTree = append(w.structTree.parentTree, structParents, refs)
+}
+
+// writeStructTree writes the structure tree with a document element that contains all structure elements in order, and returns the reference to the structure tree root.
+func (w *pdfWriter) writeStructTree() pdfRef {
+	rootRef := w.reserveObject()
+	if w.structTree.document == 0 {
+		w.structTree.document = w.reserveObject()
+	}
+
+	document := pdfDict{
+		"Type": pdfName("StructElem"),
+		"S":    pdfName("Document"),
+		"P":    rootRef,
+		"K":    w.structTree.kids,
+	}
+	if w.lang != "" {
+		document["Lang"] = w.lang
+	}
+	w.writeReservedObject(w.structTree.document, document)
+	w.writeReservedObject(rootRef, pdfDict{
+		"Type": pdfName("StructTreeRoot"),
+		"K":    w.structTree.document,
+		"ParentTree": pdfDict{
+			"Nums": w.structTree.parentTree,
+		},
+		"ParentTreeNextKey": len(w.pages),
+	})
+	return rootRef
+}
+
+// writeXMPMetadata writes the document information as XMP metadata that identifies the file as PDF/A-2b, and returns its reference. The metadata must be equal to the document information dictionary.
+func (w *pdfWriter) writeXMPMetadata(creationDate time.Time) pdfRef {
+	escape := func(s string) string {
+		b := &bytes.Buffer{}
+		xml.EscapeText(b, []byte(s))
+		return b.String()
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/" xmlns:pdf="http://ns.adobe.com/pdf/1.3/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	b.WriteString(`<pdfaid:part>2</pdfaid:part><pdfaid:conformance>B</pdfaid:conformance>`)
+	fmt.Fprintf(b, `<pdf:Producer>%s</pdf:Producer>`, escape(producer))
+	fmt.Fprintf(b, `<xmp:CreateDate>%s</xmp:CreateDate>`, creationDate.Format("2006-01-02T15:04:05Z"))
+	if w.title != "" {
+		fmt.Fprintf(b, `<dc:title><rdf:Alt><rdf:li xml:lang="x-default">%s</rdf:li></rdf:Alt></dc:title>`, escape(w.title))
+	}
+	if w.subject != "" {
+		fmt.Fprintf(b, `<dc:description><rdf:Alt><rdf:li xml:lang="x-default">%s</rdf:li></rdf:Alt></dc:description>`, escape(w.subject))
+	}
+	if w.keywords != "" {
+		fmt.Fprintf(b, `<pdf:Keywords>%s</pdf:Keywords>`, escape(w.keywords))
+	}
+	if w.author != "" {
+		fmt.Fprintf(b, `<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>`, escape(w.author))
+	}
+	b.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n<?xpacket end=\"w\"?>")
+
+	// metadata streams are not compressed so that they can be read without a PDF parser
+	return w.writeObject(pdfStream{
+		dict: pdfDict{
+			"Type":    pdfName("Metadata"),
+			"Subtype": pdfName("XML"),
+		},
+		stream: b.Bytes(),
+	})
+}
+
+// writeOutputIntent writes the output intent for PDF/A with an embedded sRGB ICC profile, which defines the device-dependent RGB colors.
+func (w *pdfWriter) writeOutputIntent() pdfRef {
+	profile := w.writeObject(pdfStream{
+		dict: pdfDict{
+			"N":      3,
+			"Filter": pdfFilterFlate,
+		},
+		stream: sRGBProfile(),
+	})
+	return w.writeObject(pdfDict{
+		"Type":                      pdfName("OutputIntent"),
+		"S":                         pdfName("GTS_PDFA1"),
+		"OutputConditionIdentifier": "sRGB IEC61966-2.1",
+		"Info":                      "sRGB IEC61966-2.1",
+		"DestOutputProfile":         profile,
+	})
+}
+
+// sRGBProfile returns an ICC version 2 display profile for the sRGB color space, with its primaries adapted to D50.
+func sRGBProfile() []byte {
+	s15Fixed16 := func(v float64) uint32 {
+		return uint32(int32(math.Round(v * 65536.0)))
+	}
+	xyz := func(x, y, z float64) []byte {
+		b := make([]byte, 20)
+		copy(b, "XYZ ")
+		binary.BigEndian.PutUint32(b[8:], s15Fixed16(x))
+		binary.BigEndian.PutUint32(b[12:], s15Fixed16(y))
+		binary.BigEndian.PutUint32(b[16:], s15Fixed16(z))
+		return b
+	}
+
+	desc := &bytes.Buffer{}
+	description := "sRGB IEC61966-2.1"
+	desc.WriteString("desc\x00\x00\x00\x00")
+	binary.Write(desc, binary.BigEndian, uint32(len(description)+1))
+	desc.WriteString(description + "\x00")
+	desc.Write(make([]byte, 4+4+2+1+67)) // empty Unicode and ScriptCode descriptions
+
+	trc := &bytes.Buffer{}
+	trc.WriteString("curv\x00\x00\x00\x00")
+	binary.Write(trc, binary.BigEndian, uint32(1024))
+	for i := 0; i < 1024; i++ {
+		v := float64(i) / 1023.0
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		binary.Write(trc, binary.BigEndian, uint16(math.Round(v*65535.0)))
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc.Bytes()},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4360747, 0.2225045, 0.0139322)},
+		{"gXYZ", xyz(0.3850649, 0.7168786, 0.0971045)},
+		{"bXYZ", xyz(0.1430804, 0.0606169, 0.7141733)},
+		{"rTRC", trc.Bytes()},
+		{"gTRC", nil}, // shares the data of rTRC
+		{"bTRC", nil},
+	}
+
+	data := &bytes.Buffer{}
+	table := &bytes.Buffer{}
+	binary.Write(table, binary.BigEndian, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	var dataOffset, dataSize int
+	for _, tag := range tags {
+		if tag.data != nil {
+			dataOffset, dataSize = offset+data.Len(), len(tag.data)
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.sig)
+		binary.Write(table, binary.BigEndian, uint32(dataOffset))
+		binary.Write(table, binary.BigEndian, uint32(dataSize))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(offset+data.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntrRGB XYZ ")
+	binary.BigEndian.PutUint16(header[24:], 2000) // date and time
+	binary.BigEndian.PutUint16(header[26:], 1)
+	binary.BigEndian.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1.0, 0.8249)[8:]) // illuminant of the PCS
+
+	b := append(header, table.Bytes()...)
+	return append(b, data.Bytes()...)
+}
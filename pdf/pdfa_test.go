@@ -0,0 +1,58 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+)
+
+func TestPDFA(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	face := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+
+	buf := &bytes.Buffer{}
+	pdf := NewWithOptions(buf, 210.0, 297.0, &Options{PDFA: true, Lang: "en-US"})
+	pdf.SetCompression(false)
+	pdf.SetInfo("Title & <more>", "Subject", "Keywords", "Author ü")
+	pdf.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	pdf.RenderText(canvas.NewTextLine(face, "text", canvas.Left), canvas.Identity)
+	pdf.RenderImage(image.NewRGBA(image.Rect(0, 0, 2, 2)), canvas.Identity)
+	test.Error(t, pdf.Close())
+
+	s := buf.String()
+	test.That(t, strings.HasPrefix(s, "%PDF-1.7\n%\xE2\xE3\xCF\xD3\n"), "must have binary comment")
+	test.That(t, strings.Contains(s, " /Artifact BMC 0 0 m 10 0 l 10 10 l 0 10 l f EMC /P <</MCID 0>> BDC BT"), "must mark content")
+	test.That(t, strings.Contains(s, " ET EMC /Figure <</MCID 1>> BDC q"), "must mark images")
+	test.That(t, strings.Contains(s, "/StructParents 0"))
+	test.That(t, strings.Contains(s, "/Type /StructElem /K 0 /P 9 0 R /Pg 4 0 R /S /P >>"), "must have text structure element")
+	test.That(t, strings.Contains(s, "/Type /StructElem /K 1 /P 9 0 R /Pg 4 0 R /S /Figure >>"), "must have figure structure element")
+	test.That(t, strings.Contains(s, "/ParentTree << /Nums [0 [10 0 R 11 0 R]] >>"), "must have parent tree")
+	test.That(t, strings.Contains(s, "/Type /Catalog /Lang (en-US) /MarkInfo << /Marked true >> /Metadata "), "must have catalog entries")
+	test.That(t, strings.Contains(s, "/Type /OutputIntent /DestOutputProfile "), "must have output intent")
+	test.That(t, strings.Contains(s, "<pdfaid:part>2</pdfaid:part><pdfaid:conformance>B</pdfaid:conformance>"), "must have PDF/A identification")
+	test.That(t, strings.Contains(s, `<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Title &amp; &lt;more&gt;</rdf:li></rdf:Alt></dc:title>`), "must have title")
+	test.That(t, strings.Contains(s, "/Author (\xFE\xFF\x00A\x00u\x00t\x00h\x00o\x00r\x00 \x00\xFC) "), "must have author in info")
+	test.That(t, strings.Contains(s, "/Interpolate false"), "must not interpolate images")
+	test.That(t, strings.Contains(s, "/FontFile2 "), "must embed TrueType as FontFile2")
+	test.That(t, strings.Contains(s, "/ID [<"), "must have file identifier")
+	testXref(t, buf.Bytes())
+}
+
+func TestSRGBProfile(t *testing.T) {
+	b := sRGBProfile()
+	test.T(t, int(binary.BigEndian.Uint32(b)), len(b))
+	test.T(t, string(b[12:24]), "mntrRGB XYZ ")
+	test.T(t, string(b[36:40]), "acsp")
+	test.T(t, binary.BigEndian.Uint32(b[128:]), uint32(9))
+	for i := 0; i < 9; i++ {
+		offset := binary.BigEndian.Uint32(b[128+4+12*i+4:])
+		size := binary.BigEndian.Uint32(b[128+4+12*i+8:])
+		test.That(t, offset%4 == 0 && int(offset+size) <= len(b), "tag must be within profile")
+	}
+}
@@ -3,16 +3,21 @@ package pdf
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/md5"
 	"encoding/ascii85"
 	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"io"
 	"math"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/tdewolff/canvas"
 	canvasFont "github.com/tdewolff/canvas/font"
@@ -24,16 +29,30 @@ type PDF struct {
 	imgEnc        canvas.ImageEncoding
 }
 
-// NewPDF creates a portable document format renderer.
+// New creates a portable document format renderer.
 func New(w io.Writer, width, height float64) *PDF {
+	return NewWithOptions(w, width, height, nil)
+}
+
+// NewWithOptions creates a portable document format renderer with options, such as for PDF/A. If opts is nil, DefaultOptions is used.
+func NewWithOptions(w io.Writer, width, height float64, opts *Options) *PDF {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+
+	pdf := newPDFWriter(w)
+	pdf.pdfa = opts.PDFA
+	pdf.tagged = opts.Tagged || opts.PDFA
+	pdf.lang = opts.Lang
 	return &PDF{
-		w:      newPDFWriter(w).NewPage(width, height),
+		w:      pdf.NewPage(width, height),
 		width:  width,
 		height: height,
 		imgEnc: canvas.Lossless,
 	}
 }
 
+// SetImageEncoding sets the encoding of images that are not embedded as is. Lossless compresses images with Flate and Lossy encodes images as JPEG. Images are not encoded as JPEG 2000, which is only embedded as is from a canvas.EncodedImage.
 func (r *PDF) SetImageEncoding(enc canvas.ImageEncoding) {
 	r.imgEnc = enc
 }
@@ -49,7 +68,7 @@ func (r *PDF) SetInfo(title, subject, keywords, author string) {
 	r.w.pdf.SetAuthor(author)
 }
 
-// NewPage starts adds a new page where further rendering will be written to
+// NewPage starts adds a new page where further rendering will be written to. The previous page is written to the output and cannot be drawn on anymore.
 func (r *PDF) NewPage(width, height float64) {
 	r.w = r.w.pdf.NewPage(width, height)
 }
@@ -63,9 +82,14 @@ func (r *PDF) Size() (float64, float64) {
 }
 
 func (r *PDF) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
-	fill := style.FillColor.A != 0
-	stroke := style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth
+	// paths are not part of the logical structure and are marked as artifacts
+	r.w.BeginMarkedContent("")
+	defer r.w.EndMarkedContent()
+
+	fill := style.HasFill()
+	stroke := style.HasStroke()
 	differentAlpha := fill && stroke && style.FillColor.A != style.StrokeColor.A
+	hasPaint := fill && style.FillPaint != nil || stroke && style.StrokePaint != nil
 
 	// PDFs don't support the arcs joiner, miter joiner (not clipped), or miter joiner (clipped) with non-bevel fallback
 	strokeUnsupported := false
@@ -86,27 +110,39 @@ func (r *PDF) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 	//}
 
 	closed := false
-	data := path.Transform(m).ToPDF()
+	path = path.Transform(m)
+	data := path.ToPDF()
 	if 1 < len(data) && data[len(data)-1] == 'h' {
 		data = data[:len(data)-2]
 		closed = true
 	}
 
+	// bounds of the path in the coordinate system of the paint, extended by the stroke width
+	var paintRect canvas.Rect
+	if hasPaint {
+		paintRect = path.Bounds()
+		if stroke {
+			paintRect = canvas.Rect{X: paintRect.X - style.StrokeWidth, Y: paintRect.Y - style.StrokeWidth, W: paintRect.W + 2.0*style.StrokeWidth, H: paintRect.H + 2.0*style.StrokeWidth}
+		}
+		paintRect = paintRect.Transform(m.Inv())
+	}
+
 	if !stroke || !strokeUnsupported {
 		if fill && !stroke {
-			r.w.SetFillColor(style.FillColor)
+			q := r.w.SetFillPaint(style.FillColor, style.FillPaint, m, paintRect)
 			r.w.Write([]byte(" "))
 			r.w.Write([]byte(data))
 			r.w.Write([]byte(" f"))
 			if style.FillRule == canvas.EvenOdd {
 				r.w.Write([]byte("*"))
 			}
+			r.w.EndPaint(q)
 		} else if !fill && stroke {
-			r.w.SetStrokeColor(style.StrokeColor)
 			r.w.SetLineWidth(style.StrokeWidth)
 			r.w.SetLineCap(style.StrokeCapper)
 			r.w.SetLineJoin(style.StrokeJoiner)
 			r.w.SetDashes(style.DashOffset, style.Dashes)
+			q := r.w.SetStrokePaint(style.StrokeColor, style.StrokePaint, m, paintRect)
 			r.w.Write([]byte(" "))
 			r.w.Write([]byte(data))
 			if closed {
@@ -117,8 +153,9 @@ func (r *PDF) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 			if style.FillRule == canvas.EvenOdd {
 				r.w.Write([]byte("*"))
 			}
+			r.w.EndPaint(q)
 		} else if fill && stroke {
-			if !differentAlpha {
+			if !differentAlpha && !hasPaint {
 				r.w.SetFillColor(style.FillColor)
 				r.w.SetStrokeColor(style.StrokeColor)
 				r.w.SetLineWidth(style.StrokeWidth)
@@ -136,19 +173,20 @@ func (r *PDF) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 					r.w.Write([]byte("*"))
 				}
 			} else {
-				r.w.SetFillColor(style.FillColor)
+				q := r.w.SetFillPaint(style.FillColor, style.FillPaint, m, paintRect)
 				r.w.Write([]byte(" "))
 				r.w.Write([]byte(data))
 				r.w.Write([]byte(" f"))
 				if style.FillRule == canvas.EvenOdd {
 					r.w.Write([]byte("*"))
 				}
+				r.w.EndPaint(q)
 
-				r.w.SetStrokeColor(style.StrokeColor)
 				r.w.SetLineWidth(style.StrokeWidth)
 				r.w.SetLineCap(style.StrokeCapper)
 				r.w.SetLineJoin(style.StrokeJoiner)
 				r.w.SetDashes(style.DashOffset, style.Dashes)
+				q = r.w.SetStrokePaint(style.StrokeColor, style.StrokePaint, m, paintRect)
 				r.w.Write([]byte(" "))
 				r.w.Write([]byte(data))
 				if closed {
@@ -159,18 +197,20 @@ func (r *PDF) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 				if style.FillRule == canvas.EvenOdd {
 					r.w.Write([]byte("*"))
 				}
+				r.w.EndPaint(q)
 			}
 		}
 	} else {
 		// stroke && strokeUnsupported
 		if fill {
-			r.w.SetFillColor(style.FillColor)
+			q := r.w.SetFillPaint(style.FillColor, style.FillPaint, m, paintRect)
 			r.w.Write([]byte(" "))
 			r.w.Write([]byte(data))
 			r.w.Write([]byte(" f"))
 			if style.FillRule == canvas.EvenOdd {
 				r.w.Write([]byte("*"))
 			}
+			r.w.EndPaint(q)
 		}
 
 		// stroke settings unsupported by PDF, draw stroke explicitly
@@ -179,17 +219,19 @@ func (r *PDF) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 		}
 		path = path.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
 
-		r.w.SetFillColor(style.StrokeColor)
+		q := r.w.SetFillPaint(style.StrokeColor, style.StrokePaint, m, paintRect)
 		r.w.Write([]byte(" "))
 		r.w.Write([]byte(path.ToPDF()))
 		r.w.Write([]byte(" f"))
 		if style.FillRule == canvas.EvenOdd {
 			r.w.Write([]byte("*"))
 		}
+		r.w.EndPaint(q)
 	}
 }
 
 func (r *PDF) RenderText(text *canvas.Text, m canvas.Matrix) {
+	r.w.BeginMarkedContent("P")
 	r.w.StartTextObject()
 
 	text.WalkSpans(func(y, dx float64, span canvas.TextSpan) {
@@ -216,38 +258,95 @@ func (r *PDF) RenderText(text *canvas.Text, m canvas.Matrix) {
 		r.w.WriteText(TJ...)
 	})
 	r.w.EndTextObject()
+	r.w.EndMarkedContent()
 
 	text.RenderDecoration(r, m)
 }
 
 func (r *PDF) RenderImage(img image.Image, m canvas.Matrix) {
+	r.w.BeginMarkedContent("Figure")
 	r.w.DrawImage(img, r.imgEnc, m)
+	r.w.EndMarkedContent()
+}
+
+func (r *PDF) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	r.w.SaveState()
+	r.w.SetClip(path.Transform(m), fillRule)
+}
+
+func (r *PDF) PopClip() {
+	r.w.RestoreState()
+}
+
+func (r *PDF) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	r.w.StartGroup(opacity, blendMode)
+}
+
+func (r *PDF) PopGroup() {
+	r.w.EndGroup()
+}
+
+func (r *PDF) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	r.w.StartMask(mask, maskType, m)
+}
+
+func (r *PDF) PopMask() {
+	r.w.EndGroup()
+}
+
+// RenderLink adds a link over the rectangle transformed by m on the current page, where a URI of the form "#name" links to the named destination.
+func (r *PDF) RenderLink(uri string, rect canvas.Rect, m canvas.Matrix) {
+	r.w.AddLink(uri, rect.Transform(m))
+}
+
+// RenderDestination adds a named destination at the point transformed by m on the current page.
+func (r *PDF) RenderDestination(name string, pos canvas.Point, m canvas.Matrix) {
+	r.w.AddDestination(name, m.Dot(pos))
+}
+
+// AddOutline adds an item to the document outline (bookmarks) that navigates to the named destination. The level is the depth in the hierarchy, where an item is nested under the last preceding item with a lower level.
+func (r *PDF) AddOutline(title string, level int, dest string) {
+	r.w.pdf.AddOutline(title, level, dest)
 }
 
+// pdfWriter writes a PDF file. Pages are written as soon as the next page is started, and other objects are written when they are complete, so that memory use does not grow with the number of pages. Objects other than streams are grouped in object streams, and the cross-reference table is written as a cross-reference stream.
 type pdfWriter struct {
 	w   io.Writer
 	err error
 
-	pos        int
-	objOffsets []int
-
-	fonts    map[*canvas.Font]pdfRef
-	pages    []*pdfPageWriter
-	compress bool
-	title    string
-	subject  string
-	keywords string
-	author   string
+	pos    int
+	xref   []pdfXref
+	objStm pdfObjectStream
+
+	fonts      map[*canvas.Font]*pdfFont
+	images     map[[16]byte]pdfRef // written images by their hash, so that images drawn more than once are written once
+	imageRefs  map[imageKey]pdfRef // written images by their identity, so that drawing the same image again does not hash it
+	page       *pdfPageWriter      // current page, which is written when the next page is started or when closing
+	pages      pdfArray            // references to the written pages
+	dests      map[string]pdfDest
+	structTree pdfStructTree
+	outlines   []pdfOutline
+	pdfa       bool
+	tagged     bool
+	lang       string
+	compress   bool
+	title      string
+	subject    string
+	keywords   string
+	author     string
 }
 
 func newPDFWriter(writer io.Writer) *pdfWriter {
 	w := &pdfWriter{
-		w:          writer,
-		fonts:      map[*canvas.Font]pdfRef{},
-		objOffsets: []int{0, 0, 0}, // catalog, metadata, page tree
+		w:         writer,
+		fonts:     map[*canvas.Font]*pdfFont{},
+		images:    map[[16]byte]pdfRef{},
+		imageRefs: map[imageKey]pdfRef{},
+		dests:     map[string]pdfDest{},
+		xref:      []pdfXref{{}, {}, {}}, // catalog, metadata, page tree
 	}
 
-	w.write("%%PDF-1.7\n")
+	w.write("%%PDF-1.7\n%%\xE2\xE3\xCF\xD3\n") // binary comment marks the file as binary
 	return w
 }
 
@@ -289,6 +388,8 @@ func (w *pdfWriter) write(s string, v ...interface{}) {
 	w.err = err
 }
 
+const producer = "tdewolff/canvas"
+
 type pdfRef int
 type pdfName string
 type pdfArray []interface{}
@@ -302,10 +403,14 @@ type pdfStream struct {
 const (
 	pdfFilterASCII85 pdfFilter = "ASCII85Decode"
 	pdfFilterFlate   pdfFilter = "FlateDecode"
+	pdfFilterDCT     pdfFilter = "DCTDecode"
+	pdfFilterJPX     pdfFilter = "JPXDecode"
 )
 
 func (w *pdfWriter) writeVal(i interface{}) {
 	switch v := i.(type) {
+	case nil:
+		w.write("null")
 	case bool:
 		if v {
 			w.write("true")
@@ -320,7 +425,10 @@ func (w *pdfWriter) writeVal(i interface{}) {
 		v = strings.Replace(v, `\`, `\\`, -1)
 		v = strings.Replace(v, `(`, `\(`, -1)
 		v = strings.Replace(v, `)`, `\)`, -1)
+		v = strings.Replace(v, "\r", `\r`, -1)
 		w.write("(%v)", v)
+	case []byte:
+		w.write("<%X>", v)
 	case pdfRef:
 		w.write("%v 0 R", v)
 	case pdfName, pdfFilter:
@@ -388,6 +496,8 @@ func (w *pdfWriter) writeVal(i interface{}) {
 				w := zlib.NewWriter(&b2)
 				w.Write(b)
 				w.Close()
+			default:
+				continue // data of DCTDecode and JPXDecode is encoded already
 			}
 			b = b2.Bytes()
 		}
@@ -402,39 +512,214 @@ func (w *pdfWriter) writeVal(i interface{}) {
 	}
 }
 
-func (w *pdfWriter) writeObject(val interface{}) pdfRef {
-	w.objOffsets = append(w.objOffsets, w.pos)
-	w.write("%v 0 obj\n", len(w.objOffsets))
+// objectStreamCapacity is the maximum number of objects in an object stream.
+const objectStreamCapacity = 100
+
+// pdfXref is an entry of the cross-reference stream, which is either the offset of an object in the file or its index in an object stream.
+type pdfXref struct {
+	stream pdfRef // object stream that contains the object, zero if the object is not in an object stream
+	offset int    // offset in the file, or index in the object stream
+}
+
+// pdfObjectStream holds the objects of an object stream until it is full.
+type pdfObjectStream struct {
+	refs    []pdfRef
+	offsets []int
+	buf     bytes.Buffer
+}
+
+// reserveObject reserves an object reference, of which the object is written later by writeReservedObject.
+func (w *pdfWriter) reserveObject() pdfRef {
+	w.xref = append(w.xref, pdfXref{})
+	return pdfRef(len(w.xref))
+}
+
+// writeReservedObject writes the object for a reserved reference. Streams are written to the output directly, while other objects are added to the current object stream.
+func (w *pdfWriter) writeReservedObject(ref pdfRef, val interface{}) {
+	if _, ok := val.(pdfStream); !ok {
+		w.objStm.refs = append(w.objStm.refs, ref)
+		w.objStm.offsets = append(w.objStm.offsets, w.objStm.buf.Len())
+
+		// write the value to the object stream instead of the output
+		writer, pos := w.w, w.pos
+		w.w = &w.objStm.buf
+		w.writeVal(val)
+		w.write("\n")
+		w.w, w.pos = writer, pos
+
+		if objectStreamCapacity <= len(w.objStm.refs) {
+			w.flushObjectStream()
+		}
+		return
+	}
+
+	w.xref[ref-1] = pdfXref{offset: w.pos}
+	w.write("%v 0 obj\n", ref)
 	w.writeVal(val)
 	w.write("\nendobj\n")
-	return pdfRef(len(w.objOffsets))
 }
 
-func (w *pdfWriter) getFont(font *canvas.Font) pdfRef {
-	if ref, ok := w.fonts[font]; ok {
-		return ref
+func (w *pdfWriter) writeObject(val interface{}) pdfRef {
+	ref := w.reserveObject()
+	w.writeReservedObject(ref, val)
+	return ref
+}
+
+// flushObjectStream writes the objects in the current object stream, if any, and starts a new object stream.
+func (w *pdfWriter) flushObjectStream() {
+	if len(w.objStm.refs) == 0 {
+		return
 	}
 
-	mediatype, b := font.Raw()
-	if mediatype != "font/truetype" && mediatype != "font/opentype" {
+	ref := w.reserveObject()
+	header := &bytes.Buffer{}
+	for i, objRef := range w.objStm.refs {
+		if i != 0 {
+			header.WriteString(" ")
+		}
+		fmt.Fprintf(header, "%d %d", objRef, w.objStm.offsets[i])
+		w.xref[objRef-1] = pdfXref{stream: ref, offset: i}
+	}
+	header.WriteString("\n")
+
+	stream := pdfStream{
+		dict: pdfDict{
+			"Type":  pdfName("ObjStm"),
+			"N":     len(w.objStm.refs),
+			"First": header.Len(),
+		},
+		stream: append(header.Bytes(), w.objStm.buf.Bytes()...),
+	}
+	if w.compress {
+		stream.dict["Filter"] = pdfFilterFlate
+	}
+	w.objStm.refs = w.objStm.refs[:0]
+	w.objStm.offsets = w.objStm.offsets[:0]
+	w.objStm.buf.Reset()
+	w.writeReservedObject(ref, stream)
+}
+
+// writeXref writes the cross-reference stream with the trailer entries, and returns its offset.
+func (w *pdfWriter) writeXref(trailer pdfDict) int {
+	ref := w.reserveObject()
+	offset := w.pos
+	w.xref[ref-1] = pdfXref{offset: offset}
+
+	// size in bytes of the second field, which holds offsets and object stream numbers
+	n := 1
+	max := offset
+	if max < len(w.xref) {
+		max = len(w.xref)
+	}
+	for max >>= 8; 0 < max; max >>= 8 {
+		n++
+	}
+
+	b := make([]byte, 0, (len(w.xref)+1)*(n+3))
+	writeEntry := func(typ, field2, field3 int) {
+		b = append(b, byte(typ))
+		for i := n - 1; 0 <= i; i-- {
+			b = append(b, byte(field2>>(8*uint(i))))
+		}
+		b = append(b, byte(field3>>8), byte(field3))
+	}
+	writeEntry(0, 0, 0xFFFF) // object zero is the head of the list of free objects
+	for _, entry := range w.xref {
+		if entry.stream != 0 {
+			writeEntry(2, int(entry.stream), entry.offset)
+		} else {
+			writeEntry(1, entry.offset, 0)
+		}
+	}
+
+	trailer["Type"] = pdfName("XRef")
+	trailer["Size"] = len(w.xref) + 1
+	trailer["W"] = pdfArray{1, n, 2}
+	if w.compress {
+		trailer["Filter"] = pdfFilterFlate
+	}
+	w.writeReservedObject(ref, pdfStream{
+		dict:   trailer,
+		stream: b,
+	})
+	return offset
+}
+
+// pdfFont is an embedded font, of which only the used glyphs are embedded when subsetting is supported for the font. The glyphs are renumbered in order of use, so that CIDs equal the glyph IDs in the subset.
+type pdfFont struct {
+	ref     pdfRef
+	glyphs  []uint16          // original glyph IDs in order of the new glyph IDs
+	index   map[uint16]uint16 // original to new glyph IDs, or nil if not subsetting
+	unicode map[uint16]string // CID to Unicode text for the ToUnicode CMap
+}
+
+// glyphID returns the glyph ID in the embedded font for the original glyph ID.
+func (f *pdfFont) glyphID(glyphID uint16) uint16 {
+	if f.index == nil {
+		return glyphID
+	} else if newGlyphID, ok := f.index[glyphID]; ok {
+		return newGlyphID
+	}
+	newGlyphID := uint16(len(f.glyphs))
+	f.index[glyphID] = newGlyphID
+	f.glyphs = append(f.glyphs, glyphID)
+	return newGlyphID
+}
+
+// getFont returns the font and reserves an object reference for it, the font is written when closing the PDF when all used glyphs are known.
+func (w *pdfWriter) getFont(font *canvas.Font) *pdfFont {
+	if pf, ok := w.fonts[font]; ok {
+		return pf
+	}
+
+	pf := &pdfFont{
+		ref:     w.reserveObject(),
+		unicode: map[uint16]string{},
+	}
+	if _, _, err := font.Subset([]uint16{0}); err == nil {
+		pf.glyphs = []uint16{0}
+		pf.index = map[uint16]uint16{0: 0}
+	}
+	w.fonts[font] = pf
+	return pf
+}
+
+func (w *pdfWriter) writeFont(font *canvas.Font, pf *pdfFont) {
+	var mediatype string
+	var b []byte
+	if pf.index != nil {
 		var err error
-		b, err = canvasFont.ToSFNT(b)
-		if err != nil {
+		if mediatype, b, err = font.Subset(pf.glyphs); err != nil {
 			panic(err)
 		}
-		mediatype, err = canvasFont.MediaType(b)
-		if err != nil || mediatype != "font/truetype" && mediatype != "font/opentype" {
-			panic("only TTF and OTF formats (potentially embedded in WOFF, WOFF2 or EOT formats) supported for embedding fonts in PDFs")
+	} else {
+		mediatype, b = font.Raw()
+		if mediatype != "font/truetype" && mediatype != "font/opentype" {
+			var err error
+			b, err = canvasFont.ToSFNT(b)
+			if err != nil {
+				panic(err)
+			}
+			mediatype, err = canvasFont.MediaType(b)
+			if err != nil || mediatype != "font/truetype" && mediatype != "font/opentype" {
+				panic("only TTF and OTF formats (potentially embedded in WOFF, WOFF2 or EOT formats) supported for embedding fonts in PDFs")
+			}
+		}
+		pf.glyphs = make([]uint16, len(font.Widths(font.UnitsPerEm())))
+		for i := range pf.glyphs {
+			pf.glyphs[i] = uint16(i)
 		}
 	}
 
-	ffSubtype := ""
-	cidSubtype := ""
-	if mediatype == "font/truetype" {
-		ffSubtype = "TrueType"
-		cidSubtype = "CIDFontType2"
-	} else if mediatype == "font/opentype" {
-		ffSubtype = "OpenType"
+	// TrueType fonts are embedded as FontFile2, and OpenType fonts with CFF outlines as FontFile3
+	fontFile := pdfName("FontFile2")
+	fontFileDict := pdfDict{
+		"Filter": pdfFilterFlate,
+	}
+	cidSubtype := pdfName("CIDFontType2")
+	if mediatype == "font/opentype" {
+		fontFile = "FontFile3"
+		fontFileDict["Subtype"] = pdfName("OpenType")
 		cidSubtype = "CIDFontType0"
 	}
 
@@ -442,9 +727,13 @@ func (w *pdfWriter) getFont(font *canvas.Font) pdfRef {
 	f := 1000 / units // factor to cancel the units and scale to 1000 (pdf spec)
 
 	fWidths := font.Widths(units)
-	widths := make([]int, 0, len(fWidths))
-	for _, w := range fWidths {
-		widths = append(widths, int(w*f+0.5))
+	widths := make([]int, 0, len(pf.glyphs))
+	for _, glyphID := range pf.glyphs {
+		width := 0.0
+		if int(glyphID) < len(fWidths) {
+			width = fWidths[glyphID]
+		}
+		widths = append(widths, int(width*f+0.5))
 	}
 
 	// shorten glyph widths array
@@ -478,122 +767,327 @@ func (w *pdfWriter) getFont(font *canvas.Font) pdfRef {
 	}
 
 	baseFont := strings.ReplaceAll(font.Name(), " ", "_")
+	if pf.index != nil {
+		// subset fonts are prefixed by a tag of six uppercase letters
+		h := fnv.New32a()
+		for _, glyphID := range pf.glyphs {
+			h.Write([]byte{byte(glyphID >> 8), byte(glyphID)})
+		}
+		tag := make([]byte, 6)
+		for i, sum := 0, h.Sum32(); i < len(tag); i, sum = i+1, sum/26 {
+			tag[i] = 'A' + byte(sum%26)
+		}
+		baseFont = string(tag) + "+" + baseFont
+	}
 	bounds := font.Bounds(units)
 	metrics := font.Metrics(units)
-	fontfileRef := w.writeObject(pdfStream{
-		dict: pdfDict{
-			"Subtype": pdfName(ffSubtype),
-			"Filter":  pdfFilterFlate,
-		},
+	fontDescriptor := pdfDict{
+		"Type":        pdfName("FontDescriptor"),
+		"FontName":    pdfName(baseFont),
+		"Flags":       4,
+		"FontBBox":    pdfArray{int(f * bounds.X), -int(f * (bounds.Y + bounds.H)), int(f * (bounds.X + bounds.W)), -int(f * bounds.Y)},
+		"ItalicAngle": font.ItalicAngle(),
+		"Ascent":      int(f * metrics.Ascent),
+		"Descent":     -int(f * metrics.Descent),
+		"CapHeight":   -int(f * metrics.CapHeight),
+		"StemV":       80, // taken from Inkscape, should be calculated somehow
+		"StemH":       80,
+	}
+	fontDescriptor[fontFile] = w.writeObject(pdfStream{
+		dict:   fontFileDict,
 		stream: b,
 	})
-	ref := w.writeObject(pdfDict{
+	cidFont := pdfDict{
 		"Type":     pdfName("Font"),
-		"Subtype":  pdfName("Type0"),
+		"Subtype":  cidSubtype,
 		"BaseFont": pdfName(baseFont),
-		"Encoding": pdfName("Identity-H"),
-		"DescendantFonts": pdfArray{pdfDict{
-			"Type":        pdfName("Font"),
-			"Subtype":     pdfName(cidSubtype),
-			"BaseFont":    pdfName(baseFont),
-			"CIDToGIDMap": pdfName("Identity"),
-			"DW":          DW,
-			"W":           W,
-			"CIDSystemInfo": pdfDict{
-				"Registry":   "Adobe",
-				"Ordering":   "Identity",
-				"Supplement": 0,
-			},
-			"FontDescriptor": pdfDict{
-				"Type":        pdfName("FontDescriptor"),
-				"FontName":    pdfName(baseFont),
-				"Flags":       4,
-				"FontBBox":    pdfArray{int(f * bounds.X), -int(f * (bounds.Y + bounds.H)), int(f * (bounds.X + bounds.W)), -int(f * bounds.Y)},
-				"ItalicAngle": font.ItalicAngle(),
-				"Ascent":      int(f * metrics.Ascent),
-				"Descent":     -int(f * metrics.Descent),
-				"CapHeight":   -int(f * metrics.CapHeight),
-				"StemV":       80, // taken from Inkscape, should be calculated somehow
-				"StemH":       80,
-				"FontFile3":   fontfileRef,
+		"DW":       DW,
+		"W":        W,
+		"CIDSystemInfo": pdfDict{
+			"Registry":   "Adobe",
+			"Ordering":   "Identity",
+			"Supplement": 0,
+		},
+		"FontDescriptor": fontDescriptor,
+	}
+	if cidSubtype == "CIDFontType2" {
+		cidFont["CIDToGIDMap"] = pdfName("Identity")
+	}
+	dict := pdfDict{
+		"Type":            pdfName("Font"),
+		"Subtype":         pdfName("Type0"),
+		"BaseFont":        pdfName(baseFont),
+		"Encoding":        pdfName("Identity-H"),
+		"DescendantFonts": pdfArray{cidFont},
+	}
+	if 0 < len(pf.unicode) {
+		dict["ToUnicode"] = w.writeObject(pdfStream{
+			dict: pdfDict{
+				"Filter": pdfFilterFlate,
 			},
-		}},
-	})
-	w.fonts[font] = ref
-	return ref
+			stream: toUnicodeCMap(pf.unicode),
+		})
+	}
+
+	w.writeReservedObject(pf.ref, dict)
+}
+
+// toUnicodeCMap returns a ToUnicode CMap that maps CIDs to their Unicode text, so that text can be searched and copied.
+func toUnicodeCMap(unicode map[uint16]string) []byte {
+	cids := make([]uint16, 0, len(unicode))
+	for cid := range unicode {
+		cids = append(cids, cid)
+	}
+	sort.Slice(cids, func(i, j int) bool { return cids[i] < cids[j] })
+
+	b := &bytes.Buffer{}
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for i := 0; i < len(cids); i += 100 {
+		j := i + 100
+		if len(cids) < j {
+			j = len(cids)
+		}
+		fmt.Fprintf(b, "%d beginbfchar\n", j-i)
+		for _, cid := range cids[i:j] {
+			fmt.Fprintf(b, "<%04X> <", cid)
+			for _, c := range utf16.Encode([]rune(unicode[cid])) {
+				fmt.Fprintf(b, "%04X", c)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	return b.Bytes()
+}
+
+// pdfDest is a named destination at a position on a page.
+type pdfDest struct {
+	page pdfRef
+	pos  canvas.Point
+}
+
+// pdfOutline is an item of the document outline that navigates to a named destination.
+type pdfOutline struct {
+	title string
+	level int
+	dest  string
+}
+
+// AddOutline adds an item to the document outline (bookmarks) that navigates to the named destination. The level is the depth in the hierarchy, where an item is nested under the last preceding item with a lower level.
+func (w *pdfWriter) AddOutline(title string, level int, dest string) {
+	w.outlines = append(w.outlines, pdfOutline{title, level, dest})
+}
+
+// writeOutlines writes the outline dictionary and its items, and returns the reference to the outline dictionary.
+func (w *pdfWriter) writeOutlines() pdfRef {
+	type node struct {
+		pdfOutline
+		ref      pdfRef
+		count    int
+		children []*node
+	}
+
+	root := &node{ref: w.reserveObject()}
+	stack := []*node{root}
+	for _, outline := range w.outlines {
+		level := outline.level + 1
+		if level < 1 {
+			level = 1
+		} else if len(stack) < level {
+			level = len(stack)
+		}
+		stack = stack[:level]
+		item := &node{pdfOutline: outline, ref: w.reserveObject()}
+		for _, parent := range stack {
+			parent.count++
+		}
+		stack[level-1].children = append(stack[level-1].children, item)
+		stack = append(stack, item)
+	}
+
+	var write func(*node, pdfDict)
+	write = func(n *node, dict pdfDict) {
+		if 0 < len(n.children) {
+			dict["First"] = n.children[0].ref
+			dict["Last"] = n.children[len(n.children)-1].ref
+			dict["Count"] = n.count
+		}
+		w.writeReservedObject(n.ref, dict)
+
+		for i, child := range n.children {
+			dict := pdfDict{
+				"Title":  pdfTextString(child.title),
+				"Parent": n.ref,
+			}
+			if child.dest != "" {
+				dict["Dest"] = child.dest
+			}
+			if 0 < i {
+				dict["Prev"] = n.children[i-1].ref
+			}
+			if i+1 < len(n.children) {
+				dict["Next"] = n.children[i+1].ref
+			}
+			write(child, dict)
+		}
+	}
+	write(root, pdfDict{"Type": pdfName("Outlines")})
+	return root.ref
+}
+
+// pdfTextString encodes a string as a PDF text string, which is UTF-16BE with a byte order mark if it contains non-ASCII characters.
+func pdfTextString(s string) string {
+	for _, r := range s {
+		if 0x80 <= r {
+			b := []byte{0xFE, 0xFF}
+			for _, c := range utf16.Encode([]rune(s)) {
+				b = append(b, byte(c>>8), byte(c))
+			}
+			return string(b)
+		}
+	}
+	return s
 }
 
 func (w *pdfWriter) Close() error {
-	// TODO: write pages directly to stream instead of using bytes.Buffer
-	kids := pdfArray{}
-	for _, p := range w.pages {
-		kids = append(kids, p.writePage(pdfRef(3)))
+	if w.page != nil {
+		w.page.writePage(pdfRef(3))
+		w.page = nil
+	}
+
+	// fonts, in order of use
+	fonts := make([]*canvas.Font, 0, len(w.fonts))
+	for font := range w.fonts {
+		fonts = append(fonts, font)
+	}
+	sort.Slice(fonts, func(i, j int) bool { return w.fonts[fonts[i]].ref < w.fonts[fonts[j]].ref })
+	for _, font := range fonts {
+		w.writeFont(font, w.fonts[font])
 	}
 
 	// document catalog
-	w.objOffsets[0] = w.pos
-	w.write("%v 0 obj\n", 1)
-	w.writeVal(pdfDict{
+	catalog := pdfDict{
 		"Type":  pdfName("Catalog"),
 		"Pages": pdfRef(3),
-	})
-	w.write("\nendobj\n")
+	}
+	if 0 < len(w.dests) {
+		names := make([]string, 0, len(w.dests))
+		for name := range w.dests {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		dests := pdfArray{}
+		for _, name := range names {
+			dest := w.dests[name]
+			dests = append(dests, name, pdfArray{dest.page, pdfName("XYZ"), dest.pos.X * ptPerMm, dest.pos.Y * ptPerMm, nil})
+		}
+		catalog["Names"] = pdfDict{
+			"Dests": pdfDict{
+				"Names": dests,
+			},
+		}
+	}
+	if 0 < len(w.outlines) {
+		catalog["Outlines"] = w.writeOutlines()
+		catalog["PageMode"] = pdfName("UseOutlines")
+	}
+	if w.tagged {
+		catalog["MarkInfo"] = pdfDict{"Marked": true}
+		catalog["StructTreeRoot"] = w.writeStructTree()
+		if w.title != "" {
+			catalog["ViewerPreferences"] = pdfDict{"DisplayDocTitle": true}
+		}
+	}
+	if w.lang != "" {
+		catalog["Lang"] = w.lang
+	}
+	creationDate := time.Now().UTC()
+	if w.pdfa {
+		catalog["Metadata"] = w.writeXMPMetadata(creationDate)
+		catalog["OutputIntents"] = pdfArray{w.writeOutputIntent()}
+	}
+	w.writeReservedObject(pdfRef(1), catalog)
 
 	// metadata
 	info := pdfDict{
-		"Producer":     "tdewolff/canvas",
-		"CreationDate": time.Now().Format("D:20060102150405Z0700"),
+		"Producer":     producer,
+		"CreationDate": creationDate.Format("D:20060102150405Z"),
 	}
 	if w.title != "" {
-		info["title"] = w.title
+		info["Title"] = pdfTextString(w.title)
 	}
 	if w.subject != "" {
-		info["subject"] = w.subject
+		info["Subject"] = pdfTextString(w.subject)
 	}
 	if w.keywords != "" {
-		info["keywords"] = w.keywords
+		info["Keywords"] = pdfTextString(w.keywords)
 	}
 	if w.author != "" {
-		info["author"] = w.author
+		info["Author"] = pdfTextString(w.author)
 	}
-
-	w.objOffsets[1] = w.pos
-	w.write("%v 0 obj\n", 2)
-	w.writeVal(info)
-	w.write("\nendobj\n")
+	w.writeReservedObject(pdfRef(2), info)
 
 	// page tree
-	w.objOffsets[2] = w.pos
-	w.write("%v 0 obj\n", 3)
-	w.writeVal(pdfDict{
+	w.writeReservedObject(pdfRef(3), pdfDict{
 		"Type":  pdfName("Pages"),
-		"Kids":  pdfArray(kids),
-		"Count": len(kids),
+		"Kids":  w.pages,
+		"Count": len(w.pages),
 	})
-	w.write("\nendobj\n")
+	w.flushObjectStream()
 
-	xrefOffset := w.pos
-	w.write("xref\n0 %d\n0000000000 65535 f \n", len(w.objOffsets)+1)
-	for _, objOffset := range w.objOffsets {
-		w.write("%010d 00000 n \n", objOffset)
-	}
-	w.write("trailer\n")
-	w.writeVal(pdfDict{
+	id := md5.Sum([]byte(fmt.Sprintf("%v %v %v", creationDate.UnixNano(), w.pos, w.title)))
+	xrefOffset := w.writeXref(pdfDict{
 		"Root": pdfRef(1),
-		"Size": len(w.objOffsets) + 1,
 		"Info": pdfRef(2),
+		"ID":   pdfArray{id[:], id[:]},
 	})
-	w.write("\nstartxref\n%v\n%%%%EOF", xrefOffset)
+	w.write("startxref\n%v\n%%%%EOF", xrefOffset)
 	return w.err
 }
 
 type pdfPageWriter struct {
 	*bytes.Buffer
 	pdf           *pdfWriter
+	ref           pdfRef // reference of the page object, zero for other content streams
 	width, height float64
 	resources     pdfDict
+	annots        []pdfDict
+	structParents int             // key in the parent tree of the structure tree
+	structElems   []pdfStructElem // structure elements in order of their MCID
+	markedStack   []bool          // whether the marked content sequence was written
 
 	graphicsStates map[float64]pdfName
+	tilingPatterns map[pdfTilingKey]pdfName
+	patternMatrix  canvas.Matrix // maps user space to pattern space, which is the initial coordinate space of the content stream
+	pdfGraphicsState
+	stateStack   []pdfGraphicsState
+	groupStack   []pdfGroup
+	inTextObject bool
+	textPosition canvas.Matrix
+}
+
+// pdfGroup is the state of the enclosing content stream of a transparency group.
+type pdfGroup struct {
+	buffer     *bytes.Buffer
+	state      pdfGraphicsState
+	stateStack []pdfGraphicsState
+	opacity    float64
+	blendMode  canvas.BlendMode
+	gs         pdfName // graphics state used to draw the group, overrides opacity and blend mode when set
+}
+
+type pdfTilingKey struct {
+	pattern *canvas.Pattern
+	m       canvas.Matrix
+}
+
+// pdfGraphicsState is the part of the graphics state that is saved and restored by the q and Q operators.
+type pdfGraphicsState struct {
 	alpha          float64
 	fillColor      color.RGBA
 	strokeColor    color.RGBA
@@ -604,13 +1098,30 @@ type pdfPageWriter struct {
 	dashes         []float64
 	font           *canvas.Font
 	fontSize       float64
-	inTextObject   bool
-	textPosition   canvas.Matrix
 	textCharSpace  float64
 	textRenderMode int
 }
 
+// NewPage starts a new page, the previous page is written to the output.
 func (w *pdfWriter) NewPage(width, height float64) *pdfPageWriter {
+	if w.page != nil {
+		w.page.writePage(pdfRef(3))
+	}
+
+	page := w.newPageWriter(width, height)
+	page.ref = w.reserveObject()
+	page.structParents = len(w.pages)
+	w.page = page
+	w.pages = append(w.pages, page.ref)
+
+	m := canvas.Identity.Scale(ptPerMm, ptPerMm)
+	fmt.Fprintf(page, " %v %v %v %v %v %v cm", dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))
+	page.patternMatrix = m
+	return page
+}
+
+// newPageWriter returns a writer for a content stream, such as that of a page or a tiling pattern.
+func (w *pdfWriter) newPageWriter(width, height float64) *pdfPageWriter {
 	// for defaults see https://help.adobe.com/pdfl_sdk/15/PDFL_SDK_HTMLHelp/PDFL_SDK_HTMLHelp/API_References/PDFL_API_Reference/PDFEdit_Layer/General.html#_t_PDEGraphicState
 	page := &pdfPageWriter{
 		Buffer:         &bytes.Buffer{},
@@ -618,30 +1129,32 @@ func (w *pdfWriter) NewPage(width, height float64) *pdfPageWriter {
 		width:          width,
 		height:         height,
 		resources:      pdfDict{},
+		structParents:  -1,
 		graphicsStates: map[float64]pdfName{},
-		alpha:          1.0,
-		fillColor:      canvas.Black,
-		strokeColor:    canvas.Black,
-		lineWidth:      1.0,
-		lineCap:        0,
-		lineJoin:       0,
-		miterLimit:     10.0,
-		dashes:         []float64{0.0}, // dashArray and dashPhase
-		font:           nil,
-		fontSize:       0.0,
-		inTextObject:   false,
-		textPosition:   canvas.Identity,
-		textCharSpace:  0.0,
-		textRenderMode: 0,
-	}
-	w.pages = append(w.pages, page)
-
-	m := canvas.Identity.Scale(ptPerMm, ptPerMm)
-	fmt.Fprintf(page, " %v %v %v %v %v %v cm", dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))
+		tilingPatterns: map[pdfTilingKey]pdfName{},
+		patternMatrix:  canvas.Identity,
+		pdfGraphicsState: pdfGraphicsState{
+			alpha:          1.0,
+			fillColor:      canvas.Black,
+			strokeColor:    canvas.Black,
+			lineWidth:      1.0,
+			lineCap:        0,
+			lineJoin:       0,
+			miterLimit:     10.0,
+			dashes:         []float64{0.0}, // dashArray and dashPhase
+			font:           nil,
+			fontSize:       0.0,
+			textCharSpace:  0.0,
+			textRenderMode: 0,
+		},
+		inTextObject: false,
+		textPosition: canvas.Identity,
+	}
 	return page
 }
 
-func (w *pdfPageWriter) writePage(parent pdfRef) pdfRef {
+// writePage writes the content stream, annotations, and structure elements of the page, after which the page writer is not used anymore.
+func (w *pdfPageWriter) writePage(parent pdfRef) {
 	b := w.Bytes()
 	if 0 < len(b) && b[0] == ' ' {
 		b = b[1:]
@@ -654,7 +1167,7 @@ func (w *pdfPageWriter) writePage(parent pdfRef) pdfRef {
 		stream.dict["Filter"] = pdfFilterFlate
 	}
 	contents := w.pdf.writeObject(stream)
-	return w.pdf.writeObject(pdfDict{
+	page := pdfDict{
 		"Type":      pdfName("Page"),
 		"Parent":    parent,
 		"MediaBox":  pdfArray{0.0, 0.0, w.width * ptPerMm, w.height * ptPerMm},
@@ -666,7 +1179,180 @@ func (w *pdfPageWriter) writePage(parent pdfRef) pdfRef {
 			"CS":   pdfName("DeviceRGB"),
 		},
 		"Contents": contents,
-	})
+	}
+	if 0 < len(w.structElems) {
+		page["StructParents"] = w.structParents
+		w.pdf.addStructElems(w.ref, w.structParents, w.structElems)
+	}
+	if 0 < len(w.annots) {
+		annots := pdfArray{}
+		for _, annot := range w.annots {
+			annots = append(annots, w.pdf.writeObject(annot))
+		}
+		page["Annots"] = annots
+	}
+	w.pdf.writeReservedObject(w.ref, page)
+}
+
+// AddLink adds a link annotation over the rectangle, where a URI of the form "#name" links to the named destination.
+func (w *pdfPageWriter) AddLink(uri string, rect canvas.Rect) {
+	annot := pdfDict{
+		"Type":    pdfName("Annot"),
+		"Subtype": pdfName("Link"),
+		"Rect":    pdfArray{rect.X * ptPerMm, rect.Y * ptPerMm, (rect.X + rect.W) * ptPerMm, (rect.Y + rect.H) * ptPerMm},
+		"Border":  pdfArray{0, 0, 0},
+		"F":       4, // print
+	}
+	if strings.HasPrefix(uri, "#") {
+		annot["Dest"] = uri[1:]
+	} else {
+		annot["A"] = pdfDict{
+			"S":   pdfName("URI"),
+			"URI": uri,
+		}
+	}
+	w.annots = append(w.annots, annot)
+}
+
+// BeginMarkedContent starts a marked content sequence for a structure element of the given type, such as P or Figure, or for an artifact if the type is empty. It only marks content for tagged documents and outside of transparency groups. It must be ended by EndMarkedContent.
+func (w *pdfPageWriter) BeginMarkedContent(kind pdfName) {
+	if !w.pdf.tagged || 0 < len(w.groupStack) || w.structParents < 0 {
+		w.markedStack = append(w.markedStack, false)
+		return
+	}
+
+	if kind == "" {
+		fmt.Fprintf(w, " /Artifact BMC")
+	} else {
+		mcid := len(w.structElems)
+		w.structElems = append(w.structElems, pdfStructElem{kind, mcid})
+		fmt.Fprintf(w, " /%v <</MCID %d>> BDC", kind, mcid)
+	}
+	w.markedStack = append(w.markedStack, true)
+}
+
+// EndMarkedContent ends the last marked content sequence.
+func (w *pdfPageWriter) EndMarkedContent() {
+	if len(w.markedStack) == 0 {
+		return
+	}
+	if w.markedStack[len(w.markedStack)-1] {
+		fmt.Fprintf(w, " EMC")
+	}
+	w.markedStack = w.markedStack[:len(w.markedStack)-1]
+}
+
+// AddDestination adds a named destination at the position on the page, which is the target of links and outline items. A later destination with the same name replaces the former.
+func (w *pdfPageWriter) AddDestination(name string, pos canvas.Point) {
+	w.pdf.dests[name] = pdfDest{w.ref, pos}
+}
+
+// SaveState saves the graphics state, so that it can be restored by RestoreState.
+func (w *pdfPageWriter) SaveState() {
+	fmt.Fprintf(w, " q")
+	w.stateStack = append(w.stateStack, w.pdfGraphicsState)
+}
+
+// RestoreState restores the last saved graphics state. If there are no states saved, this will do nothing.
+func (w *pdfPageWriter) RestoreState() {
+	if len(w.stateStack) == 0 {
+		return
+	}
+	fmt.Fprintf(w, " Q")
+	w.pdfGraphicsState = w.stateStack[len(w.stateStack)-1]
+	w.stateStack = w.stateStack[:len(w.stateStack)-1]
+}
+
+// StartGroup starts a transparency group that is composited as a single layer with the given opacity and blend mode when calling EndGroup.
+func (w *pdfPageWriter) StartGroup(opacity float64, blendMode canvas.BlendMode) {
+	w.startGroup(pdfGroup{opacity: opacity, blendMode: blendMode})
+}
+
+// StartMask starts a transparency group that is masked by the luminance or alpha of the mask canvas, which is transformed by m, when calling EndGroup.
+func (w *pdfPageWriter) StartMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	w.startGroup(pdfGroup{gs: w.getMaskGS(mask, maskType, m)})
+}
+
+func (w *pdfPageWriter) startGroup(group pdfGroup) {
+	group.buffer, group.state, group.stateStack = w.Buffer, w.pdfGraphicsState, w.stateStack
+	w.groupStack = append(w.groupStack, group)
+	w.Buffer = &bytes.Buffer{}
+	w.stateStack = nil
+	w.alpha = 1.0 // the alpha constant is reset for the group's content stream
+}
+
+// EndGroup ends the last started transparency group or mask and draws it as a form XObject. If there are no groups started, this will do nothing.
+func (w *pdfPageWriter) EndGroup() {
+	if len(w.groupStack) == 0 {
+		return
+	}
+	for 0 < len(w.stateStack) {
+		w.RestoreState()
+	}
+	group := w.groupStack[len(w.groupStack)-1]
+	w.groupStack = w.groupStack[:len(w.groupStack)-1]
+
+	stream := pdfStream{
+		dict: pdfDict{
+			"Type":     pdfName("XObject"),
+			"Subtype":  pdfName("Form"),
+			"BBox":     pdfArray{0.0, 0.0, w.width, w.height},
+			"Matrix":   pdfArray{1.0, 0.0, 0.0, 1.0, 0.0, 0.0},
+			"FormType": 1,
+			"Group": pdfDict{
+				"Type": pdfName("Group"),
+				"S":    pdfName("Transparency"),
+				"I":    true,
+				"CS":   pdfName("DeviceRGB"),
+			},
+			"Resources": w.resources,
+		},
+		stream: bytes.TrimPrefix(w.Bytes(), []byte(" ")),
+	}
+	if w.pdf.compress {
+		stream.dict["Filter"] = pdfFilterFlate
+	}
+	ref := w.pdf.writeObject(stream)
+	if _, ok := w.resources["XObject"]; !ok {
+		w.resources["XObject"] = pdfDict{}
+	}
+	name := pdfName(fmt.Sprintf("Fm%d", len(w.resources["XObject"].(pdfDict))))
+	w.resources["XObject"].(pdfDict)[name] = ref
+
+	w.Buffer = group.buffer
+	w.pdfGraphicsState = group.state
+	w.stateStack = group.stateStack
+	gs := group.gs
+	if gs == "" {
+		gs = w.getGroupGS(group.opacity, group.blendMode)
+	}
+	fmt.Fprintf(w, " q /%v gs /%v Do Q", gs, name)
+}
+
+// getGroupGS returns the name of a graphics state that sets the opacity and blend mode of a group.
+func (w *pdfPageWriter) getGroupGS(opacity float64, blendMode canvas.BlendMode) pdfName {
+	if blendMode == canvas.NormalBlend {
+		return w.getOpacityGS(opacity)
+	}
+	if _, ok := w.resources["ExtGState"]; !ok {
+		w.resources["ExtGState"] = pdfDict{}
+	}
+	name := pdfName(fmt.Sprintf("BM%d", len(w.resources["ExtGState"].(pdfDict))))
+	w.resources["ExtGState"].(pdfDict)[name] = pdfDict{
+		"CA": opacity,
+		"ca": opacity,
+		"BM": pdfName(blendMode.String()),
+	}
+	return name
+}
+
+// SetClip intersects the clipping path with the given path.
+func (w *pdfPageWriter) SetClip(path *canvas.Path, fillRule canvas.FillRule) {
+	fmt.Fprintf(w, " %v W", path.ToPDF())
+	if fillRule == canvas.EvenOdd {
+		fmt.Fprintf(w, "*")
+	}
+	fmt.Fprintf(w, " n")
 }
 
 func (w *pdfPageWriter) SetAlpha(alpha float64) {
@@ -703,6 +1389,295 @@ func (w *pdfPageWriter) SetStrokeColor(strokeColor color.RGBA) {
 	w.SetAlpha(a)
 }
 
+// SetFillPaint sets the fill color, or the fill paint if it is not nil and is supported. For paints it opens a new graphics state that must be closed by calling EndPaint with the returned value.
+func (w *pdfPageWriter) SetFillPaint(fillColor color.RGBA, paint canvas.Paint, m canvas.Matrix, rect canvas.Rect) bool {
+	if paint != nil {
+		if pattern, gs, ok := w.getPattern(paint, m, rect); ok {
+			fmt.Fprintf(w, " q")
+			for _, name := range gs {
+				fmt.Fprintf(w, " /%v gs", name)
+			}
+			fmt.Fprintf(w, " /Pattern cs /%v scn", pattern)
+			return true
+		}
+	}
+	w.SetFillColor(fillColor)
+	return false
+}
+
+// SetStrokePaint sets the stroke color, or the stroke paint if it is not nil and is supported. For paints it opens a new graphics state that must be closed by calling EndPaint with the returned value.
+func (w *pdfPageWriter) SetStrokePaint(strokeColor color.RGBA, paint canvas.Paint, m canvas.Matrix, rect canvas.Rect) bool {
+	if paint != nil {
+		if pattern, gs, ok := w.getPattern(paint, m, rect); ok {
+			fmt.Fprintf(w, " q")
+			for _, name := range gs {
+				fmt.Fprintf(w, " /%v gs", name)
+			}
+			fmt.Fprintf(w, " /Pattern CS /%v SCN", pattern)
+			return true
+		}
+	}
+	w.SetStrokeColor(strokeColor)
+	return false
+}
+
+// EndPaint closes the graphics state opened by SetFillPaint or SetStrokePaint.
+func (w *pdfPageWriter) EndPaint(q bool) {
+	if q {
+		fmt.Fprintf(w, " Q")
+	}
+}
+
+// getPattern returns the names of the pattern and of the graphics states that set its opacity for a gradient or tiling paint. The pattern is transformed by m and covers at least rect, which is given in the coordinate system of the paint.
+func (w *pdfPageWriter) getPattern(paint canvas.Paint, m canvas.Matrix, rect canvas.Rect) (pdfName, []pdfName, bool) {
+	var gradient *canvas.Gradient
+	var shadingType int
+	var coords pdfArray
+	var t0, t1 float64
+	switch p := paint.(type) {
+	case *canvas.Pattern:
+		// opacity is given by the pattern cell
+		name, ok := w.getTilingPattern(p, m)
+		return name, []pdfName{w.getOpacityGS(1.0)}, ok
+	case *canvas.LinearGradient:
+		gradient = &p.Gradient
+		t0, t1 = 0.0, 1.0
+		if gradient.Spread != canvas.PadSpread {
+			t0, t1 = p.Range(rect)
+		}
+		d := p.End.Sub(p.Start)
+		start, end := p.Start.Add(d.Mul(t0)), p.Start.Add(d.Mul(t1))
+		shadingType = 2
+		coords = pdfArray{start.X, start.Y, end.X, end.Y}
+	case *canvas.RadialGradient:
+		gradient = &p.Gradient
+		t0, t1 = 0.0, 1.0
+		if gradient.Spread != canvas.PadSpread {
+			t0, t1 = p.Range(rect)
+		}
+		d, dr := p.C1.Sub(p.C0), p.R1-p.R0
+		c0, c1 := p.C0.Add(d.Mul(t0)), p.C0.Add(d.Mul(t1))
+		shadingType = 3
+		coords = pdfArray{c0.X, c0.Y, math.Max(0.0, p.R0+t0*dr), c1.X, c1.Y, math.Max(0.0, p.R0+t1*dr)}
+	default:
+		return "", nil, false
+	}
+	if len(gradient.Stops) == 0 || t1 <= t0 {
+		return "", nil, false
+	}
+
+	stops := gradient.StopsIn(t0, t1)
+	shading := pdfDict{
+		"ShadingType": shadingType,
+		"ColorSpace":  pdfName("DeviceRGB"),
+		"Coords":      coords,
+		"Domain":      pdfArray{t0, t1},
+		"Function": pdfStitchingFunction(stops, func(col color.RGBA) pdfArray {
+			if col.A == 0 {
+				return pdfArray{0.0, 0.0, 0.0}
+			}
+			a := float64(col.A) / 255.0
+			return pdfArray{float64(col.R) / 255.0 / a, float64(col.G) / 255.0 / a, float64(col.B) / 255.0 / a}
+		}),
+		"Extend": pdfArray{true, true},
+	}
+
+	mp := w.patternMatrix.Mul(m)
+	ref := w.pdf.writeObject(pdfDict{
+		"Type":        pdfName("Pattern"),
+		"PatternType": 2,
+		"Shading":     shading,
+		"Matrix":      pdfArray{mp[0][0], mp[1][0], mp[0][1], mp[1][1], mp[0][2], mp[1][2]},
+	})
+	name := w.addPattern(ref)
+
+	// opacity, a soft mask is used if it varies along the gradient
+	alpha := stops[0].Color.A
+	for _, stop := range stops[1:] {
+		if stop.Color.A != alpha {
+			return name, []pdfName{w.getOpacityGS(1.0), w.getSoftMaskGS(shadingType, coords, t0, t1, stops, m)}, true
+		}
+	}
+	return name, []pdfName{w.getOpacityGS(float64(alpha) / 255.0)}, true
+}
+
+// addPattern adds a pattern to the resources and returns its name.
+func (w *pdfPageWriter) addPattern(ref pdfRef) pdfName {
+	if _, ok := w.resources["Pattern"]; !ok {
+		w.resources["Pattern"] = pdfDict{}
+	}
+	name := pdfName(fmt.Sprintf("P%d", len(w.resources["Pattern"].(pdfDict))))
+	w.resources["Pattern"].(pdfDict)[name] = ref
+	return name
+}
+
+// getTilingPattern returns the name of a tiling pattern transformed by m. The cell is written as a separate content stream.
+func (w *pdfPageWriter) getTilingPattern(pattern *canvas.Pattern, m canvas.Matrix) (pdfName, bool) {
+	if pattern.Cell == nil || pattern.W <= 0.0 || pattern.H <= 0.0 {
+		return "", false
+	}
+	key := pdfTilingKey{pattern, m}
+	if name, ok := w.tilingPatterns[key]; ok {
+		return name, true
+	}
+
+	cell := w.pdf.newPageWriter(pattern.W, pattern.H)
+	pattern.Cell.Render(&PDF{
+		w:      cell,
+		width:  pattern.W,
+		height: pattern.H,
+		imgEnc: canvas.Lossless,
+	})
+
+	mp := w.patternMatrix.Mul(m).Mul(pattern.View)
+	stream := pdfStream{
+		dict: pdfDict{
+			"Type":        pdfName("Pattern"),
+			"PatternType": 1,
+			"PaintType":   1,
+			"TilingType":  1,
+			"BBox":        pdfArray{0.0, 0.0, pattern.W, pattern.H},
+			"XStep":       pattern.W,
+			"YStep":       pattern.H,
+			"Resources":   cell.resources,
+			"Matrix":      pdfArray{mp[0][0], mp[1][0], mp[0][1], mp[1][1], mp[0][2], mp[1][2]},
+		},
+		stream: bytes.TrimPrefix(cell.Bytes(), []byte(" ")),
+	}
+	if w.pdf.compress {
+		stream.dict["Filter"] = pdfFilterFlate
+	}
+	name := w.addPattern(w.pdf.writeObject(stream))
+	w.tilingPatterns[key] = name
+	return name, true
+}
+
+// getSoftMaskGS returns the name of a graphics state with a soft mask given by the opacity of a gradient.
+func (w *pdfPageWriter) getSoftMaskGS(shadingType int, coords pdfArray, t0, t1 float64, stops []canvas.GradientStop, m canvas.Matrix) pdfName {
+	shading := pdfDict{
+		"ShadingType": shadingType,
+		"ColorSpace":  pdfName("DeviceGray"),
+		"Coords":      coords,
+		"Domain":      pdfArray{t0, t1},
+		"Function": pdfStitchingFunction(stops, func(col color.RGBA) pdfArray {
+			return pdfArray{float64(col.A) / 255.0}
+		}),
+		"Extend": pdfArray{true, true},
+	}
+
+	// the soft mask is defined in the coordinate system at the time the graphics state is set
+	group := w.pdf.writeObject(pdfStream{
+		dict: pdfDict{
+			"Type":    pdfName("XObject"),
+			"Subtype": pdfName("Form"),
+			"BBox":    pdfArray{0.0, 0.0, w.width, w.height},
+			"Group": pdfDict{
+				"Type": pdfName("Group"),
+				"S":    pdfName("Transparency"),
+				"CS":   pdfName("DeviceGray"),
+			},
+			"Resources": pdfDict{
+				"Shading": pdfDict{
+					"Sh0": shading,
+				},
+			},
+		},
+		stream: []byte(fmt.Sprintf("%v %v %v %v %v %v cm /Sh0 sh", dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))),
+	})
+
+	if _, ok := w.resources["ExtGState"]; !ok {
+		w.resources["ExtGState"] = pdfDict{}
+	}
+	name := pdfName(fmt.Sprintf("SM%d", len(w.resources["ExtGState"].(pdfDict))))
+	w.resources["ExtGState"].(pdfDict)[name] = pdfDict{
+		"Type": pdfName("ExtGState"),
+		"SMask": pdfDict{
+			"Type": pdfName("Mask"),
+			"S":    pdfName("Luminosity"),
+			"G":    group,
+		},
+	}
+	return name
+}
+
+// getMaskGS returns the name of a graphics state with a soft mask given by the luminance or alpha of a canvas transformed by m.
+func (w *pdfPageWriter) getMaskGS(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) pdfName {
+	content := w.pdf.newPageWriter(w.width, w.height)
+	content.patternMatrix = w.patternMatrix
+	mask.RenderView(&PDF{
+		w:      content,
+		width:  w.width,
+		height: w.height,
+		imgEnc: canvas.Lossless,
+	}, m)
+
+	// the soft mask is defined in the coordinate system at the time the graphics state is set
+	stream := pdfStream{
+		dict: pdfDict{
+			"Type":    pdfName("XObject"),
+			"Subtype": pdfName("Form"),
+			"BBox":    pdfArray{0.0, 0.0, w.width, w.height},
+			"Group": pdfDict{
+				"Type": pdfName("Group"),
+				"S":    pdfName("Transparency"),
+				"CS":   pdfName("DeviceRGB"),
+			},
+			"Resources": content.resources,
+		},
+		stream: bytes.TrimPrefix(content.Bytes(), []byte(" ")),
+	}
+	if w.pdf.compress {
+		stream.dict["Filter"] = pdfFilterFlate
+	}
+	group := w.pdf.writeObject(stream)
+
+	if _, ok := w.resources["ExtGState"]; !ok {
+		w.resources["ExtGState"] = pdfDict{}
+	}
+	name := pdfName(fmt.Sprintf("SM%d", len(w.resources["ExtGState"].(pdfDict))))
+	w.resources["ExtGState"].(pdfDict)[name] = pdfDict{
+		"Type": pdfName("ExtGState"),
+		"SMask": pdfDict{
+			"Type": pdfName("Mask"),
+			"S":    pdfName(maskType.String()),
+			"G":    group,
+		},
+	}
+	return name
+}
+
+// pdfStitchingFunction returns a function that linearly interpolates between the colors of the stops, the offsets of the stops must be increasing.
+func pdfStitchingFunction(stops []canvas.GradientStop, color func(color.RGBA) pdfArray) pdfDict {
+	if len(stops) == 1 {
+		stops = append(stops, stops[0])
+		stops[1].Offset += 1.0
+	}
+
+	functions := pdfArray{}
+	bounds := pdfArray{}
+	encode := pdfArray{}
+	for i := 1; i < len(stops); i++ {
+		functions = append(functions, pdfDict{
+			"FunctionType": 2,
+			"Domain":       pdfArray{0.0, 1.0},
+			"C0":           color(stops[i-1].Color),
+			"C1":           color(stops[i].Color),
+			"N":            1.0,
+		})
+		if i+1 < len(stops) {
+			bounds = append(bounds, stops[i].Offset)
+		}
+		encode = append(encode, 0.0, 1.0)
+	}
+	return pdfDict{
+		"FunctionType": 3,
+		"Domain":       pdfArray{stops[0].Offset, stops[len(stops)-1].Offset},
+		"Functions":    functions,
+		"Bounds":       bounds,
+		"Encode":       encode,
+	}
+}
+
 func (w *pdfPageWriter) SetLineWidth(lineWidth float64) {
 	if lineWidth != w.lineWidth {
 		fmt.Fprintf(w, " %v w", dec(lineWidth))
@@ -794,7 +1769,7 @@ func (w *pdfPageWriter) SetFont(font *canvas.Font, size float64) {
 		w.font = font
 		w.fontSize = size
 
-		ref := w.pdf.getFont(font)
+		ref := w.pdf.getFont(font).ref
 		if _, ok := w.resources["Font"]; !ok {
 			w.resources["Font"] = pdfDict{}
 		} else {
@@ -884,7 +1859,14 @@ func (w *pdfPageWriter) WriteText(TJ ...interface{}) {
 		}
 
 		buf := &bytes.Buffer{}
+		pf := w.pdf.getFont(w.font)
 		indices := w.font.IndicesOf(s)
+		for i, r := range []rune(s) {
+			indices[i] = pf.glyphID(indices[i])
+			if _, ok := pf.unicode[indices[i]]; !ok {
+				pf.unicode[indices[i]] = w.font.LigatureText(r)
+			}
+		}
 		binary.Write(buf, binary.BigEndian, indices)
 
 		s = buf.String()
@@ -940,6 +1922,58 @@ func (w *pdfPageWriter) DrawImage(img image.Image, enc canvas.ImageEncoding, m c
 }
 
 func (w *pdfPageWriter) embedImage(img image.Image, enc canvas.ImageEncoding) pdfName {
+	ref := w.pdf.getImage(img, enc)
+	if _, ok := w.resources["XObject"]; !ok {
+		w.resources["XObject"] = pdfDict{}
+	}
+	xobjects := w.resources["XObject"].(pdfDict)
+	for name, xobject := range xobjects {
+		if xobject == ref {
+			return name
+		}
+	}
+	name := pdfName(fmt.Sprintf("Im%d", len(xobjects)))
+	xobjects[name] = ref
+	return name
+}
+
+// imageKey identifies an image by its pointer or by its encoded data, images must thus not be modified after being drawn.
+type imageKey struct {
+	img  image.Image // image of pointer type
+	data *byte       // first byte of the encoded data
+	n    int         // length of the encoded data
+	enc  canvas.ImageEncoding
+}
+
+// getImage returns the reference to the image XObject, and writes the image unless the same image was written before. Images are looked up by identity first and by the hash of their data otherwise. The encoded data of a canvas.EncodedImage is embedded as is if it is JPEG or JPEG 2000, other images are compressed with Flate, or as JPEG when the encoding is Lossy.
+func (w *pdfWriter) getImage(img image.Image, enc canvas.ImageEncoding) pdfRef {
+	if encoded, ok := img.(*canvas.EncodedImage); ok && 0 < len(encoded.Data) {
+		key := imageKey{data: &encoded.Data[0], n: len(encoded.Data)}
+		if ref, ok := w.imageRefs[key]; ok {
+			return ref
+		} else if dict, ok := w.encodedImageDict(encoded); ok {
+			hash := imageHash(encoded.Mimetype, encoded.Bounds().Size(), encoded.Data)
+			ref, ok := w.images[hash]
+			if !ok {
+				ref = w.writeObject(pdfStream{
+					dict:   dict,
+					stream: encoded.Data,
+				})
+				w.images[hash] = ref
+			}
+			w.imageRefs[key] = ref
+			return ref
+		}
+	}
+
+	key := imageKey{enc: enc}
+	if reflect.TypeOf(img).Kind() == reflect.Ptr {
+		key.img = img
+		if ref, ok := w.imageRefs[key]; ok {
+			return ref
+		}
+	}
+
 	size := img.Bounds().Size()
 	sp := img.Bounds().Min // starting point
 	b := make([]byte, size.X*size.Y*3)
@@ -961,6 +1995,18 @@ func (w *pdfPageWriter) embedImage(img image.Image, enc canvas.ImageEncoding) pd
 		}
 	}
 
+	kind := "lossless"
+	if enc == canvas.Lossy {
+		kind = "lossy"
+	}
+	hash := imageHash(kind, size, b, bMask)
+	if ref, ok := w.images[hash]; ok {
+		if key.img != nil {
+			w.imageRefs[key] = ref
+		}
+		return ref
+	}
+
 	dict := pdfDict{
 		"Type":             pdfName("XObject"),
 		"Subtype":          pdfName("Image"),
@@ -968,38 +2014,109 @@ func (w *pdfPageWriter) embedImage(img image.Image, enc canvas.ImageEncoding) pd
 		"Height":           size.Y,
 		"ColorSpace":       pdfName("DeviceRGB"),
 		"BitsPerComponent": 8,
-		"Interpolate":      true,
+		"Interpolate":      !w.pdfa, // not allowed by PDF/A
 		"Filter":           pdfFilterFlate,
 	}
 
+	if enc == canvas.Lossy {
+		// TODO: (PDF) implement JPXFilter for lossy image compression
+		rgb := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+		for i := 0; i < size.X*size.Y; i++ {
+			copy(rgb.Pix[i*4:], b[i*3:i*3+3])
+			rgb.Pix[i*4+3] = 255
+		}
+
+		// images that are too large for JPEG are compressed with Flate instead
+		buf := &bytes.Buffer{}
+		if err := jpeg.Encode(buf, rgb, nil); err == nil {
+			dict["Filter"] = pdfFilterDCT
+			b = buf.Bytes()
+		}
+	}
+
 	if hasMask {
-		dict["SMask"] = w.pdf.writeObject(pdfStream{
-			dict: pdfDict{
-				"Type":             pdfName("XObject"),
-				"Subtype":          pdfName("Image"),
-				"Width":            size.X,
-				"Height":           size.Y,
-				"ColorSpace":       pdfName("DeviceGray"),
-				"BitsPerComponent": 8,
-				"Interpolate":      true,
-				"Filter":           pdfFilterFlate,
-			},
-			stream: bMask,
-		})
+		maskHash := imageHash("mask", size, bMask)
+		mask, ok := w.images[maskHash]
+		if !ok {
+			mask = w.writeObject(pdfStream{
+				dict: pdfDict{
+					"Type":             pdfName("XObject"),
+					"Subtype":          pdfName("Image"),
+					"Width":            size.X,
+					"Height":           size.Y,
+					"ColorSpace":       pdfName("DeviceGray"),
+					"BitsPerComponent": 8,
+					"Interpolate":      !w.pdfa,
+					"Filter":           pdfFilterFlate,
+				},
+				stream: bMask,
+			})
+			w.images[maskHash] = mask
+		}
+		dict["SMask"] = mask
 	}
 
-	// TODO: (PDF) implement JPXFilter for lossy image compression
-	ref := w.pdf.writeObject(pdfStream{
+	ref := w.writeObject(pdfStream{
 		dict:   dict,
 		stream: b,
 	})
+	w.images[hash] = ref
+	if key.img != nil {
+		w.imageRefs[key] = ref
+	}
+	return ref
+}
 
-	if _, ok := w.resources["XObject"]; !ok {
-		w.resources["XObject"] = pdfDict{}
+// encodedImageDict returns the image dictionary for embedding the encoded data as is, or false if the encoding is not supported.
+func (w *pdfWriter) encodedImageDict(img *canvas.EncodedImage) (pdfDict, bool) {
+	dict := pdfDict{
+		"Type":        pdfName("XObject"),
+		"Subtype":     pdfName("Image"),
+		"Interpolate": !w.pdfa, // not allowed by PDF/A
 	}
-	name := pdfName(fmt.Sprintf("Im%d", len(w.resources["XObject"].(pdfDict))))
-	w.resources["XObject"].(pdfDict)[name] = ref
-	return name
+	switch img.Mimetype {
+	case "image/jpeg":
+		config, err := jpeg.DecodeConfig(bytes.NewReader(img.Data))
+		if err != nil {
+			return nil, false
+		}
+		switch config.ColorModel {
+		case color.GrayModel:
+			dict["ColorSpace"] = pdfName("DeviceGray")
+		case color.YCbCrModel:
+			dict["ColorSpace"] = pdfName("DeviceRGB")
+		case color.RGBAModel:
+			dict["ColorSpace"] = pdfName("DeviceRGB")
+			dict["DecodeParms"] = pdfDict{"ColorTransform": 0} // Adobe JPEG without color transformation
+		default:
+			return nil, false // CMYK is often stored inverted, which cannot be told reliably
+		}
+		dict["Width"] = config.Width
+		dict["Height"] = config.Height
+		dict["BitsPerComponent"] = 8
+		dict["Filter"] = pdfFilterDCT
+	case "image/jp2", "image/jpx":
+		// color space and bit depth are taken from the JPEG 2000 data
+		size := img.Bounds().Size()
+		dict["Width"] = size.X
+		dict["Height"] = size.Y
+		dict["Filter"] = pdfFilterJPX
+	default:
+		return nil, false
+	}
+	return dict, true
+}
+
+// imageHash returns the hash of the image data, which identifies images that have been written before.
+func imageHash(kind string, size image.Point, data ...[]byte) [16]byte {
+	h := md5.New()
+	fmt.Fprintf(h, "%s %d %d\n", kind, size.X, size.Y)
+	for _, b := range data {
+		h.Write(b)
+	}
+	var hash [16]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
 }
 
 func (w *pdfPageWriter) getOpacityGS(a float64) pdfName {
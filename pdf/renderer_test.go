@@ -2,7 +2,13 @@ package pdf
 
 import (
 	"bytes"
+	"compress/zlib"
+	"fmt"
 	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -93,6 +99,115 @@ func TestPDFImage(t *testing.T) {
 	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm q 0 0 2 2 re W n 0 0 m 0 2 l 2 2 l 2 0 l h W n 2 0 0 2 0 0 cm /Im0 Do Q")
 }
 
+func TestPDFImageDeduplication(t *testing.T) {
+	logo := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	logo.Set(0, 0, canvas.Red)
+
+	buf := &bytes.Buffer{}
+	pdf := New(buf, 210.0, 297.0)
+	for i := 0; i < 3; i++ {
+		if i != 0 {
+			pdf.NewPage(210.0, 297.0)
+		}
+		pdf.RenderImage(logo, canvas.Identity)
+		pdf.RenderImage(logo, canvas.Identity.Translate(10.0, 0.0))
+
+		// an equal image that is decoded again
+		copied := image.NewNRGBA(logo.Rect)
+		copy(copied.Pix, logo.Pix)
+		pdf.RenderImage(copied, canvas.Identity.Translate(20.0, 0.0))
+	}
+	test.Error(t, pdf.Close())
+
+	s := buf.String()
+	test.T(t, strings.Count(s, "/Subtype /Image"), 2) // image and its soft mask
+	test.T(t, strings.Count(s, "/Im0 Do"), 9)
+	test.T(t, strings.Count(s, "/XObject << /Im0 "), 3)
+	testXref(t, buf.Bytes())
+
+	// images are looked up by identity before hashing their pixels
+	w := newPDFWriter(&bytes.Buffer{})
+	ref := w.getImage(logo, canvas.Lossless)
+	test.T(t, w.imageRefs[imageKey{img: logo, enc: canvas.Lossless}], ref)
+	logo.Set(1, 1, canvas.Blue) // not noticed for the same image
+	test.T(t, w.getImage(logo, canvas.Lossless), ref)
+	test.That(t, w.getImage(logo, canvas.Lossy) != ref, "must write lossy image")
+
+	copied := image.NewNRGBA(logo.Rect)
+	copy(copied.Pix, logo.Pix)
+	test.That(t, w.getImage(copied, canvas.Lossless) != ref, "must write changed image")
+
+	// encoded images are identified by their data
+	data := []byte("\x00\x00\x00\x0CjP  \r\n\x87\n")
+	encoded := &canvas.EncodedImage{Image: logo, Mimetype: "image/jp2", Data: data}
+	ref = w.getImage(encoded, canvas.Lossless)
+	test.T(t, w.getImage(&canvas.EncodedImage{Image: logo, Mimetype: "image/jp2", Data: data}, canvas.Lossless), ref)
+	test.T(t, w.getImage(&canvas.EncodedImage{Image: logo, Mimetype: "image/jp2", Data: append([]byte{}, data...)}, canvas.Lossless), ref)
+	test.T(t, len(w.imageRefs), 5)
+}
+
+func TestPDFImageJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	b := &bytes.Buffer{}
+	test.Error(t, jpeg.Encode(b, img, nil))
+	encoded, err := canvas.NewJPEGImage(bytes.NewReader(b.Bytes()))
+	test.Error(t, err)
+	test.T(t, encoded.Bounds(), img.Bounds())
+
+	buf := &bytes.Buffer{}
+	pdf := New(buf, 210.0, 297.0)
+	pdf.RenderImage(encoded, canvas.Identity)
+	test.Error(t, pdf.Close())
+
+	s := buf.String()
+	test.That(t, strings.Contains(s, "/Subtype /Image /BitsPerComponent 8 /ColorSpace /DeviceRGB /Filter /DCTDecode /Height 8 /Interpolate true /Length "), "must embed JPEG")
+	test.That(t, bytes.Contains(buf.Bytes(), b.Bytes()), "must embed JPEG data as is")
+	test.That(t, !strings.Contains(s, "/SMask"), "must not have a soft mask")
+	testXref(t, buf.Bytes())
+
+	// unsupported data is decoded and encoded again
+	buf.Reset()
+	pdf = New(buf, 210.0, 297.0)
+	pdf.RenderImage(&canvas.EncodedImage{Image: img, Mimetype: "image/jpeg", Data: []byte("invalid")}, canvas.Identity)
+	test.Error(t, pdf.Close())
+	test.That(t, strings.Contains(buf.String(), "/Filter /FlateDecode /Height 8 "), "must fall back to Flate")
+}
+
+func TestPDFImageLossy(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, canvas.Red)
+	img.Set(1, 1, canvas.Transparent)
+
+	buf := &bytes.Buffer{}
+	pdf := New(buf, 210.0, 297.0)
+	pdf.SetImageEncoding(canvas.Lossy)
+	pdf.RenderImage(img, canvas.Identity)
+	pdf.SetImageEncoding(canvas.Lossless)
+	pdf.RenderImage(img, canvas.Identity)
+	test.Error(t, pdf.Close())
+
+	s := buf.String()
+	test.T(t, strings.Count(s, "/Filter /DCTDecode"), 1)
+	test.T(t, strings.Count(s, "/ColorSpace /DeviceGray /Filter /FlateDecode"), 1) // soft mask is shared and lossless
+	test.T(t, strings.Count(s, "/Subtype /Image"), 3)
+	testXref(t, buf.Bytes())
+}
+
+func TestPDFImageJPX(t *testing.T) {
+	data := []byte("\x00\x00\x00\x0CjP  \r\n\x87\n") // JPEG 2000 signature box
+	img := &canvas.EncodedImage{Image: image.NewGray(image.Rect(0, 0, 3, 2)), Mimetype: "image/jp2", Data: data}
+
+	buf := &bytes.Buffer{}
+	pdf := New(buf, 210.0, 297.0)
+	pdf.RenderImage(img, canvas.Identity)
+	test.Error(t, pdf.Close())
+	test.That(t, strings.Contains(buf.String(), "/Subtype /Image /Filter /JPXDecode /Height 2 /Interpolate true /Length 12 /Width 3 >> stream\n"+string(data)+"\nendstream"), "must embed JPEG 2000 data as is")
+	testXref(t, buf.Bytes())
+}
+
 func TestPDFMultipage(t *testing.T) {
 	buf := &bytes.Buffer{}
 	pdf := New(buf, 210, 297)
@@ -106,3 +221,283 @@ func TestPDFMultipage(t *testing.T) {
 	nbPages := strings.Count(out, "/Type /Page ")
 	test.That(t, nbPages == 2, "expected 2 pages, got", nbPages)
 }
+
+func TestPDFGradient(t *testing.T) {
+	gradient := canvas.NewLinearGradient(0.0, 0.0, 10.0, 0.0)
+	gradient.Add(0.0, canvas.Red)
+	gradient.Add(1.0, canvas.Blue)
+
+	buf := &bytes.Buffer{}
+	w := newPDFWriter(buf)
+	pdf := w.NewPage(210.0, 297.0)
+	q := pdf.SetFillPaint(canvas.Black, gradient, canvas.Identity, canvas.Rect{X: 0.0, Y: 0.0, W: 10.0, H: 10.0})
+	pdf.EndPaint(q)
+	test.That(t, q)
+	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm q /A0 gs /Pattern cs /P0 scn Q")
+	test.Error(t, w.Close())
+
+	test.That(t, strings.Contains(buf.String(), "/PatternType 2"))
+	test.That(t, strings.Contains(buf.String(), "/ShadingType 2"))
+	test.That(t, strings.Contains(buf.String(), "/Coords [0 0 10 0]"))
+}
+
+func TestPDFClip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pdf := newPDFWriter(buf).NewPage(210.0, 297.0)
+	pdf.SetFillColor(canvas.Red)
+	pdf.SaveState()
+	pdf.SetClip(canvas.Rectangle(10.0, 10.0), canvas.EvenOdd)
+	pdf.SetFillColor(canvas.Blue)
+	pdf.RestoreState()
+	pdf.RestoreState() // does nothing
+	pdf.SetFillColor(canvas.Red)
+	test.T(t, pdf.fillColor, canvas.Red)
+	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm 1 0 0 rg q 0 0 m 10 0 l 10 10 l 0 10 l h W* n 0 0 1 rg Q")
+}
+
+func TestPDFTilingPattern(t *testing.T) {
+	pattern := canvas.NewHatchPattern(canvas.Red, 0.0, 4.0, 1.0)
+
+	buf := &bytes.Buffer{}
+	pdf := newPDFWriter(buf).NewPage(210.0, 297.0)
+	q := pdf.SetFillPaint(canvas.Black, pattern, canvas.Identity, canvas.Rect{X: 0.0, Y: 0.0, W: 10.0, H: 10.0})
+	pdf.EndPaint(q)
+	q = pdf.SetFillPaint(canvas.Black, pattern, canvas.Identity, canvas.Rect{X: 0.0, Y: 0.0, W: 10.0, H: 10.0})
+	pdf.EndPaint(q)
+	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm q /A0 gs /Pattern cs /P0 scn Q q /A0 gs /Pattern cs /P0 scn Q")
+	test.That(t, strings.Contains(buf.String(), "/PatternType 1"))
+	test.That(t, strings.Contains(buf.String(), "/BBox [0 0 4 4]"))
+	test.That(t, strings.Contains(buf.String(), "/XStep 4"))
+	test.That(t, strings.Contains(buf.String(), "1 0 0 rg 0 1.5 m 4 1.5 l 4 2.5 l 0 2.5 l f"))
+}
+
+func TestPDFGroup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pdf := newPDFWriter(buf).NewPage(210.0, 297.0)
+	pdf.SetFillColor(canvas.Red)
+	pdf.StartGroup(0.5, canvas.MultiplyBlend)
+	pdf.SetFillColor(canvas.Blue)
+	pdf.EndGroup()
+	pdf.EndGroup() // does nothing
+	test.T(t, pdf.fillColor, canvas.Red)
+	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm 1 0 0 rg q /BM0 gs /Fm0 Do Q")
+	test.That(t, strings.Contains(buf.String(), "/S /Transparency"))
+	test.That(t, strings.Contains(buf.String(), "0 0 1 rg"))
+}
+
+func TestPDFMask(t *testing.T) {
+	mask := canvas.New(10.0, 10.0)
+	mask.RenderPath(canvas.Rectangle(5.0, 5.0), canvas.DefaultStyle, canvas.Identity)
+
+	buf := &bytes.Buffer{}
+	pdf := newPDFWriter(buf).NewPage(210.0, 297.0)
+	pdf.StartMask(mask, canvas.AlphaMask, canvas.Identity.Translate(1.0, 0.0))
+	pdf.SetFillColor(canvas.Blue)
+	pdf.EndGroup()
+	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm q /SM0 gs /Fm0 Do Q")
+	test.That(t, strings.Contains(buf.String(), "/S /Alpha"))
+	test.That(t, strings.Contains(buf.String(), "1 0 m 6 0 l 6 5 l 1 5 l f"))
+}
+
+func TestPDFFontSubset(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	font := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal).Font
+
+	buf := &bytes.Buffer{}
+	w := newPDFWriter(buf)
+	pdf := w.NewPage(210.0, 297.0)
+	pdf.StartTextObject()
+	pdf.SetFont(font, 12.0)
+	pdf.WriteText("ba")
+	pdf.WriteText("ab")
+	pdf.EndTextObject()
+	test.String(t, pdf.String(), " 2.8346457 0 0 2.8346457 0 0 cm BT /F0 12 Tf[(\x00\x01\x00\x02)]TJ[(\x00\x02\x00\x01)]TJ ET")
+	test.Error(t, w.Close())
+
+	pf := w.fonts[font]
+	test.T(t, len(pf.glyphs), 3)
+	test.That(t, strings.Contains(buf.String(), "+dejavu_serif"), "must have subset tag")
+	test.That(t, buf.Len() < 50000, "must embed subset")
+}
+
+func TestPDFToUnicode(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	font := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal).Font
+	font.Use(canvas.CommonLigatures)
+
+	buf := &bytes.Buffer{}
+	w := newPDFWriter(buf)
+	pdf := w.NewPage(210.0, 297.0)
+	pdf.StartTextObject()
+	pdf.SetFont(font, 12.0)
+	pdf.WriteText("ﬁn\U0001D400")
+	pdf.EndTextObject()
+	test.Error(t, w.Close())
+
+	unicode := w.fonts[font].unicode
+	test.T(t, unicode[1], "fi")
+	test.T(t, unicode[2], "n")
+	test.That(t, strings.Contains(buf.String(), "/ToUnicode"), "must have ToUnicode CMap")
+
+	cmap := string(toUnicodeCMap(map[uint16]string{1: "fi", 2: "n", 3: "\U0001D400"}))
+	test.That(t, strings.Contains(cmap, "3 beginbfchar\n<0001> <00660069>\n<0002> <006E>\n<0003> <D835DC00>\nendbfchar\n"), cmap)
+}
+
+func TestPDFLinks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pdf := New(buf, 210.0, 297.0)
+	pdf.RenderLink("https://example.com/?a=(b)", canvas.Rect{X: 10.0, Y: 10.0, W: 20.0, H: 5.0}, canvas.Identity)
+	pdf.RenderLink("#chapter", canvas.Rect{X: 0.0, Y: 0.0, W: 10.0, H: 10.0}, canvas.Identity.Translate(10.0, 0.0))
+	pdf.AddOutline("Introduction", 0, "intro")
+	pdf.AddOutline("Chapter ü", 1, "chapter")
+	pdf.AddOutline("Conclusion", 0, "")
+	pdf.NewPage(210.0, 297.0)
+	pdf.RenderDestination("chapter", canvas.Point{X: 0.0, Y: 100.0}, canvas.Identity)
+	test.Error(t, pdf.Close())
+
+	objs := testXref(t, buf.Bytes())
+	catalog := objs[testRef(t, objs[len(objs)], "Root")] // the cross-reference stream is the last object
+	kids := regexp.MustCompile(`/Kids \[(\d+) 0 R (\d+) 0 R\]`).FindStringSubmatch(objs[testRef(t, catalog, "Pages")])
+	test.That(t, kids != nil, "must have two pages")
+
+	annots := regexp.MustCompile(`/Annots \[(\d+) 0 R (\d+) 0 R\]`).FindStringSubmatch(objs[testNum(kids[1])])
+	test.That(t, annots != nil, "first page must have two annotations")
+	test.String(t, objs[testNum(annots[1])], "<< /Type /Annot /Subtype /Link /A << /S /URI /URI (https://example.com/?a=\\(b\\)) >> /Border [0 0 0] /F 4 /Rect [28.346457 28.346457 85.03937 42.519685] >>")
+	test.String(t, objs[testNum(annots[2])], "<< /Type /Annot /Subtype /Link /Border [0 0 0] /Dest (chapter) /F 4 /Rect [28.346457 0 56.692913 28.346457] >>")
+	test.That(t, strings.Contains(catalog, fmt.Sprintf("/Names << /Dests << /Names [(chapter) [%s 0 R /XYZ 0 283.46457 null]] >> >>", kids[2])), "destination must be on the second page")
+
+	test.That(t, strings.Contains(catalog, "/PageMode /UseOutlines"), "must show outlines")
+	outlinesNum := testRef(t, catalog, "Outlines")
+	outlines := objs[outlinesNum]
+	first, last := testRef(t, outlines, "First"), testRef(t, outlines, "Last")
+	test.String(t, outlines, fmt.Sprintf("<< /Type /Outlines /Count 3 /First %d 0 R /Last %d 0 R >>", first, last))
+	child := testRef(t, objs[first], "First")
+	test.String(t, objs[first], fmt.Sprintf("<< /Count 1 /Dest (intro) /First %d 0 R /Last %d 0 R /Next %d 0 R /Parent %d 0 R /Title (Introduction) >>", child, child, last, outlinesNum))
+	test.String(t, objs[child], fmt.Sprintf("<< /Dest (chapter) /Parent %d 0 R /Title (\xFE\xFF\x00C\x00h\x00a\x00p\x00t\x00e\x00r\x00 \x00\xFC) >>", first))
+	test.String(t, objs[last], fmt.Sprintf("<< /Parent %d 0 R /Prev %d 0 R /Title (Conclusion) >>", outlinesNum, first))
+}
+
+func TestPDFDocument(t *testing.T) {
+	d := canvas.NewDocument()
+	d.NewPage(210.0, 297.0)
+	d.AddPage(canvas.New(297.0, 210.0), "Appendix")
+	d.Title = "Report"
+
+	buf := &bytes.Buffer{}
+	test.Error(t, DocumentWriter(buf, d))
+	s := buf.String()
+	test.That(t, strings.Contains(s, "/Type /Pages /Count 2"), "two pages")
+	test.That(t, strings.Contains(s, "/MediaBox [0 0 841.88976 595.27559]"), "landscape page")
+	test.That(t, strings.Contains(s, "/Title (Appendix)"), "outline of titled page")
+	test.That(t, strings.Contains(s, "/Title (Report)"), "document title")
+
+	test.T(t, DocumentWriter(buf, canvas.NewDocument()), canvas.ErrNoPages)
+}
+
+func TestPDFStreaming(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pdf := New(buf, 210.0, 297.0)
+	pdf.SetCompression(true)
+	for i := 0; i < 150; i++ {
+		if i != 0 {
+			pdf.NewPage(210.0, 297.0)
+		}
+		pdf.RenderPath(canvas.Rectangle(float64(i), 10.0), canvas.DefaultStyle, canvas.Identity)
+		pdf.RenderLink("https://example.com", canvas.Rect{X: 0.0, Y: 0.0, W: 10.0, H: 10.0}, canvas.Identity)
+
+		// the previous pages have been written
+		test.T(t, bytes.Count(buf.Bytes(), []byte(" 0 obj\n<< /Filter /FlateDecode /Length ")), i)
+	}
+	test.Error(t, pdf.Close())
+	test.That(t, 3 <= bytes.Count(buf.Bytes(), []byte("/Type /ObjStm")), "must use object streams")
+	testXref(t, buf.Bytes())
+}
+
+// testXref verifies that all entries of the cross-reference stream point to their object, either in the file or in an object stream, and returns the objects by object number.
+func testXref(t *testing.T, b []byte) map[int]string {
+	t.Helper()
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF$`).FindSubmatch(b)
+	test.That(t, startxref != nil, "must end with startxref")
+	offset, _ := strconv.Atoi(string(startxref[1]))
+	dict, data := testReadStream(t, b, offset)
+	test.That(t, strings.Contains(dict, "/Type /XRef"), "must have cross-reference stream")
+
+	W := regexp.MustCompile(`/W \[1 (\d) 2\]`).FindStringSubmatch(dict)
+	test.That(t, W != nil, "must have widths")
+	n, _ := strconv.Atoi(W[1])
+	size, _ := strconv.Atoi(regexp.MustCompile(`/Size (\d+)`).FindStringSubmatch(dict)[1])
+	test.T(t, len(data), size*(n+3))
+
+	field := func(b []byte) int {
+		v := 0
+		for _, c := range b {
+			v = v<<8 | int(c)
+		}
+		return v
+	}
+	entries := make([][3]int, size)
+	for i := range entries {
+		entry := data[i*(n+3):]
+		entries[i] = [3]int{int(entry[0]), field(entry[1 : 1+n]), field(entry[1+n : 3+n])}
+	}
+	test.T(t, entries[0], [3]int{0, 0, 0xFFFF})
+	objs := map[int]string{}
+	for i, entry := range entries[1:] {
+		num := i + 1
+		if entry[0] == 1 {
+			obj := b[entry[1]:]
+			prefix := []byte(fmt.Sprintf("%d 0 obj\n", num))
+			test.That(t, bytes.HasPrefix(obj, prefix), "object", num, "must be at its offset")
+			obj = obj[len(prefix):]
+			objs[num] = string(obj[:bytes.Index(obj, []byte("\nendobj"))])
+		} else {
+			test.T(t, entry[0], 2, "object", num)
+			stm := entries[entry[1]]
+			test.T(t, stm[0], 1, "object stream of object", num)
+			dict, data := testReadStream(t, b, stm[1])
+			test.That(t, strings.Contains(dict, "/Type /ObjStm"), "object", num, "must be in an object stream")
+			header := strings.Fields(string(data[:bytes.IndexByte(data, '\n')]))
+			test.T(t, header[2*entry[2]], strconv.Itoa(num), "object", num, "must be at its index in the object stream")
+
+			first, _ := strconv.Atoi(regexp.MustCompile(`/First (\d+)`).FindStringSubmatch(dict)[1])
+			start, _ := strconv.Atoi(header[2*entry[2]+1])
+			end := len(data) - first
+			if 2*entry[2]+3 < len(header) {
+				end, _ = strconv.Atoi(header[2*entry[2]+3])
+			}
+			objs[num] = strings.TrimSpace(string(data[first+start : first+end]))
+		}
+	}
+	return objs
+}
+
+// testRef returns the object number of the indirect reference with the given key in a dictionary.
+func testRef(t *testing.T, dict, key string) int {
+	t.Helper()
+	ref := regexp.MustCompile(`/` + key + ` (\d+) 0 R`).FindStringSubmatch(dict)
+	test.That(t, ref != nil, "must have reference", key)
+	return testNum(ref[1])
+}
+
+func testNum(s string) int {
+	num, _ := strconv.Atoi(s)
+	return num
+}
+
+// testReadStream returns the dictionary and the decoded data of the stream object at offset.
+func testReadStream(t *testing.T, b []byte, offset int) (string, []byte) {
+	t.Helper()
+	i := offset + bytes.Index(b[offset:], []byte(" stream\n"))
+	dict := string(b[offset:i])
+	length, _ := strconv.Atoi(regexp.MustCompile(`/Length (\d+)`).FindStringSubmatch(dict)[1])
+	data := b[i+8 : i+8+length]
+	if strings.Contains(dict, "/Filter /FlateDecode") {
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		test.Error(t, err)
+		data, err = ioutil.ReadAll(r)
+		test.Error(t, err)
+	}
+	return dict, data
+}
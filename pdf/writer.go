@@ -1,6 +1,7 @@
 package pdf
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/tdewolff/canvas"
@@ -12,3 +13,41 @@ func Writer(w io.Writer, c *canvas.Canvas) error {
 	c.Render(pdf)
 	return pdf.Close()
 }
+
+// WriterWithOptions returns a writer that writes the canvas as a PDF file using the options, such as for PDF/A.
+func WriterWithOptions(opts *Options) canvas.Writer {
+	return func(w io.Writer, c *canvas.Canvas) error {
+		pdf := NewWithOptions(w, c.W, c.H, opts)
+		c.Render(pdf)
+		return pdf.Close()
+	}
+}
+
+// DocumentWriter writes the document as a PDF file with a page for each page of the document.
+func DocumentWriter(w io.Writer, d *canvas.Document) error {
+	return DocumentWriterWithOptions(nil)(w, d)
+}
+
+// DocumentWriterWithOptions returns a writer that writes the document as a PDF file using the options. Titled pages are added to the document outline.
+func DocumentWriterWithOptions(opts *Options) canvas.DocumentWriter {
+	return func(w io.Writer, d *canvas.Document) error {
+		if len(d.Pages) == 0 {
+			return canvas.ErrNoPages
+		}
+
+		pdf := NewWithOptions(w, d.Pages[0].W, d.Pages[0].H, opts)
+		pdf.SetInfo(d.Title, d.Subject, d.Keywords, d.Author)
+		for i, page := range d.Pages {
+			if i != 0 {
+				pdf.NewPage(page.W, page.H)
+			}
+			page.Render(pdf)
+			if page.Title != "" {
+				dest := fmt.Sprintf("page%d", i+1)
+				pdf.RenderDestination(dest, canvas.Point{X: 0.0, Y: page.H}, canvas.Identity)
+				pdf.AddOutline(page.Title, 0, dest)
+			}
+		}
+		return pdf.Close()
+	}
+}
@@ -0,0 +1,631 @@
+package ps
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/minify/v2"
+)
+
+var psEllipseDef = `/ellipse {
+/rot exch def
+/endangle exch def
+/startangle exch def
+/yrad exch def
+/xrad exch def
+/y exch def
+/x exch def
+/savematrix matrix currentmatrix def
+x y translate
+rot rotate
+xrad yrad scale
+0 0 1 startangle endangle arc
+savematrix setmatrix
+} def /ellipsen {
+/rot exch def
+/endangle exch def
+/startangle exch def
+/yrad exch def
+/xrad exch def
+/y exch def
+/x exch def
+/savematrix matrix currentmatrix def
+x y translate
+rot rotate
+xrad yrad scale
+0 0 1 startangle endangle arcn
+savematrix setmatrix
+} def`
+
+// psReencodeDef defines a copy of a font with another encoding: /newname /basename [encoding] reencode
+var psReencodeDef = `/reencode {
+exch findfont dup length dict begin
+{1 index /FID ne {def} {pop pop} ifelse} forall
+/Encoding exch def
+currentdict end definefont pop
+} def`
+
+const mmToPt = 72.0 / 25.4
+
+// ErrEncapsulatedPages is returned by Close when more than one page was started for encapsulated PostScript.
+var ErrEncapsulatedPages = errors.New("encapsulated PostScript has only one page")
+
+// Options are the options for the PostScript renderer.
+type Options struct {
+	Encapsulated bool // write an Encapsulated PostScript file of a single page without page setup, which is written as it is rendered, see also the eps package
+}
+
+// DefaultOptions are the default options for the PostScript renderer.
+var DefaultOptions = Options{}
+
+// psState is the part of the graphics state that is set explicitly, and which is restored by grestore.
+type psState struct {
+	color      color.RGBA
+	lineWidth  float64
+	lineCap    int
+	lineJoin   int
+	miterLimit float64
+	dashes     []float64
+}
+
+// initialState is the graphics state at the start of each page.
+var initialState = psState{
+	color:      canvas.Black,
+	lineWidth:  1.0,
+	miterLimit: 10.0,
+	dashes:     []float64{0.0},
+}
+
+type psPage struct {
+	width, height float64
+	buf           *bytes.Buffer
+}
+
+// PS is a PostScript renderer that writes multiple pages as a single print job. The pages are buffered and written to the output on Close, after the embedded fonts. Encapsulated PostScript is written as it is rendered instead, where fonts are embedded completely before their first use.
+type PS struct {
+	w             io.Writer
+	err           error
+	opts          Options
+	pages         []psPage
+	body          *lineWriter
+	width, height float64
+
+	state      psState
+	stateStack []psState
+	fonts      map[*canvas.Font]*psFont
+	fontList   []*psFont
+}
+
+// New creates a PostScript renderer with a first page of the given size in millimeters. If opts is nil, DefaultOptions is used. Call Close to write the file.
+func New(w io.Writer, width, height float64, opts *Options) *PS {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+
+	r := &PS{
+		w:     w,
+		opts:  *opts,
+		fonts: map[*canvas.Font]*psFont{},
+	}
+	r.NewPage(width, height)
+	return r
+}
+
+// NewPage starts a new page where further rendering will be written to. Encapsulated PostScript has only one page, further pages are discarded and Close returns ErrEncapsulatedPages.
+func (r *PS) NewPage(width, height float64) {
+	if r.opts.Encapsulated && len(r.pages) == 1 {
+		if r.err == nil {
+			r.err = r.body.err
+		}
+		if r.err == nil {
+			r.err = ErrEncapsulatedPages
+		}
+		r.body = &lineWriter{w: ioutil.Discard}
+		return
+	}
+
+	page := psPage{
+		width:  width,
+		height: height,
+	}
+	if r.opts.Encapsulated {
+		r.pages = append(r.pages, page)
+		header := &bytes.Buffer{}
+		r.err = r.writeHeader(header)
+		fmt.Fprintf(header, "%v %v scale", dec(mmToPt), dec(mmToPt))
+		r.body = &lineWriter{w: r.w}
+		r.body.Write(header.Bytes())
+	} else {
+		page.buf = &bytes.Buffer{}
+		r.pages = append(r.pages, page)
+		r.body = &lineWriter{w: page.buf}
+	}
+	r.width, r.height = width, height
+	r.state = initialState
+	r.stateStack = r.stateStack[:0]
+}
+
+// Close writes the document structuring comments, the embedded fonts and the pages to the output. For encapsulated PostScript, which has been written already, it writes the end of the file.
+func (r *PS) Close() error {
+	if r.opts.Encapsulated {
+		if _, err := fmt.Fprintf(r.w, "\n%%%%EOF\n"); err != nil && r.err == nil {
+			r.err = err
+		}
+		if r.err == nil {
+			r.err = r.body.err
+		}
+		return r.err
+	}
+
+	ps := &bytes.Buffer{}
+	if err := r.writeHeader(ps); err != nil {
+		return err
+	}
+	for i, page := range r.pages {
+		fmt.Fprintf(ps, "%%%%Page: %d %d\n", i+1, i+1)
+		fmt.Fprintf(ps, "%%%%PageMedia: %s\n", mediaName(page))
+		fmt.Fprintf(ps, "%%%%PageBoundingBox: 0 0 %d %d\n", int(math.Ceil(page.width*mmToPt)), int(math.Ceil(page.height*mmToPt)))
+		fmt.Fprintf(ps, "%%%%BeginPageSetup\n<< /PageSize [%v %v] >> setpagedevice\n%%%%EndPageSetup\n", dec(page.width*mmToPt), dec(page.height*mmToPt))
+		fmt.Fprintf(ps, "save %v %v scale", dec(mmToPt), dec(mmToPt))
+		ps.Write(page.buf.Bytes())
+		fmt.Fprintf(ps, "\nrestore showpage\n")
+	}
+	fmt.Fprintf(ps, "%%%%Trailer\n%%%%EOF\n")
+
+	_, err := r.w.Write(ps.Bytes())
+	return err
+}
+
+// writeHeader writes the document structuring comments and the prolog with the embedded fonts.
+func (r *PS) writeHeader(ps *bytes.Buffer) error {
+	// bounding box that contains all pages
+	var width, height float64
+	for _, page := range r.pages {
+		width = math.Max(width, page.width*mmToPt)
+		height = math.Max(height, page.height*mmToPt)
+	}
+
+	if r.opts.Encapsulated {
+		fmt.Fprintf(ps, "%%!PS-Adobe-3.0 EPSF-3.0\n")
+	} else {
+		fmt.Fprintf(ps, "%%!PS-Adobe-3.0\n")
+	}
+	fmt.Fprintf(ps, "%%%%Creator: tdewolff/canvas\n")
+	fmt.Fprintf(ps, "%%%%BoundingBox: 0 0 %d %d\n", int(math.Ceil(width)), int(math.Ceil(height)))
+	fmt.Fprintf(ps, "%%%%HiResBoundingBox: 0 0 %v %v\n", dec(width), dec(height))
+	fmt.Fprintf(ps, "%%%%LanguageLevel: 3\n")
+	fmt.Fprintf(ps, "%%%%DocumentData: Clean7Bit\n")
+	if !r.opts.Encapsulated {
+		fmt.Fprintf(ps, "%%%%Pages: %d\n", len(r.pages))
+		media := map[string]bool{}
+		for _, page := range r.pages {
+			name := mediaName(page)
+			if !media[name] {
+				if len(media) == 0 {
+					fmt.Fprintf(ps, "%%%%DocumentMedia:")
+				} else {
+					fmt.Fprintf(ps, "%%%%+")
+				}
+				fmt.Fprintf(ps, " %s %v %v 0 () ()\n", name, dec(page.width*mmToPt), dec(page.height*mmToPt))
+				media[name] = true
+			}
+		}
+	}
+	fmt.Fprintf(ps, "%%%%EndComments\n")
+
+	fmt.Fprintf(ps, "%%%%BeginProlog\n%s\n%s\n", psEllipseDef, psReencodeDef)
+	for _, font := range r.fontList {
+		if err := font.write(ps); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(ps, "%%%%EndProlog\n")
+	return nil
+}
+
+// mediaName returns the name of the page size in the document structuring comments, which is the size in points.
+func mediaName(page psPage) string {
+	return fmt.Sprintf("%dx%d", int(page.width*mmToPt+0.5), int(page.height*mmToPt+0.5))
+}
+
+func (r *PS) setColor(color color.RGBA) {
+	if color != r.state.color {
+		fmt.Fprintf(r.body, " %v %v %v setrgbcolor", dec(float64(color.R)/255.0), dec(float64(color.G)/255.0), dec(float64(color.B)/255.0))
+		r.state.color = color
+	}
+}
+
+func (r *PS) setLineWidth(lineWidth float64) {
+	if lineWidth != r.state.lineWidth {
+		fmt.Fprintf(r.body, " %v setlinewidth", dec(lineWidth))
+		r.state.lineWidth = lineWidth
+	}
+}
+
+func (r *PS) setLineCap(capper canvas.Capper) {
+	var lineCap int
+	if _, ok := capper.(canvas.RoundCapper); ok {
+		lineCap = 1
+	} else if _, ok := capper.(canvas.SquareCapper); ok {
+		lineCap = 2
+	}
+	if lineCap != r.state.lineCap {
+		fmt.Fprintf(r.body, " %d setlinecap", lineCap)
+		r.state.lineCap = lineCap
+	}
+}
+
+func (r *PS) setLineJoin(joiner canvas.Joiner) {
+	var lineJoin int
+	miterLimit := r.state.miterLimit
+	if _, ok := joiner.(canvas.BevelJoiner); ok {
+		lineJoin = 2
+	} else if _, ok := joiner.(canvas.RoundJoiner); ok {
+		lineJoin = 1
+	} else if miter, ok := joiner.(canvas.MiterJoiner); ok {
+		miterLimit = miter.Limit
+	}
+	if lineJoin != r.state.lineJoin {
+		fmt.Fprintf(r.body, " %d setlinejoin", lineJoin)
+		r.state.lineJoin = lineJoin
+	}
+	if lineJoin == 0 && miterLimit != r.state.miterLimit {
+		fmt.Fprintf(r.body, " %v setmiterlimit", dec(miterLimit))
+		r.state.miterLimit = miterLimit
+	}
+}
+
+func (r *PS) setDashes(dashOffset float64, dashes []float64) {
+	// PostScript can't handle negative dash offsets
+	if dashOffset < 0.0 {
+		totalLength := 0.0
+		for _, dash := range dashes {
+			totalLength += dash
+		}
+		if 0.0 < totalLength {
+			for dashOffset < 0.0 {
+				dashOffset += totalLength
+			}
+		}
+	}
+
+	if len(dashes) == 0 {
+		dashOffset = 0.0
+	}
+	state := append(append([]float64{}, dashes...), dashOffset)
+	if !float64sEqual(state, r.state.dashes) {
+		fmt.Fprintf(r.body, " [")
+		for i, dash := range dashes {
+			if i != 0 {
+				fmt.Fprintf(r.body, " ")
+			}
+			fmt.Fprintf(r.body, "%v", dec(dash))
+		}
+		fmt.Fprintf(r.body, "] %v setdash", dec(state[len(state)-1]))
+	}
+	r.state.dashes = state
+}
+
+func (r *PS) setStroke(style canvas.Style) {
+	r.setLineWidth(style.StrokeWidth)
+	r.setLineCap(style.StrokeCapper)
+	r.setLineJoin(style.StrokeJoiner)
+	r.setDashes(style.DashOffset, style.Dashes)
+}
+
+// pushState saves the graphics state, it must be followed by popState.
+func (r *PS) pushState() {
+	r.stateStack = append(r.stateStack, r.state)
+	fmt.Fprintf(r.body, " gsave")
+}
+
+func (r *PS) popState() {
+	r.state = r.stateStack[len(r.stateStack)-1]
+	r.stateStack = r.stateStack[:len(r.stateStack)-1]
+	fmt.Fprintf(r.body, " grestore")
+}
+
+func (r *PS) Size() (float64, float64) {
+	return r.width, r.height
+}
+
+func (r *PS) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
+	// TODO: (PS) use dither to fake transparency
+	// TODO: (PS) support paints, these are drawn using their color
+	fill := style.HasFill()
+	stroke := style.HasStroke()
+	if !fill && !stroke {
+		return
+	}
+
+	// PostScript doesn't support the arcs joiner, miter joiner (not clipped), or miter joiner (clipped) with non-bevel fallback
+	strokeUnsupported := false
+	if _, ok := style.StrokeJoiner.(canvas.ArcsJoiner); ok {
+		strokeUnsupported = true
+	} else if miter, ok := style.StrokeJoiner.(canvas.MiterJoiner); ok {
+		if math.IsNaN(miter.Limit) {
+			strokeUnsupported = true
+		} else if _, ok := miter.GapJoiner.(canvas.BevelJoiner); !ok {
+			strokeUnsupported = true
+		}
+	}
+
+	path = path.Transform(m)
+	fillOp := " fill"
+	if style.FillRule == canvas.EvenOdd {
+		fillOp = " eofill"
+	}
+
+	if stroke && strokeUnsupported {
+		if fill {
+			r.setColor(style.FillColor)
+			r.body.Write([]byte(" "))
+			r.body.Write([]byte(path.ToPS()))
+			r.body.Write([]byte(fillOp))
+		}
+
+		// stroke settings unsupported by PostScript, draw stroke explicitly
+		if 0 < len(style.Dashes) {
+			path = path.Dash(style.DashOffset, style.Dashes...)
+		}
+		path = path.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
+
+		r.setColor(style.StrokeColor)
+		r.body.Write([]byte(" "))
+		r.body.Write([]byte(path.ToPS()))
+		r.body.Write([]byte(" fill"))
+		return
+	}
+
+	if stroke {
+		r.setStroke(style)
+	}
+	if fill {
+		r.setColor(style.FillColor)
+	}
+	r.body.Write([]byte(" "))
+	r.body.Write([]byte(path.ToPS()))
+	if fill && stroke {
+		// the color set before gsave is restored after filling
+		r.body.Write([]byte(" gsave"))
+		r.body.Write([]byte(fillOp))
+		r.body.Write([]byte(" grestore"))
+		r.setColor(style.StrokeColor)
+		r.body.Write([]byte(" stroke"))
+	} else if fill {
+		r.body.Write([]byte(fillOp))
+	} else {
+		r.setColor(style.StrokeColor)
+		r.body.Write([]byte(" stroke"))
+	}
+}
+
+func (r *PS) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	r.pushState()
+	r.body.Write([]byte(" "))
+	r.body.Write([]byte(path.Transform(m).ToPS()))
+	if fillRule == canvas.EvenOdd {
+		r.body.Write([]byte(" eoclip newpath"))
+	} else {
+		r.body.Write([]byte(" clip newpath"))
+	}
+}
+
+func (r *PS) PopClip() {
+	if len(r.stateStack) == 0 {
+		return
+	}
+	r.popState()
+}
+
+func (r *PS) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	// TODO: (PS) support group opacity and blend modes, PostScript has no transparency
+}
+
+func (r *PS) PopGroup() {
+}
+
+func (r *PS) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	// TODO: (PS) support masks, PostScript has no transparency
+}
+
+func (r *PS) PopMask() {
+}
+
+// RenderText writes text natively using embedded Type 42 fonts. Text with fonts that cannot be embedded, such as fonts with CFF outlines, is drawn as paths.
+func (r *PS) RenderText(text *canvas.Text, m canvas.Matrix) {
+	embed := true
+	text.WalkSpans(func(y, dx float64, span canvas.TextSpan) {
+		if r.getFont(span.Face.Font) == nil {
+			embed = false
+		}
+	})
+	if !embed {
+		canvas.RenderTextAsPath(r, text, m)
+		return
+	}
+
+	text.WalkSpans(func(y, dx float64, span canvas.TextSpan) {
+		font := r.getFont(span.Face.Font)
+		size := span.Face.Size * span.Face.Scale
+		glyphs, xs := spanGlyphs(span)
+
+		r.setColor(span.Face.Color)
+		r.pushState()
+		mspan := m.Translate(dx, y).Shear(span.Face.FauxItalic, 0.0)
+		fmt.Fprintf(r.body, " [%v %v %v %v %v %v] concat", dec(mspan[0][0]), dec(mspan[1][0]), dec(mspan[0][1]), dec(mspan[1][1]), dec(mspan[0][2]), dec(mspan[1][2]))
+
+		chunk := -1
+		for i := 0; i < len(glyphs); {
+			glyphID := font.glyphID(glyphs[i])
+			if int(glyphID/256) != chunk {
+				chunk = int(glyphID / 256)
+				fmt.Fprintf(r.body, " /%s %v selectfont", font.chunkName(chunk), dec(size))
+			}
+
+			// run of glyphs in the same encoding
+			j := i + 1
+			for j < len(glyphs) && int(font.glyphID(glyphs[j])/256) == chunk {
+				j++
+			}
+			codes := make([]byte, j-i)
+			for k := i; k < j; k++ {
+				codes[k-i] = byte(font.glyphID(glyphs[k]) % 256)
+			}
+
+			if 0.0 < span.Face.FauxBold {
+				// draw glyph outlines that are filled and stroked
+				for k, code := range codes {
+					fmt.Fprintf(r.body, " %v %v moveto <%02X> false charpath", dec(xs[i+k]), dec(span.Face.Voffset), code)
+				}
+			} else {
+				fmt.Fprintf(r.body, " %v %v moveto <%X> [", dec(xs[i]), dec(span.Face.Voffset), codes)
+				for k := i; k < j; k++ {
+					if k != i {
+						fmt.Fprintf(r.body, " ")
+					}
+					fmt.Fprintf(r.body, "%v", dec(xs[k+1]-xs[k]))
+				}
+				fmt.Fprintf(r.body, "] xshow")
+			}
+			i = j
+		}
+		if 0.0 < span.Face.FauxBold {
+			fmt.Fprintf(r.body, " gsave fill grestore %v setlinewidth stroke", dec(span.Face.FauxBold*2.0))
+		}
+		r.popState()
+	})
+
+	text.RenderDecoration(r, m)
+}
+
+// spanGlyphs returns the glyph IDs of the span and the horizontal position of each glyph, including the advance of the span as the last position.
+func spanGlyphs(span canvas.TextSpan) ([]uint16, []float64) {
+	glyphs := []uint16{}
+	xs := []float64{0.0}
+	x := 0.0
+	words := span.Words()
+	for i, word := range words {
+		runes := []rune(word)
+		glyphs = append(glyphs, span.Face.Font.IndicesOf(word)...)
+		for j, r := range runes {
+			x += span.Face.TextWidth(string(r)) + span.GlyphSpacing
+			if j+1 < len(runes) {
+				x += span.Face.Kerning(r, runes[j+1])
+			} else if i+1 < len(words) {
+				x += span.WordSpacing
+			}
+			xs = append(xs, x)
+		}
+	}
+	return glyphs, xs
+}
+
+// RenderImage writes the image as an RGB image composited on white, since PostScript does not support transparency.
+func (r *PS) RenderImage(img image.Image, m canvas.Matrix) {
+	size := img.Bounds().Size()
+	sp := img.Bounds().Min // starting point
+
+	b := &bytes.Buffer{}
+	zw := zlib.NewWriter(b)
+	pixel := make([]byte, 3)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			R, G, B, A := img.At(sp.X+x, sp.Y+y).RGBA()
+			pixel[0] = byte((R + 0xFFFF - A) >> 8)
+			pixel[1] = byte((G + 0xFFFF - A) >> 8)
+			pixel[2] = byte((B + 0xFFFF - A) >> 8)
+			zw.Write(pixel)
+		}
+	}
+	zw.Close()
+
+	r.pushState()
+	fmt.Fprintf(r.body, " [%v %v %v %v %v %v] concat", dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))
+	fmt.Fprintf(r.body, " %d %d 8 [1 0 0 -1 0 %d] currentfile /ASCII85Decode filter /FlateDecode filter false 3 colorimage\n", size.X, size.Y, size.Y)
+	writeASCII85(r.body, b.Bytes())
+	fmt.Fprintf(r.body, "~>\n")
+	r.popState()
+}
+
+// writeASCII85 writes data in the ASCII base-85 encoding in lines of 64 characters, without the end-of-data marker.
+func writeASCII85(w io.Writer, data []byte) {
+	b := make([]byte, ascii85.MaxEncodedLen(len(data)))
+	b = b[:ascii85.Encode(b, data)]
+	for 64 < len(b) {
+		w.Write(b[:64])
+		w.Write([]byte("\n"))
+		b = b[64:]
+	}
+	w.Write(b)
+}
+
+// lineWriter replaces spaces by newlines to keep lines shorter than 255 characters, as required by the document structuring conventions. It keeps the error of writing to w.
+type lineWriter struct {
+	w   io.Writer
+	col int
+	err error
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	i := 0
+	for j, c := range b {
+		if c == '\n' {
+			w.col = 0
+		} else if c == ' ' && 200 < w.col {
+			if _, err := w.w.Write(b[i:j]); err != nil {
+				w.err = err
+				return 0, err
+			}
+			if _, err := w.w.Write([]byte("\n")); err != nil {
+				w.err = err
+				return 0, err
+			}
+			i = j + 1
+			w.col = 0
+		} else {
+			w.col++
+		}
+	}
+	if _, err := w.w.Write(b[i:]); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return n, nil
+}
+
+func float64sEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, f := range a {
+		if f != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type dec float64
+
+func (f dec) String() string {
+	s := fmt.Sprintf("%.*f", canvas.Precision, f)
+	s = string(minify.Decimal([]byte(s), canvas.Precision))
+	if dec(math.MaxInt32) < f || f < dec(math.MinInt32) {
+		if i := strings.IndexByte(s, '.'); i == -1 {
+			s += ".0"
+		}
+	}
+	return s
+}
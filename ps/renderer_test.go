@@ -0,0 +1,173 @@
+package ps
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+)
+
+func TestPS(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	face := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+
+	w := &bytes.Buffer{}
+	ps := New(w, 210.0, 297.0, nil)
+	ps.RenderText(canvas.NewTextLine(face, "a", canvas.Left), canvas.Identity)
+	ps.NewPage(100.0, 50.0)
+	test.T(t, ps.width, 100.0)
+	ps.RenderText(canvas.NewTextLine(face, "b", canvas.Left), canvas.Identity)
+	ps.NewPage(210.0, 297.0)
+	test.Error(t, ps.Close())
+
+	s := w.String()
+	test.That(t, strings.HasPrefix(s, "%!PS-Adobe-3.0\n"), "must have header")
+	test.That(t, strings.Contains(s, "%%BoundingBox: 0 0 596 842\n"), "bounding box must contain all pages")
+	test.That(t, strings.Contains(s, "%%Pages: 3\n%%DocumentMedia: 595x842 595.27559 841.88976 0 () ()\n%%+ 283x142 283.46457 141.73228 0 () ()\n%%EndComments\n"), "must list pages and media")
+	test.T(t, strings.Count(s, "%%BeginResource: font"), 1, "must embed font once")
+	test.That(t, strings.Index(s, "%%BeginResource") < strings.Index(s, "%%EndProlog"), "must embed fonts in the prolog")
+	test.That(t, strings.Contains(s, "%%Page: 2 2\n%%PageMedia: 283x142\n%%PageBoundingBox: 0 0 284 142\n%%BeginPageSetup\n<< /PageSize [283.46457 141.73228] >> setpagedevice\n%%EndPageSetup\nsave 2.8346457 2.8346457 scale gsave"), "must set up page")
+	test.T(t, strings.Count(s, "restore showpage\n"), 3)
+	test.That(t, strings.HasSuffix(s, "restore showpage\n%%Trailer\n%%EOF\n"))
+}
+
+func TestPSEncapsulated(t *testing.T) {
+	w := &bytes.Buffer{}
+	ps := New(w, 100.0, 80.0, &Options{Encapsulated: true})
+	ps.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	test.That(t, strings.HasSuffix(w.String(), "closepath fill"), "must write as it renders")
+	test.Error(t, ps.Close())
+
+	s := w.String()
+	test.That(t, strings.HasPrefix(s, "%!PS-Adobe-3.0 EPSF-3.0\n"), "must have header")
+	test.That(t, !strings.Contains(s, "%%Page"), "must not have pages")
+	test.That(t, strings.HasSuffix(s, "%%EndProlog\n2.8346457 2.8346457 scale 0 0 moveto 10 0 lineto 10 10 lineto 0 10 lineto closepath fill\n%%EOF\n"))
+
+	// only one page
+	w.Reset()
+	ps = New(w, 100.0, 80.0, &Options{Encapsulated: true})
+	ps.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	ps.NewPage(100.0, 80.0)
+	ps.RenderPath(canvas.Rectangle(20.0, 20.0), canvas.DefaultStyle, canvas.Identity)
+	test.T(t, ps.Close(), ErrEncapsulatedPages)
+	test.That(t, strings.HasSuffix(w.String(), "closepath fill\n%%EOF\n"), "must end the first page")
+	test.That(t, !strings.Contains(w.String(), "20 0 lineto"), "must discard further pages")
+}
+
+func TestPSPath(t *testing.T) {
+	w := &bytes.Buffer{}
+	ps := New(w, 100, 80, nil)
+	style := canvas.DefaultStyle
+	style.StrokeColor = canvas.Blue
+	style.StrokeWidth = 0.5
+	style.StrokeCapper = canvas.RoundCap
+	style.StrokeJoiner = canvas.BevelJoin
+	style.Dashes = []float64{1.0, 2.0}
+	style.FillRule = canvas.EvenOdd
+	ps.RenderPath(canvas.Rectangle(10.0, 10.0), style, canvas.Identity)
+
+	style.FillColor = canvas.Transparent
+	style.Dashes = nil
+	ps.RenderPath(canvas.Rectangle(10.0, 10.0), style, canvas.Identity.Translate(5.0, 0.0))
+	test.Error(t, ps.Close())
+
+	s := w.String()
+	s = s[strings.Index(s, "2.8346457 scale")+15 : strings.Index(s, "\nrestore showpage")]
+	s = strings.Replace(s, "\n", " ", -1) // long lines are wrapped
+	test.String(t, s, " .5 setlinewidth 1 setlinecap 2 setlinejoin [1 2] 0 setdash 0 0 moveto 10 0 lineto 10 10 lineto 0 10 lineto closepath gsave eofill grestore 0 0 1 setrgbcolor stroke [] 0 setdash 5 0 moveto 15 0 lineto 15 10 lineto 5 10 lineto closepath stroke")
+}
+
+func TestPSText(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	face := dejaVuSerif.Face(12.0, canvas.Red, canvas.FontRegular, canvas.FontNormal)
+
+	w := &bytes.Buffer{}
+	ps := New(w, 100, 80, nil)
+	ps.RenderText(canvas.NewTextLine(face, "aba", canvas.Left), canvas.Identity.Translate(10.0, 20.0))
+	test.Error(t, ps.Close())
+
+	s := w.String()
+	test.That(t, strings.Contains(s, "%%BeginResource: font YBEDNZ+dejavu-serif\n"), "must embed font")
+	test.That(t, strings.Contains(s, "/FontType 42 def\n"), "must embed Type 42 font")
+	test.That(t, strings.Contains(s, "/CharStrings 3 dict dup begin\n/.notdef 0 def /g1 1 def /g2 2 def\nend readonly def\n"), "must only embed used glyphs")
+	test.That(t, strings.Contains(s, "/F0.0 /YBEDNZ+dejavu-serif [\n/.notdef /g1 /g2 /.notdef"), "must encode glyphs")
+	test.That(t, strings.Contains(s, " 1 0 0 setrgbcolor gsave [1 0 0 1 10 20] concat /F0.0 4.2333333 selectfont 0 0 moveto <010201> [2.515625 2.703125 2.515625] xshow grestore"), "must show glyphs")
+}
+
+func TestPSTextAsPath(t *testing.T) {
+	ebGaramond := canvas.NewFontFamily("eb-garamond")
+	test.Error(t, ebGaramond.LoadFontFile("../font/EBGaramond12-Regular.otf", canvas.FontRegular))
+	face := ebGaramond.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+
+	w := &bytes.Buffer{}
+	ps := New(w, 100, 80, nil)
+	ps.RenderText(canvas.NewTextLine(face, "a", canvas.Left), canvas.Identity)
+	test.Error(t, ps.Close())
+
+	// fonts with CFF outlines cannot be embedded as Type 42 fonts
+	s := w.String()
+	test.That(t, !strings.Contains(s, "%%BeginResource"), "must not embed font")
+	test.That(t, strings.Contains(s, " curveto"), "must draw text as path")
+}
+
+func TestPSImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.NRGBA{0, 0, 0, 0})
+
+	w := &bytes.Buffer{}
+	ps := New(w, 100, 80, nil)
+	ps.RenderImage(img, canvas.Identity.Translate(10.0, 20.0))
+	test.Error(t, ps.Close())
+
+	s := w.String()
+	test.That(t, strings.Contains(s, " gsave [1 0 0 1 10 20] concat 2 1 8 [1 0 0 -1 0 1] currentfile /ASCII85Decode filter /FlateDecode filter false 3 colorimage\n"), "must write image")
+
+	data := s[strings.Index(s, "colorimage\n")+11 : strings.Index(s, "~>")]
+	dst := make([]byte, len(data))
+	n, _, err := ascii85.Decode(dst, []byte(data), true)
+	test.Error(t, err)
+	r, err := zlib.NewReader(bytes.NewReader(dst[:n]))
+	test.Error(t, err)
+	pixels, err := ioutil.ReadAll(r)
+	test.Error(t, err)
+	test.T(t, pixels, []byte{255, 0, 0, 255, 255, 255}, "transparent pixels must be white")
+}
+
+func TestSFNTStrings(t *testing.T) {
+	b, err := ioutil.ReadFile("../font/DejaVuSerif.ttf")
+	test.Error(t, err)
+
+	strs, err := sfntStrings(b)
+	test.Error(t, err)
+	test.That(t, 1 < len(strs))
+
+	var joined []byte
+	for _, s := range strs {
+		test.That(t, len(s) <= maxSFNTString && len(s)%2 == 0, "strings must have a limited and even length")
+		joined = append(joined, s...)
+	}
+	test.T(t, joined, b)
+}
+
+func TestPSDocument(t *testing.T) {
+	d := canvas.NewDocument()
+	d.NewPage(210.0, 297.0)
+	d.NewPage(297.0, 210.0)
+
+	buf := &bytes.Buffer{}
+	test.Error(t, DocumentWriter(buf, d))
+	s := buf.String()
+	test.That(t, strings.Contains(s, "%%Pages: 2\n"), "two pages")
+	test.That(t, strings.Contains(s, "%%Page: 2 2\n"), "second page")
+	test.T(t, DocumentWriter(buf, canvas.NewDocument()), canvas.ErrNoPages)
+}
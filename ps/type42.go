@@ -0,0 +1,273 @@
+package ps
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/tdewolff/canvas"
+	canvasFont "github.com/tdewolff/canvas/font"
+)
+
+// maxSFNTString is the maximum length of the strings in the sfnts array of a Type 42 font, which must be even and less than the maximum PostScript string length.
+const maxSFNTString = 65534
+
+// psFont is a TrueType font that is embedded once as a Type 42 font in the prolog, of which only the used glyphs are embedded when subsetting is supported for the font. Encapsulated PostScript embeds the complete font in the page before its first use instead. The glyphs are renumbered in order of use, and every 256 glyphs are shown with a copy of the font using a different encoding.
+type psFont struct {
+	name   string
+	font   *canvas.Font
+	glyphs []uint16          // original glyph IDs in order of the new glyph IDs
+	index  map[uint16]uint16 // original to new glyph IDs, or nil if not subsetting
+}
+
+// getFont returns the font to embed, or nil if the font has no TrueType outlines and can thus not be embedded as a Type 42 font.
+func (r *PS) getFont(font *canvas.Font) *psFont {
+	if f, ok := r.fonts[font]; ok {
+		return f
+	}
+
+	f := &psFont{
+		name: fmt.Sprintf("F%d", len(r.fontList)),
+		font: font,
+	}
+	if mediatype, _, err := font.Subset([]uint16{0}); err == nil {
+		if mediatype != "font/truetype" {
+			f = nil
+		} else {
+			f.glyphs = []uint16{0}
+			f.index = map[uint16]uint16{0: 0}
+		}
+	} else if _, b, err := rawSFNT(font); err != nil || !isTrueType(b) {
+		f = nil
+	}
+	r.fonts[font] = f
+	if f != nil && r.opts.Encapsulated {
+		// the page is written as it is rendered, so the used glyphs are not known in advance
+		f.glyphs, f.index = nil, nil
+		if r.body.col != 0 {
+			fmt.Fprintf(r.body, "\n")
+		}
+		if err := f.write(r.body); err != nil && r.err == nil {
+			r.err = err
+		}
+	} else if f != nil {
+		r.fontList = append(r.fontList, f)
+	}
+	return f
+}
+
+// glyphID returns the glyph ID in the embedded font for the original glyph ID.
+func (f *psFont) glyphID(glyphID uint16) uint16 {
+	if f.index == nil {
+		return glyphID
+	} else if newGlyphID, ok := f.index[glyphID]; ok {
+		return newGlyphID
+	}
+	newGlyphID := uint16(len(f.glyphs))
+	f.index[glyphID] = newGlyphID
+	f.glyphs = append(f.glyphs, glyphID)
+	return newGlyphID
+}
+
+// chunkName returns the name of the font copy that encodes the glyphs 256*chunk to 256*chunk+255.
+func (f *psFont) chunkName(chunk int) string {
+	return fmt.Sprintf("%s.%d", f.name, chunk)
+}
+
+// write writes the Type 42 font and its encoded copies.
+func (f *psFont) write(w io.Writer) error {
+	var b []byte
+	var err error
+	if f.index != nil {
+		_, b, err = f.font.Subset(f.glyphs)
+	} else {
+		_, b, err = rawSFNT(f.font)
+	}
+	if err != nil {
+		return err
+	}
+
+	strs, err := sfntStrings(b)
+	if err != nil {
+		return err
+	}
+
+	numGlyphs := len(f.glyphs)
+	if f.index == nil {
+		numGlyphs = len(f.font.Widths(f.font.UnitsPerEm()))
+	}
+
+	fontName := strings.Map(func(r rune) rune {
+		if r <= ' ' || 126 < r || strings.ContainsRune("()<>[]{}/%", r) {
+			return -1
+		}
+		return r
+	}, f.font.Name())
+	if fontName == "" {
+		fontName = f.name
+	}
+	if f.index != nil {
+		fontName = subsetTag(f.glyphs) + "+" + fontName
+	}
+
+	units := f.font.UnitsPerEm()
+	bounds := f.font.Bounds(units)
+	fmt.Fprintf(w, "%%%%BeginResource: font %s\n", fontName)
+	fmt.Fprintf(w, "10 dict begin\n/FontName /%s def\n/FontType 42 def\n/PaintType 0 def\n/FontMatrix [1 0 0 1 0 0] def\n", fontName)
+	fmt.Fprintf(w, "/FontBBox [%v %v %v %v] def\n", dec(bounds.X/units), dec(-(bounds.Y+bounds.H)/units), dec((bounds.X+bounds.W)/units), dec(-bounds.Y/units))
+	fmt.Fprintf(w, "/Encoding 256 array 0 1 255 {1 index exch /.notdef put} for def\n")
+	fmt.Fprintf(w, "/CharStrings %d dict dup begin\n/.notdef 0 def", numGlyphs)
+	for glyphID := 1; glyphID < numGlyphs; glyphID++ {
+		if glyphID%8 == 0 {
+			fmt.Fprintf(w, "\n")
+		} else {
+			fmt.Fprintf(w, " ")
+		}
+		fmt.Fprintf(w, "/g%d %d def", glyphID, glyphID)
+	}
+	fmt.Fprintf(w, "\nend readonly def\n/sfnts [\n")
+	for _, s := range strs {
+		// each string has an extra byte that is ignored, for compatibility with early interpreters
+		fmt.Fprintf(w, "<")
+		for i := 0; i < len(s); i += 32 {
+			if i != 0 {
+				fmt.Fprintf(w, "\n")
+			}
+			fmt.Fprintf(w, "%X", s[i:minInt(i+32, len(s))])
+		}
+		fmt.Fprintf(w, "00>\n")
+	}
+	fmt.Fprintf(w, "] def\nFontName currentdict end definefont pop\n%%%%EndResource\n")
+
+	for chunk := 0; chunk*256 < numGlyphs; chunk++ {
+		fmt.Fprintf(w, "/%s /%s [", f.chunkName(chunk), fontName)
+		for code := 0; code < 256; code++ {
+			if code%16 == 0 {
+				fmt.Fprintf(w, "\n")
+			} else {
+				fmt.Fprintf(w, " ")
+			}
+			if glyphID := chunk*256 + code; glyphID == 0 || numGlyphs <= glyphID {
+				fmt.Fprintf(w, "/.notdef")
+			} else {
+				fmt.Fprintf(w, "/g%d", glyphID)
+			}
+		}
+		fmt.Fprintf(w, "] reencode\n")
+	}
+	return nil
+}
+
+// rawSFNT returns the font data in the TTF or OTF format.
+func rawSFNT(font *canvas.Font) (string, []byte, error) {
+	mediatype, b := font.Raw()
+	if mediatype != "font/truetype" && mediatype != "font/opentype" {
+		var err error
+		if b, err = canvasFont.ToSFNT(b); err != nil {
+			return "", nil, err
+		} else if mediatype, err = canvasFont.MediaType(b); err != nil {
+			return "", nil, err
+		}
+	}
+	return mediatype, b, nil
+}
+
+func isTrueType(b []byte) bool {
+	mediatype, err := canvasFont.MediaType(b)
+	return err == nil && mediatype == "font/truetype"
+}
+
+// subsetTag returns a tag of six uppercase letters that identifies the glyph subset, which is prepended to the font name.
+func subsetTag(glyphs []uint16) string {
+	h := fnv.New32a()
+	for _, glyphID := range glyphs {
+		h.Write([]byte{byte(glyphID >> 8), byte(glyphID)})
+	}
+	tag := make([]byte, 6)
+	for i, sum := 0, h.Sum32(); i < len(tag); i, sum = i+1, sum/26 {
+		tag[i] = 'A' + byte(sum%26)
+	}
+	return string(tag)
+}
+
+// sfntStrings splits the font data into strings for the sfnts array of a Type 42 font. Strings may only be split at table boundaries, or at glyph boundaries within the glyf table.
+func sfntStrings(b []byte) ([][]byte, error) {
+	if len(b) < 12 {
+		return nil, canvasFont.ErrInvalidFontData
+	}
+	numTables := int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < 12+16*numTables {
+		return nil, canvasFont.ErrInvalidFontData
+	}
+
+	var glyf, loca, head []byte
+	var glyfOffset uint32
+	splits := []uint32{}
+	for i := 0; i < numTables; i++ {
+		record := b[12+16*i:]
+		offset := binary.BigEndian.Uint32(record[8:])
+		length := binary.BigEndian.Uint32(record[12:])
+		if uint32(len(b)) < offset || uint32(len(b))-offset < length {
+			return nil, canvasFont.ErrInvalidFontData
+		}
+		splits = append(splits, offset)
+
+		data := b[offset : offset+length]
+		switch string(record[:4]) {
+		case "glyf":
+			glyf, glyfOffset = data, offset
+		case "loca":
+			loca = data
+		case "head":
+			head = data
+		}
+	}
+	if glyf != nil && loca != nil && 52 <= len(head) {
+		long := binary.BigEndian.Uint16(head[50:]) == 1
+		for i := 0; ; i++ {
+			var offset uint32
+			if long && 4*i+4 <= len(loca) {
+				offset = binary.BigEndian.Uint32(loca[4*i:])
+			} else if !long && 2*i+2 <= len(loca) {
+				offset = 2 * uint32(binary.BigEndian.Uint16(loca[2*i:]))
+			} else {
+				break
+			}
+			if offset%2 == 0 && offset < uint32(len(glyf)) {
+				splits = append(splits, glyfOffset+offset)
+			}
+		}
+	}
+	splits = append(splits, uint32(len(b)))
+	sort.Slice(splits, func(i, j int) bool { return splits[i] < splits[j] })
+
+	// split at the last boundary before exceeding the maximum string length
+	strs := [][]byte{}
+	start, prev := uint32(0), uint32(0)
+	for _, split := range splits {
+		if maxSFNTString < split-start && start < prev {
+			strs = append(strs, b[start:prev])
+			start = prev
+		}
+		for maxSFNTString < split-start {
+			// a single table or glyph that is too long
+			strs = append(strs, b[start:start+maxSFNTString])
+			start += maxSFNTString
+		}
+		prev = split
+	}
+	if start < uint32(len(b)) {
+		strs = append(strs, b[start:])
+	}
+	return strs, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,31 @@
+package ps
+
+import (
+	"io"
+
+	"github.com/tdewolff/canvas"
+)
+
+// Writer writes the canvas as a PostScript file.
+// Be aware that PostScript does not support transparency of colors.
+func Writer(w io.Writer, c *canvas.Canvas) error {
+	ps := New(w, c.W, c.H, nil)
+	c.Render(ps)
+	return ps.Close()
+}
+
+// DocumentWriter writes the document as a PostScript file with a page for each page of the document.
+func DocumentWriter(w io.Writer, d *canvas.Document) error {
+	if len(d.Pages) == 0 {
+		return canvas.ErrNoPages
+	}
+
+	ps := New(w, d.Pages[0].W, d.Pages[0].H, nil)
+	for i, page := range d.Pages {
+		if i != 0 {
+			ps.NewPage(page.W, page.H)
+		}
+		page.Render(ps)
+	}
+	return ps.Close()
+}
@@ -2,6 +2,8 @@ package rasterizer
 
 import (
 	"image"
+	"image/color"
+	"math"
 
 	"github.com/tdewolff/canvas"
 	"golang.org/x/image/draw"
@@ -21,6 +23,16 @@ func Draw(c *canvas.Canvas, resolution canvas.DPMM) *image.RGBA {
 type Renderer struct {
 	img        draw.Image
 	resolution canvas.DPMM
+	clips      []*image.Alpha // coverage masks of the clipping paths, each intersected with the previous
+	groups     []rasterGroup
+}
+
+// rasterGroup is a group that is drawn to an offscreen image, which is composited onto the enclosing image when ended.
+type rasterGroup struct {
+	img       draw.Image // enclosing image
+	opacity   float64
+	blendMode canvas.BlendMode
+	mask      *image.Alpha // opacity per pixel, can be nil
 }
 
 // New creates a renderer that draws to a rasterized image.
@@ -37,12 +49,96 @@ func (r *Renderer) Size() (float64, float64) {
 	return float64(size.X) / float64(r.resolution), float64(size.Y) / float64(r.resolution)
 }
 
+func (r *Renderer) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	path = path.Transform(m)
+	if fillRule == canvas.EvenOdd {
+		path = path.Settle(canvas.EvenOdd) // the rasterizer only supports the non-zero fill rule
+	}
+
+	size := r.img.Bounds().Size()
+	clip := image.NewAlpha(image.Rect(0, 0, size.X, size.Y))
+	ras := vector.NewRasterizer(size.X, size.Y)
+	path.ToRasterizer(ras, float64(r.resolution))
+	ras.Draw(clip, clip.Bounds(), image.Opaque, image.Point{})
+	if 0 < len(r.clips) {
+		prev := r.clips[len(r.clips)-1]
+		for i := range clip.Pix {
+			clip.Pix[i] = uint8((uint32(clip.Pix[i])*uint32(prev.Pix[i]) + 127) / 255)
+		}
+	}
+	r.clips = append(r.clips, clip)
+}
+
+func (r *Renderer) PopClip() {
+	if 0 < len(r.clips) {
+		r.clips = r.clips[:len(r.clips)-1]
+	}
+}
+
+func (r *Renderer) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	r.groups = append(r.groups, rasterGroup{r.img, opacity, blendMode, nil})
+	r.img = image.NewRGBA(r.img.Bounds())
+}
+
+func (r *Renderer) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	img := image.NewRGBA(r.img.Bounds())
+	mask.RenderView(New(img, r.resolution), m)
+
+	alpha := image.NewAlpha(img.Bounds())
+	for i := range alpha.Pix {
+		alpha.Pix[i] = maskType.Mask(color.RGBA{img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3]})
+	}
+	r.groups = append(r.groups, rasterGroup{r.img, 1.0, canvas.NormalBlend, alpha})
+	r.img = image.NewRGBA(r.img.Bounds())
+}
+
+func (r *Renderer) PopMask() {
+	r.PopGroup()
+}
+
+func (r *Renderer) PopGroup() {
+	if len(r.groups) == 0 {
+		return
+	}
+	group := r.groups[len(r.groups)-1]
+	r.groups = r.groups[:len(r.groups)-1]
+
+	src := r.img.(*image.RGBA)
+	r.img = group.img
+	dst, isRGBA := r.img.(*image.RGBA)
+	bounds := src.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := src.RGBAAt(x, y)
+			if col.A == 0 {
+				continue
+			}
+			opacity := group.opacity
+			if group.mask != nil {
+				opacity *= float64(group.mask.AlphaAt(x, y).A) / 255.0
+			}
+			if opacity != 1.0 {
+				col.R = uint8(float64(col.R)*opacity + 0.5)
+				col.G = uint8(float64(col.G)*opacity + 0.5)
+				col.B = uint8(float64(col.B)*opacity + 0.5)
+				col.A = uint8(float64(col.A)*opacity + 0.5)
+			}
+			if isRGBA {
+				dst.SetRGBA(x, y, group.blendMode.Composite(dst.RGBAAt(x, y), col))
+			} else {
+				backdrop := color.RGBAModel.Convert(r.img.At(x, y)).(color.RGBA)
+				r.img.Set(x, y, group.blendMode.Composite(backdrop, col))
+			}
+		}
+	}
+}
+
 func (r *Renderer) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
 	// TODO: use fill rule (EvenOdd, NonZero) for rasterizer
 	path = path.Transform(m)
 
 	strokeWidth := 0.0
-	if style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth {
+	if style.HasStroke() {
 		strokeWidth = style.StrokeWidth
 	}
 
@@ -77,12 +173,12 @@ func (r *Renderer) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Ma
 	}
 
 	path = path.Translate(-float64(x)/resolution, -float64(y)/resolution)
-	if style.FillColor.A != 0 {
+	if style.HasFill() {
 		ras := vector.NewRasterizer(w, h)
 		path.ToRasterizer(ras, resolution)
-		ras.Draw(r.img, image.Rect(x, size.Y-y, x+w, size.Y-y-h), image.NewUniform(style.FillColor), image.Point{dx, dy})
+		r.draw(ras, image.Rect(x, size.Y-y, x+w, size.Y-y-h), style.FillColor, style.FillPaint, m, image.Point{dx, dy})
 	}
-	if style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth {
+	if style.HasStroke() {
 		if 0 < len(style.Dashes) {
 			path = path.Dash(style.DashOffset, style.Dashes...)
 		}
@@ -90,8 +186,66 @@ func (r *Renderer) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Ma
 
 		ras := vector.NewRasterizer(w, h)
 		path.ToRasterizer(ras, resolution)
-		ras.Draw(r.img, image.Rect(x, size.Y-y, x+w, size.Y-y-h), image.NewUniform(style.StrokeColor), image.Point{dx, dy})
+		r.draw(ras, image.Rect(x, size.Y-y, x+w, size.Y-y-h), style.StrokeColor, style.StrokePaint, m, image.Point{dx, dy})
+	}
+}
+
+// draw draws the rasterized path using a solid color or, if not nil, a paint that is evaluated per pixel. The path is clipped by the current clipping mask.
+func (r *Renderer) draw(ras *vector.Rasterizer, rect image.Rectangle, col color.RGBA, paint canvas.Paint, m canvas.Matrix, sp image.Point) {
+	var src image.Image = image.NewUniform(col)
+	if pattern, ok := paint.(*canvas.Pattern); ok {
+		if pattern.Cell != nil && 0.0 < pattern.W && 0.0 < pattern.H {
+			src = r.patternImage(pattern, m)
+			sp = rect.Min // source coordinates equal destination coordinates
+		}
+	} else if paint != nil {
+		src = paintImage{
+			paint:      paint,
+			m:          m.Inv(),
+			resolution: float64(r.resolution),
+			height:     r.img.Bounds().Size().Y,
+		}
+		sp = rect.Min // source coordinates equal destination coordinates
+	}
+	if len(r.clips) == 0 {
+		ras.Draw(r.img, rect, src, sp)
+		return
+	}
+
+	// multiply the coverage of the path by that of the clipping paths
+	rect = rect.Canon()
+	clip := r.clips[len(r.clips)-1]
+	coverage := image.NewAlpha(rect)
+	ras.Draw(coverage, rect, image.Opaque, image.Point{})
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i, j := coverage.PixOffset(x, y), clip.PixOffset(x, y)
+			coverage.Pix[i] = uint8((uint32(coverage.Pix[i])*uint32(clip.Pix[j]) + 127) / 255)
+		}
 	}
+	draw.DrawMask(r.img, rect, src, sp, coverage, rect.Min, draw.Over)
+}
+
+// paintImage is an infinite image that evaluates a paint at the center of each pixel.
+type paintImage struct {
+	paint      canvas.Paint
+	m          canvas.Matrix // from canvas coordinates to paint coordinates
+	resolution float64
+	height     int
+}
+
+func (img paintImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img paintImage) Bounds() image.Rectangle {
+	return image.Rect(math.MinInt32, math.MinInt32, math.MaxInt32, math.MaxInt32)
+}
+
+func (img paintImage) At(x, y int) color.Color {
+	p := canvas.Point{X: (float64(x) + 0.5) / img.resolution, Y: (float64(img.height-y) - 0.5) / img.resolution}
+	p = img.m.Dot(p)
+	return img.paint.At(p.X, p.Y)
 }
 
 func (r *Renderer) RenderText(text *canvas.Text, m canvas.Matrix) {
@@ -114,5 +268,75 @@ func (r *Renderer) RenderImage(img image.Image, m canvas.Matrix) {
 
 	h := float64(r.img.Bounds().Size().Y)
 	aff3 := f64.Aff3{m[0][0], -m[0][1], origin.X, -m[1][0], m[1][1], h - origin.Y}
-	draw.CatmullRom.Transform(r.img, aff3, img2, img2.Bounds(), draw.Over, nil)
+	var opts *draw.Options
+	if 0 < len(r.clips) {
+		opts = &draw.Options{DstMask: r.clips[len(r.clips)-1]}
+	}
+	draw.CatmullRom.Transform(r.img, aff3, img2, img2.Bounds(), draw.Over, opts)
+}
+
+// maxPatternTileSize is the maximum number of pixels of a rasterized pattern cell.
+const maxPatternTileSize = 4096 * 4096
+
+// patternImage rasterizes the cell of the pattern transformed by m, at about the same resolution as the renderer.
+func (r *Renderer) patternImage(pattern *canvas.Pattern, m canvas.Matrix) patternImage {
+	m = m.Mul(pattern.View)
+	resolution := float64(r.resolution) * math.Sqrt(math.Abs(m.Det()))
+	if n := pattern.W * pattern.H * resolution * resolution; maxPatternTileSize < n {
+		resolution *= math.Sqrt(maxPatternTileSize / n)
+	}
+	nx := int(math.Max(1.0, math.Ceil(pattern.W*resolution)))
+	ny := int(math.Max(1.0, math.Ceil(pattern.H*resolution)))
+
+	tile := image.NewRGBA(image.Rect(0, 0, nx, ny))
+	pattern.Cell.Render(New(tile, canvas.DPMM(resolution)))
+	return patternImage{
+		tile:           tile,
+		w:              pattern.W,
+		h:              pattern.H,
+		tileResolution: resolution,
+		m:              m.Inv(),
+		resolution:     float64(r.resolution),
+		height:         r.img.Bounds().Size().Y,
+	}
+}
+
+// patternImage is an infinite image that repeats the rasterized cell of a pattern.
+type patternImage struct {
+	tile           *image.RGBA
+	w, h           float64 // cell size
+	tileResolution float64
+	m              canvas.Matrix // from canvas coordinates to cell coordinates
+	resolution     float64
+	height         int
+}
+
+func (img patternImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img patternImage) Bounds() image.Rectangle {
+	return image.Rect(math.MinInt32, math.MinInt32, math.MaxInt32, math.MaxInt32)
+}
+
+func (img patternImage) At(x, y int) color.Color {
+	p := canvas.Point{X: (float64(x) + 0.5) / img.resolution, Y: (float64(img.height-y) - 0.5) / img.resolution}
+	p = img.m.Dot(p)
+	p.X -= math.Floor(p.X/img.w) * img.w
+	p.Y -= math.Floor(p.Y/img.h) * img.h
+
+	size := img.tile.Bounds().Size()
+	ix := int(p.X * img.tileResolution)
+	iy := size.Y - 1 - int(p.Y*img.tileResolution)
+	if ix < 0 {
+		ix = 0
+	} else if size.X <= ix {
+		ix = size.X - 1
+	}
+	if iy < 0 {
+		iy = 0
+	} else if size.Y <= iy {
+		iy = size.Y - 1
+	}
+	return img.tile.RGBAAt(ix, iy)
 }
@@ -0,0 +1,151 @@
+package rasterizer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/tdewolff/canvas"
+)
+
+// imageSize returns the size in pixels of the canvas drawn at the given resolution, which equals the size of the image returned by Draw.
+func imageSize(c *canvas.Canvas, resolution canvas.DPMM) image.Point {
+	return image.Point{int(c.W*float64(resolution) + 0.5), int(c.H*float64(resolution) + 0.5)}
+}
+
+// DrawTile draws the part of the canvas that falls within rect on a new image with given resolution (in dots-per-millimeter). The rectangle is in pixels of the full image as returned by Draw, with the origin at the top-left, and the returned image has rect as its bounds. Pixels may differ by a few levels per color channel from those of Draw due to rounding in the rasterizer.
+func DrawTile(c *canvas.Canvas, resolution canvas.DPMM, rect image.Rectangle) *image.RGBA {
+	return drawTile(c, resolution, rect.Canon(), float64(imageSize(c, resolution).Y))
+}
+
+// drawTile draws the part of the canvas within rect, where the top of the canvas is at height pixels above the origin of the full image.
+func drawTile(c *canvas.Canvas, resolution canvas.DPMM, rect image.Rectangle, height float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	viewport := canvas.Rect{
+		X: float64(rect.Min.X) / float64(resolution),
+		Y: (height - float64(rect.Max.Y)) / float64(resolution),
+		W: float64(rect.Dx()) / float64(resolution),
+		H: float64(rect.Dy()) / float64(resolution),
+	}
+	c.RenderRect(New(img, resolution), viewport)
+	img.Rect = rect
+	return img
+}
+
+// DrawTiles draws the canvas with given resolution (in dots-per-millimeter) in square tiles of tileSize pixels, so that only one tile is kept in memory at a time. The tiles are passed to f row by row from the top-left, and have their position in the full image as bounds. Tiles at the right and bottom edges are cropped to the size of the full image, and their pixels may differ slightly from Draw as for DrawTile. Drawing stops at the first error returned by f.
+func DrawTiles(c *canvas.Canvas, resolution canvas.DPMM, tileSize int, f func(img *image.RGBA) error) error {
+	if tileSize <= 0 {
+		return fmt.Errorf("invalid tile size %d", tileSize)
+	}
+
+	size := imageSize(c, resolution)
+	for y := 0; y < size.Y; y += tileSize {
+		for x := 0; x < size.X; x += tileSize {
+			rect := image.Rect(x, y, x+tileSize, y+tileSize).Intersect(image.Rectangle{Max: size})
+			if err := f(DrawTile(c, resolution, rect)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TiledPNGWriter writes the canvas as a PNG file by drawing strips of tileSize rows at a time, which bounds the memory use for very large images.
+func TiledPNGWriter(resolution canvas.DPMM, tileSize int) canvas.Writer {
+	return func(w io.Writer, c *canvas.Canvas) error {
+		if tileSize <= 0 {
+			return fmt.Errorf("invalid tile size %d", tileSize)
+		}
+		return png.Encode(w, &stripImage{
+			c:          c,
+			resolution: resolution,
+			size:       imageSize(c, resolution),
+			rows:       tileSize,
+		})
+	}
+}
+
+// stripImage is an image that draws the canvas lazily in horizontal strips, it is efficient only when the pixels are read row by row from the top such as by the PNG encoder.
+type stripImage struct {
+	c          *canvas.Canvas
+	resolution canvas.DPMM
+	size       image.Point
+	rows       int
+	strip      *image.RGBA
+}
+
+func (img *stripImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (img *stripImage) Bounds() image.Rectangle {
+	return image.Rectangle{Max: img.size}
+}
+
+// Opaque returns false, which prevents the PNG encoder from reading all pixels in advance.
+func (img *stripImage) Opaque() bool {
+	return false
+}
+
+func (img *stripImage) At(x, y int) color.Color {
+	if img.strip == nil || !(image.Point{x, y}).In(img.strip.Rect) {
+		if !(image.Point{x, y}).In(img.Bounds()) {
+			return color.RGBA{}
+		}
+		y0 := y - y%img.rows
+		rect := image.Rect(0, y0, img.size.X, y0+img.rows).Intersect(img.Bounds())
+		img.strip = DrawTile(img.c, img.resolution, rect)
+	}
+	return img.strip.RGBAAt(x, y)
+}
+
+// DrawTilePyramid draws the canvas as a pyramid of square tiles of tileSize pixels for web map viewers, following the XYZ (slippy map) tiling scheme. At zoom level zero the canvas fits in a single tile aligned to its top-left, and each next zoom level doubles the resolution. The function f is called for every tile that overlaps with the canvas, with its zoom level, its column x and row y from the top-left, and the tile image.
+func DrawTilePyramid(c *canvas.Canvas, tileSize, minZoom, maxZoom int, f func(z, x, y int, img *image.RGBA) error) error {
+	if tileSize <= 0 {
+		return fmt.Errorf("invalid tile size %d", tileSize)
+	} else if c.W <= 0.0 || c.H <= 0.0 {
+		return fmt.Errorf("invalid canvas size %gx%g", c.W, c.H)
+	}
+
+	for z := minZoom; z <= maxZoom; z++ {
+		n := 1 << uint(z) // number of tiles along each axis
+		resolution := canvas.DPMM(float64(tileSize*n) / math.Max(c.W, c.H))
+		size := image.Point{int(math.Ceil(c.W * float64(resolution))), int(math.Ceil(c.H * float64(resolution)))}
+		for y := 0; y*tileSize < size.Y; y++ {
+			for x := 0; x*tileSize < size.X; x++ {
+				rect := image.Rect(x*tileSize, y*tileSize, (x+1)*tileSize, (y+1)*tileSize)
+				img := drawTile(c, resolution, rect, c.H*float64(resolution))
+				img.Rect = image.Rect(0, 0, tileSize, tileSize)
+				if err := f(z, x, y, img); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteTilePyramid writes the tiles of DrawTilePyramid as PNG files to dir/{z}/{x}/{y}.png, which can be served to web map viewers.
+func WriteTilePyramid(dir string, c *canvas.Canvas, tileSize, minZoom, maxZoom int) error {
+	return DrawTilePyramid(c, tileSize, minZoom, maxZoom, func(z, x, y int, img *image.RGBA) error {
+		tileDir := filepath.Join(dir, fmt.Sprint(z), fmt.Sprint(x))
+		if err := os.MkdirAll(tileDir, 0755); err != nil {
+			return err
+		}
+
+		f, err := os.Create(filepath.Join(tileDir, fmt.Sprintf("%d.png", y)))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, img); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
@@ -0,0 +1,155 @@
+package rasterizer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+)
+
+// tileTolerance is the maximum difference of a color channel between a tile and the full image, due to rounding in the rasterizer.
+const tileTolerance = 8
+
+func testTileCanvas() *canvas.Canvas {
+	c := canvas.New(37.3, 23.1)
+	ctx := canvas.NewContext(c)
+	ctx.SetFillColor(canvas.Red)
+	ctx.DrawPath(3.3, 2.2, canvas.Circle(8.1))
+	ctx.SetFillColor(canvas.Transparent)
+	ctx.SetStrokeColor(canvas.Blue)
+	ctx.SetStrokeWidth(1.3)
+	ctx.SetStrokeJoiner(canvas.RoundJoin)
+	ctx.DrawPath(1.0, 1.0, canvas.MustParseSVG("M0 0L30 20L35 3z"))
+	return c
+}
+
+func testImageDiff(t *testing.T, img, ref image.Image) {
+	t.Helper()
+	test.T(t, img.Bounds(), ref.Bounds())
+	rect := ref.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r0, g0, b0, a0 := img.At(x, y).RGBA()
+			r1, g1, b1, a1 := ref.At(x, y).RGBA()
+			for i, c := range [][2]uint32{{r0, r1}, {g0, g1}, {b0, b1}, {a0, a1}} {
+				if d := int(c[0]>>8) - int(c[1]>>8); d < -tileTolerance || tileTolerance < d {
+					t.Fatalf("pixel (%d,%d) channel %d: %d != %d", x, y, i, c[0]>>8, c[1]>>8)
+				}
+			}
+		}
+	}
+}
+
+func TestDrawTiles(t *testing.T) {
+	c := testTileCanvas()
+	for _, resolution := range []canvas.DPMM{1.0, 3.0, 7.3, 10.0} {
+		t.Run(fmt.Sprint(resolution), func(t *testing.T) {
+			img := Draw(c, resolution)
+			tiled := image.NewRGBA(img.Rect)
+			n := 0
+			err := DrawTiles(c, resolution, 13, func(tile *image.RGBA) error {
+				test.That(t, tile.Rect.In(img.Rect), "tile must be within image")
+				test.That(t, tile.Rect.Dx() <= 13 && tile.Rect.Dy() <= 13, "tile must not be larger than tile size")
+				for y := tile.Rect.Min.Y; y < tile.Rect.Max.Y; y++ {
+					for x := tile.Rect.Min.X; x < tile.Rect.Max.X; x++ {
+						tiled.SetRGBA(x, y, tile.RGBAAt(x, y))
+					}
+				}
+				n++
+				return nil
+			})
+			test.Error(t, err)
+			test.T(t, n, ((img.Rect.Dx()+12)/13)*((img.Rect.Dy()+12)/13))
+			testImageDiff(t, tiled, img)
+		})
+	}
+
+	errStop := fmt.Errorf("stop")
+	test.T(t, DrawTiles(c, 1.0, 13, func(*image.RGBA) error { return errStop }), errStop)
+	test.That(t, DrawTiles(c, 1.0, 0, nil) != nil, "must return error for invalid tile size")
+}
+
+func TestTiledPNGWriter(t *testing.T) {
+	c := testTileCanvas()
+	buf := &bytes.Buffer{}
+	test.Error(t, TiledPNGWriter(10.0, 13)(buf, c))
+
+	img, err := png.Decode(buf)
+	test.Error(t, err)
+	testImageDiff(t, img, Draw(c, 10.0))
+}
+
+func TestDrawTilePyramid(t *testing.T) {
+	c := canvas.New(30.0, 20.0)
+	ctx := canvas.NewContext(c)
+	ctx.DrawPath(0.0, 0.0, canvas.Rectangle(30.0, 20.0))
+
+	tiles := []string{}
+	err := DrawTilePyramid(c, 16, 0, 2, func(z, x, y int, img *image.RGBA) error {
+		test.T(t, img.Rect, image.Rect(0, 0, 16, 16))
+		tiles = append(tiles, fmt.Sprintf("%d/%d/%d", z, x, y))
+		if z == 0 {
+			// the canvas fits the top-left of the tile
+			test.T(t, img.RGBAAt(0, 0), canvas.Black)
+			test.T(t, img.RGBAAt(15, 9), canvas.Black)
+			test.T(t, img.RGBAAt(15, 11), color.RGBA{})
+		}
+		return nil
+	})
+	test.Error(t, err)
+	test.T(t, tiles, []string{
+		"0/0/0",
+		"1/0/0", "1/1/0", "1/0/1", "1/1/1",
+		"2/0/0", "2/1/0", "2/2/0", "2/3/0",
+		"2/0/1", "2/1/1", "2/2/1", "2/3/1",
+		"2/0/2", "2/1/2", "2/2/2", "2/3/2",
+	})
+
+	test.That(t, DrawTilePyramid(c, 0, 0, 2, nil) != nil, "must return error for invalid tile size")
+	test.That(t, DrawTilePyramid(canvas.New(0.0, 10.0), 16, 0, 2, nil) != nil, "must return error for empty canvas")
+}
+
+func TestWriteTilePyramid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiles")
+	test.Error(t, err)
+	defer os.RemoveAll(dir)
+
+	c := canvas.New(30.0, 20.0)
+	test.Error(t, WriteTilePyramid(dir, c, 16, 1, 1))
+
+	for _, name := range []string{"1/0/0.png", "1/1/0.png", "1/0/1.png", "1/1/1.png"} {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		test.Error(t, err, name)
+		img, err := png.Decode(f)
+		f.Close()
+		test.Error(t, err, name)
+		test.T(t, img.Bounds(), image.Rect(0, 0, 16, 16), name)
+	}
+	_, err = os.Stat(filepath.Join(dir, "0"))
+	test.That(t, os.IsNotExist(err), "must skip zoom level 0")
+}
+
+func TestDrawTileStroke(t *testing.T) {
+	c := canvas.New(100.0, 100.0)
+	ctx := canvas.NewContext(c)
+	ctx.SetFillColor(canvas.Transparent)
+	ctx.SetStrokeColor(canvas.Black)
+	ctx.SetStrokeWidth(4.0)
+	ctx.SetStrokeJoiner(canvas.MiterJoin)
+	ctx.DrawPath(0.0, 0.0, canvas.MustParseSVG("M10 10L50 50L10 90"))
+
+	// the tile only contains the tip of the miter join, which reaches beyond half the stroke width
+	img := Draw(c, 10.0)
+	test.That(t, img.RGBAAt(522, 500).A != 0, "miter must be drawn")
+
+	rect := image.Rect(522, 490, 540, 510)
+	testImageDiff(t, DrawTile(c, 10.0, rect), img.SubImage(rect))
+}
@@ -1,6 +1,10 @@
 package rasterizer
 
 import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -35,3 +39,55 @@ func GIFWriter(resolution canvas.DPMM, opts *gif.Options) canvas.Writer {
 		return gif.Encode(w, img, opts)
 	}
 }
+
+// GIFDocumentWriter writes the document as an animated GIF file with a frame for each page, where delay is the time between frames in hundredths of a second. Use Document.WriteFiles with PNGWriter to write numbered files instead.
+func GIFDocumentWriter(resolution canvas.DPMM, delay int, opts *gif.Options) canvas.DocumentWriter {
+	return func(w io.Writer, d *canvas.Document) error {
+		if len(d.Pages) == 0 {
+			return canvas.ErrNoPages
+		}
+
+		anim := &gif.GIF{}
+		for _, page := range d.Pages {
+			img := Draw(page.Canvas, resolution)
+			anim.Image = append(anim.Image, palettedImage(img, opts))
+			anim.Delay = append(anim.Delay, delay)
+			anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+
+			// the logical screen fits the largest page
+			size := img.Bounds().Size()
+			if anim.Config.Width < size.X {
+				anim.Config.Width = size.X
+			}
+			if anim.Config.Height < size.Y {
+				anim.Config.Height = size.Y
+			}
+		}
+		return gif.EncodeAll(w, anim)
+	}
+}
+
+// palettedImage converts the image to a paletted image in the same way as gif.Encode.
+func palettedImage(img image.Image, opts *gif.Options) *image.Paletted {
+	numColors := 256
+	var quantizer draw.Quantizer
+	var drawer draw.Drawer = draw.FloydSteinberg
+	if opts != nil {
+		if 0 < opts.NumColors && opts.NumColors < 256 {
+			numColors = opts.NumColors
+		}
+		quantizer = opts.Quantizer
+		if opts.Drawer != nil {
+			drawer = opts.Drawer
+		}
+	}
+
+	pal := color.Palette(palette.Plan9[:numColors])
+	if quantizer != nil {
+		pal = quantizer.Quantize(make(color.Palette, 0, numColors), img)
+	}
+	bounds := img.Bounds()
+	pm := image.NewPaletted(bounds, pal)
+	drawer.Draw(pm, bounds, img, bounds.Min)
+	return pm
+}
@@ -0,0 +1,89 @@
+package canvas
+
+import (
+	"math"
+	"sort"
+)
+
+// rtreeCapacity is the maximum number of children of a node in the R-tree.
+const rtreeCapacity = 16
+
+// rtreeNode is a node of a static R-tree that indexes rectangles by their position, it is a leaf if it has no children and then refers to the rectangle with the given index.
+type rtreeNode struct {
+	bounds   Rect
+	index    int
+	children []*rtreeNode
+}
+
+// newRTree builds an R-tree of the rectangles with the given indices using the sort-tile-recursive packing algorithm, which gives nodes with little overlap for a fixed set of rectangles. It returns nil if there are no rectangles.
+func newRTree(rects []Rect, indices []int) *rtreeNode {
+	if len(rects) == 0 {
+		return nil
+	}
+
+	nodes := make([]*rtreeNode, len(rects))
+	for i, rect := range rects {
+		nodes[i] = &rtreeNode{bounds: rect, index: indices[i]}
+	}
+	for 1 < len(nodes) {
+		nodes = packRTreeNodes(nodes)
+	}
+	return nodes[0]
+}
+
+// packRTreeNodes groups the nodes into parent nodes of at most rtreeCapacity children, by sorting them in vertical slices along X and then in each slice along Y.
+func packRTreeNodes(nodes []*rtreeNode) []*rtreeNode {
+	n := (len(nodes) + rtreeCapacity - 1) / rtreeCapacity // number of parents
+	slices := int(math.Ceil(math.Sqrt(float64(n))))
+	sliceSize := slices * rtreeCapacity
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].bounds.X+nodes[i].bounds.W/2.0 < nodes[j].bounds.X+nodes[j].bounds.W/2.0
+	})
+
+	parents := make([]*rtreeNode, 0, n)
+	for i := 0; i < len(nodes); i += sliceSize {
+		slice := nodes[i:minInt(i+sliceSize, len(nodes))]
+		sort.SliceStable(slice, func(i, j int) bool {
+			return slice[i].bounds.Y+slice[i].bounds.H/2.0 < slice[j].bounds.Y+slice[j].bounds.H/2.0
+		})
+		for j := 0; j < len(slice); j += rtreeCapacity {
+			children := slice[j:minInt(j+rtreeCapacity, len(slice))]
+			bounds := children[0].bounds
+			for _, child := range children[1:] {
+				bounds = rtreeUnion(bounds, child.bounds)
+			}
+			parents = append(parents, &rtreeNode{bounds: bounds, children: children})
+		}
+	}
+	return parents
+}
+
+// search calls f with the index of every rectangle that overlaps with rect, in no particular order.
+func (n *rtreeNode) search(rect Rect, f func(int)) {
+	if n == nil || !n.bounds.Overlaps(rect) {
+		return
+	} else if n.children == nil {
+		f(n.index)
+		return
+	}
+	for _, child := range n.children {
+		child.search(rect, f)
+	}
+}
+
+// rtreeUnion returns the bounds of both rectangles, unlike Rect.Add it does not ignore rectangles of zero size such as those of horizontal or vertical lines.
+func rtreeUnion(a, b Rect) Rect {
+	x0 := math.Min(a.X, b.X)
+	y0 := math.Min(a.Y, b.Y)
+	x1 := math.Max(a.X+a.W, b.X+b.W)
+	y1 := math.Max(a.Y+a.H, b.Y+b.H)
+	return Rect{x0, y0, x1 - x0, y1 - y0}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,41 @@
+package canvas
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestRTree(t *testing.T) {
+	test.T(t, newRTree(nil, nil), (*rtreeNode)(nil))
+
+	rng := rand.New(rand.NewSource(0))
+	rects := make([]Rect, 1000)
+	indices := make([]int, len(rects))
+	for i := range rects {
+		rects[i] = Rect{X: rng.Float64() * 100.0, Y: rng.Float64() * 100.0, W: rng.Float64() * 5.0, H: rng.Float64() * 5.0}
+		indices[i] = 2 * i
+	}
+	rects[0].W = 0.0 // vertical line
+	rects[1].H = 0.0 // horizontal line
+	tree := newRTree(rects, indices)
+
+	queries := []Rect{rects[0], rects[1], {X: 10.0, Y: 20.0, W: 30.0, H: 15.0}, {X: 50.0, Y: 50.0}, {X: -10.0, Y: -10.0, W: 5.0, H: 5.0}}
+	for _, query := range queries {
+		expected := []int{}
+		for i, rect := range rects {
+			if rect.Overlaps(query) {
+				expected = append(expected, indices[i])
+			}
+		}
+
+		found := []int{}
+		tree.search(query, func(i int) {
+			found = append(found, i)
+		})
+		sort.Ints(found)
+		test.T(t, found, expected, query)
+	}
+}
@@ -0,0 +1,1078 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tdewolff/canvas"
+	"golang.org/x/image/colornames"
+)
+
+const mmPerPx = 25.4 / 96.0
+const ptPerMm = 72.0 / 25.4
+
+// node is an element in the SVG document tree, or character data if its name is empty.
+type node struct {
+	name     string
+	attrs    map[string]string
+	children []*node
+	text     string
+}
+
+// inheritedProperties are the properties that are inherited by child elements, besides the non-inherited presentation attributes.
+var inheritedProperties = map[string]bool{
+	"clip-rule":         true,
+	"color":             true,
+	"fill":              true,
+	"fill-opacity":      true,
+	"fill-rule":         true,
+	"font-family":       true,
+	"font-size":         true,
+	"font-style":        true,
+	"font-weight":       true,
+	"stroke":            true,
+	"stroke-dasharray":  true,
+	"stroke-dashoffset": true,
+	"stroke-linecap":    true,
+	"stroke-linejoin":   true,
+	"stroke-miterlimit": true,
+	"stroke-opacity":    true,
+	"stroke-width":      true,
+	"text-anchor":       true,
+	"visibility":        true,
+}
+
+var nonInheritedProperties = map[string]bool{
+	"clip-path":    true,
+	"display":      true,
+	"mask":         true,
+	"mask-type":    true,
+	"opacity":      true,
+	"stop-color":   true,
+	"stop-opacity": true,
+}
+
+// state is the drawing state of an element, consisting of the transformation from user units to millimeters of the canvas, the computed properties, the font size in user units, and the viewport size in user units that percentages refer to.
+type state struct {
+	m        canvas.Matrix
+	props    map[string]string
+	fontSize float64
+	vw, vh   float64
+}
+
+func (st *state) lengthX(s string) float64 {
+	return parseLength(s, st.vw, st.fontSize)
+}
+
+func (st *state) lengthY(s string) float64 {
+	return parseLength(s, st.vh, st.fontSize)
+}
+
+func (st *state) length(s string) float64 {
+	return parseLength(s, math.Sqrt((st.vw*st.vw+st.vh*st.vh)/2.0), st.fontSize)
+}
+
+type parser struct {
+	c     *canvas.Canvas
+	fonts []*canvas.FontFamily
+	ids   map[string]*node
+	depth int
+}
+
+// Parse parses an SVG document into a canvas. It supports paths, basic shapes, groups and nested SVGs with transformations, use elements referring to definitions and symbols, the fill and stroke properties including dashes and linear and radial gradients, opacity, clipping paths, masks, text and embedded images. Properties are read from presentation attributes and style attributes. Text is drawn using the font family whose name matches the font-family property, or the first given font family otherwise, and is skipped when no font families are given. Lengths in absolute units are converted using 96 pixels per inch.
+func Parse(r io.Reader, fonts ...*canvas.FontFamily) (*canvas.Canvas, error) {
+	root, err := parseTree(r)
+	if err != nil {
+		return nil, err
+	} else if root == nil || root.name != "svg" {
+		return nil, fmt.Errorf("bad SVG: root element must be svg")
+	}
+
+	p := &parser{
+		fonts: fonts,
+		ids:   map[string]*node{},
+	}
+	p.index(root)
+
+	// the viewport size is in pixels
+	vb, hasViewBox := parseViewBox(root.attrs["viewBox"])
+	width, height := 300.0, 150.0
+	if hasViewBox {
+		width, height = vb[2], vb[3]
+	}
+	if s := root.attrs["width"]; s != "" && !strings.HasSuffix(s, "%") {
+		width = parseLength(s, 0.0, 16.0)
+	}
+	if s := root.attrs["height"]; s != "" && !strings.HasSuffix(s, "%") {
+		height = parseLength(s, 0.0, 16.0)
+	}
+	if !hasViewBox {
+		vb = [4]float64{0.0, 0.0, width, height}
+	}
+
+	p.c = canvas.New(width*mmPerPx, height*mmPerPx)
+	st := &state{
+		m:        canvas.Identity.Translate(0.0, p.c.H).ReflectY().Scale(mmPerPx, mmPerPx).Mul(viewBoxMatrix(vb, width, height, root.attrs["preserveAspectRatio"])),
+		props:    map[string]string{},
+		fontSize: 16.0,
+		vw:       vb[2],
+		vh:       vb[3],
+	}
+	if err := p.renderChildren(root, p.state(root, st)); err != nil {
+		return nil, err
+	}
+	return p.c, nil
+}
+
+// parseTree parses the XML document into a tree of nodes and returns the root element. Attributes are stored by their local name, so that xlink:href is stored as href.
+func parseTree(r io.Reader) (*node, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+
+	var root *node
+	stack := []*node{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{
+				name:  t.Name.Local,
+				attrs: map[string]string{},
+			}
+			for _, attr := range t.Attr {
+				n.attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) == 0 {
+				if root == nil {
+					root = n
+				}
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if 0 < len(stack) {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if 0 < len(stack) {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, &node{text: string(t)})
+			}
+		}
+	}
+	return root, nil
+}
+
+func (p *parser) index(n *node) {
+	if id := n.attrs["id"]; id != "" {
+		if _, ok := p.ids[id]; !ok {
+			p.ids[id] = n
+		}
+	}
+	for _, child := range n.children {
+		p.index(child)
+	}
+}
+
+// reference returns the element referred to by a URL such as url(#id) or an href such as #id.
+func (p *parser) reference(s string) *node {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "url(") {
+		end := strings.IndexByte(s, ')')
+		if end == -1 {
+			return nil
+		}
+		s = strings.Trim(s[4:end], " '\"")
+	}
+	if !strings.HasPrefix(s, "#") {
+		return nil
+	}
+	return p.ids[s[1:]]
+}
+
+// state returns the state of an element given the state of its parent, which computes the properties from the presentation and style attributes.
+func (p *parser) state(n *node, parent *state) *state {
+	st := *parent
+	st.props = map[string]string{}
+	for name, value := range parent.props {
+		if inheritedProperties[name] {
+			st.props[name] = value
+		}
+	}
+	for name, value := range n.attrs {
+		if inheritedProperties[name] || nonInheritedProperties[name] {
+			st.props[name] = strings.TrimSpace(value)
+		}
+	}
+	for _, decl := range strings.Split(n.attrs["style"], ";") {
+		if i := strings.IndexByte(decl, ':'); i != -1 {
+			name := strings.TrimSpace(decl[:i])
+			value := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(decl[i+1:]), "!important"))
+			st.props[name] = value
+		}
+	}
+	for name, value := range st.props {
+		if value == "inherit" {
+			if parentValue, ok := parent.props[name]; ok {
+				st.props[name] = parentValue
+			} else {
+				delete(st.props, name)
+			}
+		}
+	}
+	if s, ok := st.props["font-size"]; ok {
+		if fontSize := parseLength(s, parent.fontSize, parent.fontSize); 0.0 < fontSize {
+			st.fontSize = fontSize
+		}
+		st.props["font-size"] = strconv.FormatFloat(st.fontSize, 'g', -1, 64)
+	}
+	return &st
+}
+
+func (p *parser) renderChildren(n *node, st *state) error {
+	for _, child := range n.children {
+		if err := p.render(child, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) render(n *node, parent *state) error {
+	switch n.name {
+	case "svg", "g", "a", "switch", "use", "path", "rect", "circle", "ellipse", "line", "polyline", "polygon", "text", "image":
+	default:
+		return nil
+	}
+
+	// guard against circular references of use elements
+	if 64 < p.depth {
+		return nil
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	st := p.state(n, parent)
+	if st.props["display"] == "none" {
+		return nil
+	}
+	if s, ok := n.attrs["transform"]; ok {
+		m, err := parseTransform(s)
+		if err != nil {
+			return err
+		}
+		st.m = st.m.Mul(m)
+	}
+
+	if _, ok := st.props["clip-path"]; ok {
+		clip := p.clipPath(st)
+		if clip == nil || clip.Empty() {
+			return nil
+		}
+		p.c.PushClip(clip, canvas.NonZero, st.m)
+		defer p.c.PopClip()
+	}
+	if _, ok := st.props["mask"]; ok {
+		mask := p.reference(st.props["mask"])
+		if mask == nil || mask.name != "mask" {
+			return nil
+		}
+		c := p.c
+		p.c = canvas.New(c.W, c.H)
+		err := p.renderChildren(mask, p.state(mask, st))
+		maskCanvas := p.c
+		p.c = c
+		if err != nil {
+			return err
+		}
+		maskType := canvas.LuminanceMask
+		if st.props["mask-type"] == "alpha" {
+			maskType = canvas.AlphaMask
+		}
+		// TODO: (SVG) support the mask attributes x, y, width and height
+		p.c.PushMask(maskCanvas, maskType, canvas.Identity)
+		defer p.c.PopMask()
+	}
+	if s, ok := st.props["opacity"]; ok {
+		if opacity := parseOpacity(s); opacity < 1.0 {
+			p.c.PushGroup(opacity, canvas.NormalBlend)
+			defer p.c.PopGroup()
+		}
+	}
+
+	switch n.name {
+	case "g", "a", "switch":
+		return p.renderChildren(n, st)
+	case "svg":
+		x, y := st.lengthX(n.attrs["x"]), st.lengthY(n.attrs["y"])
+		width, height := st.vw, st.vh
+		if s, ok := n.attrs["width"]; ok {
+			width = st.lengthX(s)
+		}
+		if s, ok := n.attrs["height"]; ok {
+			height = st.lengthY(s)
+		}
+		st.m = st.m.Translate(x, y)
+		st.vw, st.vh = width, height
+		if vb, ok := parseViewBox(n.attrs["viewBox"]); ok {
+			st.m = st.m.Mul(viewBoxMatrix(vb, width, height, n.attrs["preserveAspectRatio"]))
+			st.vw, st.vh = vb[2], vb[3]
+		}
+		return p.renderChildren(n, st)
+	case "use":
+		target := p.reference(n.attrs["href"])
+		if target == nil {
+			return nil
+		}
+		st.m = st.m.Translate(st.lengthX(n.attrs["x"]), st.lengthY(n.attrs["y"]))
+		if target.name == "symbol" {
+			width, height := st.vw, st.vh
+			if s, ok := n.attrs["width"]; ok {
+				width = st.lengthX(s)
+			}
+			if s, ok := n.attrs["height"]; ok {
+				height = st.lengthY(s)
+			}
+			st = p.state(target, st)
+			if vb, ok := parseViewBox(target.attrs["viewBox"]); ok {
+				st.m = st.m.Mul(viewBoxMatrix(vb, width, height, target.attrs["preserveAspectRatio"]))
+				st.vw, st.vh = vb[2], vb[3]
+			}
+			return p.renderChildren(target, st)
+		}
+		return p.render(target, st)
+	case "text":
+		p.drawText(n, st)
+		return nil
+	case "image":
+		return p.drawImage(n, st)
+	}
+
+	path, err := p.shape(n, st)
+	if err != nil || path == nil {
+		return err
+	}
+	p.drawPath(path, st)
+	return nil
+}
+
+// shape returns the path of a path or basic shape element in user units.
+func (p *parser) shape(n *node, st *state) (*canvas.Path, error) {
+	path := &canvas.Path{}
+	switch n.name {
+	case "path":
+		return canvas.ParseSVG(strings.TrimSpace(n.attrs["d"]))
+	case "rect":
+		x, y := st.lengthX(n.attrs["x"]), st.lengthY(n.attrs["y"])
+		w, h := st.lengthX(n.attrs["width"]), st.lengthY(n.attrs["height"])
+		if w <= 0.0 || h <= 0.0 {
+			return nil, nil
+		}
+		rxAttr, hasRx := n.attrs["rx"]
+		ryAttr, hasRy := n.attrs["ry"]
+		rx, ry := st.lengthX(rxAttr), st.lengthY(ryAttr)
+		if !hasRx {
+			rx = ry
+		} else if !hasRy {
+			ry = rx
+		}
+		rx, ry = math.Min(rx, w/2.0), math.Min(ry, h/2.0)
+		if rx <= 0.0 || ry <= 0.0 {
+			path.MoveTo(x, y)
+			path.LineTo(x+w, y)
+			path.LineTo(x+w, y+h)
+			path.LineTo(x, y+h)
+			path.Close()
+		} else {
+			path.MoveTo(x+rx, y)
+			path.LineTo(x+w-rx, y)
+			path.ArcTo(rx, ry, 0.0, false, true, x+w, y+ry)
+			path.LineTo(x+w, y+h-ry)
+			path.ArcTo(rx, ry, 0.0, false, true, x+w-rx, y+h)
+			path.LineTo(x+rx, y+h)
+			path.ArcTo(rx, ry, 0.0, false, true, x, y+h-ry)
+			path.LineTo(x, y+ry)
+			path.ArcTo(rx, ry, 0.0, false, true, x+rx, y)
+			path.Close()
+		}
+	case "circle":
+		r := st.length(n.attrs["r"])
+		if r <= 0.0 {
+			return nil, nil
+		}
+		path = canvas.Circle(r).Translate(st.lengthX(n.attrs["cx"]), st.lengthY(n.attrs["cy"]))
+	case "ellipse":
+		rx, ry := st.lengthX(n.attrs["rx"]), st.lengthY(n.attrs["ry"])
+		if rx <= 0.0 || ry <= 0.0 {
+			return nil, nil
+		}
+		path = canvas.Ellipse(rx, ry).Translate(st.lengthX(n.attrs["cx"]), st.lengthY(n.attrs["cy"]))
+	case "line":
+		path.MoveTo(st.lengthX(n.attrs["x1"]), st.lengthY(n.attrs["y1"]))
+		path.LineTo(st.lengthX(n.attrs["x2"]), st.lengthY(n.attrs["y2"]))
+	case "polyline", "polygon":
+		points := parseNumbers(n.attrs["points"])
+		if len(points) < 4 {
+			return nil, nil
+		}
+		path.MoveTo(points[0], points[1])
+		for i := 2; i+1 < len(points); i += 2 {
+			path.LineTo(points[i], points[i+1])
+		}
+		if n.name == "polygon" {
+			path.Close()
+		}
+	default:
+		return nil, nil
+	}
+	return path, nil
+}
+
+func (p *parser) drawPath(path *canvas.Path, st *state) {
+	if visibility := st.props["visibility"]; visibility == "hidden" || visibility == "collapse" {
+		return
+	}
+
+	bounds := path.Bounds()
+	style := canvas.DefaultStyle
+	fill, ok := st.props["fill"]
+	if !ok {
+		fill = "black"
+	}
+	style.FillColor, style.FillPaint = p.paint(fill, parseOpacity(st.props["fill-opacity"]), bounds, st)
+	if st.props["fill-rule"] == "evenodd" {
+		style.FillRule = canvas.EvenOdd
+	}
+
+	style.StrokeColor, style.StrokePaint = p.paint(st.props["stroke"], parseOpacity(st.props["stroke-opacity"]), bounds, st)
+	if s, ok := st.props["stroke-width"]; ok {
+		style.StrokeWidth = st.length(s)
+	}
+	switch st.props["stroke-linecap"] {
+	case "round":
+		style.StrokeCapper = canvas.RoundCap
+	case "square":
+		style.StrokeCapper = canvas.SquareCap
+	}
+	miterLimit := 4.0
+	if s, ok := st.props["stroke-miterlimit"]; ok {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && 1.0 <= f {
+			miterLimit = f
+		}
+	}
+	switch st.props["stroke-linejoin"] {
+	case "round":
+		style.StrokeJoiner = canvas.RoundJoin
+	case "bevel":
+		style.StrokeJoiner = canvas.BevelJoin
+	case "arcs":
+		style.StrokeJoiner = canvas.ArcsClipJoin(canvas.MiterClipJoin(canvas.BevelJoin, miterLimit), miterLimit)
+	default:
+		style.StrokeJoiner = canvas.MiterClipJoin(canvas.BevelJoin, miterLimit)
+	}
+	if s, ok := st.props["stroke-dasharray"]; ok && s != "none" {
+		dashes := []float64{}
+		valid := false
+		for _, dash := range strings.FieldsFunc(s, isSeparator) {
+			d := st.length(dash)
+			if d < 0.0 {
+				valid = false
+				break
+			} else if 0.0 < d {
+				valid = true
+			}
+			dashes = append(dashes, d)
+		}
+		if valid {
+			if len(dashes)%2 == 1 {
+				dashes = append(dashes, dashes...)
+			}
+			style.Dashes = dashes
+			style.DashOffset = st.length(st.props["stroke-dashoffset"])
+		}
+	}
+
+	if style.HasFill() || style.HasStroke() {
+		p.c.RenderPath(path, style, st.m)
+	}
+}
+
+// paint returns the color and paint of a fill or stroke property, where the paint is non-nil for references to gradients. The bounds are those of the path in user units and are used for gradients in bounding box units.
+func (p *parser) paint(s string, opacity float64, bounds canvas.Rect, st *state) (color.RGBA, canvas.Paint) {
+	if strings.HasPrefix(s, "url(") {
+		fallback := canvas.Black
+		if end := strings.IndexByte(s, ')'); end != -1 {
+			if col, ok := parseColor(s[end+1:], p.currentColor(st)); ok {
+				fallback = col
+			}
+		}
+		if paint := p.gradient(p.reference(s), opacity, bounds, st); paint != nil {
+			return scaleAlpha(fallback, opacity), paint
+		}
+		return scaleAlpha(fallback, opacity), nil
+	}
+	col, _ := parseColor(s, p.currentColor(st))
+	return scaleAlpha(col, opacity), nil
+}
+
+func (p *parser) currentColor(st *state) color.RGBA {
+	if col, ok := parseColor(st.props["color"], canvas.Black); ok {
+		return col
+	}
+	return canvas.Black
+}
+
+// gradient returns the linear or radial gradient of an element, where attributes and stops that are not specified are inherited from the referenced gradient. The gradient transformation is applied to the gradient's points and radii, which is exact for rotations, translations and uniform scaling. Radii in bounding box units are relative to the average of the bounding box width and height.
+func (p *parser) gradient(n *node, opacity float64, bounds canvas.Rect, st *state) canvas.Paint {
+	if n == nil || n.name != "linearGradient" && n.name != "radialGradient" {
+		return nil
+	}
+
+	attrs := map[string]string{}
+	stops := []*node{}
+	for ref, i := n, 0; ref != nil && i < 16; i++ {
+		for name, value := range ref.attrs {
+			if _, ok := attrs[name]; !ok {
+				attrs[name] = value
+			}
+		}
+		if len(stops) == 0 {
+			for _, child := range ref.children {
+				if child.name == "stop" {
+					stops = append(stops, child)
+				}
+			}
+		}
+		ref = p.reference(ref.attrs["href"])
+	}
+	if len(stops) == 0 {
+		return nil
+	}
+
+	bbox := attrs["gradientUnits"] != "userSpaceOnUse"
+	coord := func(name, def string, offset, size, ref float64) float64 {
+		s, ok := attrs[name]
+		if !ok {
+			s = def
+		}
+		if bbox {
+			return offset + parseLength(s, 1.0, 1.0)*size
+		}
+		return parseLength(s, ref, st.fontSize)
+	}
+	diag := math.Sqrt((st.vw*st.vw + st.vh*st.vh) / 2.0)
+
+	m := canvas.Identity
+	if s, ok := attrs["gradientTransform"]; ok {
+		if t, err := parseTransform(s); err == nil {
+			m = t
+		}
+	}
+
+	var paint canvas.Paint
+	var gradient *canvas.Gradient
+	if n.name == "linearGradient" {
+		p0 := m.Dot(canvas.Point{
+			X: coord("x1", "0%", bounds.X, bounds.W, st.vw),
+			Y: coord("y1", "0%", bounds.Y, bounds.H, st.vh),
+		})
+		p1 := m.Dot(canvas.Point{
+			X: coord("x2", "100%", bounds.X, bounds.W, st.vw),
+			Y: coord("y2", "0%", bounds.Y, bounds.H, st.vh),
+		})
+		linear := canvas.NewLinearGradient(p0.X, p0.Y, p1.X, p1.Y)
+		paint, gradient = linear, &linear.Gradient
+	} else {
+		cx, cy := coord("cx", "50%", bounds.X, bounds.W, st.vw), coord("cy", "50%", bounds.Y, bounds.H, st.vh)
+		fx, fy := cx, cy
+		if _, ok := attrs["fx"]; ok {
+			fx = coord("fx", "", bounds.X, bounds.W, st.vw)
+		}
+		if _, ok := attrs["fy"]; ok {
+			fy = coord("fy", "", bounds.Y, bounds.H, st.vh)
+		}
+		r := coord("r", "50%", 0.0, (bounds.W+bounds.H)/2.0, diag)
+		fr := coord("fr", "0%", 0.0, (bounds.W+bounds.H)/2.0, diag)
+
+		scale := math.Sqrt(math.Abs(m.Det()))
+		c0, c1 := m.Dot(canvas.Point{X: fx, Y: fy}), m.Dot(canvas.Point{X: cx, Y: cy})
+		radial := canvas.NewRadialGradient(c0.X, c0.Y, fr*scale, c1.X, c1.Y, r*scale)
+		paint, gradient = radial, &radial.Gradient
+	}
+
+	switch attrs["spreadMethod"] {
+	case "reflect":
+		gradient.Spread = canvas.ReflectSpread
+	case "repeat":
+		gradient.Spread = canvas.RepeatSpread
+	}
+
+	offset := 0.0
+	for _, stop := range stops {
+		stopState := p.state(stop, st)
+		offset = math.Max(offset, parseLength(stop.attrs["offset"], 1.0, 1.0))
+		stopColor, ok := stopState.props["stop-color"]
+		if !ok {
+			stopColor = "black"
+		}
+		col, _ := parseColor(stopColor, p.currentColor(stopState))
+		gradient.Add(offset, scaleAlpha(col, opacity*parseOpacity(stopState.props["stop-opacity"])))
+	}
+	return paint
+}
+
+// clipPath returns the clipping path of an element in user units, which is the union of the clipping path's children. It returns nil if the reference is invalid.
+func (p *parser) clipPath(st *state) *canvas.Path {
+	n := p.reference(st.props["clip-path"])
+	if n == nil || n.name != "clipPath" {
+		return nil
+	}
+	// TODO: (SVG) support clipPathUnits="objectBoundingBox"
+
+	clipState := p.state(n, st)
+	clip := &canvas.Path{}
+	for _, child := range n.children {
+		childState := p.state(child, clipState)
+		if childState.props["display"] == "none" {
+			continue
+		}
+		path, err := p.shape(child, childState)
+		if err != nil || path == nil {
+			continue
+		}
+		if s, ok := child.attrs["transform"]; ok {
+			if m, err := parseTransform(s); err == nil {
+				path = path.Transform(m)
+			}
+		}
+		if childState.props["clip-rule"] == "evenodd" {
+			path = path.Settle(canvas.EvenOdd)
+		}
+		if clip.Empty() {
+			clip = path.Settle(canvas.NonZero)
+		} else {
+			clip = clip.Or(path)
+		}
+	}
+	if s, ok := n.attrs["transform"]; ok {
+		if m, err := parseTransform(s); err == nil {
+			clip = clip.Transform(m)
+		}
+	}
+	return clip
+}
+
+func (p *parser) drawText(n *node, st *state) {
+	if len(p.fonts) == 0 {
+		return
+	}
+	x, y := 0.0, 0.0
+	p.drawTextSpan(n, st, &x, &y, true)
+}
+
+// drawTextSpan draws the character data of a text or tspan element, where x and y hold the current text position that is advanced by each drawn text.
+func (p *parser) drawTextSpan(n *node, st *state, x, y *float64, chunkStart bool) bool {
+	if s := strings.FieldsFunc(n.attrs["x"], isSeparator); 0 < len(s) {
+		*x = st.lengthX(s[0])
+		chunkStart = true
+	}
+	if s := strings.FieldsFunc(n.attrs["y"], isSeparator); 0 < len(s) {
+		*y = st.lengthY(s[0])
+	}
+	if s := strings.FieldsFunc(n.attrs["dx"], isSeparator); 0 < len(s) {
+		*x += st.lengthX(s[0])
+	}
+	if s := strings.FieldsFunc(n.attrs["dy"], isSeparator); 0 < len(s) {
+		*y += st.lengthY(s[0])
+	}
+
+	for _, child := range n.children {
+		if child.name == "tspan" {
+			childState := p.state(child, st)
+			if childState.props["display"] != "none" {
+				chunkStart = p.drawTextSpan(child, childState, x, y, chunkStart)
+			}
+			continue
+		} else if child.name != "" {
+			continue
+		}
+
+		// collapse white space
+		s := strings.Join(strings.FieldsFunc(child.text, unicode.IsSpace), " ")
+		if s == "" {
+			continue
+		}
+		if unicode.IsSpace(rune(child.text[0])) && !chunkStart {
+			s = " " + s
+		}
+		if unicode.IsSpace(rune(child.text[len(child.text)-1])) {
+			s += " "
+		}
+		chunkStart = false
+		p.drawTextRun(s, st, x, y)
+	}
+	return chunkStart
+}
+
+func (p *parser) drawTextRun(s string, st *state, x, y *float64) {
+	fill, ok := st.props["fill"]
+	if !ok {
+		fill = "black"
+	}
+	col, _ := p.paint(fill, parseOpacity(st.props["fill-opacity"]), canvas.Rect{}, st)
+	face := p.fontFamily(st).Face(st.fontSize*ptPerMm, col, fontStyle(st), canvas.FontNormal)
+
+	halign := canvas.Left
+	switch st.props["text-anchor"] {
+	case "middle":
+		halign = canvas.Center
+	case "end":
+		halign = canvas.Right
+	}
+	if visibility := st.props["visibility"]; col.A != 0 && visibility != "hidden" && visibility != "collapse" {
+		p.c.RenderText(canvas.NewTextLine(face, s, halign), st.m.Translate(*x, *y).ReflectY())
+	}
+
+	// subsequent text is placed after the text, which is approximate for centered text
+	if halign == canvas.Left {
+		*x += face.TextWidth(s)
+	} else if halign == canvas.Center {
+		*x += face.TextWidth(s) / 2.0
+	}
+}
+
+func (p *parser) fontFamily(st *state) *canvas.FontFamily {
+	for _, name := range strings.Split(st.props["font-family"], ",") {
+		name = strings.Trim(strings.TrimSpace(name), "'\"")
+		for _, family := range p.fonts {
+			if strings.EqualFold(family.Name(), name) {
+				return family
+			}
+		}
+	}
+	return p.fonts[0]
+}
+
+func fontStyle(st *state) canvas.FontStyle {
+	style := canvas.FontRegular
+	switch st.props["font-weight"] {
+	case "100":
+		style = canvas.FontExtraLight
+	case "200", "lighter":
+		style = canvas.FontLight
+	case "300":
+		style = canvas.FontBook
+	case "500":
+		style = canvas.FontMedium
+	case "600":
+		style = canvas.FontSemibold
+	case "700", "bold", "bolder":
+		style = canvas.FontBold
+	case "800":
+		style = canvas.FontBlack
+	case "900":
+		style = canvas.FontExtraBlack
+	}
+	if fontStyle := st.props["font-style"]; fontStyle == "italic" || fontStyle == "oblique" {
+		style |= canvas.FontItalic
+	}
+	return style
+}
+
+// drawImage draws an image that is embedded as a data URI.
+func (p *parser) drawImage(n *node, st *state) error {
+	href := n.attrs["href"]
+	if !strings.HasPrefix(href, "data:") {
+		// TODO: (SVG) support external images
+		return nil
+	}
+	i := strings.IndexByte(href, ',')
+	if i == -1 {
+		return fmt.Errorf("bad image: invalid data URI")
+	}
+
+	var b []byte
+	var err error
+	if strings.HasSuffix(href[:i], ";base64") {
+		data := strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) || r == '=' {
+				return -1
+			}
+			return r
+		}, href[i+1:])
+		b, err = base64.RawStdEncoding.DecodeString(data)
+	} else {
+		var data string
+		data, err = url.PathUnescape(href[i+1:])
+		b = []byte(data)
+	}
+	if err != nil {
+		return fmt.Errorf("bad image: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("bad image: %v", err)
+	}
+
+	size := img.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return nil
+	}
+	iw, ih := float64(size.X), float64(size.Y)
+	x, y := st.lengthX(n.attrs["x"]), st.lengthY(n.attrs["y"])
+	w, h := iw, ih
+	if s, ok := n.attrs["width"]; ok && s != "auto" {
+		w = st.lengthX(s)
+	}
+	if s, ok := n.attrs["height"]; ok && s != "auto" {
+		h = st.lengthY(s)
+	}
+	if w <= 0.0 || h <= 0.0 {
+		return nil
+	}
+
+	// images are drawn with the first row at the top, which is at y=0 in user units
+	m := st.m.Translate(x, y).Mul(viewBoxMatrix([4]float64{0.0, 0.0, iw, ih}, w, h, n.attrs["preserveAspectRatio"]))
+	p.c.RenderImage(img, m.Translate(0.0, ih).ReflectY())
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
+
+func isSeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+}
+
+// parseNumbers parses a list of numbers separated by white space and/or commas, it stops at the first invalid number.
+func parseNumbers(s string) []float64 {
+	nums := []float64{}
+	for _, field := range strings.FieldsFunc(s, isSeparator) {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			break
+		}
+		nums = append(nums, f)
+	}
+	return nums
+}
+
+// parseLength parses a length and returns it in user units, where percentages are relative to ref and font-relative units to the font size. It returns zero for invalid lengths.
+func parseLength(s string, ref, fontSize float64) float64 {
+	s = strings.TrimSpace(s)
+	i := len(s)
+	for 0 < i && ('a' <= s[i-1] && s[i-1] <= 'z' || s[i-1] == '%') {
+		i--
+	}
+	f, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0.0
+	}
+	switch s[i:] {
+	case "mm":
+		f /= mmPerPx
+	case "cm":
+		f *= 10.0 / mmPerPx
+	case "in":
+		f *= 96.0
+	case "pt":
+		f *= 96.0 / 72.0
+	case "pc":
+		f *= 16.0
+	case "em":
+		f *= fontSize
+	case "ex":
+		f *= fontSize / 2.0
+	case "%":
+		f *= ref / 100.0
+	}
+	return f
+}
+
+// parseOpacity parses an opacity as a number or percentage, it returns one for empty or invalid values.
+func parseOpacity(s string) float64 {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = s[:len(s)-1]
+		scale = 0.01
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 1.0
+	}
+	return math.Max(0.0, math.Min(1.0, f*scale))
+}
+
+// parseColor parses a CSS color, where none returns a transparent color. It returns false for invalid colors.
+func parseColor(s string, currentColor color.RGBA) (color.RGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "none" || s == "transparent" {
+		return canvas.Transparent, true
+	} else if s == "currentcolor" {
+		return currentColor, true
+	} else if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			hex2 := make([]byte, 0, 2*len(hex))
+			for i := 0; i < len(hex); i++ {
+				hex2 = append(hex2, hex[i], hex[i])
+			}
+			hex = string(hex2)
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 8 || err != nil {
+			return canvas.Transparent, false
+		}
+		return rgba(float64(v>>24), float64(v>>16&0xff), float64(v>>8&0xff), float64(v&0xff)/255.0), true
+	} else if strings.HasPrefix(s, "rgb(") || strings.HasPrefix(s, "rgba(") {
+		start, end := strings.IndexByte(s, '('), strings.IndexByte(s, ')')
+		if end == -1 {
+			return canvas.Transparent, false
+		}
+		args := strings.FieldsFunc(s[start+1:end], func(r rune) bool {
+			return isSeparator(r) || r == '/'
+		})
+		if len(args) != 3 && len(args) != 4 {
+			return canvas.Transparent, false
+		}
+		var v [4]float64
+		v[3] = 1.0
+		for i, arg := range args {
+			if i == 3 {
+				v[i] = parseOpacity(arg)
+			} else if strings.HasSuffix(arg, "%") {
+				v[i] = parseLength(arg, 255.0, 0.0)
+			} else if f, err := strconv.ParseFloat(arg, 64); err == nil {
+				v[i] = f
+			} else {
+				return canvas.Transparent, false
+			}
+		}
+		return rgba(v[0], v[1], v[2], v[3]), true
+	} else if col, ok := colornames.Map[s]; ok {
+		return col, true
+	}
+	return canvas.Transparent, false
+}
+
+// rgba returns the alpha-premultiplied color of red, green and blue components in [0,255] and alpha in [0,1].
+func rgba(r, g, b, a float64) color.RGBA {
+	clamp := func(f float64) uint8 {
+		return uint8(math.Max(0.0, math.Min(255.0, f))*a + 0.5)
+	}
+	return color.RGBA{clamp(r), clamp(g), clamp(b), uint8(math.Max(0.0, math.Min(1.0, a))*255.0 + 0.5)}
+}
+
+func scaleAlpha(col color.RGBA, f float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(col.R)*f + 0.5),
+		G: uint8(float64(col.G)*f + 0.5),
+		B: uint8(float64(col.B)*f + 0.5),
+		A: uint8(float64(col.A)*f + 0.5),
+	}
+}
+
+// parseViewBox parses a view box of the form min-x min-y width height, where width and height must be positive.
+func parseViewBox(s string) ([4]float64, bool) {
+	nums := parseNumbers(s)
+	if len(nums) != 4 || nums[2] <= 0.0 || nums[3] <= 0.0 {
+		return [4]float64{}, false
+	}
+	return [4]float64{nums[0], nums[1], nums[2], nums[3]}, true
+}
+
+// viewBoxMatrix returns the transformation from the view box to a viewport of the given width and height, using the preserveAspectRatio attribute.
+func viewBoxMatrix(vb [4]float64, width, height float64, preserveAspectRatio string) canvas.Matrix {
+	sx, sy := width/vb[2], height/vb[3]
+	align, slice := "xmidymid", false
+	if fields := strings.Fields(strings.ToLower(preserveAspectRatio)); 0 < len(fields) {
+		align = fields[0]
+		slice = 1 < len(fields) && fields[1] == "slice"
+	}
+	if align == "none" {
+		return canvas.Identity.Scale(sx, sy).Translate(-vb[0], -vb[1])
+	}
+
+	s := math.Min(sx, sy)
+	if slice {
+		s = math.Max(sx, sy)
+	}
+	tx, ty := 0.0, 0.0
+	if strings.Contains(align, "xmid") {
+		tx = (width - vb[2]*s) / 2.0
+	} else if strings.Contains(align, "xmax") {
+		tx = width - vb[2]*s
+	}
+	if strings.Contains(align, "ymid") {
+		ty = (height - vb[3]*s) / 2.0
+	} else if strings.Contains(align, "ymax") {
+		ty = height - vb[3]*s
+	}
+	return canvas.Identity.Translate(tx, ty).Scale(s, s).Translate(-vb[0], -vb[1])
+}
+
+// parseTransform parses the transform attribute, consisting of a list of matrix, translate, scale, rotate, skewX and skewY transformations.
+func parseTransform(s string) (canvas.Matrix, error) {
+	m := canvas.Identity
+	for s = strings.TrimSpace(s); s != ""; {
+		start, end := strings.IndexByte(s, '('), strings.IndexByte(s, ')')
+		if start == -1 || end < start {
+			return canvas.Identity, fmt.Errorf("bad transform: %s", s)
+		}
+		name := strings.TrimSpace(s[:start])
+		args := parseNumbers(s[start+1 : end])
+		s = strings.TrimLeftFunc(s[end+1:], isSeparator)
+
+		n := len(args)
+		switch {
+		case name == "matrix" && n == 6:
+			m = m.Mul(canvas.Matrix{
+				{args[0], args[2], args[4]},
+				{args[1], args[3], args[5]},
+			})
+		case name == "translate" && (n == 1 || n == 2):
+			if n == 1 {
+				args = append(args, 0.0)
+			}
+			m = m.Translate(args[0], args[1])
+		case name == "scale" && (n == 1 || n == 2):
+			if n == 1 {
+				args = append(args, args[0])
+			}
+			m = m.Scale(args[0], args[1])
+		case name == "rotate" && n == 1:
+			m = m.Rotate(args[0])
+		case name == "rotate" && n == 3:
+			m = m.RotateAbout(args[0], args[1], args[2])
+		case name == "skewX" && n == 1:
+			m = m.Shear(math.Tan(args[0]*math.Pi/180.0), 0.0)
+		case name == "skewY" && n == 1:
+			m = m.Shear(0.0, math.Tan(args[0]*math.Pi/180.0))
+		default:
+			return canvas.Identity, fmt.Errorf("bad transform: %s", name)
+		}
+	}
+	return m, nil
+}
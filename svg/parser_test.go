@@ -0,0 +1,171 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+)
+
+func parseAndWrite(t *testing.T, s string, fonts ...*canvas.FontFamily) string {
+	c, err := Parse(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="10mm" height="10mm" viewBox="0 0 10 10">`+s+`</svg>`), fonts...)
+	test.Error(t, err)
+
+	buf := &bytes.Buffer{}
+	test.Error(t, Writer(buf, c))
+	out := buf.String()
+	out = out[strings.Index(out, ">")+1:]
+	return out[:len(out)-len("</svg>")]
+}
+
+func TestParse(t *testing.T) {
+	var tts = []struct {
+		svg      string
+		expected string
+	}{
+		{`<path d="M0 0L2 0L2 2z"/>`, `<path d="M0 0H2V2z"/>`},
+		{`<rect x="1" y="1" width="4" height="2" fill="red" stroke="#00f" stroke-width=".5"/>`, `<path d="M1 1H5V3H1z" style="fill:#f00;stroke:#00f;stroke-width:.5;stroke-miterlimit:16"/>`},
+		{`<rect width="4" height="2" rx="1"/>`, `<path d="M1 0H3A1 1 0 014 1A1 1 0 013 2H1A1 1 0 010 1A1 1 0 011 0z"/>`},
+		{`<circle cx="2" cy="2" r="1" style="fill:rgb(0,255,0);fill-opacity:50%"/>`, `<path d="M3 2A1 1 0 011 2A1 1 0 013 2z" fill="rgba(0,255,0,.50196078)"/>`},
+		{`<ellipse cx="2" cy="2" rx="2" ry="1" fill="none" stroke="black" stroke-width="0.5" stroke-linecap="round"/>`, `<path d="M4 2A2 1 0 010 2A2 1 0 014 2z" style="fill:none;stroke:#000;stroke-width:.5;stroke-linecap:round;stroke-miterlimit:16"/>`},
+		{`<line x1="0" y1="0" x2="10" y2="10" stroke="black" stroke-dasharray="1 2"/>`, `<path d="M0 0L10 10" style="stroke:#000;stroke-miterlimit:8;stroke-dasharray:1 2"/>`},
+		{`<polygon points="0,0 2,0 2,2" fill-rule="evenodd"/>`, `<path d="M0 0H2V2z" fill-rule="evenodd"/>`},
+		{`<polyline points="0 0 2 0 2 2" fill="none" stroke="currentColor" color="blue"/>`, `<path d="M0 0H2V2" style="fill:none;stroke:#00f;stroke-miterlimit:8"/>`},
+		{`<g transform="translate(1,2) scale(2)" fill="red"><path d="M0 0L2 0L2 2z"/></g>`, `<path d="M1 2H5V6z" fill="#f00"/>`},
+		{`<g style="fill:blue"><path d="M0 0L2 0L2 2z" fill="red"/><path d="M0 0L2 0L2 2z" style="fill:inherit"/></g>`, `<path d="M0 0H2V2z" fill="#f00"/><path d="M0 0H2V2z" fill="#00f"/>`},
+		{`<defs><path id="p" d="M0 0L2 0L2 2z"/></defs><use href="#p" x="5" y="5" fill="blue"/><use xlink:href="#p"/>`, `<path d="M5 5H7V7z" fill="#00f"/><path d="M0 0H2V2z"/>`},
+		{`<symbol id="s" viewBox="0 0 1 1"><path d="M0 0L1 0L1 1z"/></symbol><use href="#s" width="2" height="2"/>`, `<path d="M0 0H2V2z"/>`},
+		{`<svg x="1" y="1" width="2" height="2" viewBox="0 0 1 1"><path d="M0 0L1 0L1 1z"/></svg>`, `<path d="M1 1H3V3z"/>`},
+		{`<path d="M0 0L2 0L2 2z" display="none"/><g style="display:none"><path d="M0 0L2 0L2 2z"/></g>`, ``},
+		{`<g opacity="0.5"><path d="M0 0L2 0L2 2z"/></g>`, `<g opacity=".5" style="isolation:isolate"><path d="M0 0H2V2z"/></g>`},
+		{`<clipPath id="c"><rect width="5" height="5"/></clipPath><path d="M0 0L10 0L10 10z" clip-path="url(#c)"/>`, `<clipPath id="c0"><path d="M0 0H5V5H0z"/></clipPath><g clip-path="url(#c0)"><path d="M0 0H10V10z"/></g>`},
+		{`<linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="100%" stop-color="blue"/></linearGradient><rect width="10" height="5" fill="url(#g)"/>`, `<linearGradient id="g0" gradientUnits="userSpaceOnUse" x1="0" y1="0" x2="10" y2="0" gradientTransform="matrix(1 0 0 1 0 0)"><stop offset="0" stop-color="#f00"/><stop offset="1" stop-color="#00f"/></linearGradient><path d="M0 0H10V5H0z" fill="url(#g0)"/>`},
+		{`<radialGradient id="g" gradientUnits="userSpaceOnUse" cx="5" cy="5" r="5"><stop offset="0" style="stop-color:red"/><stop offset="1" stop-color="blue" stop-opacity="0"/></radialGradient><rect width="10" height="10" fill="url(#g)"/>`, `<radialGradient id="g0" gradientUnits="userSpaceOnUse" cx="5" cy="5" r="5" gradientTransform="matrix(1 0 0 1 0 0)"><stop offset="0" stop-color="#f00"/><stop offset="1" stop-color="#000" stop-opacity="0"/></radialGradient><path d="M0 0H10V10H0z" fill="url(#g0)"/>`},
+	}
+	for _, tt := range tts {
+		t.Run(tt.svg, func(t *testing.T) {
+			test.String(t, parseAndWrite(t, tt.svg), tt.expected)
+		})
+	}
+}
+
+func TestParseText(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("DejaVu Serif")
+	dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular)
+
+	s := parseAndWrite(t, `<text x="1" y="5" font-size="3" font-family="Arial, 'DejaVu Serif'">Hi <tspan fill="red">there</tspan></text>`, dejaVuSerif)
+	s = s[:strings.Index(s, "<style>")]
+	test.String(t, s, `<text x="1" y="5" style="font: 3px DejaVu Serif"><tspan x="1" y="5">Hi </tspan></text><text x="5.515625" y="5" style="font: 3px DejaVu Serif;fill:#f00"><tspan x="5.515625" y="5">there</tspan></text>`)
+
+	// text is skipped without fonts
+	test.String(t, parseAndWrite(t, `<text x="1" y="5">Hi</text>`), ``)
+}
+
+func TestParseImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	buf := &bytes.Buffer{}
+	test.Error(t, png.Encode(buf, img))
+	href := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	c, err := Parse(strings.NewReader(`<svg width="10mm" height="10mm" viewBox="0 0 10 10"><image x="1" y="1" width="4" height="4" href="` + href + `"/></svg>`))
+	test.Error(t, err)
+
+	r := &imageRecorder{}
+	c.Render(r)
+	test.T(t, len(r.images), 1)
+	test.T(t, r.images[0].Bounds(), image.Rect(0, 0, 2, 1))
+	test.That(t, r.ms[0].Equals(canvas.Identity.Translate(1.0, 6.0).Scale(2.0, 2.0)), "image is centered in its viewport")
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse(strings.NewReader(`<html/>`))
+	test.That(t, err != nil)
+	_, err = Parse(strings.NewReader(`<svg><path d="0 0"/></svg>`))
+	test.That(t, err != nil)
+	_, err = Parse(strings.NewReader(`<svg><path d="M0 0L1 1" transform="spin(1)"/></svg>`))
+	test.That(t, err != nil)
+}
+
+func TestParseColor(t *testing.T) {
+	var tts = []struct {
+		s   string
+		col color.RGBA
+	}{
+		{"red", canvas.Red},
+		{"#f00", canvas.Red},
+		{"#FF0000", canvas.Red},
+		{"#ff000080", color.RGBA{128, 0, 0, 128}},
+		{"rgb(255, 0, 0)", canvas.Red},
+		{"rgb(100%,0%,0%)", canvas.Red},
+		{"rgba(255,0,0,0.5)", color.RGBA{128, 0, 0, 128}},
+		{"rgb(255 0 0 / 50%)", color.RGBA{128, 0, 0, 128}},
+		{"none", canvas.Transparent},
+		{"currentColor", canvas.Blue},
+	}
+	for _, tt := range tts {
+		t.Run(tt.s, func(t *testing.T) {
+			col, ok := parseColor(tt.s, canvas.Blue)
+			test.That(t, ok)
+			test.T(t, col, tt.col)
+		})
+	}
+
+	_, ok := parseColor("foo", canvas.Blue)
+	test.That(t, !ok)
+}
+
+func TestParseTransform(t *testing.T) {
+	var tts = []struct {
+		s string
+		m canvas.Matrix
+	}{
+		{"translate(1)", canvas.Identity.Translate(1.0, 0.0)},
+		{"translate(1,2) scale(2)", canvas.Identity.Translate(1.0, 2.0).Scale(2.0, 2.0)},
+		{"matrix(1 2 3 4 5 6)", canvas.Matrix{{1.0, 3.0, 5.0}, {2.0, 4.0, 6.0}}},
+		{"rotate(90 1 1)", canvas.Identity.RotateAbout(90.0, 1.0, 1.0)},
+		{"skewX(45)", canvas.Identity.Shear(1.0, 0.0)},
+	}
+	for _, tt := range tts {
+		t.Run(tt.s, func(t *testing.T) {
+			m, err := parseTransform(tt.s)
+			test.Error(t, err)
+			test.T(t, m, tt.m)
+		})
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	test.Float(t, parseLength("10", 0.0, 16.0), 10.0)
+	test.Float(t, parseLength("1in", 0.0, 16.0), 96.0)
+	test.Float(t, parseLength("25.4mm", 0.0, 16.0), 96.0)
+	test.Float(t, parseLength("2em", 0.0, 16.0), 32.0)
+	test.Float(t, parseLength("50%", 300.0, 16.0), 150.0)
+	test.Float(t, parseLength("foo", 0.0, 16.0), 0.0)
+}
+
+type imageRecorder struct {
+	images []image.Image
+	ms     []canvas.Matrix
+}
+
+func (r *imageRecorder) Size() (float64, float64)                                              { return 10.0, 10.0 }
+func (r *imageRecorder) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)     {}
+func (r *imageRecorder) RenderText(text *canvas.Text, m canvas.Matrix)                         {}
+func (r *imageRecorder) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {}
+func (r *imageRecorder) PopClip()                                                              {}
+func (r *imageRecorder) PushGroup(opacity float64, blendMode canvas.BlendMode)                 {}
+func (r *imageRecorder) PopGroup()                                                             {}
+func (r *imageRecorder) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+}
+func (r *imageRecorder) PopMask() {}
+func (r *imageRecorder) RenderImage(img image.Image, m canvas.Matrix) {
+	r.images = append(r.images, img)
+	r.ms = append(r.ms, m)
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"html"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"math"
+	"sort"
 	"strings"
 
 	"github.com/tdewolff/canvas"
@@ -19,11 +21,16 @@ type SVG struct {
 	w             io.Writer
 	width, height float64
 	embedFonts    bool
-	fonts         map[*canvas.Font]bool
+	fonts         []*canvas.Font
+	fontGlyphs    map[*canvas.Font]map[uint16]bool
 	maskID        int
+	gradientID    int
+	clipID        int
+	patternID     int
 	imgEnc        canvas.ImageEncoding
 
 	classes []string
+	pages   []canvas.Point // sizes of the enclosing views of the opened pages
 }
 
 // New creates a scalable vector graphics (SVG) renderer.
@@ -34,14 +41,21 @@ func New(w io.Writer, width, height float64) *SVG {
 		width:      width,
 		height:     height,
 		embedFonts: true,
-		fonts:      map[*canvas.Font]bool{},
+		fonts:      []*canvas.Font{},
+		fontGlyphs: map[*canvas.Font]map[uint16]bool{},
 		maskID:     0,
+		gradientID: 0,
+		clipID:     0,
+		patternID:  0,
 		imgEnc:     canvas.Lossless,
 		classes:    []string{},
 	}
 }
 
 func (r *SVG) Close() error {
+	if r.embedFonts {
+		r.writeFonts()
+	}
 	_, err := fmt.Fprintf(r.w, "</svg>")
 	return err
 }
@@ -81,36 +95,241 @@ func (r *SVG) SetImageEncoding(enc canvas.ImageEncoding) {
 	r.imgEnc = enc
 }
 
-func (r *SVG) writeFonts(fonts []*canvas.Font) {
-	is := []int{}
-	for i, font := range fonts {
-		if _, ok := r.fonts[font]; !ok {
-			is = append(is, i)
-			r.fonts[font] = true
-		}
+// addGlyphs marks the glyphs of the text as used so that they are embedded in the font subset.
+func (r *SVG) addGlyphs(font *canvas.Font, text string) {
+	glyphs, ok := r.fontGlyphs[font]
+	if !ok {
+		glyphs = map[uint16]bool{}
+		r.fonts = append(r.fonts, font)
+		r.fontGlyphs[font] = glyphs
+	}
+	for _, glyphID := range font.IndicesOf(text) {
+		glyphs[glyphID] = true
+	}
+}
+
+// writeFonts embeds the fonts with only the used glyphs, or the complete font if it cannot be subsetted.
+func (r *SVG) writeFonts() {
+	if len(r.fonts) == 0 {
+		return
 	}
 
-	if 0 < len(is) {
-		fmt.Fprintf(r.w, "<style>")
-		for _, i := range is {
-			mediatype, raw := fonts[i].Raw()
-			fmt.Fprintf(r.w, "\n@font-face{font-family:'%s';src:url('data:%s;base64,", fonts[i].Name(), mediatype)
-			encoder := base64.NewEncoder(base64.StdEncoding, r.w)
-			encoder.Write(raw)
-			encoder.Close()
-			fmt.Fprintf(r.w, "');}")
+	fmt.Fprintf(r.w, "<style>")
+	for _, font := range r.fonts {
+		glyphIDs := []uint16{0}
+		for glyphID := range r.fontGlyphs[font] {
+			if glyphID != 0 {
+				glyphIDs = append(glyphIDs, glyphID)
+			}
 		}
-		fmt.Fprintf(r.w, "\n</style>")
+		sort.Slice(glyphIDs, func(i, j int) bool { return glyphIDs[i] < glyphIDs[j] })
+
+		mediatype, raw, err := font.Subset(glyphIDs)
+		if err != nil {
+			mediatype, raw = font.Raw()
+		}
+		fmt.Fprintf(r.w, "\n@font-face{font-family:'%s';src:url('data:%s;base64,", font.Name(), mediatype)
+		encoder := base64.NewEncoder(base64.StdEncoding, r.w)
+		encoder.Write(raw)
+		encoder.Close()
+		fmt.Fprintf(r.w, "');}")
 	}
+	fmt.Fprintf(r.w, "\n</style>")
 }
 
 func (r *SVG) Size() (float64, float64) {
 	return r.width, r.height
 }
 
+// writePaint writes the definition of the paint if needed and returns the value to be used for the fill or stroke properties.
+func (r *SVG) writePaint(col color.RGBA, paint canvas.Paint, m canvas.Matrix) string {
+	var gradient *canvas.Gradient
+	var attrs string
+	switch p := paint.(type) {
+	case *canvas.Pattern:
+		return r.writePattern(col, p, m)
+	case *canvas.LinearGradient:
+		gradient = &p.Gradient
+		attrs = fmt.Sprintf(`<linearGradient id="g%v" gradientUnits="userSpaceOnUse" x1="%v" y1="%v" x2="%v" y2="%v`, r.gradientID, dec(p.Start.X), dec(p.Start.Y), dec(p.End.X), dec(p.End.Y))
+	case *canvas.RadialGradient:
+		gradient = &p.Gradient
+		attrs = fmt.Sprintf(`<radialGradient id="g%v" gradientUnits="userSpaceOnUse" cx="%v" cy="%v" r="%v`, r.gradientID, dec(p.C1.X), dec(p.C1.Y), dec(p.R1))
+		if p.C0 != p.C1 {
+			attrs += fmt.Sprintf(`" fx="%v" fy="%v`, dec(p.C0.X), dec(p.C0.Y))
+		}
+		if p.R0 != 0.0 {
+			attrs += fmt.Sprintf(`" fr="%v`, dec(p.R0))
+		}
+	default:
+		return canvas.CSSColor(col).String()
+	}
+
+	fmt.Fprintf(r.w, "%s", attrs)
+	if m = canvas.Identity.ReflectYAbout(r.height / 2.0).Mul(m); m != canvas.Identity {
+		fmt.Fprintf(r.w, `" gradientTransform="matrix(%v %v %v %v %v %v)`, dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))
+	}
+	if gradient.Spread == canvas.ReflectSpread {
+		fmt.Fprintf(r.w, `" spreadMethod="reflect`)
+	} else if gradient.Spread == canvas.RepeatSpread {
+		fmt.Fprintf(r.w, `" spreadMethod="repeat`)
+	}
+	fmt.Fprintf(r.w, `">`)
+	for _, stop := range gradient.Stops {
+		// colors are alpha-premultiplied
+		col := canvas.Black
+		if stop.Color.A != 0 {
+			a := float64(stop.Color.A) / 255.0
+			col = color.RGBA{uint8(float64(stop.Color.R)/a + 0.5), uint8(float64(stop.Color.G)/a + 0.5), uint8(float64(stop.Color.B)/a + 0.5), 255}
+		}
+		fmt.Fprintf(r.w, `<stop offset="%v" stop-color="%v`, dec(stop.Offset), canvas.CSSColor(col))
+		if stop.Color.A != 255 {
+			fmt.Fprintf(r.w, `" stop-opacity="%v`, dec(float64(stop.Color.A)/255.0))
+		}
+		fmt.Fprintf(r.w, `"/>`)
+	}
+	if _, ok := paint.(*canvas.LinearGradient); ok {
+		fmt.Fprintf(r.w, `</linearGradient>`)
+	} else {
+		fmt.Fprintf(r.w, `</radialGradient>`)
+	}
+	ref := fmt.Sprintf("url(#g%v)", r.gradientID)
+	r.gradientID++
+	return ref
+}
+
+// writePattern writes the definition of a tiling pattern and returns the value to be used for the fill or stroke properties.
+func (r *SVG) writePattern(col color.RGBA, pattern *canvas.Pattern, m canvas.Matrix) string {
+	if pattern.Cell == nil || pattern.W <= 0.0 || pattern.H <= 0.0 {
+		return canvas.CSSColor(col).String()
+	}
+	id := r.patternID
+	r.patternID++
+
+	// the cell is rendered with its y-axis pointing down, as is the pattern tile
+	fmt.Fprintf(r.w, `<pattern id="p%v" patternUnits="userSpaceOnUse" width="%v" height="%v`, id, dec(pattern.W), dec(pattern.H))
+	if m = canvas.Identity.ReflectYAbout(r.height / 2.0).Mul(m).Mul(pattern.View).Mul(canvas.Identity.ReflectYAbout(pattern.H / 2.0)); m != canvas.Identity {
+		fmt.Fprintf(r.w, `" patternTransform="matrix(%v %v %v %v %v %v)`, dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))
+	}
+	fmt.Fprintf(r.w, `">`)
+
+	cell := *r
+	cell.width, cell.height = pattern.W, pattern.H
+	pattern.Cell.Render(&cell)
+	r.maskID, r.gradientID, r.clipID, r.patternID = cell.maskID, cell.gradientID, cell.clipID, cell.patternID
+
+	fmt.Fprintf(r.w, `</pattern>`)
+	return fmt.Sprintf("url(#p%v)", id)
+}
+
+func (r *SVG) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	path = path.Transform(canvas.Identity.ReflectYAbout(r.height / 2.0).Mul(m))
+	fmt.Fprintf(r.w, `<clipPath id="c%v"><path d="%s"`, r.clipID, path.ToSVG())
+	if fillRule == canvas.EvenOdd {
+		fmt.Fprintf(r.w, ` clip-rule="evenodd"`)
+	}
+	fmt.Fprintf(r.w, `/></clipPath><g clip-path="url(#c%v)">`, r.clipID)
+	r.clipID++
+}
+
+func (r *SVG) PopClip() {
+	fmt.Fprintf(r.w, `</g>`)
+}
+
+func (r *SVG) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	fmt.Fprintf(r.w, `<g`)
+	if opacity != 1.0 {
+		fmt.Fprintf(r.w, ` opacity="%v"`, dec(opacity))
+	}
+	if blendMode != canvas.NormalBlend {
+		fmt.Fprintf(r.w, ` style="mix-blend-mode:%v"`, blendMode.CSS())
+	} else {
+		fmt.Fprintf(r.w, ` style="isolation:isolate"`)
+	}
+	fmt.Fprintf(r.w, `>`)
+}
+
+func (r *SVG) PopGroup() {
+	fmt.Fprintf(r.w, `</g>`)
+}
+
+// SetTitle writes the title of the image, which must be called before rendering.
+func (r *SVG) SetTitle(title string) {
+	fmt.Fprintf(r.w, "<title>%s</title>", html.EscapeString(title))
+}
+
+// PushPage opens a group with the given ID and title for a page of width x height whose top-left corner is at (x,y) in the image. Rendering uses the coordinate system of the page until PopPage is called.
+func (r *SVG) PushPage(id, title string, x, y, width, height float64) {
+	fmt.Fprintf(r.w, `<g id="%s"`, html.EscapeString(id))
+	if x != 0.0 || y != 0.0 {
+		fmt.Fprintf(r.w, ` transform="translate(%v %v)"`, dec(x), dec(y))
+	}
+	fmt.Fprintf(r.w, `>`)
+	if title != "" {
+		fmt.Fprintf(r.w, "<title>%s</title>", html.EscapeString(title))
+	}
+	r.pages = append(r.pages, canvas.Point{X: r.width, Y: r.height})
+	r.width, r.height = width, height
+}
+
+// PopPage closes the group opened by PushPage.
+func (r *SVG) PopPage() {
+	if len(r.pages) == 0 {
+		return
+	}
+	size := r.pages[len(r.pages)-1]
+	r.pages = r.pages[:len(r.pages)-1]
+	r.width, r.height = size.X, size.Y
+	fmt.Fprintf(r.w, `</g>`)
+}
+
+func (r *SVG) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	id := r.maskID
+	r.maskID++
+
+	fmt.Fprintf(r.w, `<mask id="m%v" maskUnits="userSpaceOnUse" x="0" y="0" width="%v" height="%v"`, id, dec(r.width), dec(r.height))
+	if maskType == canvas.AlphaMask {
+		fmt.Fprintf(r.w, ` style="mask-type:alpha"`)
+	}
+	fmt.Fprintf(r.w, `>`)
+
+	content := *r
+	mask.RenderView(&content, m)
+	r.maskID, r.gradientID, r.clipID, r.patternID = content.maskID, content.gradientID, content.clipID, content.patternID
+
+	fmt.Fprintf(r.w, `</mask><g mask="url(#m%v)">`, id)
+}
+
+func (r *SVG) PopMask() {
+	fmt.Fprintf(r.w, `</g>`)
+}
+
+// RenderLink writes a link over the rectangle transformed by m, where a URI of the form "#name" links to the named destination.
+func (r *SVG) RenderLink(uri string, rect canvas.Rect, m canvas.Matrix) {
+	path := rect.ToPath().Transform(canvas.Identity.ReflectYAbout(r.height / 2.0).Mul(m))
+	fmt.Fprintf(r.w, `<a xlink:href="%s"><path d="%s" fill="none" pointer-events="all"/></a>`, html.EscapeString(uri), path.ToSVG())
+}
+
+// RenderDestination writes a named destination at the point transformed by m, which is an empty element with the name as its ID.
+func (r *SVG) RenderDestination(name string, pos canvas.Point, m canvas.Matrix) {
+	pos = m.Dot(pos)
+	fmt.Fprintf(r.w, `<g id="%s" transform="translate(%v %v)"/>`, html.EscapeString(name), num(pos.X), num(r.height-pos.Y))
+}
+
 func (r *SVG) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
-	fill := style.FillColor.A != 0
-	stroke := style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth
+	fill := style.HasFill()
+	stroke := style.HasStroke()
+
+	fillPaint, strokePaint := "", ""
+	if fill && style.FillPaint != nil {
+		fillPaint = r.writePaint(style.FillColor, style.FillPaint, m)
+	} else if fill && style.FillColor != canvas.Black {
+		fillPaint = canvas.CSSColor(style.FillColor).String()
+	}
+	if stroke && style.StrokePaint != nil {
+		strokePaint = r.writePaint(style.StrokeColor, style.StrokePaint, m)
+	} else if stroke {
+		strokePaint = canvas.CSSColor(style.StrokeColor).String()
+	}
 
 	path = path.Transform(canvas.Identity.ReflectYAbout(r.height / 2.0).Mul(m))
 	fmt.Fprintf(r.w, `<path d="%s`, path.ToSVG())
@@ -128,8 +347,8 @@ func (r *SVG) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 
 	if !stroke {
 		if fill {
-			if style.FillColor != canvas.Black {
-				fmt.Fprintf(r.w, `" fill="%v`, canvas.CSSColor(style.FillColor))
+			if fillPaint != "" {
+				fmt.Fprintf(r.w, `" fill="%v`, fillPaint)
 			}
 			if style.FillRule == canvas.EvenOdd {
 				fmt.Fprintf(r.w, `" fill-rule="evenodd`)
@@ -140,8 +359,8 @@ func (r *SVG) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 	} else {
 		b := &strings.Builder{}
 		if fill {
-			if style.FillColor != canvas.Black {
-				fmt.Fprintf(b, ";fill:%v", canvas.CSSColor(style.FillColor))
+			if fillPaint != "" {
+				fmt.Fprintf(b, ";fill:%v", fillPaint)
 			}
 			if style.FillRule == canvas.EvenOdd {
 				fmt.Fprintf(b, ";fill-rule:evenodd")
@@ -150,7 +369,7 @@ func (r *SVG) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 			fmt.Fprintf(b, ";fill:none")
 		}
 		if stroke && !strokeUnsupported {
-			fmt.Fprintf(b, `;stroke:%v`, canvas.CSSColor(style.StrokeColor))
+			fmt.Fprintf(b, `;stroke:%v`, strokePaint)
 			if style.StrokeWidth != 1.0 {
 				fmt.Fprintf(b, ";stroke-width:%v", dec(style.StrokeWidth))
 			}
@@ -203,8 +422,8 @@ func (r *SVG) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 		}
 		path = path.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
 		fmt.Fprintf(r.w, `<path d="%s`, path.ToSVG())
-		if style.StrokeColor != canvas.Black {
-			fmt.Fprintf(r.w, `" fill="%v`, canvas.CSSColor(style.StrokeColor))
+		if strokePaint != "#000" {
+			fmt.Fprintf(r.w, `" fill="%v`, strokePaint)
 		}
 		if style.FillRule == canvas.EvenOdd {
 			fmt.Fprintf(r.w, `" fill-rule="evenodd`)
@@ -276,10 +495,6 @@ func (r *SVG) writeFontStyle(ff, ffMain canvas.FontFace) {
 }
 
 func (r *SVG) RenderText(text *canvas.Text, m canvas.Matrix) {
-	if r.embedFonts {
-		r.writeFonts(text.Fonts())
-	}
-
 	if text.Empty() {
 		return
 	}
@@ -320,6 +535,9 @@ func (r *SVG) RenderText(text *canvas.Text, m canvas.Matrix) {
 			fmt.Fprintf(r.w, `" letter-spacing="%v`, num(span.GlyphSpacing))
 		}
 		r.writeFontStyle(span.Face, ffMain)
+		if r.embedFonts {
+			r.addGlyphs(span.Face.Font, span.Text)
+		}
 		s := span.Text
 		s = strings.ReplaceAll(s, `"`, `&quot;`)
 		r.writeClasses(r.w)
@@ -1,7 +1,14 @@
 package svg
 
 import (
+	"bytes"
+	"encoding/base64"
+	"strings"
 	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+	"golang.org/x/image/font/sfnt"
 )
 
 func TestSVGText(t *testing.T) {
@@ -30,3 +37,132 @@ func TestSVGText(t *testing.T) {
 	//s := regexp.MustCompile(`base64,.+'`).ReplaceAllString(buf.String(), "base64,'") // remove embedded font
 	//test.String(t, s, `<style>`+"\n"+`@font-face{font-family:'dejavu-serif';src:url('data:font/truetype;base64,');}`+"\n"+`@font-face{font-family:'eb-garamond';src:url('data:font/opentype;base64,');}`+"\n"+`</style><text x="0" y="0" style="font: 12px dejavu-serif"><tspan x="0" y="7.421875" style="font:8px dejavu-serif">dejaVu8</tspan><tspan x="0" y="20.453125" letter-spacing="1" style="font-style:italic;fill:#f00">glyphspacing</tspan><tspan x="0" y="33.725625" style="font:700 6.996px dejavu-serif">dejaVu12sub</tspan><tspan x="0" y="38.5" style="font:700 10px eb-garamond">garamond10</tspan></text><path d="M0 22.703125H91.71875V21.803125H0z" fill="#f00"/>`)
 }
+
+func TestSVGGradient(t *testing.T) {
+	gradient := canvas.NewLinearGradient(0.0, 0.0, 10.0, 0.0)
+	gradient.Add(0.0, canvas.Red)
+	gradient.Add(1.0, canvas.Blue)
+
+	style := canvas.DefaultStyle
+	style.FillPaint = gradient
+
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.RenderPath(canvas.Rectangle(10.0, 10.0), style, canvas.Identity)
+	s := buf.String()
+	s = s[strings.Index(s, "<linearGradient"):]
+	test.String(t, s, `<linearGradient id="g0" gradientUnits="userSpaceOnUse" x1="0" y1="0" x2="10" y2="0" gradientTransform="matrix(1 0 0 -1 0 10)"><stop offset="0" stop-color="#f00"/><stop offset="1" stop-color="#00f"/></linearGradient><path d="M0 10H10V0H0z" fill="url(#g0)"/>`)
+}
+
+func TestSVGClip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.PushClip(canvas.Rectangle(5.0, 5.0), canvas.EvenOdd, canvas.Identity)
+	svg.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	svg.PopClip()
+	s := buf.String()
+	s = s[strings.Index(s, "<clipPath"):]
+	test.String(t, s, `<clipPath id="c0"><path d="M0 10H5V5H0z" clip-rule="evenodd"/></clipPath><g clip-path="url(#c0)"><path d="M0 10H10V0H0z"/></g>`)
+}
+
+func TestSVGPattern(t *testing.T) {
+	style := canvas.DefaultStyle
+	style.FillPaint = canvas.NewDotPattern(canvas.Red, 4.0, 1.0)
+
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.RenderPath(canvas.Rectangle(10.0, 10.0), style, canvas.Identity)
+	s := buf.String()
+	s = s[strings.Index(s, "<pattern"):]
+	test.String(t, s, `<pattern id="p0" patternUnits="userSpaceOnUse" width="4" height="4" patternTransform="matrix(1 0 0 1 0 6)"><path d="M3 2A1 1 0 001 2A1 1 0 003 2z" fill="#f00"/></pattern><path d="M0 10H10V0H0z" fill="url(#p0)"/>`)
+}
+
+func TestSVGGroup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.PushGroup(0.5, canvas.MultiplyBlend)
+	svg.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	svg.PopGroup()
+	svg.PushGroup(1.0, canvas.NormalBlend)
+	svg.PopGroup()
+	s := buf.String()
+	s = s[strings.Index(s, "<g"):]
+	test.String(t, s, `<g opacity=".5" style="mix-blend-mode:multiply"><path d="M0 10H10V0H0z"/></g><g style="isolation:isolate"></g>`)
+}
+
+func TestSVGMask(t *testing.T) {
+	mask := canvas.New(10.0, 10.0)
+	mask.RenderPath(canvas.Rectangle(5.0, 5.0), canvas.DefaultStyle, canvas.Identity)
+
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.PushMask(mask, canvas.AlphaMask, canvas.Identity.Translate(1.0, 0.0))
+	svg.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	svg.PopMask()
+	s := buf.String()
+	s = s[strings.Index(s, "<mask"):]
+	test.String(t, s, `<mask id="m0" maskUnits="userSpaceOnUse" x="0" y="0" width="10" height="10" style="mask-type:alpha"><path d="M1 10H6V5H1z"/></mask><g mask="url(#m0)"><path d="M0 10H10V0H0z"/></g>`)
+}
+
+func TestSVGFontSubset(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	face := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.RenderText(canvas.NewTextLine(face, "abc", canvas.Left), canvas.Identity)
+	svg.RenderText(canvas.NewTextLine(face, "cd", canvas.Left), canvas.Identity)
+	test.Error(t, svg.Close())
+
+	s := buf.String()
+	test.That(t, strings.Index(s, "</text>") < strings.Index(s, "<style>"), "fonts must be written at the end")
+	s = s[strings.Index(s, "base64,")+7:]
+	b, err := base64.StdEncoding.DecodeString(s[:strings.Index(s, "'")])
+	test.Error(t, err)
+
+	font, err := sfnt.Parse(b)
+	test.Error(t, err)
+	test.T(t, font.NumGlyphs(), 5)
+}
+
+func TestSVGLinks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	svg := New(buf, 10.0, 10.0)
+	svg.RenderLink("https://example.com/?a=b&c=d", canvas.Rect{X: 0.0, Y: 0.0, W: 5.0, H: 2.0}, canvas.Identity.Translate(1.0, 1.0))
+	svg.RenderDestination("end", canvas.Point{X: 2.0, Y: 3.0}, canvas.Identity)
+	s := buf.String()
+	s = s[strings.Index(s, "<a"):]
+	test.String(t, s, `<a xlink:href="https://example.com/?a=b&amp;c=d"><path d="M1 9H6V7H1z" fill="none" pointer-events="all"/></a><g id="end" transform="translate(2 7)"/>`)
+}
+
+func TestSVGDocument(t *testing.T) {
+	d := canvas.NewDocument()
+	c := d.NewPage(10.0, 10.0)
+	c.RenderPath(canvas.Rectangle(2.0, 2.0), canvas.DefaultStyle, canvas.Identity)
+	c = canvas.New(20.0, 5.0)
+	c.RenderPath(canvas.Rectangle(2.0, 2.0), canvas.DefaultStyle, canvas.Identity)
+	d.AddPage(c, "Second & last")
+
+	buf := &bytes.Buffer{}
+	test.Error(t, DocumentWriter(buf, d))
+	test.String(t, buf.String(), `<svg version="1.1" width="20mm" height="25mm" viewBox="0 0 20 25" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><g id="page1"><path d="M0 10H2V8H0z"/></g><g id="page2" transform="translate(0 20)"><title>Second &amp; last</title><path d="M0 5H2V3H0z"/></g></svg>`)
+}
+
+func TestSVGPage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	svg := New(buf, 30.0, 20.0)
+	svg.SetTitle("a < b")
+	svg.PushPage("p", "", 5.0, 8.0, 10.0, 4.0)
+	w, h := svg.Size()
+	test.T(t, w, 10.0)
+	test.T(t, h, 4.0)
+	svg.RenderPath(canvas.Rectangle(2.0, 1.0), canvas.DefaultStyle, canvas.Identity)
+	svg.PopPage()
+	svg.RenderPath(canvas.Rectangle(2.0, 1.0), canvas.DefaultStyle, canvas.Identity)
+	test.Error(t, svg.Close())
+
+	s := buf.String()
+	s = s[strings.Index(s, "<title"):]
+	test.String(t, s, `<title>a &lt; b</title><g id="p" transform="translate(5 8)"><path d="M0 4H2V3H0z"/></g><path d="M0 20H2V19H0z"/></svg>`)
+}
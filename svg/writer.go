@@ -1,14 +1,50 @@
 package svg
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/tdewolff/canvas"
 )
 
+// pageGap is the vertical space in mm between the pages of a document.
+const pageGap = 10.0
+
 // Writer writes the canvas as a SVG file
 func Writer(w io.Writer, c *canvas.Canvas) error {
 	svg := New(w, c.W, c.H)
 	c.Render(svg)
 	return svg.Close()
 }
+
+// DocumentWriter writes the document as a single SVG file where the pages are stacked vertically, each in a group with ID "pageN". Use Document.WriteFiles with Writer to write a file for each page instead.
+func DocumentWriter(w io.Writer, d *canvas.Document) error {
+	if len(d.Pages) == 0 {
+		return canvas.ErrNoPages
+	}
+
+	width, height := 0.0, 0.0
+	for i, page := range d.Pages {
+		if width < page.W {
+			width = page.W
+		}
+		if i != 0 {
+			height += pageGap
+		}
+		height += page.H
+	}
+
+	svg := New(w, width, height)
+	if d.Title != "" {
+		svg.SetTitle(d.Title)
+	}
+
+	y := 0.0
+	for i, page := range d.Pages {
+		svg.PushPage(fmt.Sprintf("page%d", i+1), page.Title, 0.0, y, page.W, page.H)
+		page.Render(svg)
+		svg.PopPage()
+		y += page.H + pageGap
+	}
+	return svg.Close()
+}
@@ -4,34 +4,81 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"math"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/tdewolff/canvas"
 )
 
+// Options are the options for the TeX/pgf renderer.
+type Options struct {
+	NativeText    bool                 // write text as \pgftext nodes that are typeset by LaTeX in the document font, instead of as paths
+	ImagePrefix   string               // path prefix of the image files, such as "figure" for figure-0.png, which is relative to the directory of the TeX file when writing to an *os.File and is included as is by the TeX file, images are skipped when empty
+	ImageEncoding canvas.ImageEncoding // write images as PNG (Lossless) or JPG (Lossy) files
+}
+
+// DefaultOptions are the default options for the TeX/pgf renderer.
+var DefaultOptions = Options{}
+
 type TeX struct {
 	w             io.Writer
 	width, height float64
+	opts          Options
+	dir           string // directory of the TeX file, if known
+	images        int
+	err           error
+
+	style    canvas.Style
+	colors   map[color.RGBA]string
+	shadings int
+	stack    []texState
+}
 
+// texState is the renderer state that is restored when a pgfscope ends, since colors are defined locally.
+type texState struct {
 	style  canvas.Style
 	colors map[color.RGBA]string
 }
 
 // New creates a TeX/pgf renderer.
 func New(w io.Writer, width, height float64) *TeX {
+	return NewWithOptions(w, width, height, nil)
+}
+
+// NewWithOptions creates a TeX/pgf renderer with options. If opts is nil, DefaultOptions is used.
+func NewWithOptions(w io.Writer, width, height float64, opts *Options) *TeX {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+
+	dir := ""
+	if f, ok := w.(*os.File); ok {
+		dir = filepath.Dir(f.Name())
+	}
+
 	fmt.Fprintf(w, "\\begin{pgfpicture}")
 	return &TeX{
 		w:      w,
 		width:  width,
 		height: height,
+		opts:   *opts,
+		dir:    dir,
 		style:  canvas.DefaultStyle,
 		colors: map[color.RGBA]string{},
 	}
 }
 
+// Close ends the picture, and returns the first error that occurred while writing image files.
 func (r *TeX) Close() error {
 	_, err := fmt.Fprintf(r.w, "\n\\end{pgfpicture}")
+	if r.err != nil {
+		return r.err
+	}
 	return err
 }
 
@@ -54,13 +101,88 @@ func (r *TeX) getColor(col color.RGBA) string {
 	return name
 }
 
-func (r *TeX) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
-	if path.Empty() {
+func (r *TeX) PushClip(path *canvas.Path, fillRule canvas.FillRule, m canvas.Matrix) {
+	r.pushState()
+
+	fmt.Fprintf(r.w, "\n\\begin{pgfscope}")
+	r.writePath(path.Transform(m).ReplaceArcs())
+	if fillRule != r.style.FillRule {
+		r.writeFillRule(fillRule)
+	}
+	fmt.Fprintf(r.w, "\n\\pgfusepath{clip}")
+	if fillRule != r.style.FillRule {
+		r.writeFillRule(r.style.FillRule)
+	}
+}
+
+func (r *TeX) writeFillRule(fillRule canvas.FillRule) {
+	if fillRule == canvas.EvenOdd {
+		fmt.Fprintf(r.w, "\n\\pgfseteorule")
+	} else {
+		fmt.Fprintf(r.w, "\n\\pgfsetnonzerorule")
+	}
+}
+
+func (r *TeX) PopClip() {
+	if len(r.stack) == 0 {
 		return
 	}
-	path = path.Transform(m)
-	path = path.ReplaceArcs()
+	fmt.Fprintf(r.w, "\n\\end{pgfscope}")
+	r.popState()
+}
 
+func (r *TeX) pushState() {
+	colors := make(map[color.RGBA]string, len(r.colors))
+	for col, name := range r.colors {
+		colors[col] = name
+	}
+	r.stack = append(r.stack, texState{r.style, colors})
+}
+
+func (r *TeX) popState() {
+	state := r.stack[len(r.stack)-1]
+	r.style, r.colors = state.style, state.colors
+	r.stack = r.stack[:len(r.stack)-1]
+}
+
+func (r *TeX) PushGroup(opacity float64, blendMode canvas.BlendMode) {
+	r.pushState()
+
+	fmt.Fprintf(r.w, "\n\\begin{pgfscope}")
+	fmt.Fprintf(r.w, "\n\\pgfsetfillopacity{%v}", dec(opacity))
+	fmt.Fprintf(r.w, "\n\\pgfsetstrokeopacity{%v}", dec(opacity))
+	if blendMode != canvas.NormalBlend {
+		fmt.Fprintf(r.w, "\n\\pgfsetblendmode{%v}", blendMode.CSS())
+	}
+	fmt.Fprintf(r.w, "\n\\begin{pgftransparencygroup}")
+
+	// the contents of the group are drawn opaque and are composited as a whole
+	fmt.Fprintf(r.w, "\n\\pgfsetfillopacity{1}")
+	fmt.Fprintf(r.w, "\n\\pgfsetstrokeopacity{1}")
+	if blendMode != canvas.NormalBlend {
+		fmt.Fprintf(r.w, "\n\\pgfsetblendmode{normal}")
+	}
+	r.style.FillColor.A = 255
+	r.style.StrokeColor.A = 255
+}
+
+func (r *TeX) PopGroup() {
+	if len(r.stack) == 0 {
+		return
+	}
+	fmt.Fprintf(r.w, "\n\\end{pgftransparencygroup}")
+	fmt.Fprintf(r.w, "\n\\end{pgfscope}")
+	r.popState()
+}
+
+func (r *TeX) PushMask(mask *canvas.Canvas, maskType canvas.MaskType, m canvas.Matrix) {
+	// TODO: (TeX) support masks using pgf fadings
+}
+
+func (r *TeX) PopMask() {
+}
+
+func (r *TeX) writePath(path *canvas.Path) {
 	path.Iterate(func(start, end canvas.Point) {
 		fmt.Fprintf(r.w, "\n\\pgfpathmoveto{\\pgfpoint{%vmm}{%vmm}}", dec(end.X), dec(end.Y))
 	}, func(start, end canvas.Point) {
@@ -82,11 +204,172 @@ func (r *TeX) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 	}, func(start, end canvas.Point) {
 		fmt.Fprintf(r.w, "\n\\pgfpathclose")
 	})
+}
+
+// writeShading declares a shading for a gradient paint and fills the path with it, where the paint is transformed by m. It returns false if the paint is not supported.
+func (r *TeX) writeShading(path *canvas.Path, paint canvas.Paint, m canvas.Matrix) bool {
+	const L = 100.0                          // size of the shading in bp
+	const Lmm = L * 25.4 / 72.0              // size of the shading in mm
+	rect := path.Bounds().Transform(m.Inv()) // path bounds in the coordinate system of the paint
+
+	var gradient *canvas.Gradient
+	var t0, t1 float64
+	var position func(float64) float64 // position of the color stop in bp
+	var declaration string
+	var u, v, origin canvas.Point // shading axes and center in the coordinate system of the paint
+	name := fmt.Sprintf("canvasShading%v", r.shadings)
+	switch p := paint.(type) {
+	case *canvas.LinearGradient:
+		gradient = &p.Gradient
+		t0, t1 = p.Range(rect)
+		d := p.End.Sub(p.Start)
+		if d.IsZero() || t1 <= t0 {
+			return false
+		}
+
+		// extend the shading perpendicular to the gradient to cover the path
+		n := d.Rot90CCW().Norm(1.0)
+		smin, smax := math.Inf(1), math.Inf(-1)
+		for _, c := range []canvas.Point{{X: rect.X, Y: rect.Y}, {X: rect.X + rect.W, Y: rect.Y}, {X: rect.X, Y: rect.Y + rect.H}, {X: rect.X + rect.W, Y: rect.Y + rect.H}} {
+			s := c.Sub(p.Start).Dot(n)
+			smin, smax = math.Min(smin, s), math.Max(smax, s)
+		}
+		if smax-smin < canvas.Epsilon {
+			return false
+		}
+
+		u = d.Mul(t1 - t0)
+		v = n.Mul(smax - smin)
+		origin = p.Start.Add(d.Mul((t0 + t1) / 2.0)).Add(n.Mul((smin + smax) / 2.0))
+		position = func(t float64) float64 {
+			return (t - t0) / (t1 - t0) * L
+		}
+		declaration = fmt.Sprintf("\\pgfdeclarehorizontalshading{%v}{%vbp}{", name, dec(L))
+	case *canvas.RadialGradient:
+		gradient = &p.Gradient
+		t0, t1 = p.Range(rect)
+		d, dr := p.C1.Sub(p.C0), p.R1-p.R0
+		r1 := p.R0 + t1*dr
+		if t1 <= t0 || r1 <= 0.0 {
+			return false
+		}
+
+		c0, c1 := p.C0.Add(d.Mul(t0)), p.C0.Add(d.Mul(t1))
+		u = canvas.Point{X: 2.0 * r1}
+		v = canvas.Point{Y: 2.0 * r1}
+		origin = c1
+		position = func(t float64) float64 {
+			return (p.R0 + t*dr) / r1 * L / 2.0
+		}
+		focal := c0.Sub(c1).Mul(L / 2.0 / r1)
+		declaration = fmt.Sprintf("\\pgfdeclareradialshading{%v}{\\pgfpoint{%vbp}{%vbp}}{", name, dec(focal.X), dec(focal.Y))
+	default:
+		return false
+	}
+	if len(gradient.Stops) == 0 {
+		return false
+	}
+	r.shadings++
+
+	alpha := gradient.Stops[0].Color.A
+	fmt.Fprintf(r.w, "\n"+declaration)
+	stops := gradient.StopsIn(t0, t1)
+	if 0.0 < position(t0) {
+		stops = append([]canvas.GradientStop{{Offset: math.NaN(), Color: stops[0].Color}}, stops...)
+	}
+	for i, stop := range stops {
+		if i != 0 {
+			fmt.Fprintf(r.w, "; ")
+		}
+		pos := 0.0
+		if !math.IsNaN(stop.Offset) {
+			pos = position(stop.Offset)
+		}
+		R, G, B := 0.0, 0.0, 0.0
+		if stop.Color.A != 0 {
+			A := float64(stop.Color.A) / 255.0
+			R, G, B = float64(stop.Color.R)/255.0/A, float64(stop.Color.G)/255.0/A, float64(stop.Color.B)/255.0/A
+		}
+		fmt.Fprintf(r.w, "rgb(%vbp)=(%v,%v,%v)", dec(pos), dec(R), dec(G), dec(B))
+		if stop.Color.A != alpha {
+			alpha = 255 // varying opacity is not supported, ignore opacity
+		}
+	}
+	fmt.Fprintf(r.w, "}")
+
+	// clip to the path and place the shading, transforming its axes and center to the paint
+	u, v, origin = m.Dot(u).Sub(m.Dot(canvas.Point{})), m.Dot(v).Sub(m.Dot(canvas.Point{})), m.Dot(origin)
+	fmt.Fprintf(r.w, "\n\\begin{pgfscope}")
+	r.writePath(path)
+	fmt.Fprintf(r.w, "\n\\pgfusepath{clip}")
+	if alpha != 255 {
+		fmt.Fprintf(r.w, "\n\\pgfsetfillopacity{%v}", dec(float64(alpha)/255.0))
+	}
+	fmt.Fprintf(r.w, "\n\\pgftransformcm{%v}{%v}{%v}{%v}{\\pgfpoint{%vmm}{%vmm}}", dec(u.X/Lmm), dec(u.Y/Lmm), dec(v.X/Lmm), dec(v.Y/Lmm), dec(origin.X), dec(origin.Y))
+	fmt.Fprintf(r.w, "\n\\pgflowlevelsynccm")
+	fmt.Fprintf(r.w, "\n\\pgftext{\\pgfuseshading{%v}}", name)
+	fmt.Fprintf(r.w, "\n\\end{pgfscope}")
+	return true
+}
+
+func (r *TeX) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
+	if path.Empty() {
+		return
+	}
+	path = path.Transform(m)
+	path = path.ReplaceArcs()
+
+	fill := style.HasFill()
+	stroke := style.HasStroke()
+	if fill && style.FillPaint != nil && r.writeShading(path, style.FillPaint, m) {
+		fill = false
+	}
+	if stroke && style.StrokePaint != nil {
+		strokePath := path
+		if 0 < len(style.Dashes) {
+			strokePath = strokePath.Dash(style.DashOffset, style.Dashes...)
+		}
+		strokePath = strokePath.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
+		if r.writeShading(strokePath, style.StrokePaint, m) {
+			stroke = false
+		}
+	}
+	if !fill && !stroke {
+		return
+	}
+
+	if stroke && !strokeSupported(style) {
+		// PGF cannot express the stroke style, fill the stroke outline instead
+		strokePath := path
+		if 0 < len(style.Dashes) {
+			strokePath = strokePath.Dash(style.DashOffset, style.Dashes...)
+		}
+		strokePath = strokePath.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
 
-	fill := style.FillColor.A != 0
-	stroke := style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth
+		if fill {
+			fillStyle := style
+			fillStyle.FillPaint = nil
+			fillStyle.StrokeColor = canvas.Transparent
+			fillStyle.StrokePaint = nil
+			r.RenderPath(path, fillStyle, canvas.Identity)
+		}
+
+		strokeStyle := style
+		strokeStyle.FillColor = style.StrokeColor
+		strokeStyle.FillPaint = nil
+		strokeStyle.FillRule = canvas.NonZero
+		strokeStyle.StrokeColor = canvas.Transparent
+		strokeStyle.StrokePaint = nil
+		r.RenderPath(strokePath, strokeStyle, canvas.Identity)
+		return
+	}
+
+	r.writePath(path)
 
 	if fill {
+		if style.FillRule != r.style.FillRule {
+			r.writeFillRule(style.FillRule)
+		}
 		if style.FillColor.R != r.style.FillColor.R || style.FillColor.G != r.style.FillColor.G || style.FillColor.B != r.style.FillColor.B {
 			fmt.Fprintf(r.w, "\n\\pgfsetfillcolor{%v}", r.getColor(style.FillColor))
 		}
@@ -97,27 +380,25 @@ func (r *TeX) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 
 	if stroke {
 		if style.StrokeCapper != r.style.StrokeCapper {
-			if _, ok := style.StrokeCapper.(canvas.RoundCapper); ok {
+			switch style.StrokeCapper.(type) {
+			case canvas.RoundCapper:
 				fmt.Fprintf(r.w, "\n\\pgfsetroundcap")
-			} else if _, ok := style.StrokeCapper.(canvas.SquareCapper); ok {
+			case canvas.SquareCapper:
 				fmt.Fprintf(r.w, "\n\\pgfsetrectcap")
-			} else if _, ok := style.StrokeCapper.(canvas.ButtCapper); ok {
+			default:
 				fmt.Fprintf(r.w, "\n\\pgfsetbuttcap")
-			} else {
-				panic("TeX: line cap not support")
 			}
 		}
 
 		if style.StrokeJoiner != r.style.StrokeJoiner {
-			if _, ok := style.StrokeJoiner.(canvas.BevelJoiner); ok {
+			switch joiner := style.StrokeJoiner.(type) {
+			case canvas.BevelJoiner:
 				fmt.Fprintf(r.w, "\n\\pgfsetbeveljoin")
-			} else if _, ok := style.StrokeJoiner.(canvas.RoundJoiner); ok {
+			case canvas.RoundJoiner:
 				fmt.Fprintf(r.w, "\n\\pgfsetroundjoin")
-			} else if miter, ok := style.StrokeJoiner.(canvas.MiterJoiner); ok && !math.IsNaN(miter.Limit) && miter.GapJoiner == canvas.BevelJoin {
+			case canvas.MiterJoiner:
 				fmt.Fprintf(r.w, "\n\\pgfsetmiterjoin")
-				fmt.Fprintf(r.w, "\n\\pgfsetmiterlimit{%vmm}", dec(miter.Limit))
-			} else {
-				panic("TeX: line join not support")
+				fmt.Fprintf(r.w, "\n\\pgfsetmiterlimit{%v}", dec(joiner.Limit))
 			}
 		}
 
@@ -151,14 +432,162 @@ func (r *TeX) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix)
 	} else if stroke {
 		fmt.Fprintf(r.w, "\n\\pgfusepath{stroke}")
 	}
+
+	// only cache the state that has been written
+	if !fill {
+		style.FillColor = r.style.FillColor
+		style.FillRule = r.style.FillRule
+	}
+	if !stroke {
+		style.StrokeColor = r.style.StrokeColor
+		style.StrokeWidth = r.style.StrokeWidth
+		style.StrokeCapper = r.style.StrokeCapper
+		style.StrokeJoiner = r.style.StrokeJoiner
+		style.DashOffset = r.style.DashOffset
+		style.Dashes = r.style.Dashes
+	}
 	r.style = style
 }
 
+// strokeSupported returns true if the stroke capper and joiner can be expressed in PGF.
+func strokeSupported(style canvas.Style) bool {
+	switch style.StrokeCapper.(type) {
+	case canvas.ButtCapper, canvas.RoundCapper, canvas.SquareCapper:
+	default:
+		return false
+	}
+
+	switch joiner := style.StrokeJoiner.(type) {
+	case canvas.BevelJoiner, canvas.RoundJoiner:
+	case canvas.MiterJoiner:
+		if _, ok := joiner.GapJoiner.(canvas.BevelJoiner); !ok || math.IsNaN(joiner.Limit) {
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
 func (r *TeX) RenderText(text *canvas.Text, m canvas.Matrix) {
-	// TODO: (TeX) write text natively
-	canvas.RenderTextAsPath(r, text, m)
+	if !r.opts.NativeText {
+		canvas.RenderTextAsPath(r, text, m)
+		return
+	}
+
+	text.WalkSpans(func(y, dx float64, span canvas.TextSpan) {
+		face := span.Face
+		scale := math.Sqrt(math.Abs(m.Det()))
+		rot := math.Atan2(m[1][0], m[0][0]) * 180.0 / math.Pi
+		size := face.Size * face.Scale * scale * 72.0 / 25.4 // in pt
+
+		font := ""
+		if 600 <= face.Boldness() {
+			font += "\\bfseries"
+		}
+		if face.Style&canvas.FontItalic != 0 {
+			font += "\\itshape"
+		}
+		if face.Variant&canvas.FontSmallcaps != 0 {
+			font += "\\scshape"
+		}
+
+		// write words separately when the span is justified to keep the alignment
+		words := []string{span.Text}
+		if span.WordSpacing != 0.0 || span.GlyphSpacing != 0.0 {
+			words = span.Words()
+		}
+
+		color := r.getColor(face.Color)
+		r.pushState()
+		fmt.Fprintf(r.w, "\n\\begin{pgfscope}")
+		if face.Color.A != 255 {
+			fmt.Fprintf(r.w, "\n\\pgfsetfillopacity{%v}", dec(float64(face.Color.A)/255.0))
+		}
+		x := dx
+		for _, word := range words {
+			if strings.TrimSpace(word) != "" {
+				pos := m.Dot(canvas.Point{X: x, Y: y + face.Voffset})
+				fmt.Fprintf(r.w, "\n\\pgftext[left,base,at={\\pgfpoint{%vmm}{%vmm}}", dec(pos.X), dec(pos.Y))
+				if rot != 0.0 {
+					fmt.Fprintf(r.w, ",rotate=%v", dec(rot))
+				}
+				fmt.Fprintf(r.w, "]{\\fontsize{%vpt}{%vpt}\\selectfont%v\\color{%v}%v}", dec(size), dec(1.2*size), font, color, escapeText(face.Font, word))
+			}
+			x += face.TextWidth(word) + span.WordSpacing + span.GlyphSpacing*float64(len([]rune(word)))
+		}
+		fmt.Fprintf(r.w, "\n\\end{pgfscope}")
+		r.popState()
+	})
+
+	text.RenderDecoration(r, m)
 }
 
+// escapeText returns the text with ligatures replaced by their characters and TeX special characters escaped.
+func escapeText(font *canvas.Font, s string) string {
+	sb := strings.Builder{}
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString("\\textbackslash{}")
+		case '{', '}', '$', '&', '#', '_', '%':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case '^':
+			sb.WriteString("\\textasciicircum{}")
+		case '~':
+			sb.WriteString("\\textasciitilde{}")
+		case '\n', '\r', '\t':
+			sb.WriteRune(' ')
+		default:
+			sb.WriteString(font.LigatureText(r))
+		}
+	}
+	return sb.String()
+}
+
+// RenderImage writes the image to a file named by Options.ImagePrefix and includes it with \pgfimage. Images are skipped if Options.ImagePrefix is empty.
 func (r *TeX) RenderImage(img image.Image, m canvas.Matrix) {
-	// TODO: (TeX) write image
+	if r.opts.ImagePrefix == "" || r.err != nil {
+		// TODO: (TeX) embed images without writing files
+		return
+	}
+
+	ext := ".png"
+	if r.opts.ImageEncoding == canvas.Lossy {
+		ext = ".jpg"
+	}
+	filename := fmt.Sprintf("%s-%d%s", r.opts.ImagePrefix, r.images, ext)
+	r.images++
+
+	path := filename
+	if r.dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(r.dir, path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		r.err = err
+		return
+	}
+	if ext == ".jpg" {
+		err = jpeg.Encode(f, img, nil)
+	} else {
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		f.Close()
+		r.err = fmt.Errorf("%v: %v", path, err)
+		return
+	} else if err = f.Close(); err != nil {
+		r.err = err
+		return
+	}
+
+	// the image is placed in a unit square per pixel that is transformed by m
+	size := img.Bounds().Size()
+	fmt.Fprintf(r.w, "\n\\begin{pgfscope}")
+	fmt.Fprintf(r.w, "\n\\pgftransformcm{%v}{%v}{%v}{%v}{\\pgfpoint{%vmm}{%vmm}}", dec(m[0][0]), dec(m[1][0]), dec(m[0][1]), dec(m[1][1]), dec(m[0][2]), dec(m[1][2]))
+	fmt.Fprintf(r.w, "\n\\pgflowlevelsynccm")
+	fmt.Fprintf(r.w, "\n\\pgftext[left,bottom]{\\pgfimage[width=%vmm,height=%vmm]{%v}}", size.X, size.Y, filename)
+	fmt.Fprintf(r.w, "\n\\end{pgfscope}")
 }
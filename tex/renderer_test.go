@@ -0,0 +1,177 @@
+package tex
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/test"
+)
+
+func TestTeX(t *testing.T) {
+	w := &bytes.Buffer{}
+	tex := New(w, 100.0, 80.0)
+	tex.RenderPath(canvas.Rectangle(10.0, 10.0), canvas.DefaultStyle, canvas.Identity)
+	test.Error(t, tex.Close())
+	test.String(t, w.String(), "\\begin{pgfpicture}\n\\pgfpathmoveto{\\pgfpoint{0mm}{0mm}}\n\\pgfpathlineto{\\pgfpoint{10mm}{0mm}}\n\\pgfpathlineto{\\pgfpoint{10mm}{10mm}}\n\\pgfpathlineto{\\pgfpoint{0mm}{10mm}}\n\\pgfpathclose\n\\pgfusepath{fill}\n\\end{pgfpicture}")
+}
+
+type testCapper struct{}
+
+func (testCapper) Cap(p *canvas.Path, halfWidth float64, pivot, n0 canvas.Point) {
+	canvas.SquareCap.Cap(p, halfWidth, pivot, n0)
+}
+
+func TestTeXStroke(t *testing.T) {
+	path := canvas.MustParseSVG("M0 0L10 0L10 10")
+	style := canvas.DefaultStyle
+	style.FillColor = canvas.Transparent
+	style.StrokeColor = canvas.Red
+
+	var tts = []struct {
+		name   string
+		capper canvas.Capper
+		joiner canvas.Joiner
+		dashes []float64
+		native bool
+	}{
+		{"miter", canvas.ButtCap, canvas.MiterJoin, nil, true},
+		{"round", canvas.RoundCap, canvas.RoundJoin, []float64{2.0, 1.0}, true},
+		{"arcs", canvas.ButtCap, canvas.ArcsJoin, nil, false},
+		{"miter-clip", canvas.ButtCap, canvas.MiterClipJoin(canvas.RoundJoin, 4.0), nil, false},
+		{"capper", testCapper{}, canvas.BevelJoin, nil, false},
+		{"dashes", canvas.ButtCap, canvas.ArcsJoin, []float64{2.0, 1.0}, false},
+	}
+	for _, tt := range tts {
+		t.Run(tt.name, func(t *testing.T) {
+			style := style
+			style.StrokeCapper = tt.capper
+			style.StrokeJoiner = tt.joiner
+			style.Dashes = tt.dashes
+
+			w := &bytes.Buffer{}
+			tex := New(w, 100.0, 80.0)
+			tex.RenderPath(path, style, canvas.Identity)
+			test.Error(t, tex.Close())
+
+			s := w.String()
+			if tt.native {
+				test.That(t, strings.Contains(s, "\\pgfusepath{stroke}"), "must stroke path")
+				test.That(t, strings.Contains(s, "\\pgfsetdash") == (tt.dashes != nil), "must set dashes")
+			} else {
+				// stroke outline is filled instead
+				test.That(t, !strings.Contains(s, "\\pgfusepath{stroke}"), "must not stroke path")
+				test.That(t, !strings.Contains(s, "\\pgfsetdash"), "must not set dashes")
+				test.That(t, strings.Contains(s, "\\pgfsetfillcolor{canvasColor0}") && strings.Contains(s, "\\pgfusepath{fill}"), "must fill stroke outline")
+			}
+		})
+	}
+}
+
+func TestTeXFillRule(t *testing.T) {
+	path := canvas.MustParseSVG("M0 0L10 0L10 10L0 10zM2 2L8 2L8 8L2 8z")
+	style := canvas.DefaultStyle
+	style.FillRule = canvas.EvenOdd
+
+	w := &bytes.Buffer{}
+	tex := New(w, 100.0, 80.0)
+	tex.RenderPath(path, style, canvas.Identity)
+	tex.RenderPath(path, style, canvas.Identity)
+	tex.RenderPath(path, canvas.DefaultStyle, canvas.Identity)
+	test.Error(t, tex.Close())
+
+	s := w.String()
+	test.T(t, strings.Count(s, "\n\\pgfseteorule\n\\pgfusepath{fill}"), 1)
+	test.T(t, strings.Count(s, "\n\\pgfsetnonzerorule\n\\pgfusepath{fill}"), 1)
+}
+
+func TestTeXText(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	face := dejaVuSerif.Face(12.0, canvas.Red, canvas.FontBold, canvas.FontNormal)
+
+	w := &bytes.Buffer{}
+	tex := NewWithOptions(w, 100.0, 80.0, &Options{NativeText: true})
+	tex.RenderText(canvas.NewTextLine(face, "50% & more", canvas.Left), canvas.Identity.Translate(10.0, 20.0))
+	tex.RenderText(canvas.NewTextLine(face, "up", canvas.Left), canvas.Identity.Rotate(90.0))
+	test.Error(t, tex.Close())
+
+	s := w.String()
+	test.That(t, strings.Contains(s, "\n\\definecolor{canvasColor0}{RGB}{255,0,0}\n"), "must define color")
+	test.That(t, strings.Contains(s, "\n\\pgftext[left,base,at={\\pgfpoint{10mm}{20mm}}]{\\fontsize{12pt}{14.4pt}\\selectfont\\bfseries\\color{canvasColor0}50\\% \\& more}"), "must write text node")
+	test.That(t, strings.Contains(s, "\n\\pgftext[left,base,at={\\pgfpoint{0mm}{0mm}},rotate=90]{"), "must rotate text node")
+	test.That(t, !strings.Contains(s, "\\pgfpathmoveto"), "must not draw text as path")
+}
+
+func TestEscapeText(t *testing.T) {
+	dejaVuSerif := canvas.NewFontFamily("dejavu-serif")
+	test.Error(t, dejaVuSerif.LoadFontFile("../font/DejaVuSerif.ttf", canvas.FontRegular))
+	dejaVuSerif.Use(canvas.CommonLigatures)
+	font := dejaVuSerif.Face(12.0, canvas.Black, canvas.FontRegular, canvas.FontNormal).Font
+
+	var tts = []struct {
+		s, escaped string
+	}{
+		{"text", "text"},
+		{`\{}$&#_%`, `\textbackslash{}\{\}\$\&\#\_\%`},
+		{"^~", `\textasciicircum{}\textasciitilde{}`},
+		{"a\nb\tc", "a b c"},
+		{"\uFB01ne", "fine"}, // ligature
+	}
+	for _, tt := range tts {
+		t.Run(tt.s, func(t *testing.T) {
+			test.String(t, escapeText(font, tt.s), tt.escaped)
+		})
+	}
+}
+
+func TestTeXImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tex")
+	test.Error(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, "out.tex"))
+	test.Error(t, err)
+	defer f.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	tex := NewWithOptions(f, 100.0, 80.0, &Options{ImagePrefix: "figure"})
+	tex.RenderImage(img, canvas.Identity)
+	tex.RenderImage(img, canvas.Identity)
+	test.Error(t, tex.Close())
+
+	// image files are written next to the TeX file and are numbered
+	for _, name := range []string{"figure-0.png", "figure-1.png"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		test.Error(t, err, name)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	test.Error(t, err)
+	test.That(t, strings.Contains(string(b), "\n\\pgftext[left,bottom]{\\pgfimage[width=2mm,height=3mm]{figure-1.png}}"), "must include image")
+
+	// absolute prefix and lossy encoding
+	w := &bytes.Buffer{}
+	prefix := filepath.Join(dir, "photo")
+	tex = NewWithOptions(w, 100.0, 80.0, &Options{ImagePrefix: prefix, ImageEncoding: canvas.Lossy})
+	tex.RenderImage(img, canvas.Identity)
+	test.Error(t, tex.Close())
+	_, err = os.Stat(prefix + "-0.jpg")
+	test.Error(t, err)
+
+	// errors are returned by Close
+	tex = NewWithOptions(w, 100.0, 80.0, &Options{ImagePrefix: filepath.Join(dir, "missing", "figure")})
+	tex.RenderImage(img, canvas.Identity)
+	test.That(t, tex.Close() != nil, "must return error")
+
+	// images are skipped without prefix
+	w.Reset()
+	tex = New(w, 100.0, 80.0)
+	tex.RenderImage(img, canvas.Identity)
+	test.Error(t, tex.Close())
+	test.That(t, !strings.Contains(w.String(), "\\pgfimage"), "must skip image")
+}
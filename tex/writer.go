@@ -13,3 +13,12 @@ func Writer(w io.Writer, c *canvas.Canvas) error {
 	c.Render(tex)
 	return tex.Close()
 }
+
+// WriterWithOptions returns a writer that writes the canvas as a TeX file using PGF with the given options, such as native text and image files.
+func WriterWithOptions(opts *Options) canvas.Writer {
+	return func(w io.Writer, c *canvas.Canvas) error {
+		tex := NewWithOptions(w, c.W, c.H, opts)
+		c.Render(tex)
+		return tex.Close()
+	}
+}
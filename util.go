@@ -263,6 +263,11 @@ func (r Rect) Add(q Rect) Rect {
 	return Rect{x0, y0, x1 - x0, y1 - y0}
 }
 
+// Overlaps returns true if both rects overlap or touch, which includes rects of zero size.
+func (r Rect) Overlaps(q Rect) bool {
+	return r.X <= q.X+q.W && q.X <= r.X+r.W && r.Y <= q.Y+q.H && q.Y <= r.Y+r.H
+}
+
 // Transform transforms the rectangle by affine transformation matrix m and returns the new bounds of that rectangle.
 func (r Rect) Transform(m Matrix) Rect {
 	p0 := m.Dot(Point{r.X, r.Y})
@@ -73,6 +73,9 @@ func TestRect(t *testing.T) {
 	test.T(t, r.Add(Rect{5, 5, 5, 5}), Rect{0, 0, 10, 10})
 	test.T(t, r.Add(Rect{5, 5, 0, 5}), r)
 	test.T(t, Rect{5, 5, 0, 5}.Add(r), r)
+	test.That(t, r.Overlaps(Rect{5, 5, 5, 5}), "touching rects overlap")
+	test.That(t, r.Overlaps(Rect{2, 2, 0, 0}), "rects of zero size overlap")
+	test.That(t, !r.Overlaps(Rect{6, 0, 5, 5}), "disjoint rects do not overlap")
 	test.T(t, r.Transform(Identity.Rotate(90)), Rect{-5, 0, 5, 5})
 	test.T(t, r.Transform(Identity.Rotate(45)), Rect{-3.53, 0.0, 7.07, 7.07})
 	test.T(t, r.ToPath(), MustParseSVG("M0,0H5V5H0z"))